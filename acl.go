@@ -0,0 +1,283 @@
+// See the LICENSE file for license details.
+
+package boltdb
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"go.etcd.io/bbolt"
+)
+
+// -----------------------------------------------------------------------------
+
+// Permission is an access level granted on buckets. Each level includes the previous ones.
+type Permission int
+
+const (
+	PermissionNone Permission = iota
+
+	// PermissionRead allows to open buckets and read or iterate their keys.
+	PermissionRead
+
+	// PermissionWrite allows to store and delete keys and to create buckets.
+	PermissionWrite
+
+	// PermissionAdmin allows to delete buckets.
+	PermissionAdmin
+)
+
+// AnyPrincipal matches every principal, including transactions without one, in access control rules.
+const AnyPrincipal = "*"
+
+var metaACLPolicyKey = []byte("acl_policy")
+
+// ACLRule grants a permission to a principal on a bucket and its nested buckets.
+type ACLRule struct {
+	Principal  string     `json:"principal"`
+	Path       string     `json:"path"`
+	Permission Permission `json:"permission"`
+}
+
+// ACLPolicy is a set of access control rules. Permissions granted by several rules add up.
+type ACLPolicy struct {
+	Rules []ACLRule `json:"rules"`
+}
+
+// PermissionError is returned when the principal of a transaction lacks the permission required to
+// access a bucket.
+type PermissionError struct {
+	Principal string
+	Path      []byte
+	Required  Permission
+}
+
+type aclPolicy struct {
+	policy ACLPolicy
+	rules  []aclRule
+}
+
+type aclRule struct {
+	principal  string
+	fragments  [][]byte
+	permission Permission
+}
+
+// -----------------------------------------------------------------------------
+
+// String returns the name of the permission.
+func (p Permission) String() string {
+	switch p {
+	case PermissionNone:
+		return "none"
+	case PermissionRead:
+		return "read"
+	case PermissionWrite:
+		return "write"
+	case PermissionAdmin:
+		return "admin"
+	}
+	return fmt.Sprintf("Permission(%d)", int(p))
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (p Permission) MarshalText() ([]byte, error) {
+	if p < PermissionNone || p > PermissionAdmin {
+		return nil, fmt.Errorf("invalid permission %d", int(p))
+	}
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (p *Permission) UnmarshalText(text []byte) error {
+	for candidate := PermissionNone; candidate <= PermissionAdmin; candidate++ {
+		if candidate.String() == string(text) {
+			*p = candidate
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid permission %q", text)
+}
+
+func (e *PermissionError) Error() string {
+	return fmt.Sprintf("%v [principal=%q path=%s required=%v]", ErrPermissionDenied, e.Principal, e.Path,
+		e.Required)
+}
+
+func (e *PermissionError) Unwrap() error {
+	return ErrPermissionDenied
+}
+
+// SetACLPolicy enables access control using the given policy for the transactions started afterwards. A
+// nil policy disables access control. The policy is not stored, use SaveACLPolicy for that.
+func (db *DB) SetACLPolicy(policy *ACLPolicy) error {
+	if policy == nil {
+		db.acl.Store(nil)
+		return nil
+	}
+
+	compiled, err := newACLPolicy(*policy)
+	if err != nil {
+		return err
+	}
+	db.acl.Store(compiled)
+
+	// Done
+	return nil
+}
+
+// ACLPolicy returns a copy of the access control policy in use or nil if access control is disabled.
+func (db *DB) ACLPolicy() *ACLPolicy {
+	compiled := db.acl.Load()
+	if compiled == nil {
+		return nil
+	}
+	policy := ACLPolicy{
+		Rules: append([]ACLRule(nil), compiled.policy.Rules...),
+	}
+	return &policy
+}
+
+// SaveACLPolicy stores the policy inside the database, so it is loaded when the database is opened, and
+// enables it. A nil policy removes the stored one and disables access control.
+func (db *DB) SaveACLPolicy(policy *ACLPolicy) error {
+	var compiled *aclPolicy
+	var encoded []byte
+	var err error
+
+	if policy != nil {
+		compiled, err = newACLPolicy(*policy)
+		if err != nil {
+			return err
+		}
+		encoded, err = json.Marshal(policy)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = db.WithinTx(TxOptions{}, func(tx *TX) error {
+		meta, err := tx.metaBucket()
+		if err != nil {
+			return err
+		}
+		return tx.writeMeta(nil, meta, metaACLPolicyKey, encoded)
+	})
+	if err != nil {
+		return err
+	}
+	db.acl.Store(compiled)
+
+	// Done
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+
+func newACLPolicy(policy ACLPolicy) (*aclPolicy, error) {
+	compiled := &aclPolicy{
+		policy: ACLPolicy{
+			Rules: append([]ACLRule(nil), policy.Rules...),
+		},
+		rules: make([]aclRule, 0, len(policy.Rules)),
+	}
+	for _, rule := range policy.Rules {
+		var fragments [][]byte
+		var err error
+
+		if rule.Permission < PermissionNone || rule.Permission > PermissionAdmin {
+			return nil, fmt.Errorf("%w [path=%q]: invalid permission", ErrInvalidACLPolicy, rule.Path)
+		}
+		if len(rule.Path) > 0 && rule.Path != "/" {
+			fragments, err = splitPath([]byte(rule.Path))
+			if err != nil {
+				return nil, fmt.Errorf("%w [path=%q]: invalid path", ErrInvalidACLPolicy, rule.Path)
+			}
+		}
+		compiled.rules = append(compiled.rules, aclRule{
+			principal:  rule.Principal,
+			fragments:  fragments,
+			permission: rule.Permission,
+		})
+	}
+
+	// Done
+	return compiled, nil
+}
+
+// loadACLPolicy enables the policy stored in the database or disables access control if none is stored.
+func (db *DB) loadACLPolicy() error {
+	var policy *ACLPolicy
+
+	// Use a raw transaction so observers are not notified about internal reads.
+	err := db.db.View(func(tx *bbolt.Tx) error {
+		meta := tx.Bucket(metaBucketName)
+		if meta == nil {
+			return nil
+		}
+		encoded := meta.Get(metaACLPolicyKey)
+		if encoded == nil {
+			return nil
+		}
+		policy = &ACLPolicy{}
+		return json.Unmarshal(encoded, policy)
+	})
+	if err != nil {
+		return fmt.Errorf("cannot load stored access control policy: %w", err)
+	}
+	return db.SetACLPolicy(policy)
+}
+
+// permission returns the permission a principal has on the bucket with the given path fragments.
+func (p *aclPolicy) permission(principal string, fragments [][]byte) Permission {
+	granted := PermissionNone
+	for idx := range p.rules {
+		rule := &p.rules[idx]
+		if rule.permission > granted && (rule.principal == principal || rule.principal == AnyPrincipal) &&
+			hasPathPrefix(fragments, rule.fragments) {
+			granted = rule.permission
+		}
+	}
+	return granted
+}
+
+// visible returns true if the principal can access the bucket or any of its nested buckets.
+func (p *aclPolicy) visible(principal string, fragments [][]byte) bool {
+	for idx := range p.rules {
+		rule := &p.rules[idx]
+		if rule.permission > PermissionNone && (rule.principal == principal || rule.principal == AnyPrincipal) &&
+			(hasPathPrefix(fragments, rule.fragments) || hasPathPrefix(rule.fragments, fragments)) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPermission verifies the principal of the transaction has the required permission on a bucket.
+func (tx *TX) checkPermission(fragments [][]byte, required Permission) error {
+	if tx.acl == nil || tx.acl.permission(tx.principal, fragments) >= required {
+		return nil
+	}
+	return &PermissionError{
+		Principal: tx.principal,
+		Path:      joinPath(fragments),
+		Required:  required,
+	}
+}
+
+// checkPathPermission acts like checkPermission but receives an already validated path.
+func (tx *TX) checkPathPermission(path []byte, required Permission) error {
+	if tx.acl == nil {
+		return nil
+	}
+	return tx.checkPermission(splitBucketFragments(path), required)
+}
+
+// hiddenTopLevel returns true if a top-level bucket must be skipped when iterating the database or the
+// namespace of the transaction.
+func (tx *TX) hiddenTopLevel(name []byte) bool {
+	if tx.root != nil {
+		return tx.acl != nil && !tx.acl.visible(tx.principal, append(splitBucketFragments(tx.root), name))
+	}
+	return isReservedBucketName(name) || (tx.acl != nil && !tx.acl.visible(tx.principal, [][]byte{name}))
+}
@@ -0,0 +1,146 @@
+// See the LICENSE file for license details.
+
+package boltdb_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/mxmauro/boltdb/v3"
+)
+
+// -----------------------------------------------------------------------------
+
+func TestACL(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	db, err := boltdb.New(filename)
+	if err != nil {
+		t.Fatalf("cannot create test database [err=%v]", err.Error())
+	}
+
+	for _, path := range []string{"billing/invoices", "billing/archive", "users"} {
+		if err = db.Put([]byte(path), []byte("k"), []byte("v")); err != nil {
+			db.Close()
+			t.Fatalf("cannot write to test database [err=%v]", err.Error())
+		}
+	}
+
+	err = db.SaveACLPolicy(&boltdb.ACLPolicy{
+		Rules: []boltdb.ACLRule{
+			{Principal: "billing", Path: "billing", Permission: boltdb.PermissionWrite},
+			{Principal: "billing", Path: "billing/archive", Permission: boltdb.PermissionAdmin},
+			{Principal: "reports", Path: "billing/invoices", Permission: boltdb.PermissionRead},
+			{Principal: boltdb.AnyPrincipal, Path: "users", Permission: boltdb.PermissionRead},
+		},
+	})
+	db.Close()
+	if err != nil {
+		t.Fatalf("cannot save access control policy [err=%v]", err)
+	}
+
+	// The stored policy is loaded when the database is opened.
+	db, err = boltdb.New(filename)
+	if err != nil {
+		t.Fatalf("cannot open test database [err=%v]", err.Error())
+	}
+	defer db.Close()
+
+	run := func(principal string, cb func(tx *boltdb.TX) error) error {
+		return db.WithinTx(boltdb.TxOptions{Principal: principal}, cb)
+	}
+	put := func(path string) func(tx *boltdb.TX) error {
+		return func(tx *boltdb.TX) error {
+			b, err := tx.Bucket([]byte(path))
+			if err != nil {
+				return err
+			}
+			return b.Put([]byte("k"), []byte("new"))
+		}
+	}
+	nextSequence := func(path string) func(tx *boltdb.TX) error {
+		return func(tx *boltdb.TX) error {
+			b, err := tx.Bucket([]byte(path))
+			if err != nil {
+				return err
+			}
+			_, err = b.NextSequence()
+			return err
+		}
+	}
+	deleteBucket := func(path string) func(tx *boltdb.TX) error {
+		return func(tx *boltdb.TX) error {
+			return tx.DeleteBucket([]byte(path))
+		}
+	}
+
+	checks := []struct {
+		principal string
+		cb        func(tx *boltdb.TX) error
+		denied    string
+	}{
+		{"billing", put("billing/invoices"), ""},
+		{"billing", put("billing/new"), ""},
+		{"billing", put("users"), "users"},
+		{"billing", deleteBucket("billing/invoices"), "billing/invoices"},
+		{"billing", deleteBucket("billing/archive"), ""},
+		{"reports", put("billing/invoices"), "billing/invoices"},
+		{"reports", put("billing"), "billing"},
+		{"reports", nextSequence("billing/invoices"), "billing/invoices"},
+		{"billing", nextSequence("billing/invoices"), ""},
+		{"", put("users"), "users"},
+		{"", put("other"), "other"},
+	}
+	for idx, check := range checks {
+		var permErr *boltdb.PermissionError
+
+		err = run(check.principal, check.cb)
+		if check.denied == "" {
+			if err != nil {
+				t.Fatalf("unexpected error [check=%d err=%v]", idx, err)
+			}
+			continue
+		}
+		if !errors.Is(err, boltdb.ErrPermissionDenied) || !errors.As(err, &permErr) ||
+			string(permErr.Path) != check.denied {
+			t.Fatalf("access should be denied [check=%d err=%v]", idx, err)
+		}
+	}
+
+	// Top-level iteration only shows reachable buckets.
+	var names []string
+	err = run("reports", func(tx *boltdb.TX) error {
+		return tx.WithIterator(boltdb.WithIteratorOptions{}, func(iter *boltdb.Iterator) (bool, error) {
+			names = append(names, string(iter.Key()))
+			return false, nil
+		})
+	})
+	if err != nil || len(names) != 2 || names[0] != "billing" || names[1] != "users" {
+		t.Fatalf("unexpected top-level buckets [names=%v err=%v]", names, err)
+	}
+
+	// Write batches apply the policy to their lookups and changes.
+	wb := db.NewWriteBatch(boltdb.WriteBatchOptions{TxOptions: boltdb.TxOptions{Principal: "reports"}})
+	if _, err = wb.Get([]byte("billing/archive"), []byte("k")); !errors.Is(err, boltdb.ErrPermissionDenied) {
+		wb.Discard()
+		t.Fatalf("access should be denied [err=%v]", err)
+	}
+	if value, err2 := wb.Get([]byte("billing/invoices"), []byte("k")); err2 != nil || string(value) != "new" {
+		wb.Discard()
+		t.Fatalf("unexpected batch value [got=%q err=%v]", value, err2)
+	}
+	_ = wb.Put([]byte("billing/invoices"), []byte("k"), []byte("batch"))
+	if err = wb.Apply(); !errors.Is(err, boltdb.ErrPermissionDenied) {
+		wb.Discard()
+		t.Fatalf("access should be denied [err=%v]", err)
+	}
+	wb.Discard()
+
+	// Disabling the policy.
+	if err = db.SetACLPolicy(nil); err != nil {
+		t.Fatalf("cannot disable access control [err=%v]", err)
+	}
+	if err = run("", put("other")); err != nil {
+		t.Fatalf("unexpected error [err=%v]", err)
+	}
+}
@@ -0,0 +1,282 @@
+// See the LICENSE file for license details.
+
+package boltdb
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+
+// AuditOperation identifies the kind of change recorded in an audit entry.
+type AuditOperation string
+
+const (
+	AuditPut          AuditOperation = "put"
+	AuditDelete       AuditOperation = "delete"
+	AuditCreateBucket AuditOperation = "create-bucket"
+	AuditDeleteBucket AuditOperation = "delete-bucket"
+)
+
+var metaAuditBucket = []byte("audit")
+
+// AuditEntry is a record of a change made to the database.
+type AuditEntry struct {
+	Sequence  uint64         `json:"seq"`
+	Time      time.Time      `json:"time"`
+	Actor     string         `json:"actor,omitempty"`
+	Reason    string         `json:"reason,omitempty"`
+	Operation AuditOperation `json:"op"`
+	Path      []byte         `json:"path"`
+
+	// Key is the affected key. It is nil for bucket operations and on buckets with encrypted keys.
+	Key []byte `json:"key,omitempty"`
+
+	// ValueHash is the SHA-256 hash of the stored value on puts.
+	ValueHash []byte `json:"value_hash,omitempty"`
+
+	// PrevHash and Hash chain the entry with the previous one.
+	PrevHash []byte `json:"prev_hash"`
+	Hash     []byte `json:"hash"`
+}
+
+// AuditQuery specifies the audit entries to return. Zero values match everything.
+type AuditQuery struct {
+	// From and To limit the entries to the given time range. To is exclusive.
+	From time.Time
+	To   time.Time
+
+	Actor string
+
+	// Path limits the entries to the given bucket and its nested buckets.
+	Path []byte
+}
+
+// AuditReport contains the result of an audit chain verification.
+type AuditReport struct {
+	Entries uint64
+
+	// Head is the hash of the last entry. Storing it elsewhere allows to detect the removal of the most
+	// recent entries.
+	Head []byte
+}
+
+// AuditChainError is returned when the audit chain is broken.
+type AuditChainError struct {
+	Sequence uint64
+	Reason   string
+}
+
+// -----------------------------------------------------------------------------
+
+func (e *AuditChainError) Error() string {
+	return fmt.Sprintf("%v [seq=%d]: %s", ErrAuditChainBroken, e.Sequence, e.Reason)
+}
+
+func (e *AuditChainError) Unwrap() error {
+	return ErrAuditChainBroken
+}
+
+// VerifyAuditChain checks the audit entries are consecutive and that their hashes match. It returns an
+// *AuditChainError, which matches ErrAuditChainBroken, on the first inconsistency.
+func (db *DB) VerifyAuditChain(ctx context.Context) (AuditReport, error) {
+	var report AuditReport
+
+	err := db.WithinTx(TxOptions{ReadOnly: true}, func(tx *TX) error {
+		return verifyAuditChain(ctx, tx, &report)
+	})
+
+	// Done
+	return report, err
+}
+
+// QueryAudit calls the callback, in order, with the audit entries matching the query.
+func (db *DB) QueryAudit(query AuditQuery, cb func(entry AuditEntry) (stop bool, err error)) error {
+	var pathFragments [][]byte
+
+	if len(query.Path) > 0 {
+		var err error
+
+		pathFragments, err = splitPath(query.Path)
+		if err != nil {
+			return err
+		}
+	}
+
+	return db.WithinTx(TxOptions{ReadOnly: true}, func(tx *TX) error {
+		audit, err := tx.metaSubBucket(metaAuditBucket)
+		if err != nil || audit == nil {
+			return err
+		}
+
+		c := audit.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var entry AuditEntry
+
+			err = json.Unmarshal(v, &entry)
+			if err != nil {
+				return &AuditChainError{Sequence: binary.BigEndian.Uint64(k), Reason: "malformed entry"}
+			}
+			if !query.From.IsZero() && entry.Time.Before(query.From) {
+				continue
+			}
+			if !query.To.IsZero() && !entry.Time.Before(query.To) {
+				continue
+			}
+			if len(query.Actor) > 0 && entry.Actor != query.Actor {
+				continue
+			}
+			if pathFragments != nil {
+				fragments, err2 := splitPath(entry.Path)
+				if err2 != nil || !hasPathPrefix(fragments, pathFragments) {
+					continue
+				}
+			}
+
+			stop, err := cb(entry)
+			if err != nil || stop {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// -----------------------------------------------------------------------------
+
+// appendAudit adds an entry to the audit log if enabled. The value is nil except on puts.
+func (tx *TX) appendAudit(op AuditOperation, path []byte, key []byte, value []byte) error {
+	if !tx.db.audit {
+		return nil
+	}
+
+	audit, err := tx.metaSubBucket(metaAuditBucket)
+	if err != nil {
+		return err
+	}
+
+	entry := AuditEntry{
+		Sequence:  1,
+		Time:      time.Now().UTC(),
+		Actor:     tx.actor,
+		Reason:    tx.reason,
+		Operation: op,
+		Path:      cloneBytes(path),
+		Key:       cloneBytes(key),
+	}
+	if value != nil {
+		sum := sha256.Sum256(value)
+		entry.ValueHash = sum[:]
+	}
+	if k, v := audit.Cursor().Last(); k != nil {
+		var last AuditEntry
+
+		err = json.Unmarshal(v, &last)
+		if err != nil {
+			return err
+		}
+		entry.Sequence = last.Sequence + 1
+		entry.PrevHash = last.Hash
+	}
+	entry.Hash = entry.digest()
+
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	k := binary.BigEndian.AppendUint64(nil, entry.Sequence)
+	tx.journalRawKey([][]byte{metaBucketName, metaAuditBucket}, audit, k)
+
+	// Done
+	return audit.Put(k, encoded)
+}
+
+// digest computes the hash of the entry, which covers all the fields but the hash itself.
+func (entry *AuditEntry) digest() []byte {
+	h := sha256.New()
+	writeField := func(data []byte) {
+		_, _ = h.Write(binary.BigEndian.AppendUint32(nil, uint32(len(data))))
+		_, _ = h.Write(data)
+	}
+
+	writeField(entry.PrevHash)
+	writeField(binary.BigEndian.AppendUint64(nil, entry.Sequence))
+	writeField(binary.BigEndian.AppendUint64(nil, uint64(entry.Time.UnixNano())))
+	writeField([]byte(entry.Actor))
+	writeField([]byte(entry.Reason))
+	writeField([]byte(entry.Operation))
+	writeField(entry.Path)
+	writeField(entry.Key)
+	writeField(entry.ValueHash)
+
+	// Done
+	return h.Sum(nil)
+}
+
+func verifyAuditChain(ctx context.Context, tx *TX, report *AuditReport) error {
+	audit, err := tx.metaSubBucket(metaAuditBucket)
+	if err != nil || audit == nil {
+		return err
+	}
+
+	var prevHash []byte
+	expected := uint64(1)
+	c := audit.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if report.Entries%1000 == 0 {
+			err = ctx.Err()
+			if err != nil {
+				return err
+			}
+		}
+
+		var entry AuditEntry
+
+		if len(k) != 8 || binary.BigEndian.Uint64(k) != expected {
+			return &AuditChainError{Sequence: expected, Reason: "missing entry"}
+		}
+		err = json.Unmarshal(v, &entry)
+		if err != nil {
+			return &AuditChainError{Sequence: expected, Reason: "malformed entry"}
+		}
+		if entry.Sequence != expected {
+			return &AuditChainError{Sequence: expected, Reason: "sequence mismatch"}
+		}
+		if !bytes.Equal(entry.PrevHash, prevHash) {
+			return &AuditChainError{Sequence: expected, Reason: "previous hash mismatch"}
+		}
+		if !bytes.Equal(entry.Hash, entry.digest()) {
+			return &AuditChainError{Sequence: expected, Reason: "hash mismatch"}
+		}
+
+		prevHash = entry.Hash
+		expected += 1
+		report.Entries += 1
+	}
+	report.Head = cloneBytes(prevHash)
+
+	// Done
+	return nil
+}
+
+// checkAuditChain reports the first inconsistency of the audit chain, if any.
+func checkAuditChain(ctx context.Context, tx *TX, report *Report) error {
+	var chainErr *AuditChainError
+
+	err := verifyAuditChain(ctx, tx, &AuditReport{})
+	if errors.As(err, &chainErr) {
+		report.Findings = append(report.Findings, Finding{
+			Kind:    FindingAuditChain,
+			Message: chainErr.Error(),
+		})
+		return nil
+	}
+	return err
+}
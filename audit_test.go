@@ -0,0 +1,121 @@
+// See the LICENSE file for license details.
+
+package boltdb_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mxmauro/boltdb/v3"
+	"go.etcd.io/bbolt"
+)
+
+// -----------------------------------------------------------------------------
+
+func TestAuditLog(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	db, err := boltdb.NewWithOptions(filename, boltdb.Options{Audit: true})
+	if err != nil {
+		t.Fatalf("cannot create test database [err=%v]", err.Error())
+	}
+
+	start := time.Now()
+	err = db.WithinTx(boltdb.TxOptions{Actor: "alice", Reason: "import"}, func(tx *boltdb.TX) error {
+		b, err := tx.Bucket([]byte("accounts/eu"))
+		if err != nil {
+			return err
+		}
+		if err = b.Put([]byte("a"), []byte("100")); err != nil {
+			return err
+		}
+		return b.Put([]byte("b"), []byte("200"))
+	})
+	if err != nil {
+		db.Close()
+		t.Fatalf("cannot write to test database [err=%v]", err)
+	}
+	middle := time.Now()
+	err = db.WithinTx(boltdb.TxOptions{Actor: "bob"}, func(tx *boltdb.TX) error {
+		b, err := tx.Bucket([]byte("accounts/eu"))
+		if err != nil {
+			return err
+		}
+		if err = b.Delete([]byte("a")); err != nil {
+			return err
+		}
+		if _, err = tx.Bucket([]byte("other")); err != nil {
+			return err
+		}
+		return tx.DeleteBucket([]byte("other"))
+	})
+	if err != nil {
+		db.Close()
+		t.Fatalf("cannot write to test database [err=%v]", err)
+	}
+
+	collect := func(query boltdb.AuditQuery) []string {
+		var ops []string
+
+		err := db.QueryAudit(query, func(entry boltdb.AuditEntry) (bool, error) {
+			ops = append(ops, entry.Actor+":"+string(entry.Operation)+":"+string(entry.Path)+":"+string(entry.Key))
+			return false, nil
+		})
+		if err != nil {
+			db.Close()
+			t.Fatalf("cannot query audit log [err=%v]", err)
+		}
+		return ops
+	}
+
+	all := collect(boltdb.AuditQuery{})
+	if len(all) != 7 {
+		db.Close()
+		t.Fatalf("unexpected audit entries [entries=%v]", all)
+	}
+	if ops := collect(boltdb.AuditQuery{Actor: "bob", Path: []byte("accounts")}); len(ops) != 1 ||
+		ops[0] != "bob:delete:accounts/eu:a" {
+		db.Close()
+		t.Fatalf("unexpected audit entries [entries=%v]", ops)
+	}
+	if ops := collect(boltdb.AuditQuery{From: start, To: middle}); len(ops) != 4 {
+		db.Close()
+		t.Fatalf("unexpected audit entries [entries=%v]", ops)
+	}
+
+	report, err := db.VerifyAuditChain(context.Background())
+	db.Close()
+	if err != nil || report.Entries != 7 || len(report.Head) == 0 {
+		t.Fatalf("unexpected audit verification result [report=%+v err=%v]", report, err)
+	}
+
+	// Tamper with an entry.
+	raw, err := bbolt.Open(filename, 0600, nil)
+	if err != nil {
+		t.Fatalf("cannot open database [err=%v]", err)
+	}
+	err = raw.Update(func(tx *bbolt.Tx) error {
+		audit := tx.Bucket([]byte("\x00boltdb")).Bucket([]byte("audit"))
+		k, v := audit.Cursor().First()
+		return audit.Put(k, bytes.Replace(v, []byte("alice"), []byte("mallory"), 1))
+	})
+	_ = raw.Close()
+	if err != nil {
+		t.Fatalf("cannot tamper with the audit log [err=%v]", err)
+	}
+
+	db, err = boltdb.NewWithOptions(filename, boltdb.Options{Audit: true})
+	if err != nil {
+		t.Fatalf("cannot open test database [err=%v]", err.Error())
+	}
+	defer db.Close()
+
+	var chainErr *boltdb.AuditChainError
+	_, err = db.VerifyAuditChain(context.Background())
+	if !errors.Is(err, boltdb.ErrAuditChainBroken) || !errors.As(err, &chainErr) || chainErr.Sequence != 1 {
+		t.Fatalf("tampering was not detected [err=%v]", err)
+	}
+}
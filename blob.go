@@ -0,0 +1,652 @@
+// See the LICENSE file for license details.
+
+package boltdb
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// -----------------------------------------------------------------------------
+
+// BlobStore stores large objects split in chunks. Chunks are addressed by the SHA-256 of their content,
+// so identical chunks are stored once and shared between blobs using reference counts.
+//
+// A store located at path uses three nested buckets: "meta" holds the blob manifests, "chunks" the chunk
+// data and "refs" the reference count of each chunk. Bucket options, like compression or encryption,
+// matching those paths apply to the stored data.
+type BlobStore struct {
+	db   *DB
+	path []byte
+	opts BlobStoreOptions
+}
+
+// BlobStoreOptions specifies a set of options when creating a blob store.
+type BlobStoreOptions struct {
+	// ChunkSize is the size of the chunks new blobs are split into. Defaults to 256 KiB.
+	ChunkSize int
+
+	// ChunksPerTx is the maximum number of chunks written within a single transaction. It limits the
+	// memory used while storing a blob. Defaults to 16.
+	ChunksPerTx int
+}
+
+// BlobInfo contains the metadata of a blob.
+type BlobInfo struct {
+	Name        []byte    `json:"-"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type,omitempty"`
+	Checksum    []byte    `json:"checksum"` // SHA-256 of the whole content
+	ChunkSize   int       `json:"chunk_size"`
+	Created     time.Time `json:"created"`
+	Chunks      [][]byte  `json:"chunks"`
+}
+
+// BlobGCOptions specifies a set of options when collecting orphaned chunks.
+type BlobGCOptions struct {
+	// MinAge protects recently written chunks, which might belong to a blob still being stored. Defaults
+	// to one hour if zero. Use a negative value to collect all the orphaned chunks.
+	MinAge time.Duration
+
+	// BatchSize is the maximum number of chunks visited within a single transaction. Defaults to 1000.
+	BatchSize int
+}
+
+// BlobGCStats contains the result of a garbage collection.
+type BlobGCStats struct {
+	Chunks int
+	Bytes  int64
+}
+
+// BlobReader reads the content of a blob. Every chunk is loaded within its own read-only transaction.
+//
+// When the content is read sequentially up to the end, the checksum of the blob is verified and the last
+// Read fails with a *ChecksumError if it does not match.
+type BlobReader struct {
+	store  *BlobStore
+	info   BlobInfo
+	offset int64
+
+	chunkIdx int
+	chunk    []byte
+
+	// hash contains the content read sequentially from the start, up to hashed bytes.
+	hash   hash.Hash
+	hashed int64
+}
+
+// -----------------------------------------------------------------------------
+
+const (
+	defaultBlobChunkSize   = 256 * 1024
+	defaultBlobChunksPerTx = 16
+	defaultBlobGCMinAge    = time.Hour
+	defaultBlobGCBatchSize = 1000
+)
+
+var (
+	blobMetaBucket   = []byte("meta")
+	blobChunksBucket = []byte("chunks")
+	blobRefsBucket   = []byte("refs")
+)
+
+// -----------------------------------------------------------------------------
+
+// BlobStore returns a blob store located at the given bucket path.
+func (db *DB) BlobStore(path []byte, opts BlobStoreOptions) (*BlobStore, error) {
+	fragments, err := splitPath(path)
+	if err != nil {
+		return nil, err
+	}
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = defaultBlobChunkSize
+	}
+	if opts.ChunksPerTx <= 0 {
+		opts.ChunksPerTx = defaultBlobChunksPerTx
+	}
+
+	store := &BlobStore{
+		db:   db,
+		path: joinPath(fragments),
+		opts: opts,
+	}
+
+	// Done
+	return store, nil
+}
+
+// Put stores the content read from r as a blob with the given name, replacing any existing blob.
+// Chunks are written in several transactions and the blob becomes visible once all of them are stored.
+// It fails with ErrBlobChunkCollected if GC removes some of the chunks before the blob is stored.
+func (s *BlobStore) Put(name []byte, r io.Reader, contentType string) (BlobInfo, error) {
+	if len(name) == 0 {
+		return BlobInfo{}, errors.New("empty blob name")
+	}
+
+	info := BlobInfo{
+		Name:        cloneBytes(name),
+		ContentType: contentType,
+		ChunkSize:   s.opts.ChunkSize,
+		Created:     time.Now().UTC(),
+	}
+	contentHash := sha256.New()
+
+	// Store the chunks.
+	pending := make([][]byte, 0, s.opts.ChunksPerTx)
+	for {
+		chunk := make([]byte, s.opts.ChunkSize)
+		n, err := io.ReadFull(r, chunk)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return BlobInfo{}, err
+		}
+		if n > 0 {
+			chunk = chunk[:n]
+			_, _ = contentHash.Write(chunk)
+			info.Size += int64(n)
+			pending = append(pending, chunk)
+		}
+
+		eof := err != nil
+		if len(pending) == s.opts.ChunksPerTx || (eof && len(pending) > 0) {
+			hashes, err2 := s.storeChunks(pending)
+			if err2 != nil {
+				return BlobInfo{}, err2
+			}
+			info.Chunks = append(info.Chunks, hashes...)
+			pending = pending[:0]
+		}
+		if eof {
+			break
+		}
+	}
+	info.Checksum = contentHash.Sum(nil)
+
+	// Store the manifest and update the reference counts. A chunk can be collected meanwhile if storing
+	// the blob took longer than the GC minimum age, in which case the blob cannot be stored.
+	err := s.db.WithinTx(TxOptions{}, func(tx *TX) error {
+		meta, refs, err2 := s.buckets(tx)
+		if err2 != nil {
+			return err2
+		}
+		chunks, err2 := tx.Bucket(s.subPath(blobChunksBucket))
+		if err2 != nil {
+			return err2
+		}
+
+		for _, hash := range info.Chunks {
+			if refs.lookup(hash) == nil || chunks.lookup(hash) == nil {
+				return fmt.Errorf("%w [chunk=%x]", ErrBlobChunkCollected, hash)
+			}
+			err2 = addBlobChunkRef(refs, hash, 1)
+			if err2 != nil {
+				return err2
+			}
+		}
+		old, err2 := getBlobInfo(meta, name)
+		if err2 != nil && !errors.Is(err2, ErrBlobNotFound) {
+			return err2
+		}
+		if err2 == nil {
+			for _, hash := range old.Chunks {
+				err2 = addBlobChunkRef(refs, hash, -1)
+				if err2 != nil {
+					return err2
+				}
+			}
+		}
+
+		encodedInfo, err2 := json.Marshal(&info)
+		if err2 != nil {
+			return err2
+		}
+		return meta.Put(name, encodedInfo)
+	})
+	if err != nil {
+		return BlobInfo{}, err
+	}
+
+	// Done
+	return info, nil
+}
+
+// Stat returns the metadata of a blob.
+func (s *BlobStore) Stat(name []byte) (BlobInfo, error) {
+	var info BlobInfo
+
+	err := s.db.WithinTx(TxOptions{ReadOnly: true}, func(tx *TX) error {
+		meta, err2 := tx.Bucket(s.subPath(blobMetaBucket))
+		if err2 != nil {
+			if errors.Is(err2, ErrBucketNotFound) {
+				return ErrBlobNotFound
+			}
+			return err2
+		}
+		info, err2 = getBlobInfo(meta, name)
+		return err2
+	})
+
+	// Done
+	return info, err
+}
+
+// OpenBlob returns a reader for the content of a blob.
+// NOTE: If the blob is replaced or deleted while reading and its chunks are garbage collected, reads fail
+// with ErrBlobNotFound.
+func (s *BlobStore) OpenBlob(name []byte) (*BlobReader, error) {
+	info, err := s.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &BlobReader{
+		store:    s,
+		info:     info,
+		chunkIdx: -1,
+		hash:     sha256.New(),
+	}
+
+	// Done
+	return r, nil
+}
+
+// Delete removes a blob. Its chunks are released and, if no longer referenced, removed by GC. No error
+// is returned if the blob is not found.
+func (s *BlobStore) Delete(name []byte) error {
+	return s.db.WithinTx(TxOptions{}, func(tx *TX) error {
+		meta, refs, err := s.buckets(tx)
+		if err != nil {
+			return err
+		}
+
+		info, err := getBlobInfo(meta, name)
+		if err != nil {
+			if errors.Is(err, ErrBlobNotFound) {
+				return nil
+			}
+			return err
+		}
+		for _, hash := range info.Chunks {
+			err = addBlobChunkRef(refs, hash, -1)
+			if err != nil {
+				return err
+			}
+		}
+		return meta.Delete(name)
+	})
+}
+
+// GC removes the chunks no longer referenced by any blob.
+func (s *BlobStore) GC(ctx context.Context, opts BlobGCOptions) (BlobGCStats, error) {
+	var stats BlobGCStats
+	var resume []byte
+
+	if opts.MinAge == 0 {
+		opts.MinAge = defaultBlobGCMinAge
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultBlobGCBatchSize
+	}
+	deadline := time.Now().Add(-opts.MinAge).UnixNano()
+
+	for {
+		err := ctx.Err()
+		if err != nil {
+			return stats, err
+		}
+
+		done := false
+		err = s.db.WithinTx(TxOptions{}, func(tx *TX) error {
+			var orphans [][]byte
+
+			refs, err2 := tx.Bucket(s.subPath(blobRefsBucket))
+			if err2 != nil {
+				return err2
+			}
+			chunks, err2 := tx.Bucket(s.subPath(blobChunksBucket))
+			if err2 != nil {
+				return err2
+			}
+
+			iter := refs.Iterate()
+			ok := iter.First()
+			if resume != nil {
+				ok = iter.seekRaw(resume)
+				if ok && bytes.Equal(iter.rawKey, resume) {
+					ok = iter.Next()
+				}
+			}
+			for visited := 0; ok && visited < opts.BatchSize; ok = iter.Next() {
+				visited += 1
+				resume = cloneBytes(iter.rawKey)
+
+				refCount, created := decodeBlobChunkRef(iter.Value())
+				if err2 = iter.Err(); err2 != nil {
+					return err2
+				}
+				if refCount <= 0 && created < deadline {
+					orphans = append(orphans, iter.CopyKey())
+				}
+			}
+			done = !ok
+
+			for _, hash := range orphans {
+				chunk, err3 := chunks.GetValue(hash)
+				if err3 != nil {
+					return err3
+				}
+				if err3 = chunks.Delete(hash); err3 == nil {
+					err3 = refs.Delete(hash)
+				}
+				if err3 != nil {
+					return err3
+				}
+				stats.Chunks += 1
+				stats.Bytes += int64(len(chunk))
+			}
+			return nil
+		})
+		if err != nil {
+			return stats, err
+		}
+		if done {
+			break
+		}
+	}
+
+	// Done
+	return stats, nil
+}
+
+// Info returns the metadata of the blob being read.
+func (r *BlobReader) Info() BlobInfo {
+	return r.info
+}
+
+// Read reads up to len(p) bytes of the blob content.
+func (r *BlobReader) Read(p []byte) (int, error) {
+	if r.offset >= r.info.Size {
+		return 0, io.EOF
+	}
+
+	if r.info.ChunkSize <= 0 {
+		return 0, fmt.Errorf("%w [name=%q]: invalid chunk size", ErrBlobCorrupted, r.info.Name)
+	}
+	idx := int(r.offset / int64(r.info.ChunkSize))
+	if idx >= len(r.info.Chunks) {
+		return 0, fmt.Errorf("%w [name=%q]: content is larger than its chunks", ErrBlobCorrupted, r.info.Name)
+	}
+	if idx != r.chunkIdx {
+		chunk, err := r.store.loadChunk(r.info.Chunks[idx])
+		if err != nil {
+			return 0, err
+		}
+		r.chunk, r.chunkIdx = chunk, idx
+	}
+
+	pos := r.offset - int64(idx)*int64(r.info.ChunkSize)
+	if pos >= int64(len(r.chunk)) {
+		return 0, fmt.Errorf("%w [name=%q chunk=%d]: chunk is shorter than expected", ErrBlobCorrupted, r.info.Name, idx)
+	}
+	n := copy(p, r.chunk[pos:])
+	if r.hashed == r.offset {
+		_, _ = r.hash.Write(p[:n])
+		r.hashed += int64(n)
+	}
+	r.offset += int64(n)
+
+	// Verify the checksum once the whole content was hashed.
+	if r.hashed == r.info.Size && r.offset == r.info.Size && !bytes.Equal(r.hash.Sum(nil), r.info.Checksum) {
+		return n, &ChecksumError{
+			Path: r.store.subPath(blobMetaBucket),
+			Key:  cloneBytes(r.info.Name),
+		}
+	}
+
+	// Done
+	return n, nil
+}
+
+// Seek sets the offset for the next Read.
+func (r *BlobReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.info.Size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.offset = offset
+
+	// Done
+	return offset, nil
+}
+
+// -----------------------------------------------------------------------------
+
+// storeChunks writes a set of chunks within a single transaction and returns their hashes. New chunks
+// start with no references, so they are protected from GC only by their age until the manifest is stored.
+func (s *BlobStore) storeChunks(chunks [][]byte) ([][]byte, error) {
+	hashes := make([][]byte, len(chunks))
+	now := time.Now().UnixNano()
+
+	err := s.db.WithinTx(TxOptions{}, func(tx *TX) error {
+		chunksBucket, err := tx.Bucket(s.subPath(blobChunksBucket))
+		if err != nil {
+			return err
+		}
+		_, refs, err := s.buckets(tx)
+		if err != nil {
+			return err
+		}
+
+		for idx, chunk := range chunks {
+			sum := sha256.Sum256(chunk)
+			hashes[idx] = sum[:]
+
+			ref := refs.Get(hashes[idx])
+			refCount, _ := decodeBlobChunkRef(ref)
+			if ref == nil {
+				err = chunksBucket.Put(hashes[idx], chunk)
+				if err != nil {
+					return err
+				}
+			}
+
+			// Refresh the creation time, so GC does not remove a chunk about to be referenced again.
+			err = refs.Put(hashes[idx], encodeBlobChunkRef(refCount, now))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	// Done
+	return hashes, err
+}
+
+func (s *BlobStore) loadChunk(hash []byte) ([]byte, error) {
+	var chunk []byte
+
+	err := s.db.WithinTx(TxOptions{ReadOnly: true}, func(tx *TX) error {
+		b, err := tx.Bucket(s.subPath(blobChunksBucket))
+		if err == nil {
+			chunk, err = b.GetValue(hash)
+		}
+		if err == nil && chunk == nil {
+			err = fmt.Errorf("%w: missing chunk %x", ErrBlobNotFound, hash)
+		}
+		return err
+	})
+
+	// Done
+	return chunk, err
+}
+
+func (s *BlobStore) buckets(tx *TX) (*Bucket, *Bucket, error) {
+	meta, err := tx.Bucket(s.subPath(blobMetaBucket))
+	if err != nil {
+		return nil, nil, err
+	}
+	refs, err := tx.Bucket(s.subPath(blobRefsBucket))
+	if err != nil {
+		return nil, nil, err
+	}
+	return meta, refs, nil
+}
+
+func (s *BlobStore) subPath(name []byte) []byte {
+	path := make([]byte, 0, len(s.path)+1+len(name))
+	return append(append(append(path, s.path...), '/'), name...)
+}
+
+func getBlobInfo(meta *Bucket, name []byte) (BlobInfo, error) {
+	var info BlobInfo
+
+	encodedInfo, err := meta.GetValue(name)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	if encodedInfo == nil {
+		return BlobInfo{}, ErrBlobNotFound
+	}
+	err = json.Unmarshal(encodedInfo, &info)
+	if err != nil {
+		return BlobInfo{}, fmt.Errorf("invalid blob metadata [name=%q]: %w", name, err)
+	}
+	info.Name = cloneBytes(name)
+
+	// Done
+	return info, nil
+}
+
+func addBlobChunkRef(refs *Bucket, hash []byte, delta int64) error {
+	refCount, created := decodeBlobChunkRef(refs.Get(hash))
+	refCount += delta
+	if refCount < 0 {
+		refCount = 0
+	}
+	return refs.Put(hash, encodeBlobChunkRef(refCount, created))
+}
+
+// encodeBlobChunkRef encodes a chunk reference entry: reference count (8 bytes) + creation time (8 bytes).
+func encodeBlobChunkRef(refCount int64, created int64) []byte {
+	value := make([]byte, 16)
+	binary.LittleEndian.PutUint64(value[0:8], uint64(refCount))
+	binary.LittleEndian.PutUint64(value[8:16], uint64(created))
+	return value
+}
+
+func decodeBlobChunkRef(value []byte) (int64, int64) {
+	if len(value) < 16 {
+		return 0, 0
+	}
+	return int64(binary.LittleEndian.Uint64(value[0:8])), int64(binary.LittleEndian.Uint64(value[8:16]))
+}
+
+// checkBlobRefCounts verifies the chunk reference counts of the blob stores found in the database. A
+// blob store is a bucket with "meta", "chunks" and "refs" buckets. The reference count of every chunk
+// must match the number of manifests using it and every referenced chunk must exist.
+func checkBlobRefCounts(ctx context.Context, tx *TX, report *Report) error {
+	return walkBuckets(ctx, tx, func(fragments [][]byte, b *bbolt.Bucket) error {
+		if b.Bucket(blobMetaBucket) == nil || b.Bucket(blobChunksBucket) == nil || b.Bucket(blobRefsBucket) == nil {
+			return nil
+		}
+		meta := tx.bucketFromFragments(appendPath(fragments, blobMetaBucket))
+		chunks := tx.bucketFromFragments(appendPath(fragments, blobChunksBucket))
+		refs := tx.bucketFromFragments(appendPath(fragments, blobRefsBucket))
+		if meta == nil || chunks == nil || refs == nil {
+			return nil
+		}
+		addFinding := func(path []byte, key []byte, message string) {
+			report.Findings = append(report.Findings, Finding{
+				Kind:    FindingBlobRefCount,
+				Path:    cloneBytes(path),
+				Key:     cloneBytes(key),
+				Message: message,
+			})
+		}
+
+		// Count the references found in the manifests.
+		expected := make(map[string]int64)
+		err := meta.WithIterator(WithIteratorOptions{}, func(iter *Iterator) (bool, error) {
+			var info BlobInfo
+
+			if iter.IsNestedBucket() {
+				return false, nil
+			}
+			value := iter.Value()
+			if err := iter.Err(); err != nil {
+				return true, err
+			}
+			if err := json.Unmarshal(value, &info); err != nil {
+				addFinding(meta.path, iter.Key(), "invalid blob metadata")
+				return false, nil
+			}
+			for _, hash := range info.Chunks {
+				expected[string(hash)] += 1
+				if chunks.lookup(hash) == nil {
+					addFinding(chunks.path, hash, fmt.Sprintf("chunk of blob %q is missing", iter.Key()))
+				}
+			}
+			return false, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// Compare them with the stored reference counts.
+		err = refs.WithIterator(WithIteratorOptions{}, func(iter *Iterator) (bool, error) {
+			if iter.IsNestedBucket() {
+				return false, nil
+			}
+			refCount, _ := decodeBlobChunkRef(iter.Value())
+			if err := iter.Err(); err != nil {
+				return true, err
+			}
+			hash := iter.Key()
+			if refCount != expected[string(hash)] {
+				addFinding(refs.path, hash, fmt.Sprintf("reference count is %d but %d manifests use the chunk",
+					refCount, expected[string(hash)]))
+			}
+			delete(expected, string(hash))
+			return false, nil
+		})
+		if err != nil {
+			return err
+		}
+		missing := make([]string, 0, len(expected))
+		for hash := range expected {
+			missing = append(missing, hash)
+		}
+		sort.Strings(missing)
+		for _, hash := range missing {
+			addFinding(refs.path, []byte(hash), fmt.Sprintf("chunk used by %d manifests has no reference count",
+				expected[hash]))
+		}
+
+		// Chunks without a reference count are never collected.
+		return chunks.WithIterator(WithIteratorOptions{}, func(iter *Iterator) (bool, error) {
+			if !iter.IsNestedBucket() && refs.lookup(iter.Key()) == nil {
+				addFinding(chunks.path, iter.Key(), "chunk has no reference count")
+			}
+			return false, nil
+		})
+	})
+}
@@ -0,0 +1,187 @@
+// See the LICENSE file for license details.
+
+package boltdb_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/mxmauro/boltdb/v3"
+)
+
+// -----------------------------------------------------------------------------
+
+// gcReader returns its chunks one per call and runs a blob GC before returning the second one.
+type gcReader struct {
+	store  *boltdb.BlobStore
+	chunks [][]byte
+	calls  int
+}
+
+// -----------------------------------------------------------------------------
+
+func TestBlobStore(t *testing.T) {
+	db := openTestDb(t)
+	defer db.Close()
+
+	store, err := db.BlobStore([]byte("files"), boltdb.BlobStoreOptions{ChunkSize: 1000, ChunksPerTx: 3})
+	if err != nil {
+		t.Fatalf("cannot create blob store [err=%v]", err.Error())
+	}
+
+	content := make([]byte, 10500)
+	rand.New(rand.NewSource(1)).Read(content)
+
+	info, err := store.Put([]byte("a.bin"), bytes.NewReader(content), "application/octet-stream")
+	if err != nil {
+		t.Fatalf("cannot store blob [err=%v]", err.Error())
+	}
+	checksum := sha256.Sum256(content)
+	if info.Size != int64(len(content)) || len(info.Chunks) != 11 || !bytes.Equal(info.Checksum, checksum[:]) {
+		t.Fatalf("unexpected blob info [got=%+v]", info)
+	}
+	if _, err = store.Put([]byte("b.bin"), bytes.NewReader(content), ""); err != nil {
+		t.Fatalf("cannot store blob [err=%v]", err.Error())
+	}
+
+	// Read the content, including a seek in the middle of a chunk.
+	r, err := store.OpenBlob([]byte("b.bin"))
+	if err != nil {
+		t.Fatalf("cannot open blob [err=%v]", err.Error())
+	}
+	data, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(data, content) {
+		t.Fatalf("unexpected blob content [err=%v]", err)
+	}
+	if _, err = r.Seek(-1505, io.SeekEnd); err != nil {
+		t.Fatalf("cannot seek blob [err=%v]", err.Error())
+	}
+	data, err = io.ReadAll(r)
+	if err != nil || !bytes.Equal(data, content[len(content)-1505:]) {
+		t.Fatalf("unexpected blob content after seek [err=%v]", err)
+	}
+
+	// Chunks are shared, so deleting one of the blobs releases nothing.
+	if err = store.Delete([]byte("a.bin")); err != nil {
+		t.Fatalf("cannot delete blob [err=%v]", err.Error())
+	}
+	stats, err := store.GC(context.Background(), boltdb.BlobGCOptions{MinAge: -1, BatchSize: 4})
+	if err != nil || stats.Chunks != 0 {
+		t.Fatalf("unexpected gc result [stats=%+v err=%v]", stats, err)
+	}
+
+	// Replacing the last blob orphans all its chunks.
+	if _, err = store.Put([]byte("b.bin"), bytes.NewReader([]byte("small")), "text/plain"); err != nil {
+		t.Fatalf("cannot replace blob [err=%v]", err.Error())
+	}
+	stats, err = store.GC(context.Background(), boltdb.BlobGCOptions{})
+	if err != nil || stats.Chunks != 0 {
+		t.Fatalf("recent chunks must be protected [stats=%+v err=%v]", stats, err)
+	}
+	stats, err = store.GC(context.Background(), boltdb.BlobGCOptions{MinAge: -1, BatchSize: 4})
+	if err != nil || stats.Chunks != 11 || stats.Bytes != int64(len(content)) {
+		t.Fatalf("unexpected gc result [stats=%+v err=%v]", stats, err)
+	}
+
+	info, err = store.Stat([]byte("b.bin"))
+	if err != nil || info.Size != 5 || info.ContentType != "text/plain" {
+		t.Fatalf("unexpected blob info [got=%+v err=%v]", info, err)
+	}
+	if _, err = store.OpenBlob([]byte("a.bin")); !errors.Is(err, boltdb.ErrBlobNotFound) {
+		t.Fatalf("expected deleted blob to be missing [got=%v]", err)
+	}
+}
+
+func TestBlobReaderCorruption(t *testing.T) {
+	db := openTestDb(t)
+	defer db.Close()
+
+	store, err := db.BlobStore([]byte("files"), boltdb.BlobStoreOptions{ChunkSize: 100})
+	if err != nil {
+		t.Fatalf("cannot create blob store [err=%v]", err.Error())
+	}
+	info, err := store.Put([]byte("a.bin"), bytes.NewReader(bytes.Repeat([]byte("x"), 250)), "")
+	if err != nil {
+		t.Fatalf("cannot store blob [err=%v]", err.Error())
+	}
+
+	replaceChunk := func(chunk []byte) {
+		err = db.Put([]byte("files/chunks"), info.Chunks[1], chunk)
+		if err != nil {
+			t.Fatalf("cannot replace chunk [err=%v]", err.Error())
+		}
+	}
+
+	// A modified chunk is detected once the whole content is read.
+	replaceChunk(bytes.Repeat([]byte("y"), 100))
+	r, err := store.OpenBlob([]byte("a.bin"))
+	if err != nil {
+		t.Fatalf("cannot open blob [err=%v]", err.Error())
+	}
+	var checksumErr *boltdb.ChecksumError
+	if _, err = io.ReadAll(r); !errors.Is(err, boltdb.ErrChecksumMismatch) || !errors.As(err, &checksumErr) ||
+		string(checksumErr.Key) != "a.bin" {
+		t.Fatalf("expected a checksum error [got=%v]", err)
+	}
+
+	// A truncated chunk fails instead of panicking.
+	replaceChunk([]byte("short"))
+	r, err = store.OpenBlob([]byte("a.bin"))
+	if err != nil {
+		t.Fatalf("cannot open blob [err=%v]", err.Error())
+	}
+	if _, err = r.Seek(150, io.SeekStart); err != nil {
+		t.Fatalf("cannot seek blob [err=%v]", err.Error())
+	}
+	if _, err = io.ReadAll(r); !errors.Is(err, boltdb.ErrBlobCorrupted) {
+		t.Fatalf("expected a corrupted blob error [got=%v]", err)
+	}
+}
+
+func TestBlobPutChunkCollected(t *testing.T) {
+	db := openTestDb(t)
+	defer db.Close()
+
+	store, err := db.BlobStore([]byte("files"), boltdb.BlobStoreOptions{ChunkSize: 4, ChunksPerTx: 1})
+	if err != nil {
+		t.Fatalf("cannot create blob store [err=%v]", err.Error())
+	}
+
+	// The first chunk is collected before the manifest is stored.
+	r := &gcReader{
+		store:  store,
+		chunks: [][]byte{[]byte("aaaa"), []byte("bbbb")},
+	}
+	if _, err = store.Put([]byte("a.bin"), r, ""); !errors.Is(err, boltdb.ErrBlobChunkCollected) {
+		t.Fatalf("expected a collected chunk error [got=%v]", err)
+	}
+	if _, err = store.Stat([]byte("a.bin")); !errors.Is(err, boltdb.ErrBlobNotFound) {
+		t.Fatalf("expected the blob not to be stored [err=%v]", err)
+	}
+	report, err := db.Check(context.Background())
+	if err != nil || !report.OK() {
+		t.Fatalf("unexpected check result [findings=%v err=%v]", report.Findings, err)
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+func (r *gcReader) Read(p []byte) (int, error) {
+	r.calls += 1
+	if r.calls == 2 {
+		if _, err := r.store.GC(context.Background(), boltdb.BlobGCOptions{MinAge: -1}); err != nil {
+			return 0, err
+		}
+	}
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.chunks[0])
+	r.chunks = r.chunks[1:]
+	return n, nil
+}
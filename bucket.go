@@ -14,9 +14,15 @@ import (
 
 // Bucket represents a directory that contains keys and values inside the database.
 type Bucket struct {
-	tx   *TX
-	name []byte
-	b    *bbolt.Bucket
+	tx    *TX
+	name  []byte
+	path  []byte
+	b     *bbolt.Bucket
+	codec valuePipeline
+	keys  *keyCodec
+
+	versioned bool
+	history   *HistoryOptions
 }
 
 // BucketStats contains statistical data about a bucket.
@@ -39,30 +45,109 @@ func (bucket *Bucket) Name() []byte {
 	return bucket.name
 }
 
+// Path returns the full path of the bucket. If the transaction belongs to a namespace, the path is
+// relative to it.
+func (bucket *Bucket) Path() []byte {
+	if root := bucket.tx.root; root != nil && len(bucket.path) > len(root) && bytes.HasPrefix(bucket.path, root) {
+		return bucket.path[len(root)+1:]
+	}
+	return bucket.path
+}
+
 // NextSequence returns an autoincrement integer for the bucket.
 func (bucket *Bucket) NextSequence() (uint64, error) {
-	return bucket.b.NextSequence()
+	err := bucket.tx.checkPathPermission(bucket.path, PermissionWrite)
+	if err != nil {
+		return 0, err
+	}
+	bucket.tx.journalSequence(bucket)
+	sequence, err := bucket.b.NextSequence()
+	if err == nil {
+		err = bucket.onSequenceChanged(sequence)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return sequence, nil
+}
+
+// Sequence returns the current autoincrement integer for the bucket without incrementing it.
+func (bucket *Bucket) Sequence() uint64 {
+	return bucket.b.Sequence()
+}
+
+// SetSequence updates the autoincrement integer for the bucket.
+func (bucket *Bucket) SetSequence(value uint64) error {
+	err := bucket.tx.checkPathPermission(bucket.path, PermissionWrite)
+	if err != nil {
+		return err
+	}
+	bucket.tx.journalSequence(bucket)
+	err = bucket.b.SetSequence(value)
+	if err != nil {
+		return err
+	}
+	return bucket.onSequenceChanged(value)
 }
 
 // Get returns the value of a key in a bucket or nil if not found.
 // The returned slice is only valid for the lifetime of the transaction.
+// NOTE: If the value cannot be decoded, for e.g. it fails to decrypt, nil is returned. Use GetValue to
+// get the error.
 func (bucket *Bucket) Get(key []byte) []byte {
-	return bucket.b.Get(key)
+	value, _ := bucket.get(key)
+	return value
 }
 
 // CopyGet returns a copy of the value of a key in a bucket or nil if not found.
 func (bucket *Bucket) CopyGet(key []byte) []byte {
-	return cloneBytes(bucket.b.Get(key))
+	value, _ := bucket.get(key)
+	return cloneBytes(value)
+}
+
+// GetValue returns a copy of the value of a key in a bucket or nil if not found. Unlike CopyGet, it
+// returns an error if the stored value cannot be decoded.
+func (bucket *Bucket) GetValue(key []byte) ([]byte, error) {
+	value, err := bucket.get(key)
+	if err != nil {
+		return nil, err
+	}
+	return cloneBytes(value), nil
 }
 
-// Put stores a key/value pair in the bucket.
+// Put stores a key/value pair in the bucket. On versioned buckets, the key gets a new version.
 func (bucket *Bucket) Put(key []byte, value []byte) error {
-	return bucket.b.Put(key, value)
+	_, err := bucket.put(key, value)
+	return err
 }
 
 // Delete deletes a specific key. No error is returned if the key is not found.
 func (bucket *Bucket) Delete(key []byte) error {
-	return bucket.b.Delete(key)
+	err := bucket.tx.checkPathPermission(bucket.path, PermissionWrite)
+	if err != nil {
+		return err
+	}
+	if bucket.keys == nil {
+		err = bucket.onRemoved(key, [][]byte{key})
+		if err != nil {
+			return err
+		}
+		bucket.tx.journalKey(bucket, key)
+		return bucket.b.Delete(key)
+	}
+	encodedKeys := bucket.keys.encodings(bucket.path, key)
+	err = bucket.onRemoved(key, encodedKeys)
+	if err != nil {
+		return err
+	}
+	for _, encodedKey := range encodedKeys {
+		bucket.tx.journalKey(bucket, encodedKey)
+		err := bucket.b.Delete(encodedKey)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Bucket returns a bucket on the database (and creates if it does not exist)
@@ -73,12 +158,27 @@ func (bucket *Bucket) Bucket(path []byte) (*Bucket, error) {
 		return nil, err
 	}
 
+	if bucket.tx.acl != nil {
+		fragments := append(bucket.fragments(), splitBucketFragments(path)...)
+		err = bucket.tx.checkPermission(fragments, PermissionRead)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// Get nested bucket.
 	b := bucket.b
 	readOnly := bucket.tx.readOnly
+	bucketPath := cloneBytes(bucket.path)
 	pathFragment, lastFragment := pi.fragment()
 	for {
 		if !readOnly {
+			if bucket.tx.tracksBuckets() && b.Bucket(pathFragment) == nil {
+				err = bucket.tx.onBucketCreated(append(splitBucketFragments(bucketPath), pathFragment))
+				if err != nil {
+					return nil, err
+				}
+			}
 			b, err = b.CreateBucketIfNotExists(pathFragment)
 			if err != nil {
 				return nil, err
@@ -89,6 +189,7 @@ func (bucket *Bucket) Bucket(path []byte) (*Bucket, error) {
 				return nil, ErrBucketNotFound
 			}
 		}
+		bucketPath = append(append(bucketPath, '/'), pathFragment...)
 
 		if lastFragment {
 			break
@@ -96,15 +197,8 @@ func (bucket *Bucket) Bucket(path []byte) (*Bucket, error) {
 		pathFragment, lastFragment = pi.fragment()
 	}
 
-	// Create a wrapper.
-	childBucket := &Bucket{
-		tx:   bucket.tx,
-		name: pathFragment,
-		b:    b,
-	}
-
 	// Done
-	return childBucket, nil
+	return bucket.tx.newBucket(pathFragment, bucketPath, b), nil
 }
 
 // DeleteBucket removes an existing child bucket on the database
@@ -134,6 +228,13 @@ func (bucket *Bucket) DeleteBucket(path []byte) error {
 	}
 
 	// We are on the final fragment.
+	if bucket.tx.tracksBuckets() {
+		fragments := append(bucket.fragments(), splitBucketFragments(path)...)
+		err = bucket.tx.onBucketDeleted(fragments, b.Bucket(pathFragment))
+		if err != nil {
+			return err
+		}
+	}
 	err = b.DeleteBucket(pathFragment)
 
 	// Done
@@ -147,6 +248,7 @@ func (bucket *Bucket) DeleteBucket(path []byte) error {
 func (bucket *Bucket) Iterate() *Iterator {
 	// Create a wrapper.
 	iter := Iterator{
+		tx:     bucket.tx,
 		bucket: bucket,
 		cursor: bucket.b.Cursor(),
 	}
@@ -159,63 +261,118 @@ func (bucket *Bucket) Iterate() *Iterator {
 // NOTE: Prefix and FirstKey cannot be used at the same time.
 // NOTE: If value == nil, then they key points to a child bucket.
 func (bucket *Bucket) WithIterator(opts WithIteratorOptions, cb WithinIteratorCallback) error {
-	if len(opts.Prefix) > 0 && len(opts.FirstKey) > 0 {
-		return errors.New("prefix and first key cannot be used at the same time")
-	}
+	return withIterator(bucket.Iterate(), opts, cb)
+}
 
-	iter := bucket.Iterate()
+func (bucket *Bucket) Stats() BucketStats {
+	return bucket.b.Stats()
+}
 
-	// Search for the first match.
-	if len(opts.Prefix) > 0 {
-		if !opts.Reverse {
-			_ = iter.Seek(opts.Prefix, SeekPrefix)
-		} else {
-			_ = iter.Seek(opts.Prefix, SeekPrefixReverse)
+func (bucket *Bucket) put(key []byte, value []byte) (Version, error) {
+	var version Version
+
+	err := bucket.tx.checkPathPermission(bucket.path, PermissionWrite)
+	if err != nil {
+		return 0, err
+	}
+	stored := value
+	if bucket.versioned {
+		version, err = bucket.tx.nextVersion(bucket.storedVersion(key))
+		if err != nil {
+			return 0, err
 		}
-	} else if len(opts.FirstKey) > 0 {
-		if !opts.Reverse {
-			_ = iter.Seek(opts.FirstKey, SeekGreaterOrEqual)
-		} else {
-			_ = iter.Seek(opts.FirstKey, SeekLessOrEqual)
+		stored = appendVersion(version, stored)
+	}
+	if len(bucket.codec) > 0 {
+		stored, err = bucket.codec.encode(bucket.path, key, stored)
+		if err != nil {
+			return 0, err
 		}
-	} else {
-		if !opts.Reverse {
-			_ = iter.First()
-		} else {
-			_ = iter.Last()
+	}
+	if bucket.keys == nil {
+		err = bucket.onStored(key, value, [][]byte{key}, stored)
+		if err != nil {
+			return 0, err
 		}
+		bucket.tx.journalKey(bucket, key)
+		return version, bucket.b.Put(key, stored)
 	}
 
-	// Iterate.
-	for iter.IsValid() {
-		// Call callback.
-		stop, err := cb(iter)
+	// Remove copies of the key encrypted with other keys before storing the new one.
+	encodedKeys := bucket.keys.encodings(bucket.path, key)
+	err = bucket.onStored(key, value, encodedKeys, stored)
+	if err != nil {
+		return 0, err
+	}
+	for _, encodedKey := range encodedKeys[1:] {
+		bucket.tx.journalKey(bucket, encodedKey)
+		err = bucket.b.Delete(encodedKey)
 		if err != nil {
-			return err
-		}
-		if stop {
-			break
+			return 0, err
 		}
+	}
+	bucket.tx.journalKey(bucket, encodedKeys[0])
+	return version, bucket.b.Put(encodedKeys[0], stored)
+}
 
-		// Advance to the next item.
-		if !opts.Reverse {
-			_ = iter.Next()
-		} else {
-			_ = iter.Prev()
-		}
+func (bucket *Bucket) get(key []byte) ([]byte, error) {
+	payload, err := bucket.getPayload(key)
+	if err != nil || payload == nil || !bucket.versioned {
+		return payload, err
+	}
+	_, value, err := splitVersion(payload)
+	return value, err
+}
 
-		// If we passed a prefix as an option, check if it has it.
-		if iter.IsValid() && len(opts.Prefix) > 0 {
-			if !bytes.HasPrefix(iter.Key(), opts.Prefix) {
-				break
-			}
-		}
+// getPayload returns the decoded value of a key, which includes the version header on versioned buckets.
+func (bucket *Bucket) getPayload(key []byte) ([]byte, error) {
+	stored := bucket.lookup(key)
+	if stored == nil || len(bucket.codec) == 0 {
+		return stored, nil
 	}
+	value, _, err := bucket.codec.decode(bucket.path, key, stored)
+	return value, err
+}
 
-	// Done
-	return nil
+// storedVersion returns the current version of a key or zero if it does not exist or cannot be decoded.
+func (bucket *Bucket) storedVersion(key []byte) Version {
+	payload, err := bucket.getPayload(key)
+	if err != nil || payload == nil {
+		return 0
+	}
+	version, _, err := splitVersion(payload)
+	if err != nil {
+		return 0
+	}
+	return version
 }
 
-func (bucket *Bucket) Stats() BucketStats {
-	return bucket.b.Stats()
+// decodeStored decodes a value as stored in the bucket.
+func (bucket *Bucket) decodeStored(key []byte, stored []byte) ([]byte, error) {
+	var err error
+
+	value := stored
+	if len(bucket.codec) > 0 {
+		value, _, err = bucket.codec.decode(bucket.path, key, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if bucket.versioned {
+		_, value, err = splitVersion(value)
+	}
+	return value, err
+}
+
+// lookup returns the stored value of a key, trying all the known encryptions of the key if needed.
+func (bucket *Bucket) lookup(key []byte) []byte {
+	if bucket.keys == nil {
+		return bucket.b.Get(key)
+	}
+	for _, encodedKey := range bucket.keys.encodings(bucket.path, key) {
+		if stored := bucket.b.Get(encodedKey); stored != nil {
+			return stored
+		}
+	}
+	return nil
 }
@@ -0,0 +1,138 @@
+// See the LICENSE file for license details.
+
+package boltdb
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// -----------------------------------------------------------------------------
+
+// BucketOptions specifies settings that apply to the buckets matching a path pattern.
+type BucketOptions struct {
+	// Path is the slash-separated path of the buckets the options apply to. A fragment equal to "*"
+	// matches any bucket name at that level, for e.g., "tenants/*/users".
+	Path string
+
+	// EncryptKeys enables the deterministic encryption of keys. Exact lookups keep working but keys are no
+	// longer sorted, so range and prefix seeks fail with ErrUnsupportedSeek. Nested bucket names are not
+	// encrypted. It requires Options.Encryption to be set.
+	EncryptKeys bool
+
+	// Compression, if set, enables the compression of values. Values stored before compression was
+	// enabled cannot be read afterwards.
+	Compression *CompressionOptions
+
+	// Checksum, if set, stores a checksum along with each value which is verified when the value is read.
+	// Values stored before checksums were enabled fail the verification.
+	Checksum ChecksumAlgorithm
+
+	// Versioned stores a version along with each value, which changes on every write. Use
+	// Bucket.GetVersioned and Bucket.PutIfVersion for optimistic concurrency. Values stored before
+	// versioning was enabled cannot be read afterwards.
+	Versioned bool
+
+	// History, if set, keeps the values that are overwritten or deleted, along with the time they were
+	// replaced, so they can be read with Bucket.GetAt, Bucket.History and Bucket.RangeAt. It cannot be
+	// combined with EncryptKeys.
+	History *HistoryOptions
+}
+
+type bucketPattern struct {
+	fragments   [][]byte
+	opts        BucketOptions
+	compression *compressionCodec
+	checksum    *checksumCodec
+}
+
+// -----------------------------------------------------------------------------
+
+func newBucketPatterns(opts Options) ([]bucketPattern, error) {
+	patterns := make([]bucketPattern, 0, len(opts.Buckets))
+	for _, bucketOpts := range opts.Buckets {
+		fragments, err := splitPath([]byte(bucketOpts.Path))
+		if err != nil {
+			return nil, fmt.Errorf("%w [path=%q]", ErrInvalidBucketOptions, bucketOpts.Path)
+		}
+		if bucketOpts.EncryptKeys && opts.Encryption == nil {
+			return nil, fmt.Errorf("%w [path=%q]: key encryption requires encryption options",
+				ErrInvalidBucketOptions, bucketOpts.Path)
+		}
+		if bucketOpts.EncryptKeys && bucketOpts.History != nil {
+			return nil, fmt.Errorf("%w [path=%q]: history cannot be kept on buckets with encrypted keys",
+				ErrInvalidBucketOptions, bucketOpts.Path)
+		}
+
+		pattern := bucketPattern{
+			fragments: fragments,
+			opts:      bucketOpts,
+		}
+		if bucketOpts.Compression != nil {
+			pattern.compression, err = newCompressionCodec(bucketOpts.Compression)
+			if err != nil {
+				return nil, fmt.Errorf("%w [path=%q]", err, bucketOpts.Path)
+			}
+		}
+		if bucketOpts.Checksum != ChecksumNone {
+			pattern.checksum, err = newChecksumCodec(bucketOpts.Checksum)
+			if err != nil {
+				return nil, fmt.Errorf("%w [path=%q]", err, bucketOpts.Path)
+			}
+		}
+		patterns = append(patterns, pattern)
+	}
+
+	// Done
+	return patterns, nil
+}
+
+// bucketPattern returns the first pattern matching the given bucket path or nil if none.
+func (db *DB) bucketPattern(path []byte) *bucketPattern {
+	if len(db.bucketPatterns) == 0 {
+		return nil
+	}
+
+	fragments, err := splitPath(path)
+	if err != nil {
+		return nil
+	}
+	for idx := range db.bucketPatterns {
+		if db.bucketPatterns[idx].match(fragments) {
+			return &db.bucketPatterns[idx]
+		}
+	}
+
+	// Done
+	return nil
+}
+
+func (db *DB) hasChecksums() bool {
+	for idx := range db.bucketPatterns {
+		if db.bucketPatterns[idx].checksum != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (db *DB) hasHistory() bool {
+	for idx := range db.bucketPatterns {
+		if db.bucketPatterns[idx].opts.History != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *bucketPattern) match(fragments [][]byte) bool {
+	if len(fragments) != len(p.fragments) {
+		return false
+	}
+	for idx, fragment := range p.fragments {
+		if !(len(fragment) == 1 && fragment[0] == '*') && !bytes.Equal(fragment, fragments[idx]) {
+			return false
+		}
+	}
+	return true
+}
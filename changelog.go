@@ -0,0 +1,176 @@
+// See the LICENSE file for license details.
+
+package boltdb
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// -----------------------------------------------------------------------------
+
+// ChangeOperation identifies the kind of change recorded in the change log.
+type ChangeOperation string
+
+const (
+	ChangePut          ChangeOperation = "put"
+	ChangeDelete       ChangeOperation = "delete"
+	ChangeCreateBucket ChangeOperation = "create-bucket"
+	ChangeDeleteBucket ChangeOperation = "delete-bucket"
+	ChangeSetSequence  ChangeOperation = "set-sequence"
+)
+
+var metaChangeLogBucket = []byte("changelog")
+
+// ChangeLogOptions enables the change log, which records the committed changes so they can be shipped
+// to followers.
+type ChangeLogOptions struct {
+	// MaxEntries, if not zero, sets the number of entries kept. Followers falling further behind are
+	// bootstrapped from a snapshot.
+	MaxEntries uint64
+}
+
+// ChangeEntry is a change recorded in the change log. Keys and values are stored as in the database, so
+// they remain encrypted if encryption is enabled.
+type ChangeEntry struct {
+	Sequence  uint64          `json:"seq"`
+	Time      time.Time       `json:"time"`
+	Operation ChangeOperation `json:"op"`
+
+	// Path contains the bucket path fragments. Paths starting with the reserved metadata bucket carry
+	// the metadata shipped to followers, such as the access control policy, the schema version, the
+	// version clock and the tracked usage and quotas.
+	Path [][]byte `json:"path"`
+
+	Key   []byte `json:"key,omitempty"`
+	Value []byte `json:"value,omitempty"`
+
+	// BucketSequence is the new sequence of the bucket on set-sequence changes.
+	BucketSequence uint64 `json:"bucket_seq,omitempty"`
+}
+
+// -----------------------------------------------------------------------------
+
+// ChangeLogHead returns the sequence number of the last change recorded in the change log, or zero if
+// it is empty.
+func (db *DB) ChangeLogHead() (uint64, error) {
+	var head uint64
+
+	err := db.WithinTx(TxOptions{ReadOnly: true}, func(tx *TX) error {
+		head, _ = tx.changeLogBounds()
+		return nil
+	})
+
+	// Done
+	return head, err
+}
+
+// Changes calls the callback, in order, with the changes recorded after the given sequence number.
+func (db *DB) Changes(after uint64, cb func(entry ChangeEntry) (stop bool, err error)) error {
+	return db.WithinTx(TxOptions{ReadOnly: true}, func(tx *TX) error {
+		return tx.changesAfter(after, cb)
+	})
+}
+
+// -----------------------------------------------------------------------------
+
+// changeLogBucket returns the bucket containing the change log or nil if it does not exist.
+func (tx *TX) changeLogBucket() *bbolt.Bucket {
+	meta := tx.tx.Bucket(metaBucketName)
+	if meta == nil {
+		return nil
+	}
+	return meta.Bucket(metaChangeLogBucket)
+}
+
+// changeLogBounds returns the sequence numbers of the last and the first entries of the change log.
+func (tx *TX) changeLogBounds() (head uint64, first uint64) {
+	changeLog := tx.changeLogBucket()
+	if changeLog == nil {
+		return 0, 0
+	}
+	c := changeLog.Cursor()
+	if k, _ := c.Last(); k != nil {
+		head = binary.BigEndian.Uint64(k)
+	}
+	if k, _ := c.First(); k != nil {
+		first = binary.BigEndian.Uint64(k)
+	}
+	return head, first
+}
+
+// changesAfter calls the callback with the changes recorded after the given sequence number.
+func (tx *TX) changesAfter(after uint64, cb func(entry ChangeEntry) (bool, error)) error {
+	changeLog := tx.changeLogBucket()
+	if changeLog == nil {
+		return nil
+	}
+
+	c := changeLog.Cursor()
+	for k, v := c.Seek(binary.BigEndian.AppendUint64(nil, after+1)); k != nil; k, v = c.Next() {
+		var entry ChangeEntry
+
+		err := json.Unmarshal(v, &entry)
+		if err != nil {
+			return err
+		}
+		stop, err := cb(entry)
+		if err != nil || stop {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendChange adds an entry to the change log if enabled.
+func (tx *TX) appendChange(entry ChangeEntry) error {
+	if tx.db.changeLog == nil {
+		return nil
+	}
+
+	changeLog, err := tx.metaSubBucket(metaChangeLogBucket)
+	if err != nil {
+		return err
+	}
+	fragments := [][]byte{metaBucketName, metaChangeLogBucket}
+
+	entry.Sequence = 1
+	if k, _ := changeLog.Cursor().Last(); k != nil {
+		entry.Sequence = binary.BigEndian.Uint64(k) + 1
+	}
+	entry.Time = time.Now().UTC()
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	k := binary.BigEndian.AppendUint64(nil, entry.Sequence)
+	tx.journalRawKey(fragments, changeLog, k)
+	err = changeLog.Put(k, encoded)
+	if err != nil {
+		return err
+	}
+
+	// Trim old entries.
+	if maxEntries := tx.db.changeLog.MaxEntries; maxEntries > 0 && entry.Sequence > maxEntries {
+		var expired [][]byte
+
+		threshold := entry.Sequence - maxEntries
+		c := changeLog.Cursor()
+		for k, _ := c.First(); k != nil && binary.BigEndian.Uint64(k) <= threshold; k, _ = c.Next() {
+			expired = append(expired, cloneBytes(k))
+		}
+		for _, k = range expired {
+			tx.journalRawKey(fragments, changeLog, k)
+			err = changeLog.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Done
+	return nil
+}
@@ -0,0 +1,449 @@
+// See the LICENSE file for license details.
+
+package boltdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"go.etcd.io/bbolt"
+)
+
+// -----------------------------------------------------------------------------
+
+// FindingKind classifies an issue found while checking a database.
+type FindingKind string
+
+const (
+	// FindingStructure is an inconsistency reported by the BoltDB page-level consistency check.
+	FindingStructure FindingKind = "structure"
+
+	// FindingUnreadable indicates a bucket or key range cannot be traversed because of damaged pages.
+	FindingUnreadable FindingKind = "unreadable"
+
+	// FindingUnaddressableBucket indicates a bucket whose name is empty or contains a slash, so it
+	// cannot be reached using wrapper paths.
+	FindingUnaddressableBucket FindingKind = "unaddressable-bucket"
+
+	// FindingChecksum indicates a value that does not match its checksum.
+	FindingChecksum FindingKind = "checksum"
+
+	// FindingStoreIndex indicates a Store index entry that references a missing record or an index
+	// whose number of entries does not match the number of records.
+	FindingStoreIndex FindingKind = "store-index"
+
+	// FindingBlobRefCount indicates a blob chunk whose reference count does not match the manifests
+	// referencing it, or a chunk referenced by a manifest that does not exist.
+	FindingBlobRefCount FindingKind = "blob-refcount"
+
+	// FindingUsage indicates a tracked usage counter that does not match the content of its bucket.
+	FindingUsage FindingKind = "usage"
+
+	// FindingAuditChain indicates a broken audit chain.
+	FindingAuditChain FindingKind = "audit-chain"
+)
+
+// Finding describes an issue found while checking a database.
+type Finding struct {
+	Kind    FindingKind
+	Path    []byte
+	Key     []byte
+	Message string
+}
+
+// Report contains the result of a database check. Buckets and Keys count user data only, the reserved
+// metadata bucket is checked but not counted.
+type Report struct {
+	Findings []Finding
+	Buckets  int
+	Keys     int
+}
+
+// SalvageReport contains the result of a salvage operation.
+type SalvageReport struct {
+	// Findings lists the damaged areas that could not be fully copied.
+	Findings []Finding
+	Buckets  int
+	Keys     int
+}
+
+// checker validates wrapper-level invariants within a read-only transaction.
+type checker func(ctx context.Context, tx *TX, report *Report) error
+
+type salvager struct {
+	dst    *bbolt.Tx
+	report SalvageReport
+}
+
+// -----------------------------------------------------------------------------
+
+// OK returns true if no issues were found.
+func (r *Report) OK() bool {
+	return len(r.Findings) == 0
+}
+
+// String returns a human-readable description of the finding.
+func (f Finding) String() string {
+	s := string(f.Kind)
+	if len(f.Path) > 0 {
+		s += fmt.Sprintf(" [path=%q", f.Path)
+		if len(f.Key) > 0 {
+			s += fmt.Sprintf(" key=%q", f.Key)
+		}
+		s += "]"
+	}
+	if len(f.Message) > 0 {
+		s += ": " + f.Message
+	}
+	return s
+}
+
+// Check runs the BoltDB consistency check over all pages of the database and validates the invariants
+// maintained by this wrapper: checksums, Store indexes, blob reference counts, tracked usage and the
+// audit chain. Stores and blob stores are recognized by the layout of their buckets. Issues are returned
+// as findings in the report. An error is only returned if the check itself could not be completed.
+func (db *DB) Check(ctx context.Context) (Report, error) {
+	var report Report
+
+	err := db.WithinTx(TxOptions{ReadOnly: true}, func(tx *TX) error {
+		// Walk the bucket tree first. BoltDB panics when it finds a damaged page and the panic cannot be
+		// recovered if it happens inside the page-level check goroutine, so that check only runs if every
+		// page is readable.
+		err := runChecker(ctx, tx, &report, checkBucketTree)
+		if err != nil {
+			return err
+		}
+		if !report.hasFinding(FindingUnreadable) && tx.db.hasChecksums() {
+			err = runChecker(ctx, tx, &report, checkChecksums)
+			if err != nil {
+				return err
+			}
+		}
+		if report.hasFinding(FindingUnreadable) {
+			report.Findings = append(report.Findings, Finding{
+				Kind:    FindingStructure,
+				Message: "page-level check skipped because of unreadable pages",
+			})
+			return nil
+		}
+		for _, chk := range []checker{checkStoreIndexes, checkBlobRefCounts, checkUsage, checkAuditChain} {
+			err = runChecker(ctx, tx, &report, chk)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Run the page-level check. The channel must be drained even if the context is cancelled because
+		// the check goroutine reads pages owned by the transaction.
+		var ctxErr error
+
+		for err = range tx.tx.Check() {
+			if ctxErr == nil {
+				ctxErr = ctx.Err()
+			}
+			if ctxErr == nil {
+				report.Findings = append(report.Findings, Finding{
+					Kind:    FindingStructure,
+					Message: err.Error(),
+				})
+			}
+		}
+
+		// Done
+		return ctxErr
+	})
+
+	// Done
+	return report, err
+}
+
+// Salvage copies every readable bucket and key of a possibly damaged database into a new database file.
+// The source file is opened read-only and its raw content is copied as-is. Areas that cannot be read
+// are reported as findings.
+func Salvage(srcFilename string, dstFilename string, opts Options) (SalvageReport, error) {
+	var s salvager
+
+	// Open the source database.
+	src, err := bbolt.Open(srcFilename, 0600, &bbolt.Options{
+		ReadOnly: true,
+		Timeout:  opts.Timeout,
+	})
+	if err != nil {
+		return SalvageReport{}, err
+	}
+	defer func() {
+		_ = src.Close()
+	}()
+
+	// Refuse to overwrite an existing file.
+	if _, err = os.Stat(dstFilename); err == nil {
+		return SalvageReport{}, os.ErrExist
+	}
+	opts.ReadOnly = false
+	dst, err := NewWithOptions(dstFilename, opts)
+	if err != nil {
+		return SalvageReport{}, err
+	}
+	defer dst.Close()
+
+	// Copy everything within a single pair of transactions.
+	err = src.View(func(srcTx *bbolt.Tx) error {
+		return dst.db.Update(func(dstTx *bbolt.Tx) error {
+			s.dst = dstTx
+			s.salvageBucket(srcTx.Cursor(), nil)
+			return nil
+		})
+	})
+
+	// Done
+	return s.report, err
+}
+
+// -----------------------------------------------------------------------------
+
+func (r *Report) hasFinding(kind FindingKind) bool {
+	for idx := range r.Findings {
+		if r.Findings[idx].Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// runChecker executes a checker, reporting damaged pages found while traversing the database.
+func runChecker(ctx context.Context, tx *TX, report *Report, chk checker) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			report.Findings = append(report.Findings, Finding{
+				Kind:    FindingUnreadable,
+				Message: fmt.Sprint(r),
+			})
+			err = nil
+		}
+	}()
+
+	return chk(ctx, tx, report)
+}
+
+// checkBucketTree walks all buckets and keys verifying they are readable and reachable using paths.
+func checkBucketTree(ctx context.Context, tx *TX, report *Report) error {
+	var walk func(b *bbolt.Bucket, c *bbolt.Cursor, path []byte, count bool) error
+
+	walk = func(b *bbolt.Bucket, c *bbolt.Cursor, path []byte, count bool) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				report.Findings = append(report.Findings, Finding{
+					Kind:    FindingUnreadable,
+					Path:    cloneBytes(path),
+					Message: fmt.Sprint(r),
+				})
+				err = nil
+			}
+		}()
+
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if v != nil {
+				if count {
+					report.Keys += 1
+				}
+				continue
+			}
+
+			err = ctx.Err()
+			if err != nil {
+				return err
+			}
+
+			// The reserved bucket is walked to find unreadable pages but is not counted, like in the size
+			// reports.
+			childCount := count && (b != nil || !isReservedBucketName(k))
+			if childCount {
+				report.Buckets += 1
+			}
+			childPath := k
+			if len(path) > 0 {
+				childPath = append(append(cloneBytes(path), '/'), k...)
+			}
+			if len(k) == 0 || bytes.IndexByte(k, '/') >= 0 {
+				report.Findings = append(report.Findings, Finding{
+					Kind:    FindingUnaddressableBucket,
+					Path:    cloneBytes(childPath),
+					Message: fmt.Sprintf("bucket name %q cannot be used in a path", k),
+				})
+			}
+
+			var child *bbolt.Bucket
+			if b == nil {
+				child = tx.tx.Bucket(k)
+			} else {
+				child = b.Bucket(k)
+			}
+			if child != nil {
+				err = walk(child, child.Cursor(), childPath, childCount)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		// Done
+		return nil
+	}
+
+	return walk(nil, tx.tx.Cursor(), nil, true)
+}
+
+// walkBuckets calls the callback with the path fragments of every bucket reachable using paths, parents
+// first. The reserved bucket is skipped.
+func walkBuckets(ctx context.Context, tx *TX, cb func(fragments [][]byte, b *bbolt.Bucket) error) error {
+	var walk func(parent *bbolt.Bucket, c *bbolt.Cursor, fragments [][]byte) error
+
+	walk = func(parent *bbolt.Bucket, c *bbolt.Cursor, fragments [][]byte) error {
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if v != nil || len(k) == 0 || bytes.IndexByte(k, '/') >= 0 || (parent == nil && isReservedBucketName(k)) {
+				continue
+			}
+			err := ctx.Err()
+			if err != nil {
+				return err
+			}
+
+			var child *bbolt.Bucket
+			if parent == nil {
+				child = tx.tx.Bucket(k)
+			} else {
+				child = parent.Bucket(k)
+			}
+			if child == nil {
+				continue
+			}
+			childFragments := appendPath(fragments, cloneBytes(k))
+			err = cb(childFragments, child)
+			if err == nil {
+				err = walk(child, child.Cursor(), childFragments)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		// Done
+		return nil
+	}
+
+	return walk(nil, tx.tx.Cursor(), nil)
+}
+
+// salvageBucket copies the content of the bucket the cursor belongs to. Traversal stops at the first
+// damaged page and then continues backwards from the end of the bucket.
+func (s *salvager) salvageBucket(c *bbolt.Cursor, dstPath [][]byte) {
+	var lastForwardKey []byte
+
+	ok := s.salvageRange(c, dstPath, func() ([]byte, []byte) {
+		return c.First()
+	}, c.Next, func(key []byte) bool {
+		lastForwardKey = cloneBytes(key)
+		return true
+	})
+	if ok {
+		return
+	}
+
+	// Retry from the end, stopping when reaching a key already copied.
+	_ = s.salvageRange(c, dstPath, func() ([]byte, []byte) {
+		return c.Last()
+	}, c.Prev, func(key []byte) bool {
+		return lastForwardKey == nil || bytes.Compare(key, lastForwardKey) > 0
+	})
+}
+
+func (s *salvager) salvageRange(
+	c *bbolt.Cursor, dstPath [][]byte, first func() ([]byte, []byte), next func() ([]byte, []byte),
+	accept func(key []byte) bool,
+) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.report.Findings = append(s.report.Findings, Finding{
+				Kind:    FindingUnreadable,
+				Path:    joinPath(dstPath),
+				Message: fmt.Sprint(r),
+			})
+			ok = false
+		}
+	}()
+
+	for k, v := first(); k != nil; k, v = next() {
+		if !accept(k) {
+			break
+		}
+
+		childPath := appendPath(dstPath, cloneBytes(k))
+		if v != nil {
+			if s.copyKey(dstPath, k, v) {
+				s.report.Keys += 1
+			}
+			continue
+		}
+
+		child := c.Bucket().Bucket(k)
+		if child == nil {
+			continue
+		}
+		if s.createBucket(childPath, child.Sequence()) {
+			s.report.Buckets += 1
+		}
+		s.salvageBucket(child.Cursor(), childPath)
+	}
+
+	// Done
+	return true
+}
+
+func (s *salvager) createBucket(path [][]byte, sequence uint64) bool {
+	b, err := s.dst.CreateBucketIfNotExists(path[0])
+	for idx := 1; err == nil && idx < len(path); idx++ {
+		b, err = b.CreateBucketIfNotExists(path[idx])
+	}
+	if err == nil {
+		err = b.SetSequence(sequence)
+	}
+	if err != nil {
+		s.report.Findings = append(s.report.Findings, Finding{
+			Kind:    FindingUnreadable,
+			Path:    joinPath(path),
+			Message: err.Error(),
+		})
+		return false
+	}
+	return true
+}
+
+func (s *salvager) copyKey(path [][]byte, key []byte, value []byte) bool {
+	var b *bbolt.Bucket
+	var err error
+
+	if len(path) > 0 {
+		b = s.dst.Bucket(path[0])
+		for idx := 1; b != nil && idx < len(path); idx++ {
+			b = b.Bucket(path[idx])
+		}
+	}
+	if b != nil {
+		err = b.Put(cloneBytes(key), cloneBytes(value))
+	} else {
+		err = errors.New("destination bucket not found")
+	}
+	if err != nil {
+		s.report.Findings = append(s.report.Findings, Finding{
+			Kind:    FindingUnreadable,
+			Path:    joinPath(path),
+			Key:     cloneBytes(key),
+			Message: err.Error(),
+		})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,273 @@
+// See the LICENSE file for license details.
+
+package boltdb_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mxmauro/boltdb/v3"
+	"go.etcd.io/bbolt"
+)
+
+// -----------------------------------------------------------------------------
+
+func TestCheckHealthyDatabase(t *testing.T) {
+	db := openTestDb(t)
+	defer db.Close()
+
+	seedDumpTestDb(t, db)
+
+	// Store the schema version so the reserved bucket exists. It must not be counted.
+	_, err := db.Migrate([]boltdb.Migration{{
+		Version: 1,
+		Up: func(tx *boltdb.TX) error {
+			return nil
+		},
+	}}, boltdb.MigrateOptions{})
+	if err != nil {
+		t.Fatalf("cannot apply migrations [err=%v]", err.Error())
+	}
+
+	report, err := db.Check(context.Background())
+	if err != nil {
+		t.Fatalf("cannot check database [err=%v]", err.Error())
+	}
+	if !report.OK() {
+		t.Fatalf("unexpected findings [got=%v]", report.Findings)
+	}
+	if report.Buckets != 3 || report.Keys != 4 {
+		t.Fatalf("unexpected check counters [buckets=%d keys=%d]", report.Buckets, report.Keys)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = db.Check(ctx); err == nil {
+		t.Fatalf("expected check to fail with a cancelled context")
+	}
+}
+
+func TestCheckAndSalvageDamagedDatabase(t *testing.T) {
+	const keyCount = 2000
+
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "damaged.db")
+
+	db, err := boltdb.New(filename)
+	if err != nil {
+		t.Fatalf("cannot create test database [err=%v]", err.Error())
+	}
+	err = db.WithinTx(boltdb.TxOptions{}, func(tx *boltdb.TX) error {
+		b, err2 := tx.Bucket([]byte("data"))
+		if err2 != nil {
+			return err2
+		}
+		for i := 0; i < keyCount; i++ {
+			err2 = b.Put([]byte(fmt.Sprintf("key-%06d", i)), []byte(fmt.Sprintf("value-%06d-%050d", i, i)))
+			if err2 != nil {
+				return err2
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		t.Fatalf("cannot seed test database [err=%v]", err.Error())
+	}
+	db.Close()
+
+	// Damage the header of a leaf page in the middle of the bucket.
+	corruptLeafPage(t, filename)
+
+	db, err = boltdb.NewWithOptions(filename, boltdb.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("cannot reopen damaged database [err=%v]", err.Error())
+	}
+	report, err := db.Check(context.Background())
+	db.Close()
+	if err != nil {
+		t.Fatalf("cannot check database [err=%v]", err.Error())
+	}
+	if report.OK() {
+		t.Fatalf("expected findings for a damaged database")
+	}
+
+	salvageFilename := filepath.Join(dir, "salvaged.db")
+	salvageReport, err := boltdb.Salvage(filename, salvageFilename, boltdb.Options{})
+	if err != nil {
+		t.Fatalf("cannot salvage database [err=%v]", err.Error())
+	}
+	if len(salvageReport.Findings) == 0 {
+		t.Fatalf("expected salvage findings for a damaged database")
+	}
+	if salvageReport.Keys == 0 || salvageReport.Keys >= keyCount {
+		t.Fatalf("unexpected amount of salvaged keys [got=%d]", salvageReport.Keys)
+	}
+
+	salvaged, err := boltdb.New(salvageFilename)
+	if err != nil {
+		t.Fatalf("cannot open salvaged database [err=%v]", err.Error())
+	}
+	defer salvaged.Close()
+
+	for _, idx := range []int{0, keyCount - 1} {
+		value, err := salvaged.Get([]byte("data"), []byte(fmt.Sprintf("key-%06d", idx)))
+		if err != nil || value == nil {
+			t.Fatalf("expected key %d to be salvaged [err=%v]", idx, err)
+		}
+	}
+	report, err = salvaged.Check(context.Background())
+	if err != nil || !report.OK() {
+		t.Fatalf("salvaged database is not consistent [err=%v findings=%v]", err, report.Findings)
+	}
+
+	if _, err = boltdb.Salvage(filename, salvageFilename, boltdb.Options{}); !os.IsExist(err) {
+		t.Fatalf("expected salvage to refuse overwriting a file [got=%v]", err)
+	}
+}
+
+func TestCheckWrapperInvariants(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	db, err := boltdb.NewWithOptions(filename, boltdb.Options{Audit: true})
+	if err != nil {
+		t.Fatalf("cannot create test database [err=%v]", err.Error())
+	}
+
+	store, err := boltdb.NewStore[testUser]([]byte("app/users"))
+	if err != nil {
+		db.Close()
+		t.Fatalf("cannot create store [err=%v]", err.Error())
+	}
+	err = db.WithinTx(boltdb.TxOptions{}, func(tx *boltdb.TX) error {
+		for _, email := range []string{"alice@example.com", "bob@example.com"} {
+			if err2 := store.Save(tx, &testUser{Email: email, Country: "AR"}); err2 != nil {
+				return err2
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		var blobs *boltdb.BlobStore
+
+		blobs, err = db.BlobStore([]byte("files"), boltdb.BlobStoreOptions{ChunkSize: 16})
+		if err == nil {
+			_, err = blobs.Put([]byte("a.bin"), bytes.NewReader(make([]byte, 40)), "")
+		}
+	}
+	if err == nil {
+		err = db.SetQuota([]byte("logs"), boltdb.Quota{})
+	}
+	if err == nil {
+		err = db.Put([]byte("logs"), []byte("k"), []byte("v"))
+	}
+	if err != nil {
+		db.Close()
+		t.Fatalf("cannot seed test database [err=%v]", err)
+	}
+
+	report, err := db.Check(context.Background())
+	db.Close()
+	if err != nil || !report.OK() {
+		t.Fatalf("unexpected check result [findings=%v err=%v]", report.Findings, err)
+	}
+
+	// Break every invariant bypassing the wrapper.
+	raw, err := bbolt.Open(filename, 0600, nil)
+	if err != nil {
+		t.Fatalf("cannot open database [err=%v]", err)
+	}
+	err = raw.Update(func(tx *bbolt.Tx) error {
+		data := tx.Bucket([]byte("app")).Bucket([]byte("users")).Bucket([]byte("data"))
+		k, _ := data.Cursor().First()
+		if err2 := data.Delete(k); err2 != nil {
+			return err2
+		}
+		refs := tx.Bucket([]byte("files")).Bucket([]byte("refs"))
+		k, v := refs.Cursor().First()
+		if err2 := refs.Put(k, append([]byte{5}, v[1:]...)); err2 != nil {
+			return err2
+		}
+		if err2 := tx.Bucket([]byte("logs")).Put([]byte("other"), []byte("v")); err2 != nil {
+			return err2
+		}
+		audit := tx.Bucket([]byte("\x00boltdb")).Bucket([]byte("audit"))
+		k, _ = audit.Cursor().First()
+		return audit.Delete(k)
+	})
+	_ = raw.Close()
+	if err != nil {
+		t.Fatalf("cannot tamper with the database [err=%v]", err)
+	}
+
+	db, err = boltdb.NewWithOptions(filename, boltdb.Options{Audit: true})
+	if err != nil {
+		t.Fatalf("cannot open test database [err=%v]", err.Error())
+	}
+	defer db.Close()
+
+	report, err = db.Check(context.Background())
+	if err != nil {
+		t.Fatalf("cannot check database [err=%v]", err.Error())
+	}
+	kinds := make(map[boltdb.FindingKind]int)
+	for _, finding := range report.Findings {
+		kinds[finding.Kind] += 1
+	}
+	// The four indexes reference the deleted record and have one entry too many.
+	if len(kinds) != 4 || kinds[boltdb.FindingStoreIndex] != 8 || kinds[boltdb.FindingBlobRefCount] != 1 ||
+		kinds[boltdb.FindingUsage] != 1 || kinds[boltdb.FindingAuditChain] != 1 {
+		t.Fatalf("unexpected findings [got=%v]", report.Findings)
+	}
+}
+
+func corruptLeafPage(t *testing.T, filename string) {
+	t.Helper()
+
+	var leafPages []int
+	var pageSize int
+
+	raw, err := bbolt.Open(filename, 0600, &bbolt.Options{ReadOnly: true, PreLoadFreelist: true})
+	if err != nil {
+		t.Fatalf("cannot open raw database [err=%v]", err.Error())
+	}
+	pageSize = raw.Info().PageSize
+	err = raw.View(func(tx *bbolt.Tx) error {
+		for id := 2; ; id++ {
+			info, err2 := tx.Page(id)
+			if err2 != nil {
+				return err2
+			}
+			if info == nil {
+				return nil
+			}
+			if info.Type == "leaf" {
+				leafPages = append(leafPages, id)
+			}
+		}
+	})
+	_ = raw.Close()
+	if err != nil {
+		t.Fatalf("cannot inspect raw database [err=%v]", err.Error())
+	}
+	if len(leafPages) < 3 {
+		t.Fatalf("not enough leaf pages to damage [got=%d]", len(leafPages))
+	}
+
+	f, err := os.OpenFile(filename, os.O_RDWR, 0600)
+	if err != nil {
+		t.Fatalf("cannot open database file [err=%v]", err.Error())
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	// Overwrite the page flags (located after the 8-byte page id).
+	_, err = f.WriteAt([]byte{0xde, 0xad}, int64(leafPages[len(leafPages)/2]*pageSize+8))
+	if err != nil {
+		t.Fatalf("cannot damage database file [err=%v]", err.Error())
+	}
+}
@@ -0,0 +1,236 @@
+// See the LICENSE file for license details.
+
+package boltdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+
+	"go.etcd.io/bbolt"
+)
+
+// -----------------------------------------------------------------------------
+
+// ChecksumAlgorithm specifies the algorithm used to compute value checksums.
+type ChecksumAlgorithm byte
+
+const (
+	ChecksumNone ChecksumAlgorithm = iota
+	ChecksumCRC32C
+	ChecksumFNV64a
+)
+
+// ChecksumError is returned when a stored value does not match its checksum. It matches
+// ErrChecksumMismatch when using errors.Is.
+type ChecksumError struct {
+	Path []byte
+	Key  []byte
+}
+
+// ChecksumReport contains the result of a checksum verification.
+type ChecksumReport struct {
+	// Buckets and Keys are the number of buckets and keys verified. Buckets without checksums are skipped.
+	Buckets int
+	Keys    int
+
+	Mismatches []ChecksumError
+}
+
+type checksumCodec struct {
+	algorithm ChecksumAlgorithm
+}
+
+// -----------------------------------------------------------------------------
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// -----------------------------------------------------------------------------
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("%v [path=%q key=%q]", ErrChecksumMismatch, e.Path, e.Key)
+}
+
+func (e *ChecksumError) Unwrap() error {
+	return ErrChecksumMismatch
+}
+
+// VerifyChecksums verifies the checksums of all the values stored in the bucket with the given path and
+// its nested buckets. If the path is empty, the whole database is verified.
+func (db *DB) VerifyChecksums(ctx context.Context, path []byte) (ChecksumReport, error) {
+	var report ChecksumReport
+
+	err := db.WithinTx(TxOptions{ReadOnly: true}, func(tx *TX) error {
+		return verifyChecksums(ctx, tx, path, &report)
+	})
+
+	// Done
+	return report, err
+}
+
+// -----------------------------------------------------------------------------
+
+func newChecksumCodec(algorithm ChecksumAlgorithm) (*checksumCodec, error) {
+	if algorithm != ChecksumCRC32C && algorithm != ChecksumFNV64a {
+		return nil, fmt.Errorf("%w: unsupported checksum algorithm %d", ErrInvalidBucketOptions, algorithm)
+	}
+	return &checksumCodec{
+		algorithm: algorithm,
+	}, nil
+}
+
+// encode prepends the checksum to the value. The stored format is: algorithm (1 byte) + checksum + value.
+func (c *checksumCodec) encode(_ []byte, _ []byte, value []byte) ([]byte, error) {
+	sum := computeChecksum(c.algorithm, value)
+	stored := make([]byte, 0, 1+len(sum)+len(value))
+	stored = append(append(append(stored, byte(c.algorithm)), sum...), value...)
+	return stored, nil
+}
+
+func (c *checksumCodec) decode(path []byte, key []byte, stored []byte) ([]byte, bool, error) {
+	if len(stored) > 0 {
+		algorithm := ChecksumAlgorithm(stored[0])
+		if sumSize := checksumSize(algorithm); sumSize > 0 && len(stored) >= 1+sumSize {
+			value := stored[1+sumSize:]
+			if bytes.Equal(computeChecksum(algorithm, value), stored[1:1+sumSize]) {
+				return value, algorithm != c.algorithm, nil
+			}
+		}
+	}
+	return nil, false, &ChecksumError{
+		Path: path,
+		Key:  key,
+	}
+}
+
+func checksumSize(algorithm ChecksumAlgorithm) int {
+	switch algorithm {
+	case ChecksumCRC32C:
+		return 4
+	case ChecksumFNV64a:
+		return 8
+	}
+	return 0
+}
+
+func computeChecksum(algorithm ChecksumAlgorithm, value []byte) []byte {
+	switch algorithm {
+	case ChecksumCRC32C:
+		return binary.LittleEndian.AppendUint32(nil, crc32.Checksum(value, crc32cTable))
+	case ChecksumFNV64a:
+		h := fnv.New64a()
+		_, _ = h.Write(value)
+		return h.Sum(nil)
+	}
+	return nil
+}
+
+// checksumCodec returns the checksum codec of the bucket or nil if checksums are not enabled.
+func (bucket *Bucket) checksumCodec() *checksumCodec {
+	for _, codec := range bucket.codec {
+		if checksum, ok := codec.(*checksumCodec); ok {
+			return checksum
+		}
+	}
+	return nil
+}
+
+// verifyChecksums verifies the checksums of a subtree within the given transaction.
+func verifyChecksums(ctx context.Context, tx *TX, path []byte, report *ChecksumReport) error {
+	var paths [][][]byte
+
+	if len(path) == 0 {
+		err := tx.tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+			if isReservedBucketName(name) {
+				return nil
+			}
+			paths = collectBucketPaths(paths, [][]byte{cloneBytes(name)}, b)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	} else {
+		fragments, err := splitPath(path)
+		if err != nil {
+			return err
+		}
+		b := tx.bucketFromFragments(fragments)
+		if b == nil {
+			return ErrBucketNotFound
+		}
+		paths = collectBucketPaths(paths, fragments, b.b)
+	}
+
+	for _, fragments := range paths {
+		err := ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		b := tx.bucketFromFragments(fragments)
+		if b == nil {
+			continue
+		}
+		checksum := b.checksumCodec()
+		if checksum == nil {
+			continue
+		}
+
+		report.Buckets += 1
+		c := b.b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if v == nil {
+				continue
+			}
+			key := k
+			if b.keys != nil {
+				if decodedKey, _, err2 := b.keys.decode(b.path, k); err2 == nil {
+					key = decodedKey
+				}
+			}
+
+			report.Keys += 1
+			_, _, err = checksum.decode(b.path, key, v)
+			if err != nil {
+				var checksumErr *ChecksumError
+
+				if !errors.As(err, &checksumErr) {
+					return err
+				}
+				report.Mismatches = append(report.Mismatches, ChecksumError{
+					Path: cloneBytes(checksumErr.Path),
+					Key:  cloneBytes(checksumErr.Key),
+				})
+			}
+		}
+	}
+
+	// Done
+	return nil
+}
+
+// checkChecksums reports the values that do not match their checksums.
+func checkChecksums(ctx context.Context, tx *TX, report *Report) error {
+	var checksumReport ChecksumReport
+
+	err := verifyChecksums(ctx, tx, nil, &checksumReport)
+	if err != nil {
+		return err
+	}
+	for _, mismatch := range checksumReport.Mismatches {
+		report.Findings = append(report.Findings, Finding{
+			Kind:    FindingChecksum,
+			Path:    mismatch.Path,
+			Key:     mismatch.Key,
+			Message: "value does not match its checksum",
+		})
+	}
+
+	// Done
+	return nil
+}
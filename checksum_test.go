@@ -0,0 +1,101 @@
+// See the LICENSE file for license details.
+
+package boltdb_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/mxmauro/boltdb/v3"
+)
+
+// -----------------------------------------------------------------------------
+
+func TestChecksums(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	opts := boltdb.Options{
+		Buckets: []boltdb.BucketOptions{
+			{Path: "records", Checksum: boltdb.ChecksumCRC32C},
+			{Path: "records/*", Checksum: boltdb.ChecksumFNV64a},
+		},
+	}
+
+	db, err := boltdb.NewWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("cannot create test database [err=%v]", err.Error())
+	}
+	for _, path := range []string{"records", "records/archive"} {
+		for _, key := range []string{"a", "b", "c"} {
+			if err = db.Put([]byte(path), []byte(key), []byte("value-"+key)); err != nil {
+				db.Close()
+				t.Fatalf("cannot write to test database [err=%v]", err.Error())
+			}
+		}
+	}
+	value, err := db.Get([]byte("records/archive"), []byte("b"))
+	db.Close()
+	if err != nil || string(value) != "value-b" {
+		t.Fatalf("unexpected value [got=%q err=%v]", value, err)
+	}
+
+	// Damage a stored value bypassing the checksums.
+	db, err = boltdb.New(filename)
+	if err != nil {
+		t.Fatalf("cannot open test database [err=%v]", err.Error())
+	}
+	stored, err := db.Get([]byte("records/archive"), []byte("b"))
+	if err == nil {
+		stored[len(stored)-1] ^= 0xff
+		err = db.Put([]byte("records/archive"), []byte("b"), stored)
+	}
+	db.Close()
+	if err != nil {
+		t.Fatalf("cannot damage stored value [err=%v]", err.Error())
+	}
+
+	db, err = boltdb.NewWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("cannot open test database [err=%v]", err.Error())
+	}
+	defer db.Close()
+
+	var checksumErr *boltdb.ChecksumError
+	_, err = db.Get([]byte("records/archive"), []byte("b"))
+	if !errors.Is(err, boltdb.ErrChecksumMismatch) || !errors.As(err, &checksumErr) ||
+		string(checksumErr.Path) != "records/archive" || string(checksumErr.Key) != "b" {
+		t.Fatalf("expected a checksum error [got=%v]", err)
+	}
+
+	err = db.WithinTx(boltdb.TxOptions{ReadOnly: true}, func(tx *boltdb.TX) error {
+		b, err2 := tx.Bucket([]byte("records/archive"))
+		if err2 != nil {
+			return err2
+		}
+		return b.WithIterator(boltdb.WithIteratorOptions{}, func(iter *boltdb.Iterator) (bool, error) {
+			_ = iter.Value()
+			return false, nil
+		})
+	})
+	if !errors.Is(err, boltdb.ErrChecksumMismatch) {
+		t.Fatalf("expected iteration to fail [got=%v]", err)
+	}
+
+	report, err := db.VerifyChecksums(context.Background(), []byte("records"))
+	if err != nil {
+		t.Fatalf("cannot verify checksums [err=%v]", err.Error())
+	}
+	if report.Buckets != 2 || report.Keys != 6 || len(report.Mismatches) != 1 ||
+		string(report.Mismatches[0].Key) != "b" {
+		t.Fatalf("unexpected checksum report [got=%+v]", report)
+	}
+
+	checkReport, err := db.Check(context.Background())
+	if err != nil {
+		t.Fatalf("cannot check database [err=%v]", err.Error())
+	}
+	if len(checkReport.Findings) != 1 || checkReport.Findings[0].Kind != boltdb.FindingChecksum {
+		t.Fatalf("unexpected check findings [got=%v]", checkReport.Findings)
+	}
+}
@@ -0,0 +1,498 @@
+// See the LICENSE file for license details.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mxmauro/boltdb/v3"
+)
+
+// -----------------------------------------------------------------------------
+
+var errKeyNotFound = errors.New("key not found")
+
+// -----------------------------------------------------------------------------
+
+func cmdList(ctx *commandContext) error {
+	return ctx.db.WithinTx(boltdb.TxOptions{ReadOnly: true}, func(tx *boltdb.TX) error {
+		iter, err := ctx.iterate(tx, ctx.optionalPath())
+		if err != nil {
+			return err
+		}
+		for ok := iter.First(); ok; ok = iter.Next() {
+			if iter.IsNestedBucket() {
+				_, _ = fmt.Fprintf(ctx.stdout, "%s/\n", ctx.keyFormat.encode(iter.Key()))
+			} else {
+				_, _ = fmt.Fprintf(ctx.stdout, "%s\n", ctx.keyFormat.encode(iter.Key()))
+			}
+		}
+		return nil
+	})
+}
+
+func cmdTree(ctx *commandContext) error {
+	return ctx.db.WithinTx(boltdb.TxOptions{ReadOnly: true}, func(tx *boltdb.TX) error {
+		path := ctx.optionalPath()
+		if isRootPath(path) {
+			iter := tx.Iterate()
+			for ok := iter.First(); ok; ok = iter.Next() {
+				b, err := iter.NestedBucket()
+				if err != nil {
+					return err
+				}
+				err = ctx.printTree(b, 0)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		b, err := tx.Bucket([]byte(path))
+		if err != nil {
+			return err
+		}
+		return ctx.printTree(b, 0)
+	})
+}
+
+func cmdGet(ctx *commandContext) error {
+	key, err := ctx.keyFormat.decode(ctx.args[1])
+	if err != nil {
+		return fmt.Errorf("invalid key: %w", err)
+	}
+
+	return ctx.db.WithinTx(boltdb.TxOptions{ReadOnly: true}, func(tx *boltdb.TX) error {
+		b, err := tx.Bucket([]byte(ctx.args[0]))
+		if err != nil {
+			return err
+		}
+		value := b.Get(key)
+		if value == nil {
+			return errKeyNotFound
+		}
+		_, _ = fmt.Fprintf(ctx.stdout, "%s\n", ctx.valueFormat.encode(value))
+		return nil
+	})
+}
+
+func cmdPut(ctx *commandContext) error {
+	key, err := ctx.keyFormat.decode(ctx.args[1])
+	if err != nil {
+		return fmt.Errorf("invalid key: %w", err)
+	}
+	value, err := ctx.valueFormat.decode(ctx.args[2])
+	if err != nil {
+		return fmt.Errorf("invalid value: %w", err)
+	}
+	if len(key) == 0 {
+		return errors.New("key cannot be empty")
+	}
+
+	return ctx.db.Put([]byte(ctx.args[0]), key, value)
+}
+
+func cmdRemove(ctx *commandContext) error {
+	key, err := ctx.keyFormat.decode(ctx.args[1])
+	if err != nil {
+		return fmt.Errorf("invalid key: %w", err)
+	}
+
+	// Writable transactions create missing buckets, so check the key exists beforehand.
+	value, err := ctx.db.Get([]byte(ctx.args[0]), key)
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		return errKeyNotFound
+	}
+
+	return ctx.db.Delete([]byte(ctx.args[0]), key)
+}
+
+func cmdRemoveBucket(ctx *commandContext) error {
+	path := []byte(ctx.args[0])
+
+	// Check the bucket exists so the user gets feedback about mistyped paths.
+	err := ctx.db.WithinTx(boltdb.TxOptions{ReadOnly: true}, func(tx *boltdb.TX) error {
+		_, err := tx.Bucket(path)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return ctx.db.WithinTx(boltdb.TxOptions{}, func(tx *boltdb.TX) error {
+		return tx.DeleteBucket(path)
+	})
+}
+
+func cmdStats(ctx *commandContext) error {
+	path := ctx.optionalPath()
+
+	if ctx.sizeReport {
+		report, err := ctx.db.SizeReport([]byte(path))
+		if err != nil {
+			return err
+		}
+		ctx.printSizeReport(report)
+		return nil
+	}
+	if ctx.histogram {
+		hist, err := ctx.db.SizeHistogram([]byte(path))
+		if err != nil {
+			return err
+		}
+		ctx.printHistogram("Key sizes", hist.Keys)
+		ctx.printHistogram("Value sizes", hist.Values)
+		return nil
+	}
+
+	if isRootPath(path) {
+		stats, err := ctx.db.Stats()
+		if err != nil {
+			return err
+		}
+		ctx.printStats(stats)
+	}
+
+	return ctx.db.WithinTx(boltdb.TxOptions{ReadOnly: true}, func(tx *boltdb.TX) error {
+		var stats boltdb.BucketStats
+
+		if isRootPath(path) {
+			iter := tx.Iterate()
+			for ok := iter.First(); ok; ok = iter.Next() {
+				b, err := iter.NestedBucket()
+				if err != nil {
+					return err
+				}
+				stats.Add(b.Stats())
+			}
+		} else {
+			b, err := tx.Bucket([]byte(path))
+			if err != nil {
+				return err
+			}
+			stats = b.Stats()
+		}
+
+		ctx.printBucketStats(stats)
+		return nil
+	})
+}
+
+func cmdCount(ctx *commandContext) error {
+	return ctx.db.WithinTx(boltdb.TxOptions{ReadOnly: true}, func(tx *boltdb.TX) error {
+		var count int
+
+		path := ctx.optionalPath()
+		if isRootPath(path) {
+			if !ctx.recursive {
+				return errors.New("top-level entries are buckets, use -r to count their keys")
+			}
+			iter := tx.Iterate()
+			for ok := iter.First(); ok; ok = iter.Next() {
+				b, err := iter.NestedBucket()
+				if err != nil {
+					return err
+				}
+				n, err := countKeys(b, true)
+				if err != nil {
+					return err
+				}
+				count += n
+			}
+		} else {
+			b, err := tx.Bucket([]byte(path))
+			if err != nil {
+				return err
+			}
+			count, err = countKeys(b, ctx.recursive)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, _ = fmt.Fprintf(ctx.stdout, "%d\n", count)
+		return nil
+	})
+}
+
+func cmdDump(ctx *commandContext) error {
+	opts := boltdb.DumpOptions{
+		Prefix:    []byte(ctx.prefix),
+		BatchSize: ctx.batchSize,
+	}
+	if ctx.base64 {
+		opts.Encoding = boltdb.DumpEncodingBase64
+	}
+
+	if len(ctx.args) == 0 || ctx.args[0] == "-" {
+		return ctx.db.Dump(ctx.stdout, opts)
+	}
+
+	f, err := os.Create(ctx.args[0])
+	if err != nil {
+		return err
+	}
+	err = ctx.db.Dump(f, opts)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+
+	// Done
+	return err
+}
+
+func cmdLoad(ctx *commandContext) error {
+	var r io.Reader
+
+	opts := boltdb.LoadOptions{
+		Prefix:    []byte(ctx.prefix),
+		BatchSize: ctx.batchSize,
+	}
+	switch ctx.loadMode {
+	case "merge":
+		opts.Mode = boltdb.LoadMerge
+	case "overwrite":
+		opts.Mode = boltdb.LoadOverwrite
+	case "fail":
+		opts.Mode = boltdb.LoadFailOnConflict
+	default:
+		return fmt.Errorf("unsupported load mode %q (use merge, overwrite or fail)", ctx.loadMode)
+	}
+
+	if len(ctx.args) == 0 || ctx.args[0] == "-" {
+		r = ctx.stdin
+	} else {
+		f, err := os.Open(ctx.args[0])
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		r = f
+	}
+
+	stats, err := ctx.db.Load(r, opts)
+	_, _ = fmt.Fprintf(ctx.stdout, "Loaded %d buckets and %d keys, skipped %d existing keys\n", stats.Buckets, stats.Keys, stats.Skipped)
+
+	// Done
+	return err
+}
+
+func cmdCheck(ctx *commandContext) error {
+	report, err := ctx.db.Check(context.Background())
+	if err != nil {
+		return err
+	}
+
+	for _, finding := range report.Findings {
+		_, _ = fmt.Fprintf(ctx.stdout, "%s\n", finding.String())
+	}
+	if !report.OK() {
+		return fmt.Errorf("%d issues found", len(report.Findings))
+	}
+	_, _ = fmt.Fprintf(ctx.stdout, "OK: %d buckets and %d keys checked\n", report.Buckets, report.Keys)
+
+	// Done
+	return nil
+}
+
+func cmdSalvage(ctx *commandContext) error {
+	report, err := boltdb.Salvage(ctx.filename, ctx.args[0], boltdb.Options{Timeout: ctx.timeout})
+	if err != nil {
+		return ctx.describeOpenError(err)
+	}
+
+	for _, finding := range report.Findings {
+		_, _ = fmt.Fprintf(ctx.stdout, "%s\n", finding.String())
+	}
+	_, _ = fmt.Fprintf(ctx.stdout, "Salvaged %d buckets and %d keys\n", report.Buckets, report.Keys)
+
+	// Done
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+
+func statsFlags(fs *flag.FlagSet, ctx *commandContext) {
+	fs.BoolVar(&ctx.sizeReport, "report", false, "print a recursive per-bucket size report")
+	fs.BoolVar(&ctx.histogram, "histogram", false, "print the distribution of key and value sizes")
+}
+
+func countFlags(fs *flag.FlagSet, ctx *commandContext) {
+	fs.BoolVar(&ctx.recursive, "r", false, "include keys stored in nested buckets")
+}
+
+func dumpFlags(fs *flag.FlagSet, ctx *commandContext) {
+	fs.StringVar(&ctx.prefix, "prefix", "", "only dump the bucket with this path and its nested buckets")
+	fs.BoolVar(&ctx.base64, "base64", false, "encode all keys and values in base64")
+	fs.IntVar(&ctx.batchSize, "batch-size", 0, "records read per transaction (0 dumps a consistent snapshot)")
+}
+
+func loadFlags(fs *flag.FlagSet, ctx *commandContext) {
+	fs.StringVar(&ctx.prefix, "prefix", "", "only load records of the bucket with this path and its nested buckets")
+	fs.StringVar(&ctx.loadMode, "mode", "merge", "handling of existing keys: merge, overwrite or fail")
+	fs.IntVar(&ctx.batchSize, "batch-size", 0, "records written per transaction")
+}
+
+// -----------------------------------------------------------------------------
+
+func (ctx *commandContext) optionalPath() string {
+	if len(ctx.args) > 0 {
+		return ctx.args[0]
+	}
+	return ""
+}
+
+func (ctx *commandContext) iterate(tx *boltdb.TX, path string) (*boltdb.Iterator, error) {
+	if isRootPath(path) {
+		return tx.Iterate(), nil
+	}
+	b, err := tx.Bucket([]byte(path))
+	if err != nil {
+		return nil, err
+	}
+	return b.Iterate(), nil
+}
+
+func (ctx *commandContext) printTree(b *boltdb.Bucket, depth int) error {
+	var keyCount int
+	var children []*boltdb.Bucket
+
+	iter := b.Iterate()
+	for ok := iter.First(); ok; ok = iter.Next() {
+		if iter.IsNestedBucket() {
+			child, err := iter.NestedBucket()
+			if err != nil {
+				return err
+			}
+			children = append(children, child)
+		} else {
+			keyCount += 1
+		}
+	}
+
+	_, _ = fmt.Fprintf(ctx.stdout, "%s%s/ (%d keys)\n", strings.Repeat("  ", depth), ctx.keyFormat.encode(b.Name()), keyCount)
+	for _, child := range children {
+		err := ctx.printTree(child, depth+1)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Done
+	return nil
+}
+
+func (ctx *commandContext) printStats(stats boltdb.Stats) {
+	rows := []struct {
+		name  string
+		value int64
+	}{
+		{"Read transactions", int64(stats.ReadTxN)},
+		{"Open read transactions", int64(stats.OpenTxN)},
+		{"Write transactions", stats.WriteTxN},
+		{"Page allocations", stats.PageCount},
+		{"Page bytes allocated", stats.PageAlloc},
+		{"Pages written", stats.PageWrite},
+		{"Free pages", int64(stats.FreePageN)},
+		{"Pending pages", int64(stats.PendingPageN)},
+		{"Free bytes allocated", int64(stats.FreeAlloc)},
+		{"Freelist bytes in use", int64(stats.FreelistInuse)},
+		{"Page size", int64(stats.PageSize)},
+		{"File size", stats.FileSize},
+		{"Data size", stats.DataSize},
+		{"Used size", stats.UsedSize},
+	}
+	for _, row := range rows {
+		_, _ = fmt.Fprintf(ctx.stdout, "%-27s %d\n", row.name+":", row.value)
+	}
+}
+
+func (ctx *commandContext) printSizeReport(report boltdb.BucketSizeReport) {
+	if report.Path != nil {
+		storage := fmt.Sprintf("%d leaf pages", report.Pages.LeafPageN)
+		if report.Inline {
+			storage = "inline"
+		}
+		_, _ = fmt.Fprintf(ctx.stdout, "%s%s/ keys=%d key-bytes=%d value-bytes=%d total-keys=%d total-bytes=%d depth=%d %s\n",
+			strings.Repeat("  ", report.Depth), ctx.keyFormat.encode(report.Path), report.Keys, report.KeyBytes,
+			report.ValueBytes, report.TotalKeys, report.TotalKeyBytes+report.TotalValueBytes, report.Pages.Depth, storage)
+	}
+	for _, child := range report.Children {
+		ctx.printSizeReport(child)
+	}
+}
+
+func (ctx *commandContext) printHistogram(title string, hist boltdb.Histogram) {
+	_, _ = fmt.Fprintf(ctx.stdout, "%s: count=%d total=%d max=%d\n", title, hist.Count, hist.Total, hist.Max)
+	for _, bin := range hist.Bins {
+		if bin.Count > 0 {
+			_, _ = fmt.Fprintf(ctx.stdout, "  %8d - %-8d %10d items %12d bytes\n", bin.Min, bin.Max, bin.Count, bin.Bytes)
+		}
+	}
+}
+
+func (ctx *commandContext) printBucketStats(stats boltdb.BucketStats) {
+	rows := []struct {
+		name  string
+		value int
+	}{
+		{"Keys", stats.KeyN},
+		{"Depth", stats.Depth},
+		{"Buckets", stats.BucketN},
+		{"Inline buckets", stats.InlineBucketN},
+		{"Inline bucket bytes in use", stats.InlineBucketInuse},
+		{"Branch pages", stats.BranchPageN},
+		{"Branch overflow pages", stats.BranchOverflowN},
+		{"Branch bytes allocated", stats.BranchAlloc},
+		{"Branch bytes in use", stats.BranchInuse},
+		{"Leaf pages", stats.LeafPageN},
+		{"Leaf overflow pages", stats.LeafOverflowN},
+		{"Leaf bytes allocated", stats.LeafAlloc},
+		{"Leaf bytes in use", stats.LeafInuse},
+	}
+	for _, row := range rows {
+		_, _ = fmt.Fprintf(ctx.stdout, "%-27s %d\n", row.name+":", row.value)
+	}
+}
+
+func countKeys(b *boltdb.Bucket, recursive bool) (int, error) {
+	var count int
+
+	iter := b.Iterate()
+	for ok := iter.First(); ok; ok = iter.Next() {
+		if !iter.IsNestedBucket() {
+			count += 1
+			continue
+		}
+		if recursive {
+			child, err := iter.NestedBucket()
+			if err != nil {
+				return 0, err
+			}
+			n, err := countKeys(child, true)
+			if err != nil {
+				return 0, err
+			}
+			count += n
+		}
+	}
+
+	// Done
+	return count, nil
+}
+
+func isRootPath(path string) bool {
+	return len(strings.Trim(path, "/")) == 0
+}
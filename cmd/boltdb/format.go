@@ -0,0 +1,61 @@
+// See the LICENSE file for license details.
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+)
+
+// -----------------------------------------------------------------------------
+
+type format int
+
+const (
+	formatUTF8 format = iota
+	formatHex
+	formatBase64
+)
+
+// -----------------------------------------------------------------------------
+
+func parseFormat(name string) (format, error) {
+	switch name {
+	case "utf8", "utf-8", "text":
+		return formatUTF8, nil
+	case "hex":
+		return formatHex, nil
+	case "base64", "b64":
+		return formatBase64, nil
+	}
+	return formatUTF8, fmt.Errorf("unsupported format %q (use utf8, hex or base64)", name)
+}
+
+// encode renders binary data using the format. Non-UTF-8 data rendered as text is quoted so the output
+// remains printable.
+func (f format) encode(data []byte) string {
+	switch f {
+	case formatHex:
+		return hex.EncodeToString(data)
+	case formatBase64:
+		return base64.StdEncoding.EncodeToString(data)
+	}
+	if !utf8.Valid(data) {
+		return strconv.Quote(string(data))
+	}
+	return string(data)
+}
+
+// decode parses a command-line argument using the format.
+func (f format) decode(s string) ([]byte, error) {
+	switch f {
+	case formatHex:
+		return hex.DecodeString(s)
+	case formatBase64:
+		return base64.StdEncoding.DecodeString(s)
+	}
+	return []byte(s), nil
+}
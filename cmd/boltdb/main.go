@@ -0,0 +1,183 @@
+// See the LICENSE file for license details.
+
+// Command boltdb inspects and edits databases managed by the boltdb wrapper.
+//
+// Usage:
+//
+//	boltdb <command> [flags] <database> [arguments]
+//
+// Databases are opened read-only unless the --write flag is given. Bucket paths use the same slash
+// notation as TX.Bucket, for e.g. "parent/child".
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/mxmauro/boltdb/v3"
+)
+
+// -----------------------------------------------------------------------------
+
+type command struct {
+	name     string
+	args     string
+	help     string
+	minArgs  int
+	maxArgs  int
+	writable bool
+	noOpen   bool
+	flags    func(fs *flag.FlagSet, ctx *commandContext)
+	run      func(ctx *commandContext) error
+}
+
+type commandContext struct {
+	db          *boltdb.DB
+	filename    string
+	timeout     time.Duration
+	args        []string
+	keyFormat   format
+	valueFormat format
+	recursive   bool
+	sizeReport  bool
+	histogram   bool
+	prefix      string
+	loadMode    string
+	base64      bool
+	batchSize   int
+	stdin       io.Reader
+	stdout      io.Writer
+}
+
+// -----------------------------------------------------------------------------
+
+var commands = []command{
+	{name: "ls", args: "[path]", help: "List keys and nested buckets", maxArgs: 1, run: cmdList},
+	{name: "tree", args: "[path]", help: "Print the nested bucket hierarchy", maxArgs: 1, run: cmdTree},
+	{name: "get", args: "<path> <key>", help: "Print the value of a key", minArgs: 2, maxArgs: 2, run: cmdGet},
+	{name: "put", args: "<path> <key> <value>", help: "Store a key/value pair", minArgs: 3, maxArgs: 3, writable: true, run: cmdPut},
+	{name: "rm", args: "<path> <key>", help: "Delete a key", minArgs: 2, maxArgs: 2, writable: true, run: cmdRemove},
+	{name: "rmbucket", args: "<path>", help: "Delete a bucket including its keys and nested buckets", minArgs: 1, maxArgs: 1, writable: true, run: cmdRemoveBucket},
+	{name: "stats", args: "[path]", help: "Print database and bucket statistics", maxArgs: 1, flags: statsFlags, run: cmdStats},
+	{name: "count", args: "[path]", help: "Count the keys stored in a bucket", maxArgs: 1, flags: countFlags, run: cmdCount},
+	{name: "dump", args: "[file]", help: "Export the database in JSON Lines format", maxArgs: 1, flags: dumpFlags, run: cmdDump},
+	{name: "load", args: "[file]", help: "Import a JSON Lines dump", maxArgs: 1, writable: true, flags: loadFlags, run: cmdLoad},
+	{name: "check", help: "Verify the consistency of the database", run: cmdCheck},
+	{name: "salvage", args: "<new-database>", help: "Copy every readable key into a new database", minArgs: 1, maxArgs: 1, noOpen: true, run: cmdSalvage},
+}
+
+// -----------------------------------------------------------------------------
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage(stderr)
+		return 2
+	}
+
+	// Find the command.
+	var cmd *command
+	for idx := range commands {
+		if commands[idx].name == args[0] {
+			cmd = &commands[idx]
+			break
+		}
+	}
+	if cmd == nil {
+		_, _ = fmt.Fprintf(stderr, "boltdb: unknown command %q\n\n", args[0])
+		usage(stderr)
+		return 2
+	}
+
+	// Parse flags.
+	var keyFormat, valueFormat string
+
+	ctx := commandContext{
+		stdin:  stdin,
+		stdout: stdout,
+	}
+
+	fs := flag.NewFlagSet("boltdb "+cmd.name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	write := fs.Bool("write", false, "open the database for writing")
+	timeout := fs.Duration("timeout", time.Second, "time to wait for the database file lock")
+	fs.StringVar(&keyFormat, "key-format", "utf8", "key rendering and parsing format: utf8, hex or base64")
+	fs.StringVar(&valueFormat, "value-format", "utf8", "value rendering and parsing format: utf8, hex or base64")
+	if cmd.flags != nil {
+		cmd.flags(fs, &ctx)
+	}
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(stderr, "Usage: boltdb %s [flags] <database> %s\n\n%s.\n\nFlags:\n", cmd.name, cmd.args, cmd.help)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if fs.NArg() < 1+cmd.minArgs || fs.NArg() > 1+cmd.maxArgs {
+		fs.Usage()
+		return 2
+	}
+
+	ctx.filename = fs.Arg(0)
+	ctx.args = fs.Args()[1:]
+	ctx.timeout = *timeout
+
+	var err error
+	ctx.keyFormat, err = parseFormat(keyFormat)
+	if err == nil {
+		ctx.valueFormat, err = parseFormat(valueFormat)
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "boltdb: %v\n", err)
+		return 2
+	}
+	if cmd.writable && !*write {
+		_, _ = fmt.Fprintf(stderr, "boltdb: the %s command modifies the database, use the --write flag\n", cmd.name)
+		return 2
+	}
+
+	// Open the database.
+	if !cmd.noOpen {
+		ctx.db, err = boltdb.NewWithOptions(ctx.filename, boltdb.Options{
+			ReadOnly: !*write,
+			Timeout:  ctx.timeout,
+		})
+		if err != nil {
+			_, _ = fmt.Fprintf(stderr, "boltdb: cannot open database: %v\n", ctx.describeOpenError(err))
+			return 1
+		}
+		defer ctx.db.Close()
+	}
+
+	// Execute the command.
+	err = cmd.run(&ctx)
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "boltdb: %v\n", err)
+		return 1
+	}
+
+	// Done
+	return 0
+}
+
+func (ctx *commandContext) describeOpenError(err error) error {
+	if errors.Is(err, boltdb.ErrTimeout) {
+		return fmt.Errorf("database is locked by another process (waited %v)", ctx.timeout)
+	}
+	return err
+}
+
+func usage(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "Usage: boltdb <command> [flags] <database> [arguments]\n\nCommands:\n")
+	for _, cmd := range commands {
+		_, _ = fmt.Fprintf(w, "  %-9s %-22s %s\n", cmd.name, cmd.args, cmd.help)
+	}
+	_, _ = fmt.Fprintf(w, "\nRun 'boltdb <command> -h' for the list of flags.\n")
+}
@@ -0,0 +1,142 @@
+// See the LICENSE file for license details.
+
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mxmauro/boltdb/v3"
+)
+
+// -----------------------------------------------------------------------------
+
+func TestCommands(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "cli.db")
+
+	db, err := boltdb.New(filename)
+	if err != nil {
+		t.Fatalf("cannot create test database [err=%v]", err.Error())
+	}
+	err = db.Put([]byte("users/eu"), []byte("alice"), []byte("value-alice"))
+	if err == nil {
+		err = db.Put([]byte("users/eu"), []byte("bob"), []byte("value-bob"))
+	}
+	if err == nil {
+		err = db.Put([]byte("users"), []byte("carol"), []byte{0x00, 0xff})
+	}
+	if err != nil {
+		t.Fatalf("cannot seed test database [err=%v]", err.Error())
+	}
+	db.Close()
+
+	runOk := func(args ...string) string {
+		t.Helper()
+
+		var stdout, stderr bytes.Buffer
+		if code := run(args, nil, &stdout, &stderr); code != 0 {
+			t.Fatalf("command %v failed [code=%d stderr=%q]", args, code, stderr.String())
+		}
+		return stdout.String()
+	}
+	runFail := func(code int, args ...string) string {
+		t.Helper()
+
+		var stdout, stderr bytes.Buffer
+		if got := run(args, nil, &stdout, &stderr); got != code {
+			t.Fatalf("unexpected exit code for command %v [got=%d want=%d]", args, got, code)
+		}
+		return stderr.String()
+	}
+
+	if out := runOk("ls", filename); out != "users/\n" {
+		t.Fatalf("unexpected root listing [got=%q]", out)
+	}
+	if out := runOk("ls", filename, "users"); out != "carol\neu/\n" {
+		t.Fatalf("unexpected bucket listing [got=%q]", out)
+	}
+	if out := runOk("tree", filename); out != "users/ (1 keys)\n  eu/ (2 keys)\n" {
+		t.Fatalf("unexpected tree [got=%q]", out)
+	}
+	if out := runOk("get", filename, "users/eu", "alice"); out != "value-alice\n" {
+		t.Fatalf("unexpected value [got=%q]", out)
+	}
+	if out := runOk("get", "--value-format", "hex", filename, "users", "carol"); out != "00ff\n" {
+		t.Fatalf("unexpected hex value [got=%q]", out)
+	}
+	if out := runOk("get", "--key-format", "base64", filename, "users/eu", "Ym9i"); out != "value-bob\n" {
+		t.Fatalf("unexpected value for base64 key [got=%q]", out)
+	}
+	if out := runOk("count", filename, "users"); out != "1\n" {
+		t.Fatalf("unexpected count [got=%q]", out)
+	}
+	if out := runOk("count", "-r", filename); out != "3\n" {
+		t.Fatalf("unexpected recursive count [got=%q]", out)
+	}
+	if out := runOk("check", filename); !strings.HasPrefix(out, "OK:") {
+		t.Fatalf("unexpected check output [got=%q]", out)
+	}
+	if out := runOk("salvage", filename, filepath.Join(t.TempDir(), "salvaged.db")); out != "Salvaged 2 buckets and 3 keys\n" {
+		t.Fatalf("unexpected salvage output [got=%q]", out)
+	}
+	if out := runOk("stats", filename, "users/eu"); !strings.Contains(out, "Keys:") {
+		t.Fatalf("unexpected stats output [got=%q]", out)
+	}
+	if out := runOk("stats", filename); !strings.Contains(out, "File size:") {
+		t.Fatalf("unexpected database stats output [got=%q]", out)
+	}
+	if out := runOk("stats", "-report", filename); !strings.Contains(out, "  users/eu/ keys=2") {
+		t.Fatalf("unexpected size report output [got=%q]", out)
+	}
+	if out := runOk("stats", "-histogram", filename, "users"); !strings.HasPrefix(out, "Key sizes: count=3") {
+		t.Fatalf("unexpected histogram output [got=%q]", out)
+	}
+
+	// Writes require the --write flag.
+	if out := runFail(2, "put", filename, "users", "dave", "value-dave"); !strings.Contains(out, "--write") {
+		t.Fatalf("unexpected error message [got=%q]", out)
+	}
+	runOk("put", "--write", filename, "users", "dave", "value-dave")
+	if out := runOk("get", filename, "users", "dave"); out != "value-dave\n" {
+		t.Fatalf("unexpected value after put [got=%q]", out)
+	}
+	runOk("rm", "--write", filename, "users", "dave")
+	runFail(1, "get", filename, "users", "dave")
+	runFail(1, "rm", "--write", filename, "users", "dave")
+	runOk("rmbucket", "--write", filename, "users/eu")
+	if out := runOk("ls", filename, "users"); out != "carol\n" {
+		t.Fatalf("unexpected listing after bucket removal [got=%q]", out)
+	}
+	runFail(1, "rmbucket", "--write", filename, "users/eu")
+
+	// Export and import.
+	dumpFilename := filepath.Join(t.TempDir(), "cli.jsonl")
+	runOk("dump", filename, dumpFilename)
+	copyFilename := filepath.Join(t.TempDir(), "copy.db")
+	if out := runOk("load", "--write", "--mode", "fail", copyFilename, dumpFilename); !strings.Contains(out, "1 keys") {
+		t.Fatalf("unexpected load output [got=%q]", out)
+	}
+	if out := runOk("get", "--value-format", "hex", copyFilename, "users", "carol"); out != "00ff\n" {
+		t.Fatalf("unexpected value in loaded database [got=%q]", out)
+	}
+}
+
+func TestOpenTimeout(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "locked.db")
+
+	db, err := boltdb.New(filename)
+	if err != nil {
+		t.Fatalf("cannot create test database [err=%v]", err.Error())
+	}
+	defer db.Close()
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"ls", "--timeout", "50ms", filename}, nil, &stdout, &stderr); code != 1 {
+		t.Fatalf("expected open to fail while the database is locked [code=%d]", code)
+	}
+	if !strings.Contains(stderr.String(), "locked") {
+		t.Fatalf("unexpected error message [got=%q]", stderr.String())
+	}
+}
@@ -0,0 +1,86 @@
+// See the LICENSE file for license details.
+
+package boltdb
+
+// -----------------------------------------------------------------------------
+
+// valueCodec transforms values between the representation seen by the application and the one stored
+// in the database file.
+type valueCodec interface {
+	encode(path []byte, key []byte, value []byte) ([]byte, error)
+
+	// decode returns the original value. If stale is true, the stored representation is outdated, for
+	// e.g., it was encrypted with a key that is no longer the active one, and should be rewritten.
+	decode(path []byte, key []byte, stored []byte) (value []byte, stale bool, err error)
+}
+
+// valuePipeline is a chain of codecs. Values are encoded by the codecs in order and decoded in reverse
+// order.
+type valuePipeline []valueCodec
+
+// -----------------------------------------------------------------------------
+
+// bucketCodecs returns the codecs that apply to the values and keys stored in the bucket with the given
+// path.
+func (db *DB) bucketCodecs(path []byte) (valuePipeline, *keyCodec) {
+	var pipeline valuePipeline
+	var keys *keyCodec
+
+	// Compression must be applied before encryption and checksums cover the stored bytes.
+	pattern := db.bucketPattern(path)
+	if pattern != nil && pattern.compression != nil {
+		pipeline = append(pipeline, pattern.compression)
+	}
+	if db.encryption != nil {
+		pipeline = append(pipeline, db.encryption)
+	}
+	if pattern != nil && pattern.checksum != nil {
+		pipeline = append(pipeline, pattern.checksum)
+	}
+	if pattern != nil && pattern.opts.EncryptKeys {
+		keys = db.keyEncryption
+	}
+
+	// Done
+	return pipeline, keys
+}
+
+func (p valuePipeline) encode(path []byte, key []byte, value []byte) ([]byte, error) {
+	var err error
+
+	if value == nil {
+		value = []byte{}
+	}
+	for _, codec := range p {
+		value, err = codec.encode(path, key, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Done
+	return value, nil
+}
+
+func (p valuePipeline) decode(path []byte, key []byte, stored []byte) ([]byte, bool, error) {
+	var stale bool
+
+	value := stored
+	for idx := len(p) - 1; idx >= 0; idx-- {
+		var codecStale bool
+		var err error
+
+		value, codecStale, err = p[idx].decode(path, key, value)
+		if err != nil {
+			return nil, false, err
+		}
+		stale = stale || codecStale
+	}
+	if value == nil {
+		// A nil value means a nested bucket, so always return a non-nil slice.
+		value = []byte{}
+	}
+
+	// Done
+	return value, stale, nil
+}
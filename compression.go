@@ -0,0 +1,209 @@
+// See the LICENSE file for license details.
+
+package boltdb
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// -----------------------------------------------------------------------------
+
+// Compressor compresses and decompresses values.
+type Compressor interface {
+	// ID identifies the compressor. It is stored in the header of every compressed value, so it must be
+	// unique and never change. Zero is reserved for uncompressed values and IDs below 16 are reserved
+	// for the stock compressors.
+	ID() byte
+
+	Compress(value []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+// CompressionOptions specifies how the values of a bucket are compressed.
+type CompressionOptions struct {
+	// Compressor is the compressor used to store new values.
+	Compressor Compressor
+
+	// Threshold is the minimum size a value must have to be compressed. Defaults to 256 bytes.
+	Threshold int
+}
+
+type compressionCodec struct {
+	compressor Compressor
+	threshold  int
+}
+
+type flateCompressor struct {
+	level int
+}
+
+type gzipCompressor struct {
+	level int
+}
+
+// -----------------------------------------------------------------------------
+
+const (
+	compressionNone  = 0
+	compressionFlate = 1
+	compressionGzip  = 2
+
+	firstCustomCompressorID = 16
+
+	defaultCompressionThreshold = 256
+)
+
+// -----------------------------------------------------------------------------
+
+// NewFlateCompressor creates a compressor that uses the DEFLATE format with the given compression level.
+func NewFlateCompressor(level int) Compressor {
+	return &flateCompressor{
+		level: level,
+	}
+}
+
+// NewGzipCompressor creates a compressor that uses the gzip format with the given compression level.
+func NewGzipCompressor(level int) Compressor {
+	return &gzipCompressor{
+		level: level,
+	}
+}
+
+func (c *flateCompressor) ID() byte {
+	return compressionFlate
+}
+
+func (c *flateCompressor) Compress(value []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w, err := flate.NewWriter(&buf, c.level)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(value)
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Done
+	return buf.Bytes(), nil
+}
+
+func (c *flateCompressor) Decompress(data []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer func() {
+		_ = r.Close()
+	}()
+	return io.ReadAll(r)
+}
+
+func (c *gzipCompressor) ID() byte {
+	return compressionGzip
+}
+
+func (c *gzipCompressor) Compress(value []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w, err := gzip.NewWriterLevel(&buf, c.level)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(value)
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Done
+	return buf.Bytes(), nil
+}
+
+func (c *gzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+	return io.ReadAll(r)
+}
+
+// -----------------------------------------------------------------------------
+
+func newCompressionCodec(opts *CompressionOptions) (*compressionCodec, error) {
+	if opts.Compressor == nil || opts.Compressor.ID() == compressionNone {
+		return nil, fmt.Errorf("%w: invalid compressor", ErrInvalidBucketOptions)
+	}
+	switch opts.Compressor.(type) {
+	case *flateCompressor, *gzipCompressor:
+	default:
+		if opts.Compressor.ID() < firstCustomCompressorID {
+			return nil, fmt.Errorf("%w: compressor id %d is reserved", ErrInvalidBucketOptions, opts.Compressor.ID())
+		}
+	}
+
+	codec := &compressionCodec{
+		compressor: opts.Compressor,
+		threshold:  opts.Threshold,
+	}
+	if codec.threshold <= 0 {
+		codec.threshold = defaultCompressionThreshold
+	}
+
+	// Done
+	return codec, nil
+}
+
+// encode compresses a value. The stored format is: compressor id (1 byte) + data. Values below the
+// threshold or that do not shrink are stored with a zero id.
+func (c *compressionCodec) encode(_ []byte, _ []byte, value []byte) ([]byte, error) {
+	if len(value) >= c.threshold {
+		compressed, err := c.compressor.Compress(value)
+		if err != nil {
+			return nil, err
+		}
+		if len(compressed) < len(value) {
+			return append([]byte{c.compressor.ID()}, compressed...), nil
+		}
+	}
+	return append([]byte{compressionNone}, value...), nil
+}
+
+func (c *compressionCodec) decode(path []byte, key []byte, stored []byte) ([]byte, bool, error) {
+	var compressor Compressor
+
+	if len(stored) == 0 {
+		return nil, false, fmt.Errorf("%w [path=%q key=%q]: missing header", ErrDecompressionFailed, path, key)
+	}
+
+	switch id := stored[0]; id {
+	case compressionNone:
+		return stored[1:], false, nil
+	case c.compressor.ID():
+		compressor = c.compressor
+	case compressionFlate:
+		compressor = &flateCompressor{}
+	case compressionGzip:
+		compressor = &gzipCompressor{}
+	default:
+		return nil, false, fmt.Errorf("%w [path=%q key=%q]: unknown compressor %d", ErrDecompressionFailed, path, key, id)
+	}
+
+	value, err := compressor.Decompress(stored[1:])
+	if err != nil {
+		return nil, false, fmt.Errorf("%w [path=%q key=%q]: %v", ErrDecompressionFailed, path, key, err)
+	}
+
+	// Done
+	return value, false, nil
+}
@@ -0,0 +1,125 @@
+// See the LICENSE file for license details.
+
+package boltdb_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/mxmauro/boltdb/v3"
+)
+
+// -----------------------------------------------------------------------------
+
+// customCompressor checks that compressors with IDs other than the stock ones can be plugged in.
+type customCompressor struct {
+	boltdb.Compressor
+}
+
+// reservedCompressor uses an ID reserved for the stock compressors.
+type reservedCompressor struct {
+	boltdb.Compressor
+}
+
+// -----------------------------------------------------------------------------
+
+func TestCompression(t *testing.T) {
+	large := bytes.Repeat([]byte(`{"name":"Alice","country":"AR"},`), 100)
+	small := []byte(`{"name":"Bob"}`)
+
+	for _, compressor := range []boltdb.Compressor{
+		boltdb.NewFlateCompressor(flate.BestSpeed),
+		boltdb.NewGzipCompressor(flate.DefaultCompression),
+		customCompressor{Compressor: boltdb.NewFlateCompressor(flate.BestCompression)},
+	} {
+		db, err := boltdb.NewWithOptions(filepath.Join(t.TempDir(), "test.db"), boltdb.Options{
+			Encryption: &boltdb.EncryptionOptions{
+				Keys:        []boltdb.EncryptionKey{testEncryptionKey(1, boltdb.AES256GCM)},
+				ActiveKeyID: 1,
+			},
+			Buckets: []boltdb.BucketOptions{
+				{
+					Path: "docs",
+					Compression: &boltdb.CompressionOptions{
+						Compressor: compressor,
+						Threshold:  64,
+					},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatalf("cannot create test database [err=%v]", err.Error())
+		}
+
+		if err = db.Put([]byte("docs"), []byte("large"), large); err == nil {
+			err = db.Put([]byte("docs"), []byte("small"), small)
+		}
+		if err != nil {
+			db.Close()
+			t.Fatalf("cannot write to test database [err=%v]", err.Error())
+		}
+
+		value, err := db.Get([]byte("docs"), []byte("large"))
+		if err != nil || !bytes.Equal(value, large) {
+			db.Close()
+			t.Fatalf("unexpected decompressed value [compressor=%d err=%v]", compressor.ID(), err)
+		}
+
+		err = db.WithinTx(boltdb.TxOptions{ReadOnly: true}, func(tx *boltdb.TX) error {
+			b, err2 := tx.Bucket([]byte("docs"))
+			if err2 != nil {
+				return err2
+			}
+			if !bytes.Equal(b.Get([]byte("small")), small) || !bytes.Equal(b.CopyGet([]byte("large")), large) {
+				return fmt.Errorf("unexpected bucket values")
+			}
+			return b.WithIterator(boltdb.WithIteratorOptions{}, func(iter *boltdb.Iterator) (bool, error) {
+				if string(iter.Key()) == "large" && !bytes.Equal(iter.Value(), large) {
+					return true, fmt.Errorf("unexpected iterator value")
+				}
+				return false, nil
+			})
+		})
+		if err != nil {
+			db.Close()
+			t.Fatalf("cannot read compressed bucket [compressor=%d err=%v]", compressor.ID(), err)
+		}
+
+		report, err := db.SizeReport([]byte("docs"))
+		db.Close()
+		if err != nil {
+			t.Fatalf("cannot compute size report [err=%v]", err.Error())
+		}
+		if report.ValueBytes >= int64(len(large)) {
+			t.Fatalf("values were not compressed [compressor=%d size=%d]", compressor.ID(), report.ValueBytes)
+		}
+	}
+}
+
+func (customCompressor) ID() byte {
+	return 200
+}
+
+func TestCompressionReservedID(t *testing.T) {
+	_, err := boltdb.NewWithOptions(filepath.Join(t.TempDir(), "test.db"), boltdb.Options{
+		Buckets: []boltdb.BucketOptions{
+			{
+				Path: "docs",
+				Compression: &boltdb.CompressionOptions{
+					Compressor: reservedCompressor{Compressor: boltdb.NewFlateCompressor(flate.BestSpeed)},
+				},
+			},
+		},
+	})
+	if !errors.Is(err, boltdb.ErrInvalidBucketOptions) {
+		t.Fatalf("reserved compressor id should be rejected [err=%v]", err)
+	}
+}
+
+func (reservedCompressor) ID() byte {
+	return 2
+}
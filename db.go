@@ -4,8 +4,11 @@ package boltdb
 
 import (
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"go.etcd.io/bbolt"
@@ -15,8 +18,17 @@ import (
 
 // DB represents a database connection to a BoltDB database.
 type DB struct {
-	db       *bbolt.DB
-	readOnly bool
+	db              *bbolt.DB
+	readOnly        bool
+	writeTxN        atomic.Int64
+	observer        Observer
+	slowTxThreshold time.Duration
+	encryption      *encryptionCodec
+	keyEncryption   *keyCodec
+	bucketPatterns  []bucketPattern
+	audit           bool
+	acl             atomic.Pointer[aclPolicy]
+	changeLog       *ChangeLogOptions
 }
 
 // Options specify a set of options when creating/opening the database.
@@ -25,6 +37,33 @@ type Options struct {
 	DirFileMode os.FileMode
 	DbFileMode  os.FileMode
 	Timeout     time.Duration
+
+	// Observer, if set, receives events about transactions and iterators.
+	Observer Observer
+
+	// SlowTxThreshold sets the duration above which the observer is notified about a slow transaction.
+	SlowTxThreshold time.Duration
+
+	// Encryption, if set, enables the encryption of values at rest.
+	Encryption *EncryptionOptions
+
+	// Buckets contains settings for specific buckets. The first entry whose path matches a bucket applies.
+	Buckets []BucketOptions
+
+	// ACL, if set, enables access control using the given policy instead of the one stored in the
+	// database, if any.
+	ACL *ACLPolicy
+
+	// Audit enables the audit log. Every change made through the wrapper appends an entry, chained with
+	// the previous one using hashes, to a hidden bucket.
+	Audit bool
+
+	// ChangeLog, if set, enables the change log used to replicate the database to followers.
+	ChangeLog *ChangeLogOptions
+
+	// SchemaVersion, if not zero, is the latest schema version the application knows. Opening a database
+	// whose stored schema version is greater fails with ErrSchemaTooNew.
+	SchemaVersion uint64
 }
 
 // -----------------------------------------------------------------------------
@@ -37,6 +76,25 @@ func New(filename string) (*DB, error) {
 // NewWithOptions returns a new database wrapper using the provided options.
 func NewWithOptions(filename string, opts Options) (*DB, error) {
 	var fileMode os.FileMode
+	var encryption *encryptionCodec
+	var keyEncryption *keyCodec
+	var err error
+
+	// Validate options.
+	if opts.Encryption != nil {
+		encryption, err = newEncryptionCodec(opts.Encryption)
+		if err != nil {
+			return nil, err
+		}
+		keyEncryption, err = newKeyCodec(opts.Encryption)
+		if err != nil {
+			return nil, err
+		}
+	}
+	bucketPatterns, err := newBucketPatterns(opts)
+	if err != nil {
+		return nil, err
+	}
 
 	// Create a directory if writing to the database
 	if !opts.ReadOnly {
@@ -46,7 +104,7 @@ func NewWithOptions(filename string, opts Options) (*DB, error) {
 		}
 
 		dir := filepath.Dir(filename)
-		err := os.MkdirAll(dir, fileMode)
+		err = os.MkdirAll(dir, fileMode)
 		if err != nil {
 			return nil, err
 		}
@@ -68,8 +126,41 @@ func NewWithOptions(filename string, opts Options) (*DB, error) {
 
 	// Create a wrapper.
 	b := &DB{
-		db:       db,
-		readOnly: opts.ReadOnly,
+		db:              db,
+		readOnly:        opts.ReadOnly,
+		observer:        opts.Observer,
+		slowTxThreshold: opts.SlowTxThreshold,
+		encryption:      encryption,
+		keyEncryption:   keyEncryption,
+		bucketPatterns:  bucketPatterns,
+		audit:           opts.Audit,
+	}
+	if opts.ChangeLog != nil {
+		changeLog := *opts.ChangeLog
+		b.changeLog = &changeLog
+	}
+
+	// Set up access control.
+	if opts.ACL != nil {
+		err = b.SetACLPolicy(opts.ACL)
+	} else {
+		err = b.loadACLPolicy()
+	}
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+
+	// Refuse to work with a schema newer than the application knows.
+	if opts.SchemaVersion > 0 {
+		version, err := b.SchemaVersion()
+		if err == nil && version > opts.SchemaVersion {
+			err = fmt.Errorf("%w [stored=%d supported=%d]", ErrSchemaTooNew, version, opts.SchemaVersion)
+		}
+		if err != nil {
+			b.Close()
+			return nil, err
+		}
 	}
 
 	// Done
@@ -92,13 +183,24 @@ func (db *DB) BeginTx(opts TxOptions) (*TX, error) {
 
 	// Create a wrapper.
 	tx := TX{
-		db:       db,
-		readOnly: opts.ReadOnly,
+		db:        db,
+		readOnly:  opts.ReadOnly,
+		actor:     strings.ToValidUTF8(opts.Actor, "\uFFFD"),
+		reason:    strings.ToValidUTF8(opts.Reason, "\uFFFD"),
+		acl:       db.acl.Load(),
+		principal: opts.Principal,
 	}
+	beginStart := time.Now()
 	tx.tx, err = db.db.Begin(!opts.ReadOnly)
 	if err != nil {
 		return nil, err
 	}
+	tx.start = time.Now()
+	tx.wait = tx.start.Sub(beginStart)
+	if !opts.ReadOnly {
+		db.writeTxN.Add(1)
+	}
+	tx.notifyBegin()
 
 	// Done
 	return &tx, nil
@@ -134,8 +236,8 @@ func (db *DB) Get(bucket []byte, key []byte) ([]byte, error) {
 			return err
 		}
 
-		value = cloneBytes(b.Get(key))
-		return nil
+		value, err = b.GetValue(key)
+		return err
 	})
 
 	// Done
@@ -0,0 +1,245 @@
+// See the LICENSE file for license details.
+
+package boltdb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash"
+)
+
+// Digests cover ranges of keys of a bucket: the digest of a range is the hash of the leaf hashes of its
+// keys, in order. Two copies of a bucket are compared by splitting the ranges whose digests differ at
+// pivot keys, taken at regular intervals from the copy having more keys, so every level divides a range
+// in parts holding about the same number of keys whatever their distribution. Nested buckets count as a
+// single key whose hash covers the digest of their contents.
+
+// -----------------------------------------------------------------------------
+
+const (
+	digestLeafValue  = 0
+	digestLeafBucket = 1
+)
+
+// RangeDigest is the digest of the keys of a bucket within a range.
+type RangeDigest struct {
+	// Start is the first key of the range and End the key following the last one. A nil bound leaves
+	// the range open on that side.
+	Start []byte `json:"start,omitempty"`
+	End   []byte `json:"end,omitempty"`
+
+	// Hash is nil if there are no keys.
+	Hash []byte `json:"hash,omitempty"`
+	Keys uint64 `json:"keys"`
+}
+
+// DigestLeaf is the hash of a single key of a bucket.
+type DigestLeaf struct {
+	Key    []byte `json:"key"`
+	Bucket bool   `json:"bucket,omitempty"`
+	Hash   []byte `json:"hash"`
+}
+
+type rangeHasher struct {
+	h    hash.Hash
+	keys uint64
+}
+
+// -----------------------------------------------------------------------------
+
+// Digest returns the digest of the keys stored in a bucket and its nested buckets. It is nil if the
+// bucket is empty or does not exist.
+func (db *DB) Digest(path []byte) ([]byte, error) {
+	digest, err := db.RangeDigest(path, nil, nil)
+	return digest.Hash, err
+}
+
+// RangeDigest returns the digest of the keys of a bucket from start, inclusive, to end, exclusive. Nil
+// bounds leave the range open on that side.
+func (db *DB) RangeDigest(path []byte, start []byte, end []byte) (RangeDigest, error) {
+	var digest RangeDigest
+
+	err := db.withDigestBucket(path, func(b *Bucket) error {
+		digests, err := rangeDigests(b, [][]byte{start, end})
+		if err == nil {
+			digest = digests[0]
+		}
+		return err
+	})
+
+	// Done
+	return digest, err
+}
+
+// -----------------------------------------------------------------------------
+
+// withDigestBucket calls the callback with the given bucket opened in a read-only transaction, or nil
+// if it does not exist.
+func (db *DB) withDigestBucket(path []byte, cb func(b *Bucket) error) error {
+	return db.WithinTx(TxOptions{ReadOnly: true}, func(tx *TX) error {
+		b, err := tx.Bucket(path)
+		if err != nil {
+			if errors.Is(err, ErrBucketNotFound) {
+				return cb(nil)
+			}
+			return err
+		}
+		if b.keys != nil {
+			return ErrUnsupportedSeek
+		}
+		return cb(b)
+	})
+}
+
+// rangeDigests computes, in a single pass, the digests of the consecutive ranges delimited by the given
+// bounds. The first and last bounds can be nil to leave the ranges open. A nil bucket is empty.
+func rangeDigests(b *Bucket, bounds [][]byte) ([]RangeDigest, error) {
+	if len(bounds) < 2 {
+		return nil, errors.New("missing range bounds")
+	}
+
+	digests := make([]RangeDigest, 0, len(bounds)-1)
+	var hasher rangeHasher
+
+	idx := 0
+	flush := func() {
+		digests = append(digests, hasher.digest(bounds[idx], bounds[idx+1]))
+		hasher = rangeHasher{}
+		idx += 1
+	}
+	err := forEachLeaf(b, bounds[0], bounds[len(bounds)-1], func(leaf DigestLeaf, _ []byte) error {
+		for idx < len(bounds)-2 && bytes.Compare(leaf.Key, bounds[idx+1]) >= 0 {
+			flush()
+		}
+		hasher.add(leaf.Hash)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for idx < len(bounds)-1 {
+		flush()
+	}
+
+	// Done
+	return digests, nil
+}
+
+// rangePivots returns up to count-1 keys splitting the range from start to end in count parts holding
+// about the same number of keys. The pivots are sorted and greater than the first key of the range.
+func rangePivots(b *Bucket, start []byte, end []byte, count int) ([][]byte, error) {
+	var keys uint64
+	var pivots [][]byte
+
+	err := forEachKey(b, start, end, func(_ []byte, _ *Iterator) error {
+		keys += 1
+		return nil
+	})
+	if err != nil || keys < 2 || count < 2 {
+		return nil, err
+	}
+
+	var pos uint64
+	next := 1
+	err = forEachKey(b, start, end, func(key []byte, _ *Iterator) error {
+		for next < count && uint64(next)*keys/uint64(count) < pos {
+			next += 1
+		}
+		if next < count && pos > 0 && uint64(next)*keys/uint64(count) == pos {
+			pivots = append(pivots, cloneBytes(key))
+			next += 1
+		}
+		pos += 1
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Done
+	return pivots, nil
+}
+
+// forEachKey calls the callback, in key order, with the keys from start, inclusive, to end, exclusive.
+// Nil bounds leave the range open on that side. The key is only valid during the call.
+func forEachKey(b *Bucket, start []byte, end []byte, cb func(key []byte, iter *Iterator) error) error {
+	if b == nil {
+		return nil
+	}
+
+	opts := WithIteratorOptions{
+		FirstKey: start,
+	}
+	return b.WithIterator(opts, func(iter *Iterator) (bool, error) {
+		key := iter.Key()
+		if end != nil && bytes.Compare(key, end) >= 0 {
+			return true, nil
+		}
+		return false, cb(key, iter)
+	})
+}
+
+// forEachLeaf calls the callback, in key order, with the leaves of the keys from start, inclusive, to
+// end, exclusive. The value is nil for nested buckets. The leaf key and the value are only valid during
+// the call.
+func forEachLeaf(b *Bucket, start []byte, end []byte, cb func(leaf DigestLeaf, value []byte) error) error {
+	return forEachKey(b, start, end, func(key []byte, iter *Iterator) error {
+		leaf := DigestLeaf{
+			Key: key,
+		}
+		var value []byte
+		if iter.IsNestedBucket() {
+			child, err := iter.NestedBucket()
+			if err != nil {
+				return err
+			}
+			if child.keys != nil {
+				return ErrUnsupportedSeek
+			}
+			digests, err := rangeDigests(child, [][]byte{nil, nil})
+			if err != nil {
+				return err
+			}
+			leaf.Bucket = true
+			leaf.Hash = leafHash(digestLeafBucket, key, digests[0].Hash)
+		} else {
+			value = iter.Value()
+			if iter.Err() != nil {
+				return iter.Err()
+			}
+			leaf.Hash = leafHash(digestLeafValue, key, value)
+		}
+		return cb(leaf, value)
+	})
+}
+
+func leafHash(kind byte, key []byte, data []byte) []byte {
+	h := sha256.New()
+	_, _ = h.Write([]byte{kind})
+	_, _ = h.Write(binary.BigEndian.AppendUint32(nil, uint32(len(key))))
+	_, _ = h.Write(key)
+	_, _ = h.Write(data)
+	return h.Sum(nil)
+}
+
+func (hasher *rangeHasher) add(leafHash []byte) {
+	if hasher.h == nil {
+		hasher.h = sha256.New()
+	}
+	_, _ = hasher.h.Write(leafHash)
+	hasher.keys += 1
+}
+
+func (hasher *rangeHasher) digest(start []byte, end []byte) RangeDigest {
+	digest := RangeDigest{
+		Start: cloneBytes(start),
+		End:   cloneBytes(end),
+		Keys:  hasher.keys,
+	}
+	if hasher.h != nil {
+		digest.Hash = hasher.h.Sum(nil)
+	}
+	return digest
+}
@@ -0,0 +1,538 @@
+// See the LICENSE file for license details.
+
+package boltdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// Dumps use the JSON Lines format: one JSON object per line. The first line is a header:
+//
+//	{"type":"header","format":"boltdb-jsonl","version":1}
+//
+// Each bucket is described by a "bucket" record before any of its keys or nested buckets. The path
+// uses the same slash notation as TX.Bucket and the sequence is omitted if zero:
+//
+//	{"type":"bucket","path":"users/eu","sequence":12}
+//
+// Each key/value pair is described by a "key" record:
+//
+//	{"type":"key","path":"users/eu","key":"alice","value":"eyJuYW1lIjoiQWxpY2UifQ==","value_encoding":"base64"}
+//
+// Values always carry a "value_encoding" field set to either "utf8" or "base64". Paths and keys are
+// stored as UTF-8 strings unless the matching "path_encoding" or "key_encoding" field is set to "base64".
+// Records appear in depth-first order, with keys and nested buckets sorted as stored in the database.
+
+// -----------------------------------------------------------------------------
+
+const (
+	dumpFormatName    = "boltdb-jsonl"
+	dumpFormatVersion = 1
+
+	dumpRecordHeader = "header"
+	dumpRecordBucket = "bucket"
+	dumpRecordKey    = "key"
+
+	dumpEncodingUTF8   = "utf8"
+	dumpEncodingBase64 = "base64"
+
+	defaultLoadBatchSize = 1000
+)
+
+// DumpEncoding specifies how keys and values are rendered in a dump.
+type DumpEncoding int
+
+const (
+	// DumpEncodingAuto stores valid UTF-8 data as plain strings and everything else in base64.
+	DumpEncodingAuto DumpEncoding = iota
+
+	// DumpEncodingBase64 stores all keys and values in base64.
+	DumpEncodingBase64
+)
+
+// DumpOptions specifies a set of options when dumping a database.
+type DumpOptions struct {
+	// Prefix limits the dump to the bucket with the given path and its nested buckets.
+	Prefix []byte
+
+	// Encoding selects how keys and values are rendered.
+	Encoding DumpEncoding
+
+	// BatchSize limits the amount of records read within a single read-only transaction. If zero, the
+	// whole dump is taken from one transaction and represents a consistent snapshot of the database.
+	// NOTE: Changes made between batches may or may not be included in the dump.
+	BatchSize int
+}
+
+// LoadMode specifies how to handle keys that already exist in the database when loading a dump.
+type LoadMode int
+
+const (
+	// LoadMerge adds missing keys and keeps the current value of existing ones.
+	LoadMerge LoadMode = iota
+
+	// LoadOverwrite replaces the value of existing keys with the dumped one.
+	LoadOverwrite
+
+	// LoadFailOnConflict aborts the load if an existing key has a different value.
+	LoadFailOnConflict
+)
+
+// LoadOptions specifies a set of options when loading a dump.
+type LoadOptions struct {
+	// Prefix limits the load to the records of the bucket with the given path and its nested buckets.
+	Prefix []byte
+
+	// Mode selects how existing keys are handled.
+	Mode LoadMode
+
+	// BatchSize limits the amount of records written within a single transaction. Defaults to 1000.
+	// NOTE: If the load fails, batches committed before the failure are kept.
+	BatchSize int
+}
+
+// LoadStats contains statistical data about a load operation.
+type LoadStats struct {
+	Buckets int
+	Keys    int
+	Skipped int
+}
+
+type dumpRecord struct {
+	Type          string  `json:"type"`
+	Format        string  `json:"format,omitempty"`
+	Version       int     `json:"version,omitempty"`
+	Path          string  `json:"path,omitempty"`
+	PathEncoding  string  `json:"path_encoding,omitempty"`
+	Key           string  `json:"key,omitempty"`
+	KeyEncoding   string  `json:"key_encoding,omitempty"`
+	Value         *string `json:"value,omitempty"`
+	ValueEncoding string  `json:"value_encoding,omitempty"`
+	Sequence      uint64  `json:"sequence,omitempty"`
+}
+
+type dumper struct {
+	opts    DumpOptions
+	enc     *json.Encoder
+	prefix  [][]byte
+	started bool
+	resume  [][]byte
+	count   int
+}
+
+type loader struct {
+	opts   LoadOptions
+	prefix [][]byte
+	stats  LoadStats
+}
+
+// -----------------------------------------------------------------------------
+
+var errDumpBatchFull = errors.New("dump batch full")
+
+// -----------------------------------------------------------------------------
+
+// Dump writes the content of the database into the provided writer using the JSON Lines format.
+func (db *DB) Dump(w io.Writer, opts DumpOptions) error {
+	var err error
+
+	d := dumper{
+		opts: opts,
+	}
+	if len(opts.Prefix) > 0 {
+		d.prefix, err = splitPath(opts.Prefix)
+		if err != nil {
+			return err
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	d.enc = json.NewEncoder(bw)
+	d.enc.SetEscapeHTML(false)
+
+	// Write the header.
+	err = d.enc.Encode(dumpRecord{
+		Type:    dumpRecordHeader,
+		Format:  dumpFormatName,
+		Version: dumpFormatVersion,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Dump records, one batch per transaction.
+	for done := false; !done; {
+		err = db.WithinTx(TxOptions{ReadOnly: true}, func(tx *TX) error {
+			d.count = 0
+			err2 := d.dumpTx(tx)
+			if err2 == nil {
+				done = true
+			} else if errors.Is(err2, errDumpBatchFull) {
+				err2 = nil
+			}
+			return err2
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Done
+	return bw.Flush()
+}
+
+// Load reads a dump in the JSON Lines format and stores its content into the database.
+func (db *DB) Load(r io.Reader, opts LoadOptions) (LoadStats, error) {
+	var err error
+
+	l := loader{
+		opts: opts,
+	}
+	if l.opts.BatchSize <= 0 {
+		l.opts.BatchSize = defaultLoadBatchSize
+	}
+	if len(opts.Prefix) > 0 {
+		l.prefix, err = splitPath(opts.Prefix)
+		if err != nil {
+			return LoadStats{}, err
+		}
+	}
+
+	dec := json.NewDecoder(bufio.NewReader(r))
+
+	// Read and validate the header.
+	var header dumpRecord
+	err = dec.Decode(&header)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return LoadStats{}, fmt.Errorf("%w: %v", ErrInvalidDumpFormat, err)
+	}
+	if header.Type != dumpRecordHeader || header.Format != dumpFormatName {
+		return LoadStats{}, fmt.Errorf("%w: missing header", ErrInvalidDumpFormat)
+	}
+	if header.Version != dumpFormatVersion {
+		return LoadStats{}, fmt.Errorf("%w: unsupported version %d", ErrInvalidDumpFormat, header.Version)
+	}
+
+	// Read and apply records in batches.
+	batch := make([]dumpRecord, 0, l.opts.BatchSize)
+	for {
+		var rec dumpRecord
+
+		err = dec.Decode(&rec)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return l.stats, fmt.Errorf("%w: %v", ErrInvalidDumpFormat, err)
+		}
+		if err == nil {
+			batch = append(batch, rec)
+			if len(batch) < l.opts.BatchSize {
+				continue
+			}
+		}
+
+		if len(batch) > 0 {
+			committedStats := l.stats
+			err2 := db.WithinTx(TxOptions{}, func(tx *TX) error {
+				return l.apply(tx, batch)
+			})
+			if err2 != nil {
+				return committedStats, err2
+			}
+			batch = batch[:0]
+		}
+		if err != nil {
+			break // Reached the end of the stream.
+		}
+	}
+
+	// Done
+	return l.stats, nil
+}
+
+// -----------------------------------------------------------------------------
+
+func (d *dumper) dumpTx(tx *TX) error {
+	if len(d.prefix) == 0 {
+		return d.dumpLevel(tx, nil, nil, d.resume)
+	}
+
+	b, err := tx.Bucket(joinPath(d.prefix))
+	if err != nil {
+		if errors.Is(err, ErrBucketNotFound) {
+			return nil
+		}
+		return err
+	}
+	if !d.started {
+		err = d.emit(d.bucketRecord(nil, b), [][]byte{})
+		if err != nil {
+			return err
+		}
+	}
+	return d.dumpLevel(tx, b, nil, d.resume)
+}
+
+// dumpLevel dumps the content of a bucket, or the top-level buckets if parent is nil. If resume is not
+// empty, it contains the position of the last record emitted by a previous batch.
+func (d *dumper) dumpLevel(tx *TX, parent *Bucket, path [][]byte, resume [][]byte) error {
+	var iter *Iterator
+	var ok bool
+
+	if parent != nil {
+		iter = parent.Iterate()
+	} else {
+		iter = tx.Iterate()
+	}
+
+	if len(resume) == 0 {
+		ok = iter.First()
+	} else {
+		// Positions hold stored keys, so they remain valid on buckets with encrypted keys.
+		ok = iter.seekRaw(resume[0])
+		if ok && bytes.Equal(iter.rawKey, resume[0]) {
+			// The record at this position was already emitted. If it is a bucket, continue with its content.
+			if iter.IsNestedBucket() {
+				child, err := d.openChild(tx, parent, iter.Key())
+				if err != nil {
+					return err
+				}
+				err = d.dumpLevel(tx, child, appendPath(path, iter.CopyKey()), resume[1:])
+				if err != nil {
+					return err
+				}
+			}
+			ok = iter.Next()
+		}
+	}
+
+	for ; ok; ok = iter.Next() {
+		childPath := appendPath(path, iter.CopyKey())
+		position := appendPath(path, cloneBytes(iter.rawKey))
+
+		if iter.IsNestedBucket() {
+			child, err := d.openChild(tx, parent, iter.Key())
+			if err != nil {
+				return err
+			}
+			err = d.emit(d.bucketRecord(childPath, child), position)
+			if err != nil {
+				return err
+			}
+			err = d.dumpLevel(tx, child, childPath, nil)
+			if err != nil {
+				return err
+			}
+		} else {
+			value := iter.Value()
+			if err := iter.Err(); err != nil {
+				return err
+			}
+			err := d.emit(d.keyRecord(path, iter.Key(), value), position)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Done
+	return nil
+}
+
+func (d *dumper) openChild(tx *TX, parent *Bucket, name []byte) (*Bucket, error) {
+	if bytes.IndexByte(name, '/') >= 0 {
+		return nil, fmt.Errorf("%w: bucket name %q contains a slash", ErrInvalidPath, name)
+	}
+	if parent == nil {
+		return tx.Bucket(name)
+	}
+	return parent.Bucket(name)
+}
+
+func (d *dumper) emit(rec dumpRecord, position [][]byte) error {
+	err := d.enc.Encode(rec)
+	if err != nil {
+		return err
+	}
+
+	d.started = true
+	d.resume = position
+	d.count += 1
+	if d.opts.BatchSize > 0 && d.count >= d.opts.BatchSize {
+		return errDumpBatchFull
+	}
+
+	// Done
+	return nil
+}
+
+func (d *dumper) bucketRecord(path [][]byte, b *Bucket) dumpRecord {
+	rec := dumpRecord{
+		Type:     dumpRecordBucket,
+		Sequence: b.Sequence(),
+	}
+	rec.Path, rec.PathEncoding = d.encodePath(path)
+	return rec
+}
+
+func (d *dumper) keyRecord(path [][]byte, key []byte, value []byte) dumpRecord {
+	rec := dumpRecord{
+		Type: dumpRecordKey,
+	}
+	rec.Path, rec.PathEncoding = d.encodePath(path)
+	rec.Key, rec.KeyEncoding = encodeDumpData(key, d.opts.Encoding)
+	encodedValue, valueEncoding := encodeDumpData(value, d.opts.Encoding)
+	if valueEncoding == "" {
+		valueEncoding = dumpEncodingUTF8
+	}
+	rec.Value = &encodedValue
+	rec.ValueEncoding = valueEncoding
+	return rec
+}
+
+func (d *dumper) encodePath(path [][]byte) (string, string) {
+	fullPath := make([][]byte, 0, len(d.prefix)+len(path))
+	fullPath = append(fullPath, d.prefix...)
+	fullPath = append(fullPath, path...)
+	return encodeDumpData(joinPath(fullPath), DumpEncodingAuto)
+}
+
+func (l *loader) apply(tx *TX, batch []dumpRecord) error {
+	buckets := make(map[string]*Bucket)
+
+	getBucket := func(rec *dumpRecord) (*Bucket, bool, error) {
+		path, err := decodeDumpData(rec.Path, rec.PathEncoding)
+		if err != nil {
+			return nil, false, fmt.Errorf("%w: invalid path: %v", ErrInvalidDumpFormat, err)
+		}
+		fragments, err := splitPath(path)
+		if err != nil {
+			return nil, false, fmt.Errorf("%w: invalid path %q", ErrInvalidDumpFormat, path)
+		}
+		if !hasPathPrefix(fragments, l.prefix) {
+			return nil, false, nil
+		}
+
+		normalizedPath := string(joinPath(fragments))
+		b, ok := buckets[normalizedPath]
+		if !ok {
+			b, err = tx.Bucket([]byte(normalizedPath))
+			if err != nil {
+				return nil, false, err
+			}
+			buckets[normalizedPath] = b
+		}
+		return b, true, nil
+	}
+
+	for idx := range batch {
+		rec := &batch[idx]
+
+		switch rec.Type {
+		case dumpRecordBucket:
+			b, ok, err := getBucket(rec)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			if rec.Sequence > b.Sequence() || (l.opts.Mode == LoadOverwrite && rec.Sequence != b.Sequence()) {
+				err = b.SetSequence(rec.Sequence)
+				if err != nil {
+					return err
+				}
+			}
+			l.stats.Buckets += 1
+
+		case dumpRecordKey:
+			if rec.Value == nil {
+				return fmt.Errorf("%w: key record without value", ErrInvalidDumpFormat)
+			}
+			key, err := decodeDumpData(rec.Key, rec.KeyEncoding)
+			if err == nil && len(key) == 0 {
+				err = errors.New("empty key")
+			}
+			if err != nil {
+				return fmt.Errorf("%w: invalid key: %v", ErrInvalidDumpFormat, err)
+			}
+			value, err := decodeDumpData(*rec.Value, rec.ValueEncoding)
+			if err != nil {
+				return fmt.Errorf("%w: invalid value: %v", ErrInvalidDumpFormat, err)
+			}
+
+			b, ok, err := getBucket(rec)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+
+			currentValue, err := b.GetValue(key)
+			if err != nil {
+				return err
+			}
+			if currentValue != nil {
+				switch l.opts.Mode {
+				case LoadMerge:
+					l.stats.Skipped += 1
+					continue
+
+				case LoadFailOnConflict:
+					if !bytes.Equal(currentValue, value) {
+						return fmt.Errorf("%w [path=%q key=%q]", ErrLoadConflict, rec.Path, key)
+					}
+					l.stats.Skipped += 1
+					continue
+
+				case LoadOverwrite:
+				}
+			}
+
+			err = b.Put(key, value)
+			if err != nil {
+				return err
+			}
+			l.stats.Keys += 1
+
+		default:
+			return fmt.Errorf("%w: unknown record type %q", ErrInvalidDumpFormat, rec.Type)
+		}
+	}
+
+	// Done
+	return nil
+}
+
+func encodeDumpData(data []byte, encoding DumpEncoding) (string, string) {
+	if encoding == DumpEncodingAuto && utf8.Valid(data) {
+		return string(data), ""
+	}
+	return base64.StdEncoding.EncodeToString(data), dumpEncodingBase64
+}
+
+func decodeDumpData(s string, encoding string) ([]byte, error) {
+	switch encoding {
+	case "", dumpEncodingUTF8:
+		return []byte(s), nil
+	case dumpEncodingBase64:
+		return base64.StdEncoding.DecodeString(s)
+	}
+	return nil, fmt.Errorf("unsupported encoding %q", encoding)
+}
+
+func appendPath(path [][]byte, fragment []byte) [][]byte {
+	newPath := make([][]byte, len(path)+1)
+	copy(newPath, path)
+	newPath[len(path)] = fragment
+	return newPath
+}
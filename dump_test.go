@@ -0,0 +1,195 @@
+// See the LICENSE file for license details.
+
+package boltdb_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mxmauro/boltdb/v3"
+)
+
+// -----------------------------------------------------------------------------
+
+func TestDumpAndLoad(t *testing.T) {
+	db := openTestDb(t)
+	defer db.Close()
+
+	seedDumpTestDb(t, db)
+
+	var dump bytes.Buffer
+	if err := db.Dump(&dump, boltdb.DumpOptions{}); err != nil {
+		t.Fatalf("cannot dump database [err=%v]", err.Error())
+	}
+
+	lines := strings.Split(strings.TrimSpace(dump.String()), "\n")
+	if len(lines) != 8 {
+		t.Fatalf("unexpected amount of dump records [got=%d]\n%s", len(lines), dump.String())
+	}
+	if lines[0] != `{"type":"header","format":"boltdb-jsonl","version":1}` {
+		t.Fatalf("unexpected header [got=%s]", lines[0])
+	}
+	if lines[1] != `{"type":"bucket","path":"empty"}` {
+		t.Fatalf("unexpected empty bucket record [got=%s]", lines[1])
+	}
+	if lines[2] != `{"type":"bucket","path":"users","sequence":2}` {
+		t.Fatalf("unexpected bucket record [got=%s]", lines[2])
+	}
+	if lines[3] != `{"type":"key","path":"users","key":"binary","value":"AP8=","value_encoding":"base64"}` {
+		t.Fatalf("unexpected binary key record [got=%s]", lines[3])
+	}
+	if !strings.HasSuffix(lines[4], `"value_encoding":"utf8"}`) {
+		t.Fatalf("missing utf8 encoding marker [got=%s]", lines[4])
+	}
+
+	// A batched dump must produce the same output.
+	var batchedDump bytes.Buffer
+	if err := db.Dump(&batchedDump, boltdb.DumpOptions{BatchSize: 1}); err != nil {
+		t.Fatalf("cannot dump database in batches [err=%v]", err.Error())
+	}
+	if batchedDump.String() != dump.String() {
+		t.Fatalf("batched dump differs\n%s\n%s", batchedDump.String(), dump.String())
+	}
+
+	// Load into a new database and compare.
+	db2 := openTestDb(t)
+	defer db2.Close()
+
+	stats, err := db2.Load(bytes.NewReader(dump.Bytes()), boltdb.LoadOptions{BatchSize: 2})
+	if err != nil {
+		t.Fatalf("cannot load dump [err=%v]", err.Error())
+	}
+	if stats.Buckets != 3 || stats.Keys != 4 || stats.Skipped != 0 {
+		t.Fatalf("unexpected load stats [got=%+v]", stats)
+	}
+
+	var dump2 bytes.Buffer
+	if err = db2.Dump(&dump2, boltdb.DumpOptions{}); err != nil {
+		t.Fatalf("cannot dump loaded database [err=%v]", err.Error())
+	}
+	if dump2.String() != dump.String() {
+		t.Fatalf("loaded database differs\n%s\n%s", dump2.String(), dump.String())
+	}
+}
+
+func TestDumpPrefix(t *testing.T) {
+	db := openTestDb(t)
+	defer db.Close()
+
+	seedDumpTestDb(t, db)
+
+	var dump bytes.Buffer
+	if err := db.Dump(&dump, boltdb.DumpOptions{Prefix: []byte("/users/eu/"), Encoding: boltdb.DumpEncodingBase64}); err != nil {
+		t.Fatalf("cannot dump database [err=%v]", err.Error())
+	}
+
+	lines := strings.Split(strings.TrimSpace(dump.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("unexpected amount of dump records [got=%d]\n%s", len(lines), dump.String())
+	}
+	if lines[1] != `{"type":"bucket","path":"users/eu"}` {
+		t.Fatalf("unexpected bucket record [got=%s]", lines[1])
+	}
+	if lines[2] != `{"type":"key","path":"users/eu","key":"YWxpY2U=","key_encoding":"base64","value":"dmFsdWUtYWxpY2U=","value_encoding":"base64"}` {
+		t.Fatalf("unexpected key record [got=%s]", lines[2])
+	}
+}
+
+func TestLoadModes(t *testing.T) {
+	source := openTestDb(t)
+	defer source.Close()
+
+	seedDumpTestDb(t, source)
+
+	var dump bytes.Buffer
+	if err := source.Dump(&dump, boltdb.DumpOptions{}); err != nil {
+		t.Fatalf("cannot dump database [err=%v]", err.Error())
+	}
+
+	load := func(db *boltdb.DB, opts boltdb.LoadOptions) (boltdb.LoadStats, error) {
+		return db.Load(bytes.NewReader(dump.Bytes()), opts)
+	}
+
+	db := openTestDb(t)
+	defer db.Close()
+
+	if err := db.Put([]byte("users/eu"), []byte("alice"), []byte("changed")); err != nil {
+		t.Fatalf("cannot write to test database [err=%v]", err.Error())
+	}
+
+	// Merge keeps existing values.
+	stats, err := load(db, boltdb.LoadOptions{})
+	if err != nil {
+		t.Fatalf("cannot merge dump [err=%v]", err.Error())
+	}
+	if stats.Skipped != 1 {
+		t.Fatalf("unexpected merge stats [got=%+v]", stats)
+	}
+	if value, _ := db.Get([]byte("users/eu"), []byte("alice")); string(value) != "changed" {
+		t.Fatalf("merge overwrote an existing value [got=%q]", value)
+	}
+
+	// Fail-on-conflict aborts.
+	_, err = load(db, boltdb.LoadOptions{Mode: boltdb.LoadFailOnConflict})
+	if !errors.Is(err, boltdb.ErrLoadConflict) {
+		t.Fatalf("expected ErrLoadConflict [got=%v]", err)
+	}
+
+	// Overwrite replaces existing values, restricted to a prefix.
+	stats, err = load(db, boltdb.LoadOptions{Mode: boltdb.LoadOverwrite, Prefix: []byte("users/eu")})
+	if err != nil {
+		t.Fatalf("cannot overwrite from dump [err=%v]", err.Error())
+	}
+	if stats.Buckets != 1 || stats.Keys != 2 {
+		t.Fatalf("unexpected overwrite stats [got=%+v]", stats)
+	}
+	if value, _ := db.Get([]byte("users/eu"), []byte("alice")); string(value) != "value-alice" {
+		t.Fatalf("overwrite did not replace the existing value [got=%q]", value)
+	}
+
+	// Invalid input is rejected.
+	_, err = db.Load(strings.NewReader(`{"type":"key"}`), boltdb.LoadOptions{})
+	if !errors.Is(err, boltdb.ErrInvalidDumpFormat) {
+		t.Fatalf("expected ErrInvalidDumpFormat [got=%v]", err)
+	}
+}
+
+func seedDumpTestDb(t *testing.T, db *boltdb.DB) {
+	t.Helper()
+
+	err := db.WithinTx(boltdb.TxOptions{}, func(tx *boltdb.TX) error {
+		if _, err := tx.Bucket([]byte("empty")); err != nil {
+			return err
+		}
+
+		users, err := tx.Bucket([]byte("users"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 2; i++ {
+			if _, err = users.NextSequence(); err != nil {
+				return err
+			}
+		}
+		if err = users.Put([]byte("binary"), []byte{0x00, 0xff}); err != nil {
+			return err
+		}
+		if err = users.Put([]byte("carol"), []byte("")); err != nil {
+			return err
+		}
+
+		eu, err := users.Bucket([]byte("eu"))
+		if err != nil {
+			return err
+		}
+		if err = eu.Put([]byte("alice"), []byte("value-alice")); err != nil {
+			return err
+		}
+		return eu.Put([]byte("bob"), []byte(`{"name":"Bob"}`))
+	})
+	if err != nil {
+		t.Fatalf("cannot seed test database [err=%v]", err.Error())
+	}
+}
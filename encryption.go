@@ -0,0 +1,519 @@
+// See the LICENSE file for license details.
+
+package boltdb
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"go.etcd.io/bbolt"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// -----------------------------------------------------------------------------
+
+// EncryptionAlgorithm specifies the AEAD cipher used to encrypt values.
+type EncryptionAlgorithm int
+
+const (
+	AES256GCM EncryptionAlgorithm = iota
+	ChaCha20Poly1305
+)
+
+// EncryptionKey is a key used to encrypt and decrypt values.
+type EncryptionKey struct {
+	// ID identifies the key. It is stored along with every encrypted value, so it must never be reused
+	// for a different key.
+	ID        uint32
+	Algorithm EncryptionAlgorithm
+
+	// Key is the raw key material. It must be 32 bytes long.
+	Key []byte
+}
+
+// EncryptionOptions specifies how values are encrypted at rest.
+//
+// Values are sealed with the active key and the bucket path and key as associated data, so a value
+// cannot be moved to another location without being detected. Bucket names and sequences are stored in
+// plain text, and so are keys unless BucketOptions.EncryptKeys is set.
+//
+// NOTE: Existing unencrypted values cannot be read once encryption is enabled. Use Dump and Load to
+// convert an existing database.
+type EncryptionOptions struct {
+	// Keys contains all the keys able to decrypt stored values. Old keys must be kept until RotateKeys
+	// re-encrypts all the values sealed with them.
+	Keys []EncryptionKey
+
+	// ActiveKeyID is the ID of the key used to encrypt new values.
+	ActiveKeyID uint32
+}
+
+// RotateKeysOptions specifies a set of options when re-encrypting the database.
+type RotateKeysOptions struct {
+	// BatchSize is the maximum number of keys visited within a single transaction. Defaults to 1000.
+	BatchSize int
+}
+
+// RotateKeysStats contains the result of a key rotation. Keys and Rotated refer to the keys of the
+// buckets. PastValues and Changes are the past values kept as history and the change log entries that
+// were re-encrypted.
+type RotateKeysStats struct {
+	Buckets    int
+	Keys       int
+	Rotated    int
+	PastValues int
+	Changes    int
+}
+
+type encryptionCodec struct {
+	active *encryptionKey
+	keys   map[uint32]*encryptionKey
+}
+
+type encryptionKey struct {
+	id   uint32
+	aead cipher.AEAD
+}
+
+// rotatedEntry is a key/value pair re-encrypted by a key rotation. The value is the decoded one and the
+// first raw key is the one used to store it.
+type rotatedEntry struct {
+	key     []byte
+	value   []byte
+	rawKeys [][]byte
+	stored  []byte
+}
+
+// -----------------------------------------------------------------------------
+
+const (
+	encryptionFormatVersion = 1
+	encryptionHeaderSize    = 1 + 4
+)
+
+// -----------------------------------------------------------------------------
+
+// RotateKeys re-encrypts, with the active key, all the values and encrypted keys sealed with other keys,
+// including the past values kept as history and the payloads of the change log, so old keys can be
+// dropped afterwards. The database is processed in several transactions, so it can be used while the
+// rotation is in progress. Rewritten values count towards the usage, and are audited and recorded in the
+// change log like regular writes.
+// NOTE: On buckets with encrypted keys, re-encrypted keys change their position and might be visited
+// twice, so Keys can be higher than the actual amount of keys.
+func (db *DB) RotateKeys(ctx context.Context, opts RotateKeysOptions) (RotateKeysStats, error) {
+	var stats RotateKeysStats
+	var paths [][][]byte
+
+	if db.encryption == nil {
+		return stats, errors.New("encryption is not enabled")
+	}
+	if db.readOnly {
+		return stats, ErrDatabaseReadOnly
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+
+	// Collect the buckets to process.
+	err := db.db.View(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+			if isReservedBucketName(name) {
+				return nil
+			}
+			paths = collectBucketPaths(paths, [][]byte{cloneBytes(name)}, b)
+			return nil
+		})
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	// Process each bucket in batches.
+	for _, fragments := range paths {
+		var resume []byte
+
+		stats.Buckets += 1
+		for {
+			err = ctx.Err()
+			if err != nil {
+				return stats, err
+			}
+
+			done := false
+			err = db.WithinTx(TxOptions{}, func(tx *TX) error {
+				var pending []rotatedEntry
+
+				b := tx.bucketFromFragments(fragments)
+				if b == nil {
+					done = true // The bucket was deleted meanwhile.
+					return nil
+				}
+
+				visited := 0
+				c := b.b.Cursor()
+				k, v := c.First()
+				if resume != nil {
+					k, v = c.Seek(resume)
+					if k != nil && bytes.Equal(k, resume) {
+						k, v = c.Next()
+					}
+				}
+				for ; k != nil && visited < opts.BatchSize; k, v = c.Next() {
+					if v == nil {
+						continue
+					}
+					visited += 1
+					resume = cloneBytes(k)
+
+					key := k
+					keyStale := false
+					if b.keys != nil {
+						var err2 error
+
+						key, keyStale, err2 = b.keys.decode(b.path, k)
+						if err2 != nil {
+							return err2
+						}
+					}
+					value, stale, err2 := b.codec.decode(b.path, key, v)
+					if err2 != nil {
+						return err2
+					}
+					if stale || keyStale {
+						entry := rotatedEntry{
+							key:     cloneBytes(key),
+							value:   cloneBytes(value),
+							rawKeys: [][]byte{cloneBytes(k)},
+						}
+						entry.stored, err2 = b.codec.encode(b.path, key, value)
+						if err2 == nil && b.versioned {
+							_, entry.value, err2 = splitVersion(entry.value)
+						}
+						if err2 != nil {
+							return err2
+						}
+						if keyStale {
+							entry.rawKeys = [][]byte{b.keys.encode(b.path, key), entry.rawKeys[0]}
+						}
+						pending = append(pending, entry)
+					}
+				}
+				done = k == nil
+
+				// Store the re-encrypted entries once the cursor is no longer needed.
+				for _, entry := range pending {
+					err2 := b.onRewritten(entry.key, entry.value, entry.rawKeys, entry.stored)
+					if err2 != nil {
+						return err2
+					}
+					for _, rawKey := range entry.rawKeys[1:] {
+						err2 = b.b.Delete(rawKey)
+						if err2 != nil {
+							return err2
+						}
+					}
+					err2 = b.b.Put(entry.rawKeys[0], entry.stored)
+					if err2 != nil {
+						return err2
+					}
+				}
+				stats.Keys += visited
+				stats.Rotated += len(pending)
+				return nil
+			})
+			if err != nil {
+				return stats, err
+			}
+			if done {
+				break
+			}
+		}
+	}
+
+	// Re-encrypt the past values.
+	var shadows [][]byte
+	err = db.db.View(func(tx *bbolt.Tx) error {
+		history := tx.Bucket(metaBucketName)
+		if history != nil {
+			history = history.Bucket(metaHistoryBucket)
+		}
+		if history == nil {
+			return nil
+		}
+		return history.ForEachBucket(func(name []byte) error {
+			shadows = append(shadows, cloneBytes(name))
+			return nil
+		})
+	})
+	if err != nil {
+		return stats, err
+	}
+	for _, path := range shadows {
+		codec, _ := db.bucketCodecs(path)
+		if len(codec) == 0 {
+			continue
+		}
+		rotated, err2 := db.rotateRawValues(ctx, opts, historyFragments(path), func(k []byte, v []byte) ([]byte, error) {
+			key, _, ok := decodeOrderedBytes(k)
+			if !ok || len(v) < 2 || v[0] != 1 {
+				return nil, nil
+			}
+			stored, err3 := rotateValue(codec, path, key, v[1:])
+			if err3 != nil || stored == nil {
+				return nil, err3
+			}
+			return append([]byte{1}, stored...), nil
+		})
+		stats.PastValues += rotated
+		if err2 != nil {
+			return stats, err2
+		}
+	}
+
+	// Re-encrypt the keys and values recorded in the change log.
+	rotated, err := db.rotateRawValues(ctx, opts, [][]byte{metaBucketName, metaChangeLogBucket}, db.rotateChange)
+	stats.Changes += rotated
+	if err != nil {
+		return stats, err
+	}
+
+	// Done
+	return stats, nil
+}
+
+// -----------------------------------------------------------------------------
+
+func newEncryptionCodec(opts *EncryptionOptions) (*encryptionCodec, error) {
+	codec := &encryptionCodec{
+		keys: make(map[uint32]*encryptionKey),
+	}
+
+	for _, key := range opts.Keys {
+		var aead cipher.AEAD
+		var err error
+
+		if len(key.Key) != 32 {
+			return nil, fmt.Errorf("%w [id=%d]: key must be 32 bytes long", ErrInvalidEncryptionKey, key.ID)
+		}
+		if _, ok := codec.keys[key.ID]; ok {
+			return nil, fmt.Errorf("%w [id=%d]: duplicated key id", ErrInvalidEncryptionKey, key.ID)
+		}
+
+		switch key.Algorithm {
+		case AES256GCM:
+			var block cipher.Block
+
+			block, err = aes.NewCipher(key.Key)
+			if err == nil {
+				aead, err = cipher.NewGCM(block)
+			}
+
+		case ChaCha20Poly1305:
+			aead, err = chacha20poly1305.New(key.Key)
+
+		default:
+			err = errors.New("unsupported algorithm")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w [id=%d]: %v", ErrInvalidEncryptionKey, key.ID, err)
+		}
+
+		codec.keys[key.ID] = &encryptionKey{
+			id:   key.ID,
+			aead: aead,
+		}
+	}
+
+	codec.active = codec.keys[opts.ActiveKeyID]
+	if codec.active == nil {
+		return nil, fmt.Errorf("%w [id=%d]: active key not found", ErrInvalidEncryptionKey, opts.ActiveKeyID)
+	}
+
+	// Done
+	return codec, nil
+}
+
+// encode seals a value. The stored format is: version (1 byte) + key id (4 bytes) + nonce + ciphertext.
+func (c *encryptionCodec) encode(path []byte, key []byte, value []byte) ([]byte, error) {
+	aead := c.active.aead
+	nonceSize := aead.NonceSize()
+
+	stored := make([]byte, encryptionHeaderSize+nonceSize, encryptionHeaderSize+nonceSize+len(value)+aead.Overhead())
+	stored[0] = encryptionFormatVersion
+	binary.LittleEndian.PutUint32(stored[1:5], c.active.id)
+	_, err := rand.Read(stored[encryptionHeaderSize:])
+	if err != nil {
+		return nil, err
+	}
+
+	// Done
+	return aead.Seal(stored, stored[encryptionHeaderSize:], value, encryptionAAD(path, key)), nil
+}
+
+func (c *encryptionCodec) decode(path []byte, key []byte, stored []byte) ([]byte, bool, error) {
+	if len(stored) < encryptionHeaderSize || stored[0] != encryptionFormatVersion {
+		return nil, false, fmt.Errorf("%w [path=%q key=%q]: invalid header", ErrDecryptionFailed, path, key)
+	}
+
+	id := binary.LittleEndian.Uint32(stored[1:5])
+	k := c.keys[id]
+	if k == nil {
+		return nil, false, fmt.Errorf("%w [path=%q key=%q id=%d]", ErrUnknownEncryptionKey, path, key, id)
+	}
+
+	nonceSize := k.aead.NonceSize()
+	if len(stored) < encryptionHeaderSize+nonceSize+k.aead.Overhead() {
+		return nil, false, fmt.Errorf("%w [path=%q key=%q]: value too short", ErrDecryptionFailed, path, key)
+	}
+	nonce := stored[encryptionHeaderSize : encryptionHeaderSize+nonceSize]
+	value, err := k.aead.Open(nil, nonce, stored[encryptionHeaderSize+nonceSize:], encryptionAAD(path, key))
+	if err != nil {
+		return nil, false, fmt.Errorf("%w [path=%q key=%q]", ErrDecryptionFailed, path, key)
+	}
+
+	// Done
+	return value, k != c.active, nil
+}
+
+// encryptionAAD binds a value to its location: path length (4 bytes) + path + key.
+func encryptionAAD(path []byte, key []byte) []byte {
+	aad := make([]byte, 4, 4+len(path)+len(key))
+	binary.LittleEndian.PutUint32(aad, uint32(len(path)))
+	return append(append(aad, path...), key...)
+}
+
+// rotateRawValues calls the rotate function, in batches, with every entry of the raw bucket at the given
+// fragments and stores the value it returns, if any. It returns the number of entries rewritten.
+func (db *DB) rotateRawValues(ctx context.Context, opts RotateKeysOptions, fragments [][]byte,
+	rotate func(k []byte, v []byte) ([]byte, error),
+) (int, error) {
+	var resume []byte
+
+	rotated := 0
+	for {
+		err := ctx.Err()
+		if err != nil {
+			return rotated, err
+		}
+
+		done := false
+		err = db.WithinTx(TxOptions{}, func(tx *TX) error {
+			var keys, values [][]byte
+
+			b := tx.rawBucket(fragments)
+			if b == nil {
+				done = true
+				return nil
+			}
+
+			visited := 0
+			c := b.Cursor()
+			k, v := c.First()
+			if resume != nil {
+				k, v = c.Seek(resume)
+				if k != nil && bytes.Equal(k, resume) {
+					k, v = c.Next()
+				}
+			}
+			for ; k != nil && visited < opts.BatchSize; k, v = c.Next() {
+				if v == nil {
+					continue
+				}
+				visited += 1
+				resume = cloneBytes(k)
+
+				value, err2 := rotate(k, v)
+				if err2 != nil {
+					return err2
+				}
+				if value != nil {
+					keys, values = append(keys, cloneBytes(k)), append(values, value)
+				}
+			}
+			done = k == nil
+
+			// Store the re-encrypted entries once the cursor is no longer needed.
+			for idx := range keys {
+				err2 := b.Put(keys[idx], values[idx])
+				if err2 != nil {
+					return err2
+				}
+			}
+			rotated += len(keys)
+			return nil
+		})
+		if err != nil || done {
+			return rotated, err
+		}
+	}
+}
+
+// rotateValue returns the value stored with the given codecs re-encoded with the active key, or nil if
+// it is up to date.
+func rotateValue(codec valuePipeline, path []byte, key []byte, stored []byte) ([]byte, error) {
+	value, stale, err := codec.decode(path, key, stored)
+	if err != nil || !stale {
+		return nil, err
+	}
+	return codec.encode(path, key, value)
+}
+
+// rotateChange returns the encoded change log entry with its key and value re-encoded with the active
+// key, or nil if it is up to date.
+func (db *DB) rotateChange(_ []byte, v []byte) ([]byte, error) {
+	var entry ChangeEntry
+
+	err := json.Unmarshal(v, &entry)
+	if err != nil {
+		return nil, err
+	}
+	if (entry.Operation != ChangePut && entry.Operation != ChangeDelete) ||
+		(len(entry.Path) > 0 && isReservedBucketName(entry.Path[0])) {
+		return nil, nil
+	}
+
+	path := joinPath(entry.Path)
+	codec, keys := db.bucketCodecs(path)
+	key := entry.Key
+	changed := false
+	if keys != nil {
+		var keyStale bool
+
+		key, keyStale, err = keys.decode(path, entry.Key)
+		if err != nil {
+			return nil, err
+		}
+		if keyStale {
+			entry.Key, changed = keys.encode(path, key), true
+		}
+	}
+	if entry.Operation == ChangePut && len(codec) > 0 {
+		stored, err2 := rotateValue(codec, path, key, entry.Value)
+		if err2 != nil {
+			return nil, err2
+		}
+		if stored != nil {
+			entry.Value, changed = stored, true
+		}
+	}
+	if !changed {
+		return nil, nil
+	}
+	return json.Marshal(entry)
+}
+
+func collectBucketPaths(paths [][][]byte, fragments [][]byte, b *bbolt.Bucket) [][][]byte {
+	paths = append(paths, fragments)
+	_ = b.ForEachBucket(func(name []byte) error {
+		paths = collectBucketPaths(paths, appendPath(fragments, cloneBytes(name)), b.Bucket(name))
+		return nil
+	})
+	return paths
+}
@@ -0,0 +1,439 @@
+// See the LICENSE file for license details.
+
+package boltdb_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mxmauro/boltdb/v3"
+)
+
+// -----------------------------------------------------------------------------
+
+func TestEncryption(t *testing.T) {
+	for _, algorithm := range []boltdb.EncryptionAlgorithm{boltdb.AES256GCM, boltdb.ChaCha20Poly1305} {
+		filename := filepath.Join(t.TempDir(), "test.db")
+		opts := boltdb.Options{
+			Encryption: &boltdb.EncryptionOptions{
+				Keys:        []boltdb.EncryptionKey{testEncryptionKey(1, algorithm)},
+				ActiveKeyID: 1,
+			},
+		}
+
+		db, err := boltdb.NewWithOptions(filename, opts)
+		if err != nil {
+			t.Fatalf("cannot create test database [err=%v]", err.Error())
+		}
+		secret := []byte("very-secret-personal-data")
+		if err = db.Put([]byte("users/eu"), []byte("alice"), secret); err != nil {
+			t.Fatalf("cannot write to test database [err=%v]", err.Error())
+		}
+		if err = db.Put([]byte("users/eu"), []byte("empty"), []byte{}); err != nil {
+			t.Fatalf("cannot write to test database [err=%v]", err.Error())
+		}
+
+		value, err := db.Get([]byte("users/eu"), []byte("alice"))
+		if err != nil || !bytes.Equal(value, secret) {
+			t.Fatalf("unexpected decrypted value [got=%q err=%v]", value, err)
+		}
+
+		err = db.WithinTx(boltdb.TxOptions{ReadOnly: true}, func(tx *boltdb.TX) error {
+			b, err2 := tx.Bucket([]byte("users/eu"))
+			if err2 != nil {
+				return err2
+			}
+			values := make(map[string][]byte)
+			err2 = b.WithIterator(boltdb.WithIteratorOptions{}, func(iter *boltdb.Iterator) (bool, error) {
+				values[string(iter.Key())] = iter.CopyValue()
+				return false, nil
+			})
+			if err2 != nil {
+				return err2
+			}
+			if !bytes.Equal(values["alice"], secret) || values["empty"] == nil || len(values["empty"]) != 0 {
+				return fmt.Errorf("unexpected iterated values [got=%q]", values)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("cannot iterate encrypted bucket [err=%v]", err.Error())
+		}
+		db.Close()
+
+		raw, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("cannot read database file [err=%v]", err.Error())
+		}
+		if bytes.Contains(raw, secret) {
+			t.Fatalf("plain text value found in the database file")
+		}
+	}
+}
+
+func TestEncryptionBindsLocation(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	opts := boltdb.Options{
+		Encryption: &boltdb.EncryptionOptions{
+			Keys:        []boltdb.EncryptionKey{testEncryptionKey(1, boltdb.AES256GCM)},
+			ActiveKeyID: 1,
+		},
+	}
+
+	db, err := boltdb.NewWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("cannot create test database [err=%v]", err.Error())
+	}
+	if err = db.Put([]byte("users"), []byte("alice"), []byte("secret")); err != nil {
+		t.Fatalf("cannot write to test database [err=%v]", err.Error())
+	}
+	db.Close()
+
+	// Copy the sealed value to another key without decrypting it.
+	db, err = boltdb.New(filename)
+	if err != nil {
+		t.Fatalf("cannot open test database [err=%v]", err.Error())
+	}
+	sealed, err := db.Get([]byte("users"), []byte("alice"))
+	if err == nil {
+		err = db.Put([]byte("users"), []byte("mallory"), sealed)
+	}
+	db.Close()
+	if err != nil {
+		t.Fatalf("cannot copy sealed value [err=%v]", err.Error())
+	}
+
+	db, err = boltdb.NewWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("cannot open test database [err=%v]", err.Error())
+	}
+	defer db.Close()
+
+	if _, err = db.Get([]byte("users"), []byte("mallory")); !errors.Is(err, boltdb.ErrDecryptionFailed) {
+		t.Fatalf("expected decryption to fail for a moved value [got=%v]", err)
+	}
+	err = db.WithinTx(boltdb.TxOptions{ReadOnly: true}, func(tx *boltdb.TX) error {
+		b, err2 := tx.Bucket([]byte("users"))
+		if err2 != nil {
+			return err2
+		}
+		return b.WithIterator(boltdb.WithIteratorOptions{}, func(iter *boltdb.Iterator) (bool, error) {
+			_ = iter.Value()
+			return false, nil
+		})
+	})
+	if !errors.Is(err, boltdb.ErrDecryptionFailed) {
+		t.Fatalf("expected iteration to fail for a moved value [got=%v]", err)
+	}
+}
+
+func TestRotateKeys(t *testing.T) {
+	const keyCount = 25
+
+	filename := filepath.Join(t.TempDir(), "test.db")
+	key1 := testEncryptionKey(1, boltdb.AES256GCM)
+	key2 := testEncryptionKey(2, boltdb.ChaCha20Poly1305)
+
+	db, err := boltdb.NewWithOptions(filename, boltdb.Options{
+		Encryption: &boltdb.EncryptionOptions{
+			Keys:        []boltdb.EncryptionKey{key1},
+			ActiveKeyID: 1,
+		},
+	})
+	if err != nil {
+		t.Fatalf("cannot create test database [err=%v]", err.Error())
+	}
+	for i := 0; i < keyCount; i++ {
+		err = db.Put([]byte("data/nested"), []byte(fmt.Sprintf("key-%03d", i)), []byte(fmt.Sprintf("value-%03d", i)))
+		if err != nil {
+			db.Close()
+			t.Fatalf("cannot write to test database [err=%v]", err.Error())
+		}
+	}
+	db.Close()
+
+	db, err = boltdb.NewWithOptions(filename, boltdb.Options{
+		Encryption: &boltdb.EncryptionOptions{
+			Keys:        []boltdb.EncryptionKey{key1, key2},
+			ActiveKeyID: 2,
+		},
+		ChangeLog: &boltdb.ChangeLogOptions{},
+	})
+	if err != nil {
+		t.Fatalf("cannot open test database [err=%v]", err.Error())
+	}
+	stats, err := db.RotateKeys(context.Background(), boltdb.RotateKeysOptions{BatchSize: 7})
+	if err != nil {
+		db.Close()
+		t.Fatalf("cannot rotate keys [err=%v]", err.Error())
+	}
+	if stats.Buckets != 2 || stats.Keys != keyCount || stats.Rotated != keyCount {
+		db.Close()
+		t.Fatalf("unexpected rotation stats [got=%+v]", stats)
+	}
+
+	// Rotated values are recorded in the change log, so followers receive them.
+	puts := 0
+	err = db.Changes(0, func(entry boltdb.ChangeEntry) (bool, error) {
+		if entry.Operation == boltdb.ChangePut {
+			puts += 1
+		}
+		return false, nil
+	})
+	if err != nil || puts != keyCount {
+		db.Close()
+		t.Fatalf("unexpected change log [puts=%d err=%v]", puts, err)
+	}
+	stats, err = db.RotateKeys(context.Background(), boltdb.RotateKeysOptions{})
+	db.Close()
+	if err != nil || stats.Rotated != 0 {
+		t.Fatalf("expected nothing to rotate [stats=%+v err=%v]", stats, err)
+	}
+
+	// The old key is no longer needed.
+	db, err = boltdb.NewWithOptions(filename, boltdb.Options{
+		Encryption: &boltdb.EncryptionOptions{
+			Keys:        []boltdb.EncryptionKey{key2},
+			ActiveKeyID: 2,
+		},
+	})
+	if err != nil {
+		t.Fatalf("cannot open test database [err=%v]", err.Error())
+	}
+	defer db.Close()
+
+	value, err := db.Get([]byte("data/nested"), []byte("key-013"))
+	if err != nil || string(value) != "value-013" {
+		t.Fatalf("unexpected value after rotation [got=%q err=%v]", value, err)
+	}
+
+	_, err = boltdb.NewWithOptions(filename, boltdb.Options{
+		Encryption: &boltdb.EncryptionOptions{
+			Keys:        []boltdb.EncryptionKey{key2},
+			ActiveKeyID: 1,
+		},
+	})
+	if !errors.Is(err, boltdb.ErrInvalidEncryptionKey) {
+		t.Fatalf("expected missing active key to be rejected [got=%v]", err)
+	}
+}
+
+func TestRotateKeysHistoryAndChangeLog(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	key1 := testEncryptionKey(1, boltdb.AES256GCM)
+	key2 := testEncryptionKey(2, boltdb.ChaCha20Poly1305)
+
+	open := func(keys []boltdb.EncryptionKey, active uint32) *boltdb.DB {
+		t.Helper()
+
+		db, err := boltdb.NewWithOptions(filename, boltdb.Options{
+			Encryption: &boltdb.EncryptionOptions{
+				Keys:        keys,
+				ActiveKeyID: active,
+			},
+			Buckets: []boltdb.BucketOptions{
+				{Path: "ledger", History: &boltdb.HistoryOptions{}},
+			},
+			ChangeLog: &boltdb.ChangeLogOptions{},
+		})
+		if err != nil {
+			t.Fatalf("cannot open test database [err=%v]", err.Error())
+		}
+		return db
+	}
+
+	db := open([]boltdb.EncryptionKey{key1}, 1)
+	for _, value := range []string{"1", "2"} {
+		if err := db.Put([]byte("ledger"), []byte("a"), []byte(value)); err != nil {
+			db.Close()
+			t.Fatalf("cannot write to test database [err=%v]", err.Error())
+		}
+	}
+	db.Close()
+
+	db = open([]boltdb.EncryptionKey{key1, key2}, 2)
+	stats, err := db.RotateKeys(context.Background(), boltdb.RotateKeysOptions{BatchSize: 1})
+	db.Close()
+	if err != nil {
+		t.Fatalf("cannot rotate keys [err=%v]", err.Error())
+	}
+	if stats.Rotated != 1 || stats.PastValues != 1 || stats.Changes != 2 {
+		t.Fatalf("unexpected rotation stats [got=%+v]", stats)
+	}
+
+	// History and the change log are readable without the old key.
+	db = open([]boltdb.EncryptionKey{key2}, 2)
+	defer db.Close()
+
+	var past []string
+	err = db.WithinTx(boltdb.TxOptions{ReadOnly: true}, func(tx *boltdb.TX) error {
+		b, err2 := tx.Bucket([]byte("ledger"))
+		if err2 != nil {
+			return err2
+		}
+		return b.History([]byte("a"), func(entry boltdb.HistoryEntry) (bool, error) {
+			past = append(past, string(entry.Value))
+			return false, nil
+		})
+	})
+	if err != nil || len(past) != 2 || past[0] != "" || past[1] != "1" {
+		t.Fatalf("unexpected history after rotation [got=%q err=%v]", past, err)
+	}
+
+	err = db.Changes(0, func(entry boltdb.ChangeEntry) (bool, error) {
+		if entry.Operation == boltdb.ChangePut && binary.LittleEndian.Uint32(entry.Value[1:5]) != key2.ID {
+			t.Errorf("change log entry not rotated [seq=%d]", entry.Sequence)
+		}
+		return false, nil
+	})
+	if err != nil {
+		t.Fatalf("cannot read change log [err=%v]", err.Error())
+	}
+}
+
+func TestEncryptedKeys(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	key1 := testEncryptionKey(1, boltdb.AES256GCM)
+	key2 := testEncryptionKey(2, boltdb.AES256GCM)
+	bucketOpts := []boltdb.BucketOptions{
+		{Path: "accounts/*", EncryptKeys: true},
+	}
+	emails := []string{"alice@example.com", "bob@example.com", "carol@example.com"}
+
+	db, err := boltdb.NewWithOptions(filename, boltdb.Options{
+		Encryption: &boltdb.EncryptionOptions{
+			Keys:        []boltdb.EncryptionKey{key1},
+			ActiveKeyID: 1,
+		},
+		Buckets: bucketOpts,
+	})
+	if err != nil {
+		t.Fatalf("cannot create test database [err=%v]", err.Error())
+	}
+	for _, email := range emails {
+		if err = db.Put([]byte("accounts/eu"), []byte(email), []byte("id-"+email)); err != nil {
+			db.Close()
+			t.Fatalf("cannot write to test database [err=%v]", err.Error())
+		}
+	}
+
+	err = db.WithinTx(boltdb.TxOptions{}, func(tx *boltdb.TX) error {
+		b, err2 := tx.Bucket([]byte("accounts/eu"))
+		if err2 != nil {
+			return err2
+		}
+		if v := b.Get([]byte("bob@example.com")); string(v) != "id-bob@example.com" {
+			return fmt.Errorf("unexpected value [got=%q]", v)
+		}
+
+		iter := b.Iterate()
+		if !iter.Seek([]byte("carol@example.com"), boltdb.SeekExact) || string(iter.Key()) != "carol@example.com" {
+			return fmt.Errorf("exact seek failed [key=%q err=%v]", iter.Key(), iter.Err())
+		}
+		if iter.Seek([]byte("carol"), boltdb.SeekPrefix) || !errors.Is(iter.Err(), boltdb.ErrUnsupportedSeek) {
+			return fmt.Errorf("expected prefix seek to fail [err=%v]", iter.Err())
+		}
+
+		err2 = b.WithIterator(boltdb.WithIteratorOptions{Prefix: []byte("a")}, func(_ *boltdb.Iterator) (bool, error) {
+			return false, nil
+		})
+		if !errors.Is(err2, boltdb.ErrUnsupportedSeek) {
+			return fmt.Errorf("expected prefix iteration to fail [got=%v]", err2)
+		}
+
+		found := make(map[string]bool)
+		err2 = b.WithIterator(boltdb.WithIteratorOptions{}, func(iter *boltdb.Iterator) (bool, error) {
+			found[string(iter.Key())] = string(iter.Value()) == "id-"+string(iter.Key())
+			return false, nil
+		})
+		if err2 != nil {
+			return err2
+		}
+		for _, email := range emails {
+			if !found[email] {
+				return fmt.Errorf("key not found while iterating [key=%q]", email)
+			}
+		}
+
+		return b.Delete([]byte("alice@example.com"))
+	})
+	if err != nil {
+		db.Close()
+		t.Fatalf("cannot use bucket with encrypted keys [err=%v]", err.Error())
+	}
+	db.Close()
+
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("cannot read database file [err=%v]", err.Error())
+	}
+	if bytes.Contains(raw, []byte("@example.com")) {
+		t.Fatalf("plain text key found in the database file")
+	}
+
+	// Rotate and drop the old key.
+	db, err = boltdb.NewWithOptions(filename, boltdb.Options{
+		Encryption: &boltdb.EncryptionOptions{
+			Keys:        []boltdb.EncryptionKey{key1, key2},
+			ActiveKeyID: 2,
+		},
+		Buckets: bucketOpts,
+	})
+	if err != nil {
+		t.Fatalf("cannot open test database [err=%v]", err.Error())
+	}
+	stats, err := db.RotateKeys(context.Background(), boltdb.RotateKeysOptions{BatchSize: 1})
+	db.Close()
+	if err != nil || stats.Rotated != 2 {
+		t.Fatalf("unexpected rotation result [stats=%+v err=%v]", stats, err)
+	}
+
+	db, err = boltdb.NewWithOptions(filename, boltdb.Options{
+		Encryption: &boltdb.EncryptionOptions{
+			Keys:        []boltdb.EncryptionKey{key2},
+			ActiveKeyID: 2,
+		},
+		Buckets: bucketOpts,
+	})
+	if err != nil {
+		t.Fatalf("cannot open test database [err=%v]", err.Error())
+	}
+	defer db.Close()
+
+	for _, email := range emails[1:] {
+		value, err := db.Get([]byte("accounts/eu"), []byte(email))
+		if err != nil || string(value) != "id-"+email {
+			t.Fatalf("unexpected value after rotation [key=%q got=%q err=%v]", email, value, err)
+		}
+	}
+	if value, _ := db.Get([]byte("accounts/eu"), []byte(emails[0])); value != nil {
+		t.Fatalf("deleted key found after rotation")
+	}
+
+	var dump bytes.Buffer
+	if err = db.Dump(&dump, boltdb.DumpOptions{BatchSize: 1}); err != nil {
+		t.Fatalf("cannot dump database [err=%v]", err.Error())
+	}
+	if !bytes.Contains(dump.Bytes(), []byte("bob@example.com")) {
+		t.Fatalf("expected dump to contain decrypted keys [got=%s]", dump.String())
+	}
+
+	_, err = boltdb.NewWithOptions(filename, boltdb.Options{Buckets: bucketOpts})
+	if !errors.Is(err, boltdb.ErrInvalidBucketOptions) {
+		t.Fatalf("expected key encryption without keys to be rejected [got=%v]", err)
+	}
+}
+
+func testEncryptionKey(id uint32, algorithm boltdb.EncryptionAlgorithm) boltdb.EncryptionKey {
+	return boltdb.EncryptionKey{
+		ID:        id,
+		Algorithm: algorithm,
+		Key:       bytes.Repeat([]byte{byte(id)}, 32),
+	}
+}
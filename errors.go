@@ -15,5 +15,40 @@ var (
 	ErrBucketNotFound        = bbolt.ErrBucketNotFound
 	ErrTxNotWritable         = bbolt.ErrTxNotWritable
 	ErrDatabaseReadOnly      = bbolt.ErrDatabaseReadOnly
+	ErrTimeout               = bbolt.ErrTimeout
+	ErrDatabaseNotOpen       = bbolt.ErrDatabaseNotOpen
 	ErrInvalidCursorPosition = errors.New("invalid cursor position")
+	ErrInvalidDumpFormat     = errors.New("invalid dump format")
+	ErrLoadConflict          = errors.New("key already exists with a different value")
+	ErrInvalidEncryptionKey  = errors.New("invalid encryption key")
+	ErrUnknownEncryptionKey  = errors.New("unknown encryption key")
+	ErrDecryptionFailed      = errors.New("decryption failed")
+	ErrInvalidBucketOptions  = errors.New("invalid bucket options")
+	ErrUnsupportedSeek       = errors.New("seek method not supported on buckets with encrypted keys")
+	ErrDecompressionFailed   = errors.New("decompression failed")
+	ErrBlobNotFound          = errors.New("blob not found")
+	ErrBlobCorrupted         = errors.New("blob is corrupted")
+	ErrBlobChunkCollected    = errors.New("blob chunk was garbage collected")
+	ErrChecksumMismatch      = errors.New("checksum mismatch")
+	ErrRecordNotFound        = errors.New("record not found")
+	ErrUniqueViolation       = errors.New("unique constraint violation")
+	ErrFieldNotIndexed       = errors.New("field is not indexed")
+	ErrInvalidQuery          = errors.New("invalid query")
+	ErrInvalidMigration      = errors.New("invalid migration")
+	ErrSchemaTooNew          = errors.New("database schema is newer than supported")
+	ErrInvalidSavepoint      = errors.New("invalid or released savepoint")
+	ErrBatchConflict         = errors.New("value read by the batch was changed")
+	ErrVersionConflict       = errors.New("version conflict")
+	ErrBucketNotVersioned    = errors.New("bucket is not versioned")
+	ErrBucketNoHistory       = errors.New("bucket does not keep history")
+	ErrAuditChainBroken      = errors.New("audit chain is broken")
+	ErrPermissionDenied      = errors.New("permission denied")
+	ErrInvalidACLPolicy      = errors.New("invalid access control policy")
+	ErrQuotaExceeded         = errors.New("quota exceeded")
+	ErrInvalidShardCount     = errors.New("invalid shard count")
+	ErrInvalidWindow         = errors.New("invalid partition window")
+	ErrPartitionNotFound     = errors.New("partition not found")
+	ErrReplicationProtocol   = errors.New("replication protocol error")
+	ErrSyncProtocol          = errors.New("sync protocol error")
+	ErrSyncPeerFailed        = errors.New("sync peer failed")
 )
@@ -2,6 +2,9 @@ module github.com/mxmauro/boltdb/v3
 
 go 1.23
 
-require go.etcd.io/bbolt v1.4.3
+require (
+	go.etcd.io/bbolt v1.4.3
+	golang.org/x/crypto v0.32.0
+)
 
 require golang.org/x/sys v0.29.0 // indirect
@@ -0,0 +1,471 @@
+// See the LICENSE file for license details.
+
+package boltdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// -----------------------------------------------------------------------------
+
+const historySuffixSize = 16
+
+var (
+	metaHistoryBucket     = []byte("history")
+	metaHistoryClockKey   = []byte("history_clock")
+	historyGroupUpperSeek = bytes.Repeat([]byte{0xFF}, historySuffixSize+1)
+)
+
+// HistoryOptions specifies the retention of the values kept by history-keeping buckets. Zero values mean
+// no limit.
+type HistoryOptions struct {
+	// MaxVersions is the maximum number of past values kept per key.
+	MaxVersions int
+
+	// MaxAge is the maximum time a past value is kept after it was replaced.
+	MaxAge time.Duration
+}
+
+// HistoryEntry is a past state of a key.
+type HistoryEntry struct {
+	// Value contains the value the key had. It is nil if the key did not exist.
+	Value []byte
+
+	// Until is the time the transaction that replaced the value first wrote to a history-keeping
+	// bucket. It is taken before the transaction commits, so it can be slightly older than the commit.
+	Until time.Time
+}
+
+// PruneHistoryOptions specifies a set of options when pruning the history of all buckets.
+type PruneHistoryOptions struct {
+	// BatchSize is the number of keys processed within a single write transaction. Defaults to 1000.
+	BatchSize int
+}
+
+// PruneHistoryStats contains the result of a history pruning operation.
+type PruneHistoryStats struct {
+	Buckets int
+	Keys    int
+	Pruned  int
+}
+
+// -----------------------------------------------------------------------------
+
+// GetAt returns a copy of the value a key had at the given time or nil if it did not exist. The bucket
+// must be configured to keep history.
+// NOTE: Values replaced before the history was enabled are assumed to have always existed, and results
+// for times older than the retained history are not accurate.
+func (bucket *Bucket) GetAt(key []byte, t time.Time) ([]byte, error) {
+	if bucket.history == nil {
+		return nil, ErrBucketNoHistory
+	}
+
+	shadow, err := bucket.tx.historyBucket(bucket.path)
+	if err != nil {
+		return nil, err
+	}
+	if shadow != nil {
+		// The first value replaced after the given time is the one the key had at that time.
+		prefix := appendOrderedBytes(nil, key)
+		k, v := shadow.Cursor().Seek(appendOrderedInt(cloneBytes(prefix), t.UnixNano()+1))
+		if k != nil && bytes.HasPrefix(k, prefix) {
+			entry, err := bucket.decodeHistoryEntry(key, k[len(prefix):], v)
+			if err != nil {
+				return nil, err
+			}
+			return entry.Value, nil
+		}
+	}
+
+	// Done
+	return bucket.GetValue(key)
+}
+
+// History calls the callback with the past states of a key, from the oldest to the newest. The current
+// value is not included. The bucket must be configured to keep history.
+func (bucket *Bucket) History(key []byte, cb func(entry HistoryEntry) (stop bool, err error)) error {
+	if bucket.history == nil {
+		return ErrBucketNoHistory
+	}
+
+	shadow, err := bucket.tx.historyBucket(bucket.path)
+	if err != nil || shadow == nil {
+		return err
+	}
+	prefix := appendOrderedBytes(nil, key)
+	c := shadow.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		entry, err := bucket.decodeHistoryEntry(key, k[len(prefix):], v)
+		if err != nil {
+			return err
+		}
+		stop, err := cb(entry)
+		if err != nil || stop {
+			return err
+		}
+	}
+
+	// Done
+	return nil
+}
+
+// RangeAt calls the callback, in key order, with the keys starting with the given prefix and the values
+// they had at the given time. Keys that did not exist at that time are skipped. The bucket must be
+// configured to keep history.
+func (bucket *Bucket) RangeAt(prefix []byte, t time.Time, cb func(key []byte, value []byte) (stop bool, err error)) error {
+	if bucket.history == nil {
+		return ErrBucketNoHistory
+	}
+
+	shadow, err := bucket.tx.historyBucket(bucket.path)
+	if err != nil {
+		return err
+	}
+
+	// Current keys.
+	current := bucket.b.Cursor()
+	currentKey, currentValue := current.Seek(prefix)
+	nextCurrent := func() []byte {
+		for currentKey != nil && currentValue == nil {
+			currentKey, currentValue = current.Next()
+		}
+		if currentKey == nil || !bytes.HasPrefix(currentKey, prefix) {
+			return nil
+		}
+		k := currentKey
+		currentKey, currentValue = current.Next()
+		return k
+	}
+
+	// Keys with history. The escaped prefix, without the terminator, is a prefix of the encoded keys.
+	var past *bbolt.Cursor
+	var pastKey []byte
+	encodedPrefix := appendOrderedBytes(nil, prefix)
+	encodedPrefix = encodedPrefix[:len(encodedPrefix)-2]
+	if shadow != nil {
+		past = shadow.Cursor()
+		pastKey, _ = past.Seek(encodedPrefix)
+	}
+	nextPast := func() ([]byte, error) {
+		if pastKey == nil || !bytes.HasPrefix(pastKey, encodedPrefix) {
+			return nil, nil
+		}
+		k, _, ok := decodeOrderedBytes(pastKey)
+		if !ok {
+			return nil, errors.New("invalid history key")
+		}
+		pastKey, _ = past.Seek(append(pastKey[:len(pastKey)-historySuffixSize:len(pastKey)-historySuffixSize],
+			historyGroupUpperSeek...))
+		return k, nil
+	}
+
+	// Merge both sorted sequences.
+	a := nextCurrent()
+	b, err := nextPast()
+	for err == nil && (a != nil || b != nil) {
+		var key []byte
+
+		switch {
+		case b == nil || (a != nil && bytes.Compare(a, b) < 0):
+			key = cloneBytes(a)
+			a = nextCurrent()
+		case a == nil || bytes.Compare(a, b) > 0:
+			key = b
+			b, err = nextPast()
+		default:
+			key = b
+			a = nextCurrent()
+			b, err = nextPast()
+		}
+		if err != nil {
+			break
+		}
+
+		var value []byte
+		value, err = bucket.GetAt(key, t)
+		if err != nil || value == nil {
+			continue
+		}
+		var stop bool
+		stop, err = cb(key, value)
+		if stop {
+			break
+		}
+	}
+
+	// Done
+	return err
+}
+
+// PruneHistory removes, in bounded transactions, the past values of all history-keeping buckets that
+// exceed the configured retention. Keys are also pruned each time they are written.
+func (db *DB) PruneHistory(ctx context.Context, opts PruneHistoryOptions) (PruneHistoryStats, error) {
+	var stats PruneHistoryStats
+	var paths [][]byte
+
+	if db.readOnly {
+		return stats, ErrDatabaseReadOnly
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+
+	// Collect the buckets to process.
+	err := db.db.View(func(tx *bbolt.Tx) error {
+		meta := tx.Bucket(metaBucketName)
+		if meta == nil {
+			return nil
+		}
+		history := meta.Bucket(metaHistoryBucket)
+		if history == nil {
+			return nil
+		}
+		return history.ForEachBucket(func(name []byte) error {
+			paths = append(paths, cloneBytes(name))
+			return nil
+		})
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	// Process each bucket in batches.
+	now := time.Now()
+	for _, path := range paths {
+		var resume []byte
+
+		pattern := db.bucketPattern(path)
+		if pattern == nil || pattern.opts.History == nil {
+			continue
+		}
+		stats.Buckets += 1
+		for done := false; !done; {
+			err = ctx.Err()
+			if err != nil {
+				return stats, err
+			}
+
+			err = db.WithinTx(TxOptions{}, func(tx *TX) error {
+				shadow, err := tx.historyBucket(path)
+				if err != nil || shadow == nil {
+					done = true
+					return err
+				}
+				fragments := historyFragments(path)
+
+				c := shadow.Cursor()
+				k, _ := c.Seek(resume)
+				for count := 0; count < opts.BatchSize; count++ {
+					if k == nil {
+						done = true
+						return nil
+					}
+					if len(k) < historySuffixSize {
+						return errors.New("invalid history key")
+					}
+					prefix := cloneBytes(k[:len(k)-historySuffixSize])
+					pruned, err := tx.pruneHistoryKey(fragments, shadow, prefix, pattern.opts.History, now)
+					if err != nil {
+						return err
+					}
+					stats.Keys += 1
+					stats.Pruned += pruned
+
+					resume = append(prefix, historyGroupUpperSeek...)
+					c = shadow.Cursor()
+					k, _ = c.Seek(resume)
+				}
+				return nil
+			})
+			if err != nil {
+				return stats, err
+			}
+		}
+	}
+
+	// Done
+	return stats, nil
+}
+
+// -----------------------------------------------------------------------------
+
+// recordHistory stores the current state of a key before it is modified. The state is stored once per
+// transaction, so intermediate values set within the same transaction are not kept.
+func (bucket *Bucket) recordHistory(key []byte) error {
+	shadow, err := bucket.tx.historyBucket(bucket.path)
+	if err != nil {
+		return err
+	}
+	err = bucket.tx.initHistoryClock()
+	if err != nil {
+		return err
+	}
+	fragments := historyFragments(bucket.path)
+
+	prefix := appendOrderedBytes(nil, key)
+	historyKey := appendOrderedInt(cloneBytes(prefix), bucket.tx.historyTime.UnixNano())
+	historyKey = binary.BigEndian.AppendUint64(historyKey, bucket.tx.historyID)
+	if shadow.Get(historyKey) != nil {
+		return nil
+	}
+
+	stored := bucket.b.Get(key)
+	historyValue := []byte{0}
+	if stored != nil {
+		historyValue = append([]byte{1}, stored...)
+	}
+	bucket.tx.journalRawKey(fragments, shadow, historyKey)
+	err = shadow.Put(historyKey, historyValue)
+	if err != nil {
+		return err
+	}
+
+	// Done
+	_, err = bucket.tx.pruneHistoryKey(fragments, shadow, prefix, bucket.history, bucket.tx.historyTime)
+	return err
+}
+
+// recordBucketHistory stores the current state of the keys of a bucket, and of its nested buckets, that
+// keep history before the bucket is deleted.
+func (tx *TX) recordBucketHistory(fragments [][]byte, b *bbolt.Bucket) error {
+	if !tx.db.hasHistory() {
+		return nil
+	}
+
+	var nested [][]byte
+
+	bucket := tx.newBucket(fragments[len(fragments)-1], joinPath(fragments), b)
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if v == nil {
+			nested = append(nested, cloneBytes(k))
+			continue
+		}
+		if bucket.history != nil {
+			err := bucket.recordHistory(cloneBytes(k))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, name := range nested {
+		err := tx.recordBucketHistory(append(cloneFragments(fragments), name), b.Bucket(name))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Done
+	return nil
+}
+
+// pruneHistoryKey removes the past values of a key exceeding the retention and returns how many were
+// removed.
+func (tx *TX) pruneHistoryKey(fragments [][]byte, shadow *bbolt.Bucket, prefix []byte, opts *HistoryOptions,
+	now time.Time,
+) (int, error) {
+	if opts.MaxVersions <= 0 && opts.MaxAge <= 0 {
+		return 0, nil
+	}
+
+	var keys [][]byte
+	var timestamps []int64
+	c := shadow.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		if len(k) != len(prefix)+historySuffixSize {
+			continue
+		}
+		keys = append(keys, cloneBytes(k))
+		timestamps = append(timestamps, decodeOrderedInt(k[len(prefix):]))
+	}
+
+	pruned := 0
+	for idx, k := range keys {
+		excess := opts.MaxVersions > 0 && len(keys)-idx > opts.MaxVersions
+		expired := opts.MaxAge > 0 && now.Sub(time.Unix(0, timestamps[idx])) > opts.MaxAge
+		if !excess && !expired {
+			break
+		}
+		tx.journalRawKey(fragments, shadow, k)
+		err := shadow.Delete(k)
+		if err != nil {
+			return pruned, err
+		}
+		pruned += 1
+	}
+
+	// Done
+	return pruned, nil
+}
+
+// initHistoryClock assigns the transaction timestamp and a unique identifier used to store past values.
+func (tx *TX) initHistoryClock() error {
+	if tx.historyID != 0 {
+		return nil
+	}
+	meta, err := tx.metaBucket()
+	if err != nil {
+		return err
+	}
+
+	var id uint64
+	if value := meta.Get(metaHistoryClockKey); value != nil {
+		id = DecodeUint64(value)
+	}
+	id += 1
+	err = meta.Put(metaHistoryClockKey, EncodeUint64(id))
+	if err != nil {
+		return err
+	}
+
+	// Done
+	tx.historyID, tx.historyTime = id, time.Now()
+	return nil
+}
+
+// historyBucket returns the shadow bucket holding the past values of a bucket, creating it if the
+// transaction is writable. It returns nil if it does not exist in a read-only transaction.
+func (tx *TX) historyBucket(path []byte) (*bbolt.Bucket, error) {
+	history, err := tx.metaSubBucket(metaHistoryBucket)
+	if err != nil || history == nil {
+		return nil, err
+	}
+	if tx.readOnly {
+		return history.Bucket(path), nil
+	}
+	return history.CreateBucketIfNotExists(path)
+}
+
+func historyFragments(path []byte) [][]byte {
+	return [][]byte{metaBucketName, metaHistoryBucket, cloneBytes(path)}
+}
+
+func (bucket *Bucket) decodeHistoryEntry(key []byte, suffix []byte, value []byte) (HistoryEntry, error) {
+	if len(suffix) != historySuffixSize || len(value) == 0 {
+		return HistoryEntry{}, errors.New("invalid history entry")
+	}
+
+	entry := HistoryEntry{
+		Until: time.Unix(0, decodeOrderedInt(suffix)),
+	}
+	if value[0] == 1 {
+		decoded, err := bucket.decodeStored(key, value[1:])
+		if err != nil {
+			return HistoryEntry{}, fmt.Errorf("cannot decode past value: %w", err)
+		}
+		entry.Value = cloneBytes(decoded)
+		if entry.Value == nil {
+			entry.Value = []byte{}
+		}
+	}
+
+	// Done
+	return entry, nil
+}
@@ -0,0 +1,209 @@
+// See the LICENSE file for license details.
+
+package boltdb_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mxmauro/boltdb/v3"
+)
+
+// -----------------------------------------------------------------------------
+
+func TestHistory(t *testing.T) {
+	db := openHistoryTestDb(t, &boltdb.HistoryOptions{})
+	defer db.Close()
+
+	write := func(cb func(b *boltdb.Bucket) error) time.Time {
+		err := db.WithinTx(boltdb.TxOptions{}, func(tx *boltdb.TX) error {
+			b, err := tx.Bucket([]byte("ledger"))
+			if err != nil {
+				return err
+			}
+			return cb(b)
+		})
+		if err != nil {
+			t.Fatalf("cannot write to test database [err=%v]", err)
+		}
+		return time.Now()
+	}
+
+	t0 := time.Now()
+	t1 := write(func(b *boltdb.Bucket) error {
+		_ = b.Put([]byte("a"), []byte("1"))
+		return b.Put([]byte("b"), []byte("1"))
+	})
+	t2 := write(func(b *boltdb.Bucket) error {
+		_ = b.Put([]byte("a"), []byte("intermediate"))
+		_ = b.Put([]byte("a"), []byte("2"))
+		return b.Delete([]byte("b"))
+	})
+	t3 := write(func(b *boltdb.Bucket) error {
+		return b.Put([]byte("c"), []byte("1"))
+	})
+
+	err := db.WithinTx(boltdb.TxOptions{ReadOnly: true}, func(tx *boltdb.TX) error {
+		b, err := tx.Bucket([]byte("ledger"))
+		if err != nil {
+			return err
+		}
+
+		// Point-in-time reads.
+		checks := []struct {
+			key      string
+			at       time.Time
+			expected string
+		}{
+			{"a", t0, ""}, {"a", t1, "1"}, {"a", t2, "2"}, {"a", t3, "2"},
+			{"b", t1, "1"}, {"b", t2, ""},
+			{"c", t2, ""}, {"c", t3, "1"},
+		}
+		for _, check := range checks {
+			value, err := b.GetAt([]byte(check.key), check.at)
+			if err != nil || string(value) != check.expected {
+				return fmt.Errorf("unexpected value [key=%s got=%q err=%v]", check.key, value, err)
+			}
+		}
+
+		// History.
+		var entries []boltdb.HistoryEntry
+		err = b.History([]byte("a"), func(entry boltdb.HistoryEntry) (bool, error) {
+			entries = append(entries, entry)
+			return false, nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(entries) != 2 || entries[0].Value != nil || string(entries[1].Value) != "1" ||
+			!entries[0].Until.Before(entries[1].Until) {
+			return fmt.Errorf("unexpected history [entries=%v]", entries)
+		}
+
+		// Range reads.
+		for at, expected := range map[time.Time]string{t0: "", t1: "a=1,b=1,", t2: "a=2,", t3: "a=2,c=1,"} {
+			got := ""
+			err = b.RangeAt(nil, at, func(key []byte, value []byte) (bool, error) {
+				got += string(key) + "=" + string(value) + ","
+				return false, nil
+			})
+			if err != nil || got != expected {
+				return fmt.Errorf("unexpected range [got=%q expected=%q err=%v]", got, expected, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected history state [err=%v]", err)
+	}
+}
+
+func TestHistoryRetention(t *testing.T) {
+	db := openHistoryTestDb(t, &boltdb.HistoryOptions{MaxVersions: 2, MaxAge: 50 * time.Millisecond})
+	defer db.Close()
+
+	for i := 0; i < 5; i++ {
+		if err := db.Put([]byte("ledger"), []byte("a"), []byte{byte('0' + i)}); err != nil {
+			t.Fatalf("cannot write to test database [err=%v]", err.Error())
+		}
+	}
+	if count := countHistory(t, db, "a"); count != 2 {
+		t.Fatalf("history was not pruned on write [count=%d]", count)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	stats, err := db.PruneHistory(context.Background(), boltdb.PruneHistoryOptions{BatchSize: 1})
+	if err != nil || stats.Buckets != 1 || stats.Pruned != 2 {
+		t.Fatalf("unexpected prune result [stats=%+v err=%v]", stats, err)
+	}
+	if count := countHistory(t, db, "a"); count != 0 {
+		t.Fatalf("history was not pruned [count=%d]", count)
+	}
+	value, err := db.Get([]byte("ledger"), []byte("a"))
+	if err != nil || string(value) != "4" {
+		t.Fatalf("unexpected value [got=%q err=%v]", value, err)
+	}
+}
+
+func openHistoryTestDb(t *testing.T, opts *boltdb.HistoryOptions) *boltdb.DB {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	db, err := boltdb.NewWithOptions(filename, boltdb.Options{
+		Buckets: []boltdb.BucketOptions{
+			{Path: "ledger", History: opts},
+		},
+	})
+	if err != nil {
+		t.Fatalf("cannot create test database [err=%v]", err.Error())
+	}
+	return db
+}
+
+func countHistory(t *testing.T, db *boltdb.DB, key string) int {
+	count := 0
+	err := db.WithinTx(boltdb.TxOptions{ReadOnly: true}, func(tx *boltdb.TX) error {
+		b, err := tx.Bucket([]byte("ledger"))
+		if err != nil {
+			return err
+		}
+		return b.History([]byte(key), func(_ boltdb.HistoryEntry) (bool, error) {
+			count += 1
+			return false, nil
+		})
+	})
+	if err != nil {
+		t.Fatalf("cannot read history [err=%v]", err)
+	}
+	return count
+}
+
+func TestHistoryDeletedBucket(t *testing.T) {
+	db, err := boltdb.NewWithOptions(filepath.Join(t.TempDir(), "test.db"), boltdb.Options{
+		Buckets: []boltdb.BucketOptions{
+			{Path: "books/*", History: &boltdb.HistoryOptions{}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("cannot create test database [err=%v]", err.Error())
+	}
+	defer db.Close()
+
+	for _, path := range []string{"books/2023", "books/2024"} {
+		if err = db.Put([]byte(path), []byte("a"), []byte(path)); err != nil {
+			t.Fatalf("cannot write to test database [err=%v]", err)
+		}
+	}
+	t1 := time.Now()
+
+	// Delete a bucket directly and another one through its parent.
+	for _, path := range []string{"books/2023", "books"} {
+		err = db.WithinTx(boltdb.TxOptions{}, func(tx *boltdb.TX) error {
+			return tx.DeleteBucket([]byte(path))
+		})
+		if err != nil {
+			t.Fatalf("cannot delete bucket [err=%v]", err)
+		}
+	}
+	t2 := time.Now()
+
+	err = db.WithinTx(boltdb.TxOptions{}, func(tx *boltdb.TX) error {
+		for _, path := range []string{"books/2023", "books/2024"} {
+			b, err := tx.Bucket([]byte(path))
+			if err != nil {
+				return err
+			}
+			if value, err := b.GetAt([]byte("a"), t1); err != nil || string(value) != path {
+				return fmt.Errorf("unexpected value [path=%s got=%q err=%v]", path, value, err)
+			}
+			if value, err := b.GetAt([]byte("a"), t2); err != nil || value != nil {
+				return fmt.Errorf("unexpected value [path=%s got=%q err=%v]", path, value, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected history state [err=%v]", err)
+	}
+}
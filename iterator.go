@@ -2,8 +2,11 @@ package boltdb
 
 import (
 	"bytes"
+	"errors"
+	"time"
 
 	"go.etcd.io/bbolt"
+	bolterrors "go.etcd.io/bbolt/errors"
 )
 
 // -----------------------------------------------------------------------------
@@ -21,10 +24,22 @@ const (
 
 // Iterator encapsulates a bucket key/value iterator
 type Iterator struct {
+	tx     *TX
 	bucket *Bucket
 	cursor *bbolt.Cursor
 	key    []byte
 	value  []byte
+
+	// rawKey is the key as stored in the database. It differs from key if keys are encrypted.
+	rawKey []byte
+
+	// decoded caches the decoded value of the current position.
+	decoded    []byte
+	hasDecoded bool
+	err        error
+
+	// empty is set when the traversed namespace does not exist yet.
+	empty bool
 }
 
 // WithIteratorOptions specifies a set of options when creating a new iterator.
@@ -72,20 +87,43 @@ func (iter *Iterator) HasKeyPrefix(prefix []byte) bool {
 }
 
 // Value gets the current iterator value. The value is valid until the iterator position is changed.
-// IMPORTANT: If value is nil, then the key points to a nested bucket name.
+// IMPORTANT: If value is nil, then the key points to a nested bucket name, unless the value cannot be
+// decoded. In that case, Err returns the reason.
 func (iter *Iterator) Value() []byte {
-	return iter.value
+	if iter.value == nil || iter.bucket == nil || (len(iter.bucket.codec) == 0 && !iter.bucket.versioned) {
+		return iter.value
+	}
+	if !iter.hasDecoded {
+		var err error
+
+		value := iter.value
+		if len(iter.bucket.codec) > 0 {
+			value, _, err = iter.bucket.codec.decode(iter.bucket.path, iter.key, value)
+		}
+		if err == nil && iter.bucket.versioned {
+			_, value, err = splitVersion(value)
+		}
+		if err != nil {
+			value = nil
+			if iter.err == nil {
+				iter.err = err
+			}
+		}
+		iter.decoded, iter.hasDecoded = value, true
+	}
+	return iter.decoded
 }
 
 // CopyValue acts like Value but returns a copy of the value, so it remains valid after moving the iterator
 // position.
 func (iter *Iterator) CopyValue() []byte {
-	if iter.value == nil {
-		return nil
-	}
-	copiedValue := make([]byte, len(iter.value))
-	copy(copiedValue, iter.value)
-	return copiedValue
+	return cloneBytes(iter.Value())
+}
+
+// Err returns the first error found while decoding a key or a value or trying an unsupported seek
+// method, if any.
+func (iter *Iterator) Err() error {
+	return iter.err
 }
 
 // IsNestedBucket returns true if the iterator is pointing to a nested bucket.
@@ -93,39 +131,73 @@ func (iter *Iterator) IsNestedBucket() bool {
 	return iter.key != nil && iter.value == nil
 }
 
-// Bucket returns the bucket associated with this iterator.
+// Bucket returns the bucket associated with this iterator. It is nil if the iterator traverses the
+// top-level buckets of the transaction.
 func (iter *Iterator) Bucket() *Bucket {
 	return iter.bucket
 }
 
+// NestedBucket returns the nested bucket the iterator is pointing to. Unlike looking it up by path, it
+// works with any bucket name, including names that contain slashes.
+func (iter *Iterator) NestedBucket() (*Bucket, error) {
+	var fragments [][]byte
+	var parent *bbolt.Bucket
+
+	if !iter.IsNestedBucket() {
+		return nil, ErrBucketNotFound
+	}
+	if iter.bucket != nil {
+		fragments, parent = iter.bucket.fragments(), iter.bucket.b
+	} else if iter.tx.root != nil {
+		fragments = splitBucketFragments(iter.tx.root)
+		parent = iter.tx.rawBucket(fragments)
+	} else if isReservedBucketName(iter.rawKey) {
+		return nil, ErrInvalidPath
+	}
+	return iter.tx.childBucket(fragments, parent, iter.rawKey)
+}
+
 // First moves the iterator to the first entry inside the bucket.
 func (iter *Iterator) First() bool {
-	iter.key, iter.value = iter.cursor.First()
-	return iter.key != nil
+	return iter.setForwardPosition(iter.cursor.First())
 }
 
 // Last moves the iterator to the last entry inside the bucket.
 func (iter *Iterator) Last() bool {
-	iter.key, iter.value = iter.cursor.Last()
-	return iter.key != nil
+	return iter.setBackwardPosition(iter.cursor.Last())
 }
 
 // Next moves the iterator to the next entry inside the bucket.
 func (iter *Iterator) Next() bool {
-	iter.key, iter.value = iter.cursor.Next()
-	return iter.key != nil
+	return iter.setForwardPosition(iter.cursor.Next())
 }
 
 // Prev moves the iterator to the previous entry inside the bucket.
 func (iter *Iterator) Prev() bool {
-	iter.key, iter.value = iter.cursor.Prev()
-	return iter.key != nil
+	return iter.setBackwardPosition(iter.cursor.Prev())
 }
 
 // Seek searches for a key match with the provided prefix and method. Prefix can be nil.
+// NOTE: On buckets with encrypted keys, only SeekExact can be used with a non-empty prefix. Other methods
+// fail and Err returns ErrUnsupportedSeek.
 func (iter *Iterator) Seek(prefix []byte, method SeekMethod) bool {
 	origPrefix := prefix
 
+	if iter.bucket != nil && iter.bucket.keys != nil && len(prefix) > 0 {
+		if method != SeekExact {
+			if iter.err == nil {
+				iter.err = ErrUnsupportedSeek
+			}
+			return iter.clean()
+		}
+		for _, encodedKey := range iter.bucket.keys.encodings(iter.bucket.path, prefix) {
+			if iter.seekRaw(encodedKey) && bytes.Equal(iter.rawKey, encodedKey) {
+				return true
+			}
+		}
+		return iter.clean()
+	}
+
 	if len(prefix) > 0 && method == SeekPrefixReverse {
 		var i int
 
@@ -160,7 +232,7 @@ func (iter *Iterator) Seek(prefix []byte, method SeekMethod) bool {
 	}
 
 	// Search for the prefix.
-	iter.key, iter.value = iter.cursor.Seek(prefix)
+	_ = iter.setForwardPosition(iter.cursor.Seek(prefix))
 
 	switch method {
 	case SeekExact:
@@ -215,12 +287,203 @@ func (iter *Iterator) Delete() error {
 		return ErrInvalidCursorPosition
 	}
 	if iter.value != nil {
+		err := iter.tx.checkPathPermission(iter.bucket.path, PermissionWrite)
+		if err == nil {
+			err = iter.bucket.onRemoved(iter.key, [][]byte{iter.rawKey})
+		}
+		if err != nil {
+			return err
+		}
+		iter.tx.journalKey(iter.bucket, iter.rawKey)
 		return iter.cursor.Delete()
 	}
-	return iter.bucket.DeleteBucket(iter.key)
+	return iter.deleteBucket()
+}
+
+// deleteBucket deletes the nested bucket the iterator is pointing to by its raw name, which may not be a
+// valid path.
+func (iter *Iterator) deleteBucket() error {
+	var fragments [][]byte
+	var parent *bbolt.Bucket
+
+	if iter.tx.readOnly {
+		return ErrTxNotWritable
+	}
+	if iter.bucket != nil {
+		fragments, parent = iter.bucket.fragments(), iter.bucket.b
+	} else if iter.tx.root != nil {
+		fragments = splitBucketFragments(iter.tx.root)
+		parent = iter.tx.rawBucket(fragments)
+	} else if isReservedBucketName(iter.key) {
+		return ErrInvalidPath
+	}
+	name := cloneBytes(iter.rawKey)
+
+	if iter.tx.tracksBuckets() {
+		var b *bbolt.Bucket
+
+		if parent != nil {
+			b = parent.Bucket(name)
+		} else {
+			b = iter.tx.tx.Bucket(name)
+		}
+		err := iter.tx.onBucketDeleted(append(fragments, name), b)
+		if err != nil {
+			return err
+		}
+	}
+	var err error
+	if parent != nil {
+		err = parent.DeleteBucket(name)
+	} else {
+		err = iter.tx.tx.DeleteBucket(name)
+	}
+
+	// Done
+	if err != nil && errors.Is(err, bolterrors.ErrBucketNotFound) {
+		return nil // Ignore bucket not found errors.
+	}
+	return err
+}
+
+func (iter *Iterator) setPosition(key []byte, value []byte) bool {
+	iter.key, iter.rawKey, iter.value = key, key, value
+	iter.decoded, iter.hasDecoded = nil, false
+	if key == nil {
+		return false
+	}
+	iter.tx.keysScanned += 1
+
+	// Nested bucket names are never encrypted.
+	if iter.bucket != nil && iter.bucket.keys != nil && value != nil {
+		decodedKey, _, err := iter.bucket.keys.decode(iter.bucket.path, key)
+		if err == nil {
+			iter.key = decodedKey
+		} else if iter.err == nil {
+			iter.err = err
+		}
+	}
+	return true
+}
+
+// seekRaw moves the iterator to the first stored key greater than or equal to the given one.
+func (iter *Iterator) seekRaw(rawKey []byte) bool {
+	return iter.setForwardPosition(iter.cursor.Seek(rawKey))
+}
+
+// setForwardPosition sets the iterator position, moving forward past the metadata bucket, and the ones
+// the principal cannot access, when traversing the top-level buckets.
+func (iter *Iterator) setForwardPosition(key []byte, value []byte) bool {
+	if iter.empty {
+		return iter.clean()
+	}
+	for iter.bucket == nil && key != nil && iter.tx.hiddenTopLevel(key) {
+		key, value = iter.cursor.Next()
+	}
+	return iter.setPosition(key, value)
+}
+
+// setBackwardPosition acts like setForwardPosition but moves backwards.
+func (iter *Iterator) setBackwardPosition(key []byte, value []byte) bool {
+	if iter.empty {
+		return iter.clean()
+	}
+	for iter.bucket == nil && key != nil && iter.tx.hiddenTopLevel(key) {
+		key, value = iter.cursor.Prev()
+	}
+	return iter.setPosition(key, value)
 }
 
 func (iter *Iterator) clean() bool {
-	iter.key, iter.value = nil, nil
+	iter.key, iter.rawKey, iter.value = nil, nil, nil
+	iter.decoded, iter.hasDecoded = nil, false
 	return false
 }
+
+func withIterator(iter *Iterator, opts WithIteratorOptions, cb WithinIteratorCallback) error {
+	err := startIterator(iter, opts)
+	if err != nil {
+		return err
+	}
+
+	// Iterate.
+	var visited int64
+	start := time.Now()
+	defer func() {
+		iter.notifyScan(visited, start)
+	}()
+	for iter.IsValid() {
+		if iter.err != nil {
+			return iter.err
+		}
+
+		// Call callback.
+		visited += 1
+		stop, err := cb(iter)
+		if err != nil {
+			return err
+		}
+		if iter.err != nil {
+			return iter.err
+		}
+		if stop {
+			break
+		}
+
+		// Advance to the next item.
+		advanceIterator(iter, opts)
+	}
+
+	// Done
+	return nil
+}
+
+// startIterator moves the iterator to the first entry matching the options.
+func startIterator(iter *Iterator, opts WithIteratorOptions) error {
+	if len(opts.Prefix) > 0 && len(opts.FirstKey) > 0 {
+		return errors.New("prefix and first key cannot be used at the same time")
+	}
+	if (len(opts.Prefix) > 0 || len(opts.FirstKey) > 0) && iter.bucket != nil && iter.bucket.keys != nil {
+		return ErrUnsupportedSeek
+	}
+
+	// Search for the first match.
+	if len(opts.Prefix) > 0 {
+		if !opts.Reverse {
+			_ = iter.Seek(opts.Prefix, SeekPrefix)
+		} else {
+			_ = iter.Seek(opts.Prefix, SeekPrefixReverse)
+		}
+	} else if len(opts.FirstKey) > 0 {
+		if !opts.Reverse {
+			_ = iter.Seek(opts.FirstKey, SeekGreaterOrEqual)
+		} else {
+			_ = iter.Seek(opts.FirstKey, SeekLessOrEqual)
+		}
+	} else {
+		if !opts.Reverse {
+			_ = iter.First()
+		} else {
+			_ = iter.Last()
+		}
+	}
+
+	// Done
+	return nil
+}
+
+// advanceIterator moves the iterator to the next entry matching the options.
+func advanceIterator(iter *Iterator, opts WithIteratorOptions) {
+	if !opts.Reverse {
+		_ = iter.Next()
+	} else {
+		_ = iter.Prev()
+	}
+
+	// If we passed a prefix as an option, check if it has it.
+	if iter.IsValid() && len(opts.Prefix) > 0 {
+		if !bytes.HasPrefix(iter.Key(), opts.Prefix) {
+			_ = iter.clean()
+		}
+	}
+}
@@ -5,9 +5,11 @@ package boltdb_test
 import (
 	"bytes"
 	"errors"
+	"path/filepath"
 	"testing"
 
 	"github.com/mxmauro/boltdb/v3"
+	"go.etcd.io/bbolt"
 )
 
 // -----------------------------------------------------------------------------
@@ -202,6 +204,133 @@ func TestIteratorDeleteKeyAndBucket(t *testing.T) {
 	}
 }
 
+func TestIteratorDeleteRawBucketName(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+
+	// A top-level bucket whose name is not a valid path can only be created through the raw database.
+	raw, err := bbolt.Open(filename, 0600, nil)
+	if err != nil {
+		t.Fatalf("cannot create test database [err=%v]", err.Error())
+	}
+	err = raw.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("a/b"))
+		if err == nil {
+			var b *bbolt.Bucket
+
+			b, err = tx.CreateBucket([]byte("a"))
+			if err == nil {
+				_, err = b.CreateBucket([]byte("b"))
+			}
+		}
+		return err
+	})
+	_ = raw.Close()
+	if err != nil {
+		t.Fatalf("cannot prepare test data [err=%v]", err.Error())
+	}
+
+	db, err := boltdb.New(filename)
+	if err != nil {
+		t.Fatalf("cannot open test database [err=%v]", err.Error())
+	}
+	defer db.Close()
+
+	err = db.WithinTx(boltdb.TxOptions{}, func(tx *boltdb.TX) error {
+		iter := tx.Iterate()
+		if !iter.Seek([]byte("a/b"), boltdb.SeekExact) {
+			t.Fatalf("cannot position iterator on top-level bucket")
+		}
+		return iter.Delete()
+	})
+	if err != nil {
+		t.Fatalf("cannot delete through iterator [err=%v]", err.Error())
+	}
+
+	err = db.WithinTx(boltdb.TxOptions{ReadOnly: true}, func(tx *boltdb.TX) error {
+		iter := tx.Iterate()
+		if iter.Seek([]byte("a/b"), boltdb.SeekExact) {
+			t.Fatalf("expected top-level bucket to be deleted")
+		}
+		_, err := tx.Bucket([]byte("a/b"))
+		return err
+	})
+	if err != nil {
+		t.Fatalf("expected nested bucket to be kept [err=%v]", err.Error())
+	}
+}
+
+func TestIteratorNestedBucketRawName(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+
+	// Bucket names with slashes can only be created through the raw database.
+	raw, err := bbolt.Open(filename, 0600, nil)
+	if err != nil {
+		t.Fatalf("cannot create test database [err=%v]", err.Error())
+	}
+	err = raw.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("a/b"))
+		if err == nil {
+			err = b.Put([]byte("top"), []byte("1"))
+		}
+		if err == nil {
+			b, err = b.CreateBucket([]byte("c/d"))
+		}
+		if err == nil {
+			err = b.Put([]byte("nested"), []byte("2"))
+		}
+		return err
+	})
+	_ = raw.Close()
+	if err != nil {
+		t.Fatalf("cannot prepare test data [err=%v]", err.Error())
+	}
+
+	db, err := boltdb.New(filename)
+	if err != nil {
+		t.Fatalf("cannot open test database [err=%v]", err.Error())
+	}
+	defer db.Close()
+
+	err = db.WithinTx(boltdb.TxOptions{ReadOnly: true}, func(tx *boltdb.TX) error {
+		iter := tx.Iterate()
+		if !iter.Seek([]byte("a/b"), boltdb.SeekExact) {
+			t.Fatalf("cannot position iterator on top-level bucket")
+		}
+		top, err := iter.NestedBucket()
+		if err != nil {
+			return err
+		}
+		if value := top.Get([]byte("top")); string(value) != "1" {
+			t.Errorf("unexpected value in top-level bucket [got=%q]", value)
+		}
+
+		iter = top.Iterate()
+		if !iter.Seek([]byte("c/d"), boltdb.SeekExact) {
+			t.Fatalf("cannot position iterator on nested bucket")
+		}
+		nested, err := iter.NestedBucket()
+		if err != nil {
+			return err
+		}
+		if value := nested.Get([]byte("nested")); string(value) != "2" {
+			t.Errorf("unexpected value in nested bucket [got=%q]", value)
+		}
+
+		// Plain keys are not buckets.
+		iter = top.Iterate()
+		if !iter.Seek([]byte("top"), boltdb.SeekExact) {
+			t.Fatalf("cannot position iterator on key")
+		}
+		if _, err = iter.NestedBucket(); !errors.Is(err, boltdb.ErrBucketNotFound) {
+			t.Errorf("expected ErrBucketNotFound [got=%v]", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("cannot open nested buckets [err=%v]", err.Error())
+	}
+}
+
 func TestIteratorDeleteInvalidPosition(t *testing.T) {
 	db := openTestDb(t)
 	defer db.Close()
@@ -280,6 +409,55 @@ func TestIteratorReverseEdgeCases(t *testing.T) {
 	checkSeek([]byte("aaa"), boltdb.SeekLessOrEqual, nil)
 }
 
+func TestTxIterator(t *testing.T) {
+	db := openTestDb(t)
+	defer db.Close()
+
+	for _, name := range []string{"bucket-a", "bucket-b", "bucket-c/child"} {
+		if err := db.Put([]byte(name), []byte("key"), []byte("value")); err != nil {
+			t.Fatalf("cannot write to test database [err=%v]", err.Error())
+		}
+	}
+
+	err := db.WithinTx(boltdb.TxOptions{}, func(tx *boltdb.TX) error {
+		var names []string
+
+		err := tx.WithIterator(boltdb.WithIteratorOptions{}, func(iter *boltdb.Iterator) (bool, error) {
+			if !iter.IsNestedBucket() {
+				t.Fatalf("expected top-level entries to be buckets [key=%q]", iter.Key())
+			}
+			names = append(names, string(iter.Key()))
+			return false, nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(names) != 3 || names[0] != "bucket-a" || names[2] != "bucket-c" {
+			t.Fatalf("unexpected top-level buckets [got=%v]", names)
+		}
+
+		iter := tx.Iterate()
+		if !iter.Seek([]byte("bucket-b"), boltdb.SeekExact) {
+			t.Fatalf("cannot position iterator on top-level bucket")
+		}
+		return iter.Delete()
+	})
+	if err != nil {
+		t.Fatalf("cannot iterate top-level buckets [err=%v]", err.Error())
+	}
+
+	err = db.WithinTx(boltdb.TxOptions{ReadOnly: true}, func(tx *boltdb.TX) error {
+		_, err := tx.Bucket([]byte("bucket-b"))
+		if !errors.Is(err, boltdb.ErrBucketNotFound) {
+			t.Fatalf("expected top-level bucket to be deleted [got=%v]", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("cannot verify iterator deletions [err=%v]", err.Error())
+	}
+}
+
 func seekMethod2string(m boltdb.SeekMethod) string {
 	switch m {
 	case boltdb.SeekExact:
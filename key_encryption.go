@@ -0,0 +1,136 @@
+// See the LICENSE file for license details.
+
+package boltdb
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+// -----------------------------------------------------------------------------
+
+// keyCodec deterministically encrypts keys, so the same key always gets the same stored representation
+// and exact lookups work. It follows the SIV construction: a synthetic IV computed as the HMAC of the
+// bucket path and key is used both as the AES-CTR IV and as the authentication tag.
+//
+// The stored format is: version (1 byte) + key id (4 bytes) + tag (16 bytes) + ciphertext.
+type keyCodec struct {
+	active *keyCipher
+
+	// ciphers holds all the keys, starting with the active one.
+	ciphers []*keyCipher
+}
+
+type keyCipher struct {
+	id     uint32
+	block  cipher.Block
+	macKey []byte
+}
+
+// -----------------------------------------------------------------------------
+
+const (
+	keyEncryptionFormatVersion = 1
+	keyEncryptionTagSize       = 16
+	keyEncryptionHeaderSize    = 1 + 4 + keyEncryptionTagSize
+)
+
+// -----------------------------------------------------------------------------
+
+func newKeyCodec(opts *EncryptionOptions) (*keyCodec, error) {
+	codec := &keyCodec{}
+
+	for _, key := range opts.Keys {
+		// Derive independent keys for encryption and authentication.
+		encKey := deriveKey(key.Key, "boltdb key encryption")
+		block, err := aes.NewCipher(encKey)
+		if err != nil {
+			return nil, fmt.Errorf("%w [id=%d]: %v", ErrInvalidEncryptionKey, key.ID, err)
+		}
+
+		kc := &keyCipher{
+			id:     key.ID,
+			block:  block,
+			macKey: deriveKey(key.Key, "boltdb key authentication"),
+		}
+		if key.ID == opts.ActiveKeyID {
+			codec.active = kc
+			codec.ciphers = append([]*keyCipher{kc}, codec.ciphers...)
+		} else {
+			codec.ciphers = append(codec.ciphers, kc)
+		}
+	}
+
+	// Done
+	return codec, nil
+}
+
+// encode returns the stored representation of a key using the active key.
+func (c *keyCodec) encode(path []byte, key []byte) []byte {
+	return c.active.encode(path, key)
+}
+
+// encodings returns the stored representations of a key using every known key, starting with the
+// active one.
+func (c *keyCodec) encodings(path []byte, key []byte) [][]byte {
+	encoded := make([][]byte, len(c.ciphers))
+	for idx, kc := range c.ciphers {
+		encoded[idx] = kc.encode(path, key)
+	}
+	return encoded
+}
+
+// decode returns the original key. If stale is true, the key was encrypted with an inactive key.
+func (c *keyCodec) decode(path []byte, stored []byte) ([]byte, bool, error) {
+	if len(stored) < keyEncryptionHeaderSize || stored[0] != keyEncryptionFormatVersion {
+		return nil, false, fmt.Errorf("%w [path=%q]: invalid key header", ErrDecryptionFailed, path)
+	}
+
+	id := binary.LittleEndian.Uint32(stored[1:5])
+	var kc *keyCipher
+	for _, candidate := range c.ciphers {
+		if candidate.id == id {
+			kc = candidate
+			break
+		}
+	}
+	if kc == nil {
+		return nil, false, fmt.Errorf("%w [path=%q id=%d]", ErrUnknownEncryptionKey, path, id)
+	}
+
+	tag := stored[5:keyEncryptionHeaderSize]
+	key := make([]byte, len(stored)-keyEncryptionHeaderSize)
+	cipher.NewCTR(kc.block, tag).XORKeyStream(key, stored[keyEncryptionHeaderSize:])
+	if !hmac.Equal(tag, kc.tag(path, key)) {
+		return nil, false, fmt.Errorf("%w [path=%q]: invalid key tag", ErrDecryptionFailed, path)
+	}
+
+	// Done
+	return key, kc != c.active, nil
+}
+
+func (kc *keyCipher) encode(path []byte, key []byte) []byte {
+	stored := make([]byte, keyEncryptionHeaderSize+len(key))
+	stored[0] = keyEncryptionFormatVersion
+	binary.LittleEndian.PutUint32(stored[1:5], kc.id)
+	tag := kc.tag(path, key)
+	copy(stored[5:], tag)
+	cipher.NewCTR(kc.block, tag).XORKeyStream(stored[keyEncryptionHeaderSize:], key)
+	return stored
+}
+
+func (kc *keyCipher) tag(path []byte, key []byte) []byte {
+	mac := hmac.New(sha256.New, kc.macKey)
+	_, _ = mac.Write(encryptionAAD(path, key))
+	return mac.Sum(nil)[:keyEncryptionTagSize]
+}
+
+func deriveKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
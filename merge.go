@@ -0,0 +1,113 @@
+// See the LICENSE file for license details.
+
+package boltdb
+
+import (
+	"bytes"
+	"container/heap"
+)
+
+// -----------------------------------------------------------------------------
+
+// MergedIteratorCallback is called for every key found while iterating several databases at once. The
+// key and the value are only valid during the call.
+type MergedIteratorCallback func(key []byte, value []byte) (stop bool, err error)
+
+type mergeEntry struct {
+	iter   *Iterator
+	source int
+}
+
+type mergeHeap struct {
+	entries []mergeEntry
+	reverse bool
+}
+
+// -----------------------------------------------------------------------------
+
+// mergeIterators traverses several iterators at once in key order. Nested buckets are skipped. Keys
+// found in more than one iterator are reported once per iterator, in the order of the iterators.
+func mergeIterators(iters []*Iterator, opts WithIteratorOptions, cb MergedIteratorCallback) error {
+	h := &mergeHeap{
+		entries: make([]mergeEntry, 0, len(iters)),
+		reverse: opts.Reverse,
+	}
+	for idx, iter := range iters {
+		err := startIterator(iter, opts)
+		if err != nil {
+			return err
+		}
+		skipNestedBuckets(iter, opts)
+		if iter.err != nil {
+			return iter.err
+		}
+		if iter.IsValid() {
+			h.entries = append(h.entries, mergeEntry{iter: iter, source: idx})
+		}
+	}
+	heap.Init(h)
+
+	for h.Len() > 0 {
+		iter := h.entries[0].iter
+
+		value := iter.Value()
+		if iter.err != nil {
+			return iter.err
+		}
+		stop, err := cb(iter.Key(), value)
+		if err != nil || stop {
+			return err
+		}
+
+		// Advance the iterator that provided the key.
+		advanceIterator(iter, opts)
+		skipNestedBuckets(iter, opts)
+		if iter.err != nil {
+			return iter.err
+		}
+		if iter.IsValid() {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+
+	// Done
+	return nil
+}
+
+func skipNestedBuckets(iter *Iterator, opts WithIteratorOptions) {
+	for iter.IsNestedBucket() {
+		advanceIterator(iter, opts)
+	}
+}
+
+func (h *mergeHeap) Len() int {
+	return len(h.entries)
+}
+
+func (h *mergeHeap) Less(i, j int) bool {
+	cmp := bytes.Compare(h.entries[i].iter.Key(), h.entries[j].iter.Key())
+	if cmp == 0 {
+		return h.entries[i].source < h.entries[j].source
+	}
+	if h.reverse {
+		return cmp > 0
+	}
+	return cmp < 0
+}
+
+func (h *mergeHeap) Swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+}
+
+func (h *mergeHeap) Push(x any) {
+	h.entries = append(h.entries, x.(mergeEntry))
+}
+
+func (h *mergeHeap) Pop() any {
+	last := len(h.entries) - 1
+	entry := h.entries[last]
+	h.entries = h.entries[:last]
+	return entry
+}
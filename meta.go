@@ -0,0 +1,105 @@
+// See the LICENSE file for license details.
+
+package boltdb
+
+import (
+	"bytes"
+
+	"go.etcd.io/bbolt"
+)
+
+// -----------------------------------------------------------------------------
+
+// metaBucketName is the top-level bucket where the wrapper keeps its own data. It is hidden from the
+// top-level iterators and cannot be opened using paths.
+var metaBucketName = []byte("\x00boltdb")
+
+var (
+	metaSchemaVersionKey       = []byte("schema_version")
+	metaMigrationCheckpointKey = []byte("migration_checkpoint")
+)
+
+// replicatedMetaKeys are the keys of the metadata bucket shipped to followers along with the usage
+// records.
+var replicatedMetaKeys = [][]byte{
+	metaACLPolicyKey, metaSchemaVersionKey, metaMigrationCheckpointKey, metaVersionClockKey,
+}
+
+// -----------------------------------------------------------------------------
+
+func isReservedBucketName(name []byte) bool {
+	return bytes.Equal(name, metaBucketName)
+}
+
+// metaBucket returns the metadata bucket, creating it if the transaction is writable. It returns nil if
+// it does not exist in a read-only transaction.
+func (tx *TX) metaBucket() (*bbolt.Bucket, error) {
+	if tx.readOnly {
+		return tx.tx.Bucket(metaBucketName), nil
+	}
+	return tx.tx.CreateBucketIfNotExists(metaBucketName)
+}
+
+// metaSubBucket returns a nested bucket of the metadata bucket, creating it if the transaction is
+// writable. It returns nil if it does not exist in a read-only transaction.
+func (tx *TX) metaSubBucket(name []byte) (*bbolt.Bucket, error) {
+	meta, err := tx.metaBucket()
+	if err != nil || meta == nil {
+		return nil, err
+	}
+	if tx.readOnly {
+		return meta.Bucket(name), nil
+	}
+	return meta.CreateBucketIfNotExists(name)
+}
+
+// writeMeta stores, or removes if the value is nil, a key of the metadata bucket, or of the given nested
+// bucket of it, and records the change in the change log so followers receive it. Only the keys accepted
+// by replicatedMetaBucket must be written this way.
+func (tx *TX) writeMeta(sub []byte, b *bbolt.Bucket, key []byte, value []byte) error {
+	var err error
+
+	op := ChangePut
+	if value == nil {
+		op = ChangeDelete
+		err = b.Delete(key)
+	} else {
+		err = b.Put(key, value)
+	}
+	if err != nil {
+		return err
+	}
+
+	path := [][]byte{metaBucketName}
+	if sub != nil {
+		path = append(path, sub)
+	}
+	return tx.appendChange(ChangeEntry{
+		Operation: op,
+		Path:      path,
+		Key:       key,
+		Value:     value,
+	})
+}
+
+// replicatedMetaBucket returns the metadata bucket located at the given path fragments, creating it, if
+// it holds metadata shipped to followers: the access control policy, the schema version, the migration
+// checkpoint, the version clock and the tracked usage and quotas. It returns nil otherwise.
+func (tx *TX) replicatedMetaBucket(fragments [][]byte, key []byte) (*bbolt.Bucket, error) {
+	if len(fragments) == 0 || !isReservedBucketName(fragments[0]) {
+		return nil, nil
+	}
+	switch len(fragments) {
+	case 1:
+		for _, metaKey := range replicatedMetaKeys {
+			if bytes.Equal(key, metaKey) {
+				return tx.metaBucket()
+			}
+		}
+	case 2:
+		if bytes.Equal(fragments[1], metaUsageBucket) {
+			return tx.metaSubBucket(metaUsageBucket)
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,279 @@
+// See the LICENSE file for license details.
+
+package boltdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// -----------------------------------------------------------------------------
+
+// Migration is a step that upgrades the database layout to a schema version.
+type Migration struct {
+	// Version is the schema version the database has after applying the migration. It must be greater
+	// than zero and unique.
+	Version     uint64
+	Description string
+
+	// Up applies the migration within a single write transaction.
+	Up func(tx *TX) error
+
+	// UpBatch, if set, is used instead of Up for large rewrites. It is called repeatedly, each time in a
+	// new write transaction, with the checkpoint returned by the previous call, nil for the first one,
+	// until it reports it is done. Checkpoints are stored in the same transaction as the changes, so an
+	// interrupted migration resumes from the last committed batch.
+	UpBatch func(tx *TX, checkpoint []byte) (next []byte, done bool, err error)
+}
+
+// MigrateOptions specifies a set of options when running migrations.
+type MigrateOptions struct {
+	// DryRun applies all the pending migrations within a single transaction which is then rolled back.
+	DryRun bool
+}
+
+// MigrationReport contains the result of running migrations.
+type MigrationReport struct {
+	From    uint64
+	To      uint64
+	Applied []uint64
+	DryRun  bool
+}
+
+type migrationCheckpoint struct {
+	Version    uint64 `json:"version"`
+	Checkpoint []byte `json:"checkpoint"`
+}
+
+// -----------------------------------------------------------------------------
+
+// SchemaVersion returns the schema version stored in the database. It is zero if no migration was
+// applied.
+func (db *DB) SchemaVersion() (uint64, error) {
+	var version uint64
+
+	err := db.WithinTx(TxOptions{ReadOnly: true}, func(tx *TX) error {
+		var err error
+
+		version, err = tx.schemaVersion()
+		return err
+	})
+
+	// Done
+	return version, err
+}
+
+// Migrate applies, in order, the migrations with a version greater than the stored schema version. Each
+// migration runs in its own write transaction, or in several if it is batched, along with the update of
+// the schema version.
+func (db *DB) Migrate(migrations []Migration, opts MigrateOptions) (MigrationReport, error) {
+	report := MigrationReport{
+		DryRun: opts.DryRun,
+	}
+
+	// Validate migrations.
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+	for idx, m := range sorted {
+		if m.Version == 0 || (idx > 0 && sorted[idx-1].Version == m.Version) {
+			return report, fmt.Errorf("%w [version=%d]: versions must be unique and greater than zero",
+				ErrInvalidMigration, m.Version)
+		}
+		if m.Up == nil && m.UpBatch == nil {
+			return report, fmt.Errorf("%w [version=%d]: missing up function", ErrInvalidMigration, m.Version)
+		}
+	}
+
+	current, err := db.SchemaVersion()
+	if err != nil {
+		return report, err
+	}
+	report.From, report.To = current, current
+
+	var latest uint64
+	if len(sorted) > 0 {
+		latest = sorted[len(sorted)-1].Version
+	}
+	if current > latest {
+		return report, fmt.Errorf("%w [stored=%d known=%d]", ErrSchemaTooNew, current, latest)
+	}
+
+	var pending []Migration
+	for _, m := range sorted {
+		if m.Version > current {
+			pending = append(pending, m)
+		}
+	}
+	if len(pending) == 0 {
+		return report, nil
+	}
+
+	// On dry runs, apply everything in a single transaction and discard it.
+	if opts.DryRun {
+		tx, err := db.BeginTx(TxOptions{})
+		if err != nil {
+			return report, err
+		}
+		defer tx.Rollback()
+
+		for _, m := range pending {
+			err = m.apply(tx)
+			if err != nil {
+				return report, fmt.Errorf("migration %d failed: %w", m.Version, err)
+			}
+			report.Applied = append(report.Applied, m.Version)
+			report.To = m.Version
+		}
+		return report, nil
+	}
+
+	// Another process may have migrated the database since the version was read, so every transaction
+	// checks the stored version again and skips migrations that are already applied.
+	for _, m := range pending {
+		applied := false
+		if m.UpBatch == nil {
+			err = db.WithinTx(TxOptions{}, func(tx *TX) error {
+				stored, err2 := tx.schemaVersion()
+				if err2 != nil || stored >= m.Version {
+					return err2
+				}
+				applied = true
+				return m.apply(tx)
+			})
+		} else {
+			done := false
+			for !done && err == nil {
+				err = db.WithinTx(TxOptions{}, func(tx *TX) error {
+					stored, err2 := tx.schemaVersion()
+					if err2 != nil {
+						return err2
+					}
+					if stored >= m.Version {
+						done = true
+						return nil
+					}
+					checkpoint, err2 := tx.migrationCheckpoint(m.Version)
+					if err2 != nil {
+						return err2
+					}
+					next, batchDone, err2 := m.UpBatch(tx, checkpoint)
+					if err2 != nil {
+						return err2
+					}
+					if !batchDone {
+						return tx.setMigrationCheckpoint(m.Version, next)
+					}
+					done, applied = true, true
+					return tx.finishMigration(m.Version)
+				})
+			}
+		}
+		if err != nil {
+			return report, fmt.Errorf("migration %d failed: %w", m.Version, err)
+		}
+		if applied {
+			report.Applied = append(report.Applied, m.Version)
+		}
+		report.To = m.Version
+	}
+
+	// Done
+	return report, nil
+}
+
+// -----------------------------------------------------------------------------
+
+// apply runs the whole migration within the given transaction. Batched migrations resume from the stored
+// checkpoint, if any, and run until done.
+func (m *Migration) apply(tx *TX) error {
+	if m.UpBatch == nil {
+		err := m.Up(tx)
+		if err != nil {
+			return err
+		}
+	} else {
+		checkpoint, err := tx.migrationCheckpoint(m.Version)
+		if err != nil {
+			return err
+		}
+		for done := false; !done; {
+			checkpoint, done, err = m.UpBatch(tx, checkpoint)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Done
+	return tx.finishMigration(m.Version)
+}
+
+func (tx *TX) schemaVersion() (uint64, error) {
+	meta, err := tx.metaBucket()
+	if err != nil || meta == nil {
+		return 0, err
+	}
+	value := meta.Get(metaSchemaVersionKey)
+	if value == nil {
+		return 0, nil
+	}
+	return DecodeUint64(value), nil
+}
+
+// finishMigration stores the new schema version and removes the migration checkpoint.
+func (tx *TX) finishMigration(version uint64) error {
+	meta, err := tx.metaBucket()
+	if err != nil {
+		return err
+	}
+	if meta.Get(metaMigrationCheckpointKey) != nil {
+		err = tx.writeMeta(nil, meta, metaMigrationCheckpointKey, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.writeMeta(nil, meta, metaSchemaVersionKey, EncodeUint64(version))
+}
+
+func (tx *TX) migrationCheckpoint(version uint64) ([]byte, error) {
+	var cp migrationCheckpoint
+
+	meta, err := tx.metaBucket()
+	if err != nil || meta == nil {
+		return nil, err
+	}
+	value := meta.Get(metaMigrationCheckpointKey)
+	if value == nil {
+		return nil, nil
+	}
+	err = json.Unmarshal(value, &cp)
+	if err != nil {
+		return nil, err
+	}
+	if cp.Version != version {
+		return nil, errors.New("checkpoint belongs to another migration")
+	}
+
+	// Done
+	return cp.Checkpoint, nil
+}
+
+func (tx *TX) setMigrationCheckpoint(version uint64, checkpoint []byte) error {
+	meta, err := tx.metaBucket()
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(migrationCheckpoint{
+		Version:    version,
+		Checkpoint: checkpoint,
+	})
+	if err != nil {
+		return err
+	}
+	return tx.writeMeta(nil, meta, metaMigrationCheckpointKey, value)
+}
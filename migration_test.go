@@ -0,0 +1,248 @@
+// See the LICENSE file for license details.
+
+package boltdb_test
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mxmauro/boltdb/v3"
+)
+
+// -----------------------------------------------------------------------------
+
+// readEndObserver signals the end of read-only transactions once armed.
+type readEndObserver struct {
+	armed atomic.Bool
+	ended chan struct{}
+}
+
+// -----------------------------------------------------------------------------
+
+func TestMigrations(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+
+	db, err := boltdb.New(filename)
+	if err != nil {
+		t.Fatalf("cannot create test database [err=%v]", err.Error())
+	}
+
+	migrations := []boltdb.Migration{
+		{
+			Version: 2,
+			Up: func(tx *boltdb.TX) error {
+				b, err := tx.Bucket([]byte("settings"))
+				if err != nil {
+					return err
+				}
+				return b.Put([]byte("theme"), []byte("dark"))
+			},
+		},
+		{
+			Version: 1,
+			Up: func(tx *boltdb.TX) error {
+				b, err := tx.Bucket([]byte("settings"))
+				if err != nil {
+					return err
+				}
+				return b.Put([]byte("lang"), []byte("en"))
+			},
+		},
+	}
+
+	// A dry run must not change anything.
+	report, err := db.Migrate(migrations, boltdb.MigrateOptions{DryRun: true})
+	if err != nil || report.To != 2 || len(report.Applied) != 2 {
+		db.Close()
+		t.Fatalf("unexpected dry run result [report=%+v err=%v]", report, err)
+	}
+	version, err := db.SchemaVersion()
+	if err != nil || version != 0 {
+		db.Close()
+		t.Fatalf("unexpected schema version after dry run [version=%d err=%v]", version, err)
+	}
+	if value, _ := db.Get([]byte("settings"), []byte("lang")); value != nil {
+		db.Close()
+		t.Fatalf("dry run changes were committed [value=%q]", value)
+	}
+
+	// Apply the first migration only, then the rest.
+	report, err = db.Migrate(migrations[1:], boltdb.MigrateOptions{})
+	if err != nil || report.From != 0 || report.To != 1 {
+		db.Close()
+		t.Fatalf("cannot apply migrations [report=%+v err=%v]", report, err)
+	}
+	report, err = db.Migrate(migrations, boltdb.MigrateOptions{})
+	if err != nil || report.From != 1 || report.To != 2 || len(report.Applied) != 1 {
+		db.Close()
+		t.Fatalf("cannot apply migrations [report=%+v err=%v]", report, err)
+	}
+
+	// The metadata bucket is not visible.
+	var names []string
+	err = db.WithinTx(boltdb.TxOptions{ReadOnly: true}, func(tx *boltdb.TX) error {
+		return tx.WithIterator(boltdb.WithIteratorOptions{}, func(iter *boltdb.Iterator) (bool, error) {
+			names = append(names, string(iter.Key()))
+			return false, nil
+		})
+	})
+	if err != nil || len(names) != 1 || names[0] != "settings" {
+		db.Close()
+		t.Fatalf("unexpected top-level buckets [names=%v err=%v]", names, err)
+	}
+	db.Close()
+
+	// Open with an older binary.
+	_, err = boltdb.NewWithOptions(filename, boltdb.Options{SchemaVersion: 1})
+	if !errors.Is(err, boltdb.ErrSchemaTooNew) {
+		t.Fatalf("opening a newer schema should fail [err=%v]", err)
+	}
+	db, err = boltdb.NewWithOptions(filename, boltdb.Options{SchemaVersion: 2})
+	if err != nil {
+		t.Fatalf("cannot open test database [err=%v]", err.Error())
+	}
+	defer db.Close()
+
+	_, err = db.Migrate(migrations[1:], boltdb.MigrateOptions{})
+	if !errors.Is(err, boltdb.ErrSchemaTooNew) {
+		t.Fatalf("migrating a newer schema should fail [err=%v]", err)
+	}
+}
+
+func TestBatchedMigration(t *testing.T) {
+	db := openTestDb(t)
+	defer db.Close()
+
+	for i := 0; i < 10; i++ {
+		if err := db.Put([]byte("items"), []byte(fmt.Sprintf("%02d", i)), []byte("old")); err != nil {
+			t.Fatalf("cannot write to test database [err=%v]", err.Error())
+		}
+	}
+
+	// Rewrite three keys per batch and fail once in the middle.
+	calls := 0
+	failed := false
+	migration := boltdb.Migration{
+		Version: 1,
+		UpBatch: func(tx *boltdb.TX, checkpoint []byte) ([]byte, bool, error) {
+			calls += 1
+			if calls == 3 && !failed {
+				failed = true
+				return nil, false, errors.New("interrupted")
+			}
+
+			b, err := tx.Bucket([]byte("items"))
+			if err != nil {
+				return nil, false, err
+			}
+			var keys [][]byte
+			iter := b.Iterate()
+			ok := iter.Seek(checkpoint, boltdb.SeekGreaterOrEqual)
+			for ; ok && len(keys) < 3; ok = iter.Next() {
+				keys = append(keys, iter.CopyKey())
+			}
+			var next []byte
+			if ok {
+				next = iter.CopyKey()
+			}
+			for _, key := range keys {
+				if err = b.Put(key, []byte("new")); err != nil {
+					return nil, false, err
+				}
+			}
+			return next, next == nil, nil
+		},
+	}
+
+	if _, err := db.Migrate([]boltdb.Migration{migration}, boltdb.MigrateOptions{}); err == nil {
+		t.Fatalf("migration should fail")
+	}
+	report, err := db.Migrate([]boltdb.Migration{migration}, boltdb.MigrateOptions{})
+	if err != nil || report.To != 1 {
+		t.Fatalf("cannot resume migration [report=%+v err=%v]", report, err)
+	}
+	// Two batches before the failure, the failed call and the two remaining batches.
+	if calls != 5 {
+		t.Fatalf("migration did not resume from the checkpoint [calls=%d]", calls)
+	}
+	for i := 0; i < 10; i++ {
+		value, err := db.Get([]byte("items"), []byte(fmt.Sprintf("%02d", i)))
+		if err != nil || string(value) != "new" {
+			t.Fatalf("unexpected value [key=%02d got=%q err=%v]", i, value, err)
+		}
+	}
+}
+
+func TestConcurrentMigrations(t *testing.T) {
+	obs := &readEndObserver{
+		ended: make(chan struct{}, 1),
+	}
+	db, err := boltdb.NewWithOptions(filepath.Join(t.TempDir(), "test.db"), boltdb.Options{
+		Observer: obs,
+	})
+	if err != nil {
+		t.Fatalf("cannot create test database [err=%v]", err.Error())
+	}
+	defer db.Close()
+
+	var runs [2]atomic.Int32
+	var inner chan error
+	var migrations []boltdb.Migration
+	migrations = []boltdb.Migration{
+		{
+			Version: 1,
+			Up: func(tx *boltdb.TX) error {
+				if runs[0].Add(1) == 1 {
+					// Start a second migration that reads the old schema version and then waits for
+					// this transaction to commit.
+					inner = make(chan error, 1)
+					obs.armed.Store(true)
+					go func() {
+						_, err2 := db.Migrate(migrations, boltdb.MigrateOptions{})
+						inner <- err2
+					}()
+					<-obs.ended
+				}
+				return nil
+			},
+		},
+		{
+			Version: 2,
+			Up: func(tx *boltdb.TX) error {
+				runs[1].Add(1)
+				return nil
+			},
+		},
+	}
+
+	_, err = db.Migrate(migrations, boltdb.MigrateOptions{})
+	if err != nil {
+		t.Fatalf("cannot apply migrations [err=%v]", err.Error())
+	}
+	if err = <-inner; err != nil {
+		t.Fatalf("cannot apply concurrent migrations [err=%v]", err.Error())
+	}
+	if runs[0].Load() != 1 || runs[1].Load() != 1 {
+		t.Fatalf("migrations applied more than once [runs=%d,%d]", runs[0].Load(), runs[1].Load())
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+func (o *readEndObserver) TxBegin(_ boltdb.TxBeginEvent) {
+}
+
+func (o *readEndObserver) TxEnd(ev boltdb.TxEndEvent) {
+	if ev.ReadOnly && o.armed.CompareAndSwap(true, false) {
+		o.ended <- struct{}{}
+	}
+}
+
+func (o *readEndObserver) SlowTx(_ boltdb.TxEndEvent) {
+}
+
+func (o *readEndObserver) IteratorScan(_ boltdb.IteratorScanEvent) {
+}
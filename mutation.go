@@ -0,0 +1,176 @@
+// See the LICENSE file for license details.
+
+package boltdb
+
+import (
+	"go.etcd.io/bbolt"
+)
+
+// -----------------------------------------------------------------------------
+
+// tracksBuckets returns true if bucket creations and deletions must be reported to the mutation hooks.
+func (tx *TX) tracksBuckets() bool {
+	return tx.journaling() || tx.db.audit || tx.acl != nil || tx.tracksUsage() || tx.db.changeLog != nil ||
+		tx.db.hasHistory()
+}
+
+// onBucketCreated is called before a bucket is created.
+func (tx *TX) onBucketCreated(fragments [][]byte) error {
+	err := tx.checkPermission(fragments, PermissionWrite)
+	if err != nil {
+		return err
+	}
+	tx.journalBucketCreated(fragments)
+	err = tx.appendAudit(AuditCreateBucket, joinPath(fragments), nil, nil)
+	if err != nil {
+		return err
+	}
+	return tx.appendChange(ChangeEntry{
+		Operation: ChangeCreateBucket,
+		Path:      fragments,
+	})
+}
+
+// onBucketDeleted is called before an existing bucket is deleted.
+func (tx *TX) onBucketDeleted(fragments [][]byte, b *bbolt.Bucket) error {
+	if b == nil {
+		return nil
+	}
+	err := tx.checkPermission(fragments, PermissionAdmin)
+	if err == nil {
+		err = tx.untrackBucket(fragments, b)
+	}
+	if err == nil {
+		err = tx.recordBucketHistory(fragments, b)
+	}
+	if err != nil {
+		return err
+	}
+	tx.journalBucketDeleted(fragments, b)
+	err = tx.appendAudit(AuditDeleteBucket, joinPath(fragments), nil, nil)
+	if err != nil {
+		return err
+	}
+	return tx.appendChange(ChangeEntry{
+		Operation: ChangeDeleteBucket,
+		Path:      fragments,
+	})
+}
+
+// onKeyChanged is called before a key is stored or deleted through the wrapper, once the quotas were
+// checked. The value is nil on deletions.
+func (bucket *Bucket) onKeyChanged(key []byte, value []byte) error {
+	if bucket.history != nil {
+		if value != nil || bucket.b.Get(key) != nil {
+			err := bucket.recordHistory(key)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if bucket.tx.db.audit {
+		op := AuditPut
+		if value == nil {
+			op = AuditDelete
+		}
+		// Keys of buckets with encrypted keys are not disclosed.
+		if bucket.keys != nil {
+			key = nil
+		}
+		return bucket.tx.appendAudit(op, bucket.path, key, value)
+	}
+
+	// Done
+	return nil
+}
+
+// onStored is called before a value, already encoded, is stored using the first raw key and the other
+// raw keys are removed. The key and the value are the ones before encoding. The write permission must be
+// checked beforehand.
+func (bucket *Bucket) onStored(key []byte, value []byte, rawKeys [][]byte, stored []byte) error {
+	err := bucket.trackPut(rawKeys, stored, len(key), len(value))
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	err = bucket.onKeyChanged(key, value)
+	if err != nil {
+		return err
+	}
+	return bucket.logStored(rawKeys, stored)
+}
+
+// onRemoved is called before the given raw keys of a key are removed. The write permission must be
+// checked beforehand.
+func (bucket *Bucket) onRemoved(key []byte, rawKeys [][]byte) error {
+	err := bucket.trackDelete(rawKeys)
+	if err == nil {
+		err = bucket.onKeyChanged(key, nil)
+	}
+	if err != nil || bucket.tx.db.changeLog == nil {
+		return err
+	}
+	return bucket.logRemoved(rawKeys)
+}
+
+// onRewritten is called before a value is stored again, with a new encoding but the same contents, using
+// the first raw key and the other raw keys are removed. History is not recorded because the value does
+// not change and size limits are not checked again.
+func (bucket *Bucket) onRewritten(key []byte, value []byte, rawKeys [][]byte, stored []byte) error {
+	err := bucket.trackPut(rawKeys, stored, 0, 0)
+	if err == nil && bucket.tx.db.audit {
+		// Keys of buckets with encrypted keys are not disclosed.
+		if bucket.keys != nil {
+			key = nil
+		}
+		err = bucket.tx.appendAudit(AuditPut, bucket.path, key, value)
+	}
+	if err != nil {
+		return err
+	}
+	return bucket.logStored(rawKeys, stored)
+}
+
+// onSequenceChanged is called after the sequence of the bucket changes.
+func (bucket *Bucket) onSequenceChanged(sequence uint64) error {
+	return bucket.tx.appendChange(ChangeEntry{
+		Operation:      ChangeSetSequence,
+		Path:           bucket.fragments(),
+		BucketSequence: sequence,
+	})
+}
+
+func (bucket *Bucket) logStored(rawKeys [][]byte, stored []byte) error {
+	if bucket.tx.db.changeLog == nil {
+		return nil
+	}
+	err := bucket.logRemoved(rawKeys[1:])
+	if err != nil {
+		return err
+	}
+	return bucket.tx.appendChange(ChangeEntry{
+		Operation: ChangePut,
+		Path:      bucket.fragments(),
+		Key:       rawKeys[0],
+		Value:     stored,
+	})
+}
+
+func (bucket *Bucket) logRemoved(rawKeys [][]byte) error {
+	for _, rawKey := range rawKeys {
+		if bucket.b.Get(rawKey) == nil {
+			continue
+		}
+		err := bucket.tx.appendChange(ChangeEntry{
+			Operation: ChangeDelete,
+			Path:      bucket.fragments(),
+			Key:       rawKey,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
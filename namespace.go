@@ -0,0 +1,166 @@
+// See the LICENSE file for license details.
+
+package boltdb
+
+import (
+	"errors"
+)
+
+// -----------------------------------------------------------------------------
+
+// Namespace is a view of the database where all the bucket paths are located inside a tenant bucket.
+type Namespace struct {
+	db     *DB
+	prefix []byte
+}
+
+// NamespaceOptions specifies a set of options when creating a namespace view.
+type NamespaceOptions struct {
+	// Quota, if set, replaces the quota of the namespace. See DB.SetQuota.
+	Quota *Quota
+}
+
+// -----------------------------------------------------------------------------
+
+// Namespace returns a view of the database where all the bucket paths are located inside the bucket
+// with the given path.
+func (db *DB) Namespace(prefix []byte) (*Namespace, error) {
+	return db.NamespaceWithOptions(prefix, NamespaceOptions{})
+}
+
+// NamespaceWithOptions acts like Namespace but also allows to set the quota of the namespace. The usage
+// of a namespace is tracked, and its quota enforced, on every write inside it, including those made
+// without the view.
+func (db *DB) NamespaceWithOptions(prefix []byte, opts NamespaceOptions) (*Namespace, error) {
+	fragments, err := splitUserPath(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a wrapper.
+	ns := &Namespace{
+		db:     db,
+		prefix: joinPath(cloneFragments(fragments)),
+	}
+
+	// Start tracking the usage. The writable transaction is only needed the first time or when the quota
+	// changes.
+	if !db.readOnly {
+		tracked := false
+		err = db.WithinTx(TxOptions{ReadOnly: true}, func(tx *TX) error {
+			tracked = tx.hasQuota(fragments, opts.Quota)
+			return nil
+		})
+		if err == nil && !tracked {
+			err = db.WithinTx(TxOptions{}, func(tx *TX) error {
+				return tx.setQuota(fragments, opts.Quota)
+			})
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Done
+	return ns, nil
+}
+
+// DB gets the database this namespace belongs to.
+func (ns *Namespace) DB() *DB {
+	return ns.db
+}
+
+// Prefix returns the path of the bucket containing the namespace.
+func (ns *Namespace) Prefix() []byte {
+	return ns.prefix
+}
+
+// BeginTx starts a new transaction confined to the namespace.
+func (ns *Namespace) BeginTx(opts TxOptions) (*TX, error) {
+	tx, err := ns.db.BeginTx(opts)
+	if err != nil {
+		return nil, err
+	}
+	tx.root = ns.prefix
+
+	// Done
+	return tx, nil
+}
+
+// WithinTx initiates a transaction confined to the namespace and calls a callback.
+func (ns *Namespace) WithinTx(opts TxOptions, cb WithinTxCallback) error {
+	tx, err := ns.BeginTx(opts)
+	if err == nil {
+		err = cb(tx)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			tx.Rollback()
+		}
+	}
+
+	// Done
+	return err
+}
+
+// Get returns the value of a key in the specified bucket or nil if not found.
+func (ns *Namespace) Get(bucket []byte, key []byte) ([]byte, error) {
+	var value []byte
+
+	err := ns.WithinTx(TxOptions{ReadOnly: true}, func(tx *TX) error {
+		b, err := tx.Bucket(bucket)
+		if err != nil {
+			if errors.Is(err, ErrBucketNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		value, err = b.GetValue(key)
+		return err
+	})
+
+	// Done
+	return value, err
+}
+
+// Put stores a key/value pair in the specified bucket.
+func (ns *Namespace) Put(bucket []byte, key []byte, value []byte) error {
+	return ns.WithinTx(TxOptions{}, func(tx *TX) error {
+		b, err := tx.Bucket(bucket)
+		if err != nil {
+			return err
+		}
+
+		return b.Put(key, value)
+	})
+}
+
+// Delete deletes a specific key in the specified bucket. No error is returned if the key is not found.
+func (ns *Namespace) Delete(bucket []byte, key []byte) error {
+	return ns.WithinTx(TxOptions{}, func(tx *TX) error {
+		b, err := tx.Bucket(bucket)
+		if err != nil {
+			if errors.Is(err, ErrBucketNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		return b.Delete(key)
+	})
+}
+
+// Usage returns the number of keys stored inside the namespace and their size as stored.
+func (ns *Namespace) Usage() (Usage, error) {
+	var usage Usage
+
+	err := ns.db.WithinTx(TxOptions{ReadOnly: true}, func(tx *TX) error {
+		usage = tx.usage(splitBucketFragments(ns.prefix))
+		return nil
+	})
+
+	// Done
+	return usage, err
+}
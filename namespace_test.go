@@ -0,0 +1,155 @@
+// See the LICENSE file for license details.
+
+package boltdb_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/mxmauro/boltdb/v3"
+)
+
+// -----------------------------------------------------------------------------
+
+func TestNamespace(t *testing.T) {
+	db := openTestDb(t)
+	defer db.Close()
+
+	// Existing data is accounted when the namespace is created.
+	if err := db.Put([]byte("tenants/acme/orders"), []byte("o1"), []byte("12345")); err != nil {
+		t.Fatalf("cannot write to test database [err=%v]", err)
+	}
+
+	acme, err := db.NamespaceWithOptions([]byte("tenants/acme"), boltdb.NamespaceOptions{
+		Quota: &boltdb.Quota{MaxKeys: 3},
+	})
+	if err != nil {
+		t.Fatalf("cannot create namespace [err=%v]", err)
+	}
+	globex, err := db.Namespace([]byte("tenants/globex"))
+	if err != nil {
+		t.Fatalf("cannot create namespace [err=%v]", err)
+	}
+
+	if err = acme.Put([]byte("users"), []byte("u1"), []byte("alice")); err != nil {
+		t.Fatalf("cannot write to namespace [err=%v]", err)
+	}
+	if err = globex.Put([]byte("users"), []byte("u1"), []byte("bob")); err != nil {
+		t.Fatalf("cannot write to namespace [err=%v]", err)
+	}
+
+	// Paths are rooted under the tenant bucket.
+	if value, err := acme.Get([]byte("orders"), []byte("o1")); err != nil || string(value) != "12345" {
+		t.Fatalf("unexpected value [value=%q err=%v]", value, err)
+	}
+	if value, err := globex.Get([]byte("users"), []byte("u1")); err != nil || string(value) != "bob" {
+		t.Fatalf("unexpected value [value=%q err=%v]", value, err)
+	}
+	if value, err := db.Get([]byte("tenants/acme/users"), []byte("u1")); err != nil || string(value) != "alice" {
+		t.Fatalf("unexpected value [value=%q err=%v]", value, err)
+	}
+	if value, err := globex.Get([]byte("orders"), []byte("o1")); err != nil || value != nil {
+		t.Fatalf("value leaked across namespaces [value=%q err=%v]", value, err)
+	}
+
+	// Iteration only shows the buckets of the namespace.
+	var names []string
+	err = acme.WithinTx(boltdb.TxOptions{ReadOnly: true}, func(tx *boltdb.TX) error {
+		return tx.WithIterator(boltdb.WithIteratorOptions{}, func(iter *boltdb.Iterator) (bool, error) {
+			b, err := tx.Bucket(iter.Key())
+			if err != nil {
+				return true, err
+			}
+			names = append(names, string(b.Path()))
+			return false, nil
+		})
+	})
+	if err != nil || len(names) != 2 || names[0] != "orders" || names[1] != "users" {
+		t.Fatalf("unexpected namespace buckets [names=%v err=%v]", names, err)
+	}
+
+	// Quotas are enforced on write.
+	if err = acme.Put([]byte("users"), []byte("u2"), []byte("carol")); err != nil {
+		t.Fatalf("cannot write to namespace [err=%v]", err)
+	}
+	if err = acme.Put([]byte("users"), []byte("u3"), []byte("dave")); !errors.Is(err, boltdb.ErrQuotaExceeded) {
+		t.Fatalf("quota was not enforced [err=%v]", err)
+	}
+	if err = db.Put([]byte("tenants/acme/users"), []byte("u3"), []byte("dave")); !errors.Is(err, boltdb.ErrQuotaExceeded) {
+		t.Fatalf("quota was not enforced outside the view [err=%v]", err)
+	}
+	if err = acme.Put([]byte("users"), []byte("u2"), []byte("caroline")); err != nil {
+		t.Fatalf("cannot overwrite a key [err=%v]", err)
+	}
+
+	usage, err := acme.Usage()
+	if err != nil || usage.Keys != 3 || usage.Bytes != uint64(2+5+2+5+2+8) {
+		t.Fatalf("unexpected usage [usage=%+v err=%v]", usage, err)
+	}
+
+	// Deletions release the quota.
+	err = acme.WithinTx(boltdb.TxOptions{}, func(tx *boltdb.TX) error {
+		return tx.DeleteBucket([]byte("orders"))
+	})
+	if err != nil {
+		t.Fatalf("cannot delete bucket [err=%v]", err)
+	}
+	if err = acme.Delete([]byte("users"), []byte("u1")); err != nil {
+		t.Fatalf("cannot delete key [err=%v]", err)
+	}
+	usage, err = acme.Usage()
+	if err != nil || usage.Keys != 1 || usage.Bytes != uint64(2+8) {
+		t.Fatalf("unexpected usage [usage=%+v err=%v]", usage, err)
+	}
+}
+
+func TestNamespaceReopenSkipsWrites(t *testing.T) {
+	rec := &recordingObserver{}
+	db, err := boltdb.NewWithOptions(filepath.Join(t.TempDir(), "test.db"), boltdb.Options{
+		Observer: rec,
+	})
+	if err != nil {
+		t.Fatalf("cannot create test database [err=%v]", err.Error())
+	}
+	defer db.Close()
+
+	writes := func() int {
+		rec.mtx.Lock()
+		defer rec.mtx.Unlock()
+
+		count := 0
+		for _, ev := range rec.begins {
+			if !ev.ReadOnly {
+				count += 1
+			}
+		}
+		return count
+	}
+
+	// Only the first call and a quota change need a writable transaction.
+	opts := boltdb.NamespaceOptions{
+		Quota: &boltdb.Quota{MaxKeys: 3},
+	}
+	for _, o := range []boltdb.NamespaceOptions{opts, opts, {}} {
+		if _, err = db.NamespaceWithOptions([]byte("tenants/acme"), o); err != nil {
+			t.Fatalf("cannot create namespace [err=%v]", err)
+		}
+	}
+	if got := writes(); got != 1 {
+		t.Fatalf("unexpected amount of writable transactions [got=%d]", got)
+	}
+	if _, err = db.NamespaceWithOptions([]byte("tenants/acme"), boltdb.NamespaceOptions{
+		Quota: &boltdb.Quota{MaxKeys: 5},
+	}); err != nil {
+		t.Fatalf("cannot create namespace [err=%v]", err)
+	}
+	if got := writes(); got != 2 {
+		t.Fatalf("unexpected amount of writable transactions [got=%d]", got)
+	}
+
+	statuses, err := db.Quotas()
+	if err != nil || len(statuses) != 1 || statuses[0].Quota.MaxKeys != 5 {
+		t.Fatalf("unexpected quotas [got=%+v err=%v]", statuses, err)
+	}
+}
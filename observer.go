@@ -0,0 +1,172 @@
+// See the LICENSE file for license details.
+
+package boltdb
+
+import (
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+
+// Observer receives events about the activity of a database. It can be used to collect metrics or
+// traces. Implementations must be safe for concurrent use and should return quickly because events are
+// delivered synchronously.
+type Observer interface {
+	// TxBegin is called after a transaction starts.
+	TxBegin(ev TxBeginEvent)
+
+	// TxEnd is called after a transaction is committed or rolled back.
+	TxEnd(ev TxEndEvent)
+
+	// SlowTx is called, in addition to TxEnd, when a transaction lasts longer than the configured
+	// slow transaction threshold.
+	SlowTx(ev TxEndEvent)
+
+	// IteratorScan is called when a WithIterator callback loop ends.
+	IteratorScan(ev IteratorScanEvent)
+}
+
+// TxOutcome specifies how a transaction ended.
+type TxOutcome int
+
+const (
+	TxCommitted TxOutcome = iota
+	TxRolledBack
+)
+
+// TxBeginEvent contains information about a transaction that started.
+type TxBeginEvent struct {
+	ReadOnly bool
+
+	// Wait is the time spent waiting to start the transaction. For writable transactions, it includes the
+	// time waiting for the writer lock.
+	Wait time.Duration
+}
+
+// TxEndEvent contains information about a transaction that ended.
+type TxEndEvent struct {
+	ReadOnly bool
+	Outcome  TxOutcome
+
+	// Err is the error returned by the commit, if any.
+	Err error
+
+	// Wait is the time spent waiting to start the transaction.
+	Wait time.Duration
+
+	// Duration is the time elapsed between the start and the end of the transaction, including the commit.
+	// For writable transactions, it is the time the writer lock was held.
+	Duration time.Duration
+
+	// CommitDuration is the time spent committing the changes to disk.
+	CommitDuration time.Duration
+
+	// PagesAllocated is the number of pages allocated for the changes of the transaction. PagesWritten
+	// and BytesWritten describe what a successful commit wrote to disk: the allocated pages, which hold
+	// every modified page, plus the meta page. They are zero for transactions that were not committed.
+	PagesAllocated int64
+	PagesWritten   int64
+	BytesWritten   int64
+
+	// KeysScanned is the number of iterator movements done within the transaction.
+	KeysScanned int64
+}
+
+// IteratorScanEvent contains information about an iterator loop.
+type IteratorScanEvent struct {
+	// Path is the bucket path the iterator belongs to. It is nil for top-level iterators.
+	Path []byte
+
+	// Keys is the number of entries visited.
+	Keys int64
+
+	Duration time.Duration
+}
+
+type multiObserver []Observer
+
+// -----------------------------------------------------------------------------
+
+// MultiObserver returns an observer that forwards events to all the provided observers.
+func MultiObserver(observers ...Observer) Observer {
+	return multiObserver(observers)
+}
+
+func (m multiObserver) TxBegin(ev TxBeginEvent) {
+	for _, o := range m {
+		o.TxBegin(ev)
+	}
+}
+
+func (m multiObserver) TxEnd(ev TxEndEvent) {
+	for _, o := range m {
+		o.TxEnd(ev)
+	}
+}
+
+func (m multiObserver) SlowTx(ev TxEndEvent) {
+	for _, o := range m {
+		o.SlowTx(ev)
+	}
+}
+
+func (m multiObserver) IteratorScan(ev IteratorScanEvent) {
+	for _, o := range m {
+		o.IteratorScan(ev)
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+func (tx *TX) notifyBegin() {
+	if tx.db.observer != nil {
+		tx.db.observer.TxBegin(TxBeginEvent{
+			ReadOnly: tx.readOnly,
+			Wait:     tx.wait,
+		})
+	}
+}
+
+func (tx *TX) notifyEnd(outcome TxOutcome, commitStart time.Time, err error) {
+	if tx.db.observer == nil {
+		return
+	}
+
+	now := time.Now()
+	stats := tx.tx.Stats()
+	ev := TxEndEvent{
+		ReadOnly:       tx.readOnly,
+		Outcome:        outcome,
+		Err:            err,
+		Wait:           tx.wait,
+		Duration:       now.Sub(tx.start),
+		PagesAllocated: stats.GetPageCount(),
+		KeysScanned:    tx.keysScanned,
+	}
+	if !tx.readOnly && outcome == TxCommitted && err == nil {
+		// Every dirty page is written to a newly allocated page and the commit ends writing the meta page.
+		ev.PagesWritten = stats.GetPageCount() + 1
+		ev.BytesWritten = stats.GetPageAlloc() + int64(tx.db.db.Info().PageSize)
+	}
+	if !commitStart.IsZero() {
+		ev.CommitDuration = now.Sub(commitStart)
+	}
+
+	tx.db.observer.TxEnd(ev)
+	if tx.db.slowTxThreshold > 0 && ev.Duration > tx.db.slowTxThreshold {
+		tx.db.observer.SlowTx(ev)
+	}
+}
+
+func (iter *Iterator) notifyScan(keys int64, start time.Time) {
+	if iter.tx.db.observer != nil {
+		ev := IteratorScanEvent{
+			Keys:     keys,
+			Duration: time.Since(start),
+		}
+		if iter.bucket != nil {
+			ev.Path = iter.bucket.path
+		}
+		iter.tx.db.observer.IteratorScan(ev)
+	}
+}
@@ -0,0 +1,76 @@
+// See the LICENSE file for license details.
+
+package boltdb
+
+import (
+	"expvar"
+)
+
+// -----------------------------------------------------------------------------
+
+// ExpvarObserver is an observer that publishes counters using the expvar package.
+type ExpvarObserver struct {
+	vars *expvar.Map
+}
+
+// -----------------------------------------------------------------------------
+
+// NewExpvarObserver creates an observer that publishes its counters as an expvar map with the given
+// name. If a map with the same name was already published, it is reused.
+func NewExpvarObserver(name string) *ExpvarObserver {
+	vars, ok := expvar.Get(name).(*expvar.Map)
+	if !ok {
+		vars = expvar.NewMap(name)
+	}
+	return &ExpvarObserver{
+		vars: vars,
+	}
+}
+
+// Map returns the published expvar map.
+func (o *ExpvarObserver) Map() *expvar.Map {
+	return o.vars
+}
+
+// TxBegin implements the Observer interface.
+func (o *ExpvarObserver) TxBegin(ev TxBeginEvent) {
+	if ev.ReadOnly {
+		o.vars.Add("tx_read_begin", 1)
+	} else {
+		o.vars.Add("tx_write_begin", 1)
+		o.vars.Add("tx_write_wait_ns", int64(ev.Wait))
+	}
+}
+
+// TxEnd implements the Observer interface.
+func (o *ExpvarObserver) TxEnd(ev TxEndEvent) {
+	if ev.Outcome == TxCommitted {
+		o.vars.Add("tx_commit", 1)
+	} else {
+		o.vars.Add("tx_rollback", 1)
+	}
+	if ev.Err != nil {
+		o.vars.Add("tx_error", 1)
+	}
+	if ev.ReadOnly {
+		o.vars.Add("tx_read_duration_ns", int64(ev.Duration))
+	} else {
+		o.vars.Add("tx_write_duration_ns", int64(ev.Duration))
+		o.vars.Add("tx_commit_duration_ns", int64(ev.CommitDuration))
+	}
+	o.vars.Add("pages_allocated", ev.PagesAllocated)
+	o.vars.Add("pages_written", ev.PagesWritten)
+	o.vars.Add("bytes_written", ev.BytesWritten)
+	o.vars.Add("keys_scanned", ev.KeysScanned)
+}
+
+// SlowTx implements the Observer interface.
+func (o *ExpvarObserver) SlowTx(_ TxEndEvent) {
+	o.vars.Add("tx_slow", 1)
+}
+
+// IteratorScan implements the Observer interface.
+func (o *ExpvarObserver) IteratorScan(ev IteratorScanEvent) {
+	o.vars.Add("iterator_scans", 1)
+	o.vars.Add("iterator_keys", ev.Keys)
+}
@@ -0,0 +1,83 @@
+// See the LICENSE file for license details.
+
+package boltdb
+
+import (
+	"context"
+	"log/slog"
+)
+
+// -----------------------------------------------------------------------------
+
+// SlogObserver is an observer that logs events using a structured logger. Slow transactions are logged
+// with the warning level and the rest of the events with the debug level.
+type SlogObserver struct {
+	logger *slog.Logger
+}
+
+// -----------------------------------------------------------------------------
+
+// NewSlogObserver creates an observer that logs events using the provided logger. If the logger is nil,
+// the default logger is used.
+func NewSlogObserver(logger *slog.Logger) *SlogObserver {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &SlogObserver{
+		logger: logger,
+	}
+}
+
+// TxBegin implements the Observer interface.
+func (o *SlogObserver) TxBegin(ev TxBeginEvent) {
+	o.logger.LogAttrs(context.Background(), slog.LevelDebug, "boltdb transaction started",
+		slog.Bool("read_only", ev.ReadOnly),
+		slog.Duration("wait", ev.Wait),
+	)
+}
+
+// TxEnd implements the Observer interface.
+func (o *SlogObserver) TxEnd(ev TxEndEvent) {
+	level := slog.LevelDebug
+	if ev.Err != nil {
+		level = slog.LevelError
+	}
+	o.logger.LogAttrs(context.Background(), level, "boltdb transaction ended", txEndAttrs(ev)...)
+}
+
+// SlowTx implements the Observer interface.
+func (o *SlogObserver) SlowTx(ev TxEndEvent) {
+	o.logger.LogAttrs(context.Background(), slog.LevelWarn, "boltdb slow transaction", txEndAttrs(ev)...)
+}
+
+// IteratorScan implements the Observer interface.
+func (o *SlogObserver) IteratorScan(ev IteratorScanEvent) {
+	o.logger.LogAttrs(context.Background(), slog.LevelDebug, "boltdb iterator scan",
+		slog.String("path", string(ev.Path)),
+		slog.Int64("keys", ev.Keys),
+		slog.Duration("duration", ev.Duration),
+	)
+}
+
+func txEndAttrs(ev TxEndEvent) []slog.Attr {
+	outcome := "commit"
+	if ev.Outcome == TxRolledBack {
+		outcome = "rollback"
+	}
+
+	attrs := []slog.Attr{
+		slog.Bool("read_only", ev.ReadOnly),
+		slog.String("outcome", outcome),
+		slog.Duration("wait", ev.Wait),
+		slog.Duration("duration", ev.Duration),
+		slog.Duration("commit_duration", ev.CommitDuration),
+		slog.Int64("pages_allocated", ev.PagesAllocated),
+		slog.Int64("pages_written", ev.PagesWritten),
+		slog.Int64("bytes_written", ev.BytesWritten),
+		slog.Int64("keys_scanned", ev.KeysScanned),
+	}
+	if ev.Err != nil {
+		attrs = append(attrs, slog.String("error", ev.Err.Error()))
+	}
+	return attrs
+}
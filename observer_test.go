@@ -0,0 +1,131 @@
+// See the LICENSE file for license details.
+
+package boltdb_test
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mxmauro/boltdb/v3"
+)
+
+// -----------------------------------------------------------------------------
+
+type recordingObserver struct {
+	mtx    sync.Mutex
+	begins []boltdb.TxBeginEvent
+	ends   []boltdb.TxEndEvent
+	slow   []boltdb.TxEndEvent
+	scans  []boltdb.IteratorScanEvent
+}
+
+// -----------------------------------------------------------------------------
+
+func TestObserver(t *testing.T) {
+	rec := &recordingObserver{}
+	expvarObs := boltdb.NewExpvarObserver("boltdb_test_observer")
+
+	var logBuf bytes.Buffer
+	slogObs := boltdb.NewSlogObserver(slog.New(slog.NewTextHandler(&logBuf, &slog.HandlerOptions{Level: slog.LevelWarn})))
+
+	db, err := boltdb.NewWithOptions(filepath.Join(t.TempDir(), "test.db"), boltdb.Options{
+		Observer:        boltdb.MultiObserver(rec, expvarObs, slogObs),
+		SlowTxThreshold: time.Nanosecond,
+	})
+	if err != nil {
+		t.Fatalf("cannot create test database [err=%v]", err.Error())
+	}
+	defer db.Close()
+
+	for _, key := range []string{"a", "b", "c"} {
+		if err = db.Put([]byte("bucket"), []byte(key), []byte("value")); err != nil {
+			t.Fatalf("cannot write to test database [err=%v]", err.Error())
+		}
+	}
+
+	// A committed transaction followed by a deferred rollback must be reported once.
+	tx, err := db.BeginTx(boltdb.TxOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("cannot begin transaction [err=%v]", err.Error())
+	}
+	b, err := tx.Bucket([]byte("bucket"))
+	if err != nil {
+		t.Fatalf("cannot get test bucket [err=%v]", err.Error())
+	}
+	err = b.WithIterator(boltdb.WithIteratorOptions{}, func(iter *boltdb.Iterator) (bool, error) {
+		return false, nil
+	})
+	if err != nil {
+		t.Fatalf("cannot iterate test bucket [err=%v]", err.Error())
+	}
+	_ = tx.Commit()
+	tx.Rollback()
+
+	// A failed transaction is rolled back.
+	errTest := errors.New("test error")
+	if err = db.WithinTx(boltdb.TxOptions{}, func(tx *boltdb.TX) error { return errTest }); !errors.Is(err, errTest) {
+		t.Fatalf("unexpected transaction error [got=%v]", err)
+	}
+
+	rec.mtx.Lock()
+	defer rec.mtx.Unlock()
+
+	if len(rec.begins) != 5 || len(rec.ends) != 5 || len(rec.slow) != 5 {
+		t.Fatalf("unexpected amount of events [begins=%d ends=%d slow=%d]", len(rec.begins), len(rec.ends), len(rec.slow))
+	}
+	if rec.ends[0].ReadOnly || rec.ends[0].Outcome != boltdb.TxCommitted || rec.ends[0].PagesAllocated == 0 ||
+		rec.ends[0].PagesWritten != rec.ends[0].PagesAllocated+1 ||
+		rec.ends[0].BytesWritten != rec.ends[0].PagesWritten*int64(os.Getpagesize()) {
+		t.Fatalf("unexpected write transaction event [got=%+v]", rec.ends[0])
+	}
+	if !rec.ends[3].ReadOnly || rec.ends[3].KeysScanned != 3 {
+		t.Fatalf("unexpected read transaction event [got=%+v]", rec.ends[3])
+	}
+	if rec.ends[4].Outcome != boltdb.TxRolledBack || rec.ends[4].PagesWritten != 0 || rec.ends[4].BytesWritten != 0 {
+		t.Fatalf("unexpected failed transaction event [got=%+v]", rec.ends[4])
+	}
+	if len(rec.scans) != 1 || rec.scans[0].Keys != 3 || string(rec.scans[0].Path) != "bucket" {
+		t.Fatalf("unexpected iterator events [got=%+v]", rec.scans)
+	}
+
+	if v := expvarObs.Map().Get("tx_commit"); v == nil || v.String() != "4" {
+		t.Fatalf("unexpected expvar commit counter [got=%v]", v)
+	}
+	if v := expvarObs.Map().Get("iterator_keys"); v == nil || v.String() != "3" {
+		t.Fatalf("unexpected expvar iterator counter [got=%v]", v)
+	}
+	if !strings.Contains(logBuf.String(), "boltdb slow transaction") {
+		t.Fatalf("expected slow transactions to be logged [got=%q]", logBuf.String())
+	}
+}
+
+func (o *recordingObserver) TxBegin(ev boltdb.TxBeginEvent) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	o.begins = append(o.begins, ev)
+}
+
+func (o *recordingObserver) TxEnd(ev boltdb.TxEndEvent) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	o.ends = append(o.ends, ev)
+}
+
+func (o *recordingObserver) SlowTx(ev boltdb.TxEndEvent) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	o.slow = append(o.slow, ev)
+}
+
+func (o *recordingObserver) IteratorScan(ev boltdb.IteratorScanEvent) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	o.scans = append(o.scans, ev)
+}
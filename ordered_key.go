@@ -0,0 +1,124 @@
+// See the LICENSE file for license details.
+
+package boltdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// -----------------------------------------------------------------------------
+
+// encodeOrderedKey encodes a value so the byte-wise order of encoded values matches the natural order of
+// the values. Encodings are prefix-free, so other data can be appended to them.
+//
+// Integers and floats use 8 big-endian bytes with the sign bit adjusted, times are encoded as their Unix
+// nanoseconds and strings and byte slices are escaped and terminated by 0x00 0x01.
+func encodeOrderedKey(v reflect.Value) ([]byte, error) {
+	if v.Type() == timeType {
+		return appendOrderedInt(nil, v.Interface().(time.Time).UnixNano()), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendOrderedInt(nil, v.Int()), nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return binary.BigEndian.AppendUint64(nil, v.Uint()), nil
+
+	case reflect.Float32, reflect.Float64:
+		return appendOrderedFloat(nil, v.Float()), nil
+
+	case reflect.Bool:
+		if v.Bool() {
+			return []byte{1}, nil
+		}
+		return []byte{0}, nil
+
+	case reflect.String:
+		return appendOrderedBytes(nil, []byte(v.String())), nil
+
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return appendOrderedBytes(nil, v.Bytes()), nil
+		}
+	}
+
+	// Done
+	return nil, fmt.Errorf("unsupported key type %v", v.Type())
+}
+
+// convertOrderedKey converts a value provided by the caller to the given type and encodes it.
+func convertOrderedKey(value any, typ reflect.Type) ([]byte, error) {
+	v := reflect.ValueOf(value)
+	if !v.IsValid() {
+		return nil, fmt.Errorf("invalid nil value for type %v", typ)
+	}
+	if v.Type() != typ {
+		if !v.Type().ConvertibleTo(typ) {
+			return nil, fmt.Errorf("cannot convert %v to %v", v.Type(), typ)
+		}
+		v = v.Convert(typ)
+	}
+	return encodeOrderedKey(v)
+}
+
+func appendOrderedInt(dst []byte, value int64) []byte {
+	return binary.BigEndian.AppendUint64(dst, uint64(value)^(1<<63))
+}
+
+func appendOrderedFloat(dst []byte, value float64) []byte {
+	bits := math.Float64bits(value)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	return binary.BigEndian.AppendUint64(dst, bits)
+}
+
+func appendOrderedBytes(dst []byte, value []byte) []byte {
+	for _, b := range value {
+		if b == 0 {
+			dst = append(dst, 0, 0xff)
+		} else {
+			dst = append(dst, b)
+		}
+	}
+	return append(dst, 0, 1)
+}
+
+func decodeOrderedInt(src []byte) int64 {
+	return int64(binary.BigEndian.Uint64(src) ^ (1 << 63))
+}
+
+// decodeOrderedBytes decodes a value encoded by appendOrderedBytes and returns the rest of the input.
+func decodeOrderedBytes(src []byte) ([]byte, []byte, bool) {
+	value := make([]byte, 0, len(src))
+	for idx := 0; idx < len(src); idx++ {
+		if src[idx] != 0 {
+			value = append(value, src[idx])
+			continue
+		}
+		if idx+1 >= len(src) {
+			break
+		}
+		switch src[idx+1] {
+		case 0xff:
+			value = append(value, 0)
+			idx += 1
+		case 1:
+			return value, src[idx+2:], true
+		default:
+			return nil, nil, false
+		}
+	}
+	return nil, nil, false
+}
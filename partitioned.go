@@ -0,0 +1,373 @@
+// See the LICENSE file for license details.
+
+package boltdb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+
+const (
+	defaultPartitionWindow = 24 * time.Hour
+
+	partitionFileLayout    = "20060102T150405Z"
+	partitionFileExtension = ".db"
+)
+
+// PartitionedDB stores data in one database file per time window, so old data can be removed by deleting
+// whole files.
+type PartitionedDB struct {
+	dir        string
+	opts       PartitionedOptions
+	mtx        sync.RWMutex
+	partitions map[int64]*partition
+	closed     bool
+}
+
+// PartitionedOptions specifies a set of options when creating/opening a partitioned database.
+type PartitionedOptions struct {
+	// Options are used to open every partition.
+	Options Options
+
+	// Window sets the time range covered by each partition. Windows are aligned to UTC and must be
+	// at least one second long. Defaults to one day. It must not change between runs.
+	Window time.Duration
+
+	// Retention sets how long partitions are kept by DropExpired. Zero keeps them forever.
+	Retention time.Duration
+}
+
+// partition is an open partition along with the operations using it, which are waited for before
+// closing it. Partitions being dropped stay in the map, so their window cannot be created again until
+// their file is removed, but no new operation can use them.
+type partition struct {
+	db       *DB
+	users    sync.WaitGroup
+	dropping bool
+}
+
+// -----------------------------------------------------------------------------
+
+// NewPartitioned opens a partitioned database stored in the given directory. Existing partitions are
+// opened and new ones are created when data is written to their time window.
+func NewPartitioned(dir string, opts PartitionedOptions) (*PartitionedDB, error) {
+	if opts.Window == 0 {
+		opts.Window = defaultPartitionWindow
+	}
+	if opts.Window < time.Second {
+		return nil, ErrInvalidWindow
+	}
+
+	// Create a wrapper.
+	p := &PartitionedDB{
+		dir:        dir,
+		opts:       opts,
+		partitions: make(map[int64]*partition),
+	}
+
+	// Open existing partitions.
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), partitionFileExtension)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		start, err := time.Parse(partitionFileLayout, name)
+		if err != nil {
+			continue
+		}
+		db, err := NewWithOptions(filepath.Join(dir, entry.Name()), opts.Options)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.partitions[start.UnixNano()] = &partition{db: db}
+	}
+
+	// Done
+	return p, nil
+}
+
+// Close closes all the partitions once the operations using them complete. Operations started afterwards
+// fail with ErrDatabaseNotOpen.
+func (p *PartitionedDB) Close() {
+	p.mtx.Lock()
+	p.closed = true
+	parts := make([]*partition, 0, len(p.partitions))
+	for start, part := range p.partitions {
+		if !part.dropping {
+			parts = append(parts, part)
+			delete(p.partitions, start)
+		}
+	}
+	p.mtx.Unlock()
+
+	for _, part := range parts {
+		part.close()
+	}
+}
+
+// Partitions returns the start time of the existing partitions, sorted.
+func (p *PartitionedDB) Partitions() []time.Time {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	starts := make([]time.Time, 0, len(p.partitions))
+	for _, start := range p.sortedStarts() {
+		starts = append(starts, time.Unix(0, start).UTC())
+	}
+	return starts
+}
+
+// PartitionFor returns the start time of the partition covering the given time.
+func (p *PartitionedDB) PartitionFor(t time.Time) time.Time {
+	return t.UTC().Truncate(p.opts.Window)
+}
+
+// WithinPartitionTx initiates a transaction on the partition covering the given time and calls a
+// callback. Writable transactions create the partition if it does not exist. Read-only ones fail with
+// ErrPartitionNotFound.
+func (p *PartitionedDB) WithinPartitionTx(t time.Time, opts TxOptions, cb WithinTxCallback) error {
+	return p.withPartition(t, !opts.ReadOnly, func(db *DB) error {
+		if db == nil {
+			return ErrPartitionNotFound
+		}
+		return db.WithinTx(opts, cb)
+	})
+}
+
+// Get returns the value of a key in the specified bucket of the partition covering the given time or nil
+// if not found.
+func (p *PartitionedDB) Get(t time.Time, bucket []byte, key []byte) ([]byte, error) {
+	var value []byte
+
+	err := p.withPartition(t, false, func(db *DB) error {
+		var err error
+
+		if db != nil {
+			value, err = db.Get(bucket, key)
+		}
+		return err
+	})
+
+	// Done
+	return value, err
+}
+
+// Put stores a key/value pair in the specified bucket of the partition covering the given time.
+func (p *PartitionedDB) Put(t time.Time, bucket []byte, key []byte, value []byte) error {
+	return p.withPartition(t, true, func(db *DB) error {
+		return db.Put(bucket, key, value)
+	})
+}
+
+// Delete deletes a specific key in the specified bucket of the partition covering the given time. No
+// error is returned if the key is not found.
+func (p *PartitionedDB) Delete(t time.Time, bucket []byte, key []byte) error {
+	return p.withPartition(t, false, func(db *DB) error {
+		if db == nil {
+			return nil
+		}
+		return db.Delete(bucket, key)
+	})
+}
+
+// WithIterator traverses the keys of a bucket in all the partitions overlapping the [from, to) time
+// range, merging them in key order. Zero times leave the range open. Nested buckets are skipped and keys
+// found in more than one partition are reported once per partition, oldest first.
+// NOTE: Prefix and FirstKey cannot be used at the same time.
+func (p *PartitionedDB) WithIterator(from time.Time, to time.Time, bucket []byte, opts WithIteratorOptions,
+	cb MergedIteratorCallback,
+) error {
+	var parts []*partition
+
+	p.mtx.RLock()
+	if p.closed {
+		p.mtx.RUnlock()
+		return ErrDatabaseNotOpen
+	}
+	for _, start := range p.sortedStarts() {
+		if !to.IsZero() && start >= to.UnixNano() {
+			continue
+		}
+		if !from.IsZero() && start+int64(p.opts.Window) <= from.UnixNano() {
+			continue
+		}
+		part := p.partitions[start]
+		part.users.Add(1)
+		parts = append(parts, part)
+	}
+	p.mtx.RUnlock()
+	defer func() {
+		for _, part := range parts {
+			part.users.Done()
+		}
+	}()
+
+	iters := make([]*Iterator, 0, len(parts))
+	for _, part := range parts {
+		tx, err := part.db.BeginTx(TxOptions{ReadOnly: true})
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		b, err := tx.Bucket(bucket)
+		if err != nil {
+			if errors.Is(err, ErrBucketNotFound) {
+				continue
+			}
+			return err
+		}
+		iters = append(iters, b.Iterate())
+	}
+
+	// Done
+	return mergeIterators(iters, opts, cb)
+}
+
+// DropBefore closes and deletes the partitions whose time window ends before or at the given time. It
+// returns the start time of the dropped partitions.
+// NOTE: Partitions are closed once the operations using them complete, so it must not be called from
+// the callback of an operation on a partition being dropped.
+func (p *PartitionedDB) DropBefore(t time.Time) ([]time.Time, error) {
+	var dropped []time.Time
+	var starts []int64
+	var parts []*partition
+	var firstErr error
+
+	// Mark the partitions first, so no new operation uses them.
+	p.mtx.Lock()
+	for _, start := range p.sortedStarts() {
+		if start+int64(p.opts.Window) > t.UnixNano() {
+			break
+		}
+		part := p.partitions[start]
+		part.dropping = true
+		starts, parts = append(starts, start), append(parts, part)
+	}
+	p.mtx.Unlock()
+
+	for idx, part := range parts {
+		start := starts[idx]
+		filename := part.db.db.Path()
+		part.close()
+		err := os.Remove(filename)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		dropped = append(dropped, time.Unix(0, start).UTC())
+	}
+
+	p.mtx.Lock()
+	for _, start := range starts {
+		delete(p.partitions, start)
+	}
+	p.mtx.Unlock()
+
+	// Done
+	return dropped, firstErr
+}
+
+// DropExpired drops the partitions older than the retention period. See DropBefore.
+func (p *PartitionedDB) DropExpired() ([]time.Time, error) {
+	if p.opts.Retention <= 0 {
+		return nil, nil
+	}
+	return p.DropBefore(time.Now().Add(-p.opts.Retention))
+}
+
+// -----------------------------------------------------------------------------
+
+// withPartition calls the callback with the partition covering the given time, creating it if requested.
+// The callback receives nil if the partition does not exist. The partition is not closed until the
+// callback returns, but the lock is not held meanwhile.
+func (p *PartitionedDB) withPartition(t time.Time, create bool, cb func(db *DB) error) error {
+	var err error
+
+	start := p.PartitionFor(t).UnixNano()
+
+	// acquire must be called with the lock held.
+	acquire := func() *partition {
+		part := p.partitions[start]
+		if part == nil || part.dropping {
+			return nil
+		}
+		part.users.Add(1)
+		return part
+	}
+
+	p.mtx.RLock()
+	closed := p.closed
+	part := acquire()
+	p.mtx.RUnlock()
+	if closed {
+		return ErrDatabaseNotOpen
+	}
+
+	if part == nil && create {
+		p.mtx.Lock()
+		if p.closed {
+			err = ErrDatabaseNotOpen
+		} else if p.partitions[start] == nil {
+			filename := filepath.Join(p.dir, time.Unix(0, start).UTC().Format(partitionFileLayout)+partitionFileExtension)
+			db, err2 := NewWithOptions(filename, p.opts.Options)
+			if err2 == nil {
+				p.partitions[start] = &partition{db: db}
+			}
+			err = err2
+		}
+		if err == nil {
+			part = acquire()
+			if part == nil {
+				// Being dropped.
+				err = ErrPartitionNotFound
+			}
+		}
+		p.mtx.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	if part == nil {
+		return cb(nil)
+	}
+	defer part.users.Done()
+
+	// Done
+	return cb(part.db)
+}
+
+// sortedStarts returns the start time of the partitions not being dropped, sorted. The caller must hold
+// the lock.
+func (p *PartitionedDB) sortedStarts() []int64 {
+	starts := make([]int64, 0, len(p.partitions))
+	for start, part := range p.partitions {
+		if !part.dropping {
+			starts = append(starts, start)
+		}
+	}
+	sort.Slice(starts, func(i, j int) bool {
+		return starts[i] < starts[j]
+	})
+	return starts
+}
+
+// close waits for the operations using the partition and closes it.
+func (part *partition) close() {
+	part.users.Wait()
+	part.db.Close()
+}
@@ -0,0 +1,176 @@
+// See the LICENSE file for license details.
+
+package boltdb_test
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mxmauro/boltdb/v3"
+)
+
+// -----------------------------------------------------------------------------
+
+func TestPartitionedDB(t *testing.T) {
+	dir := t.TempDir()
+	opts := boltdb.PartitionedOptions{
+		Window:    time.Hour,
+		Retention: 24 * time.Hour,
+	}
+
+	p, err := boltdb.NewPartitioned(dir, opts)
+	if err != nil {
+		t.Fatalf("cannot create partitioned database [err=%v]", err)
+	}
+
+	base := time.Date(2001, 2, 3, 10, 0, 0, 0, time.UTC)
+	events := []struct {
+		offset time.Duration
+		key    string
+	}{
+		{5 * time.Minute, "c"},
+		{30 * time.Minute, "a"},
+		{65 * time.Minute, "b"},
+		{125 * time.Minute, "d"},
+	}
+	for _, event := range events {
+		err = p.Put(base.Add(event.offset), []byte("events"), []byte(event.key), []byte(event.offset.String()))
+		if err != nil {
+			p.Close()
+			t.Fatalf("cannot write to partitioned database [err=%v]", err)
+		}
+	}
+	if partitions := p.Partitions(); len(partitions) != 3 || !partitions[0].Equal(base) {
+		p.Close()
+		t.Fatalf("unexpected partitions [partitions=%v]", partitions)
+	}
+	p.Close()
+
+	// Existing partitions are found when reopening.
+	p, err = boltdb.NewPartitioned(dir, opts)
+	if err != nil {
+		t.Fatalf("cannot open partitioned database [err=%v]", err)
+	}
+	defer p.Close()
+
+	if value, err := p.Get(base.Add(70*time.Minute), []byte("events"), []byte("b")); err != nil ||
+		string(value) != "1h5m0s" {
+		t.Fatalf("unexpected value [value=%q err=%v]", value, err)
+	}
+	if value, err := p.Get(base, []byte("events"), []byte("b")); err != nil || value != nil {
+		t.Fatalf("unexpected value [value=%q err=%v]", value, err)
+	}
+
+	collect := func(from time.Time, to time.Time) string {
+		var keys []string
+
+		err := p.WithIterator(from, to, []byte("events"), boltdb.WithIteratorOptions{},
+			func(key []byte, value []byte) (bool, error) {
+				keys = append(keys, string(key))
+				return false, nil
+			})
+		if err != nil {
+			t.Fatalf("cannot iterate partitioned database [err=%v]", err)
+		}
+		return strings.Join(keys, ",")
+	}
+	if keys := collect(time.Time{}, time.Time{}); keys != "a,b,c,d" {
+		t.Fatalf("unexpected keys [keys=%v]", keys)
+	}
+	if keys := collect(base.Add(30*time.Minute), base.Add(2*time.Hour)); keys != "a,b,c" {
+		t.Fatalf("unexpected keys [keys=%v]", keys)
+	}
+
+	// Dropping partitions deletes their files.
+	dropped, err := p.DropBefore(base.Add(time.Hour))
+	if err != nil || len(dropped) != 1 || !dropped[0].Equal(base) {
+		t.Fatalf("unexpected dropped partitions [dropped=%v err=%v]", dropped, err)
+	}
+	if keys := collect(time.Time{}, time.Time{}); keys != "b,d" {
+		t.Fatalf("unexpected keys [keys=%v]", keys)
+	}
+	if dropped, err = p.DropExpired(); err != nil || len(dropped) != 2 {
+		t.Fatalf("unexpected dropped partitions [dropped=%v err=%v]", dropped, err)
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil || len(files) != 0 {
+		t.Fatalf("partition files were not removed [files=%v err=%v]", files, err)
+	}
+}
+
+func TestPartitionedDBCallbacks(t *testing.T) {
+	p, err := boltdb.NewPartitioned(t.TempDir(), boltdb.PartitionedOptions{Window: time.Hour})
+	if err != nil {
+		t.Fatalf("cannot create partitioned database [err=%v]", err)
+	}
+	defer p.Close()
+
+	base := time.Date(2001, 2, 3, 10, 0, 0, 0, time.UTC)
+	if err = p.Put(base, []byte("events"), []byte("a"), []byte("1")); err != nil {
+		t.Fatalf("cannot store key [err=%v]", err)
+	}
+
+	// Callbacks can create other partitions.
+	err = p.WithIterator(time.Time{}, time.Time{}, []byte("events"), boltdb.WithIteratorOptions{},
+		func(key []byte, value []byte) (bool, error) {
+			return false, p.Put(base.Add(time.Hour), []byte("events"), key, value)
+		},
+	)
+	if err != nil || len(p.Partitions()) != 2 {
+		t.Fatalf("cannot write from an iterator callback [partitions=%v err=%v]", p.Partitions(), err)
+	}
+
+	// Dropping waits for the operations using the partition.
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- p.WithinPartitionTx(base, boltdb.TxOptions{ReadOnly: true}, func(tx *boltdb.TX) error {
+			close(started)
+			<-release
+			_, err2 := tx.Bucket([]byte("events"))
+			return err2
+		})
+	}()
+	<-started
+	dropped := make(chan []time.Time, 1)
+	go func() {
+		partitions, _ := p.DropBefore(base.Add(time.Hour))
+		dropped <- partitions
+	}()
+	select {
+	case <-dropped:
+		t.Fatalf("partition dropped while in use")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if _, err = p.Get(base, []byte("events"), []byte("a")); err != nil {
+		t.Fatalf("cannot read while dropping [err=%v]", err)
+	}
+	close(release)
+	if err = <-done; err != nil {
+		t.Fatalf("transaction failed [err=%v]", err)
+	}
+	if partitions := <-dropped; len(partitions) != 1 || !partitions[0].Equal(base) {
+		t.Fatalf("unexpected dropped partitions [got=%v]", partitions)
+	}
+
+	// Operations after closing fail instead of creating partitions again.
+	p.Close()
+	if err = p.Put(base, []byte("events"), []byte("a"), []byte("1")); !errors.Is(err, boltdb.ErrDatabaseNotOpen) {
+		t.Fatalf("expected ErrDatabaseNotOpen [got=%v]", err)
+	}
+	err = p.WithIterator(time.Time{}, time.Time{}, []byte("events"), boltdb.WithIteratorOptions{},
+		func(key []byte, value []byte) (bool, error) {
+			return false, nil
+		},
+	)
+	if !errors.Is(err, boltdb.ErrDatabaseNotOpen) {
+		t.Fatalf("expected ErrDatabaseNotOpen [got=%v]", err)
+	}
+	if partitions := p.Partitions(); len(partitions) != 0 {
+		t.Fatalf("unexpected partitions after closing [got=%v]", partitions)
+	}
+}
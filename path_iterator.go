@@ -1,5 +1,9 @@
 package boltdb
 
+import (
+	"bytes"
+)
+
 // -----------------------------------------------------------------------------
 
 type pathIterator struct {
@@ -58,3 +62,43 @@ func (pi *pathIterator) fragment() ([]byte, bool) {
 	// Done
 	return pi.path[fragmentStart:fragmentEnd], pi.offset >= pi.pathLen
 }
+
+// splitPath parses a slash-separated path into its fragments.
+func splitPath(path []byte) ([][]byte, error) {
+	pi, err := newPathIterator(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fragments := make([][]byte, 0, 4)
+	for {
+		pathFragment, lastFragment := pi.fragment()
+		if pathFragment != nil {
+			fragments = append(fragments, pathFragment)
+		}
+		if lastFragment {
+			break
+		}
+	}
+
+	// Done
+	return fragments, nil
+}
+
+// joinPath builds a normalized slash-separated path from its fragments.
+func joinPath(fragments [][]byte) []byte {
+	return bytes.Join(fragments, []byte{'/'})
+}
+
+// hasPathPrefix returns true if the path fragments start with all the prefix fragments.
+func hasPathPrefix(fragments [][]byte, prefix [][]byte) bool {
+	if len(fragments) < len(prefix) {
+		return false
+	}
+	for idx := range prefix {
+		if !bytes.Equal(fragments[idx], prefix[idx]) {
+			return false
+		}
+	}
+	return true
+}
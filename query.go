@@ -0,0 +1,789 @@
+// See the LICENSE file for license details.
+
+package boltdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// -----------------------------------------------------------------------------
+
+// Operator specifies how a query predicate compares a document field.
+type Operator string
+
+const (
+	OpEq     Operator = "eq"
+	OpNe     Operator = "ne"
+	OpLt     Operator = "lt"
+	OpGt     Operator = "gt"
+	OpIn     Operator = "in"
+	OpPrefix Operator = "prefix"
+	OpExists Operator = "exists"
+)
+
+// Query selects JSON documents stored in a bucket. Fields are addressed using dotted paths, for e.g.,
+// "address.city" or "tags.0". Values that are not JSON objects and nested buckets are skipped.
+//
+// Queries are planned against the declared indexes and key prefix before falling back to a full scan.
+// Predicates are always evaluated on the documents, so indexes only need to be a superset of the matches.
+type Query struct {
+	path       []byte
+	predicates []queryPredicate
+	sorts      []querySort
+	limit      int
+	offset     int
+	projection []string
+	keyPrefix  []byte
+	indexes    []QueryIndex
+	err        error
+}
+
+// QueryIndex declares a bucket that indexes a document field. Index keys are built using QueryIndexKey
+// and their values are the keys of the indexed documents, the same layout used by the non-unique indexes
+// of Store. Maintaining the index is up to the caller.
+type QueryIndex struct {
+	Field string
+	Path  []byte
+}
+
+// QueryResult is a document returned by a query. Numbers are decoded as json.Number, so integers keep
+// their precision.
+type QueryResult struct {
+	Key []byte
+	Doc map[string]any
+}
+
+// QueryPlan describes how a query is executed.
+type QueryPlan struct {
+	// Strategy is one of "index-scan", "prefix-scan" or "full-scan".
+	Strategy string
+	Bucket   []byte
+
+	// Index and IndexPredicate are set when an index is used.
+	Index          []byte
+	IndexPredicate string
+
+	// KeyPrefix is set when a prefix scan is used.
+	KeyPrefix []byte
+
+	Filters    []string
+	Sort       []string
+	Offset     int
+	Limit      int
+	Projection []string
+}
+
+type queryPredicate struct {
+	field string
+	op    Operator
+	value any
+}
+
+type querySort struct {
+	field string
+	desc  bool
+}
+
+// queryScan is a range of keys of the scanned bucket. If end is set, keys must not be greater than end
+// unless they have it as prefix.
+type queryScan struct {
+	start []byte
+	end   []byte
+
+	// prefix, if set, restricts the scan to keys with this prefix.
+	prefix []byte
+}
+
+// -----------------------------------------------------------------------------
+
+const (
+	queryTypeNull   = 1
+	queryTypeBool   = 2
+	queryTypeNumber = 3
+	queryTypeString = 4
+)
+
+// -----------------------------------------------------------------------------
+
+// NewQuery creates a query over the documents stored in the bucket with the given path.
+func NewQuery(path []byte) *Query {
+	q := &Query{}
+	fragments, err := splitPath(path)
+	if err != nil {
+		q.err = err
+	}
+	q.path = joinPath(fragments)
+	return q
+}
+
+// Where adds a predicate. For OpIn, value must be a slice. For OpExists, value must be a boolean.
+func (q *Query) Where(field string, op Operator, value any) *Query {
+	switch op {
+	case OpEq, OpNe, OpLt, OpGt, OpPrefix:
+		value = normalizeQueryValue(value)
+	case OpIn:
+		rv := reflect.ValueOf(value)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			q.setError(fmt.Errorf("%w: %s requires a slice", ErrInvalidQuery, op))
+			return q
+		}
+		values := make([]any, rv.Len())
+		for idx := range values {
+			values[idx] = normalizeQueryValue(rv.Index(idx).Interface())
+		}
+		value = values
+	case OpExists:
+		if _, ok := value.(bool); !ok {
+			q.setError(fmt.Errorf("%w: %s requires a boolean", ErrInvalidQuery, op))
+			return q
+		}
+	default:
+		q.setError(fmt.Errorf("%w: unknown operator %q", ErrInvalidQuery, op))
+		return q
+	}
+	if op == OpPrefix {
+		if _, ok := value.(string); !ok {
+			q.setError(fmt.Errorf("%w: %s requires a string", ErrInvalidQuery, op))
+			return q
+		}
+	}
+
+	q.predicates = append(q.predicates, queryPredicate{
+		field: field,
+		op:    op,
+		value: value,
+	})
+	return q
+}
+
+// OrderBy adds a sort criteria. Documents lacking the field sort first.
+func (q *Query) OrderBy(field string, desc bool) *Query {
+	q.sorts = append(q.sorts, querySort{
+		field: field,
+		desc:  desc,
+	})
+	return q
+}
+
+// Limit sets the maximum number of documents returned. Zero means no limit.
+func (q *Query) Limit(limit int) *Query {
+	q.limit = limit
+	return q
+}
+
+// Offset sets the number of matching documents skipped.
+func (q *Query) Offset(offset int) *Query {
+	q.offset = offset
+	return q
+}
+
+// Select restricts the fields included in the returned documents. Array elements selected by index keep
+// their position and the elements before them are set to null.
+func (q *Query) Select(fields ...string) *Query {
+	q.projection = append(q.projection, fields...)
+	return q
+}
+
+// KeyPrefix declares that all the matching documents have keys starting with the given prefix.
+func (q *Query) KeyPrefix(prefix []byte) *Query {
+	q.keyPrefix = cloneBytes(prefix)
+	return q
+}
+
+// UseIndex declares an index the planner can use.
+func (q *Query) UseIndex(index QueryIndex) *Query {
+	fragments, err := splitPath(index.Path)
+	if err != nil {
+		q.setError(err)
+		return q
+	}
+	index.Path = joinPath(fragments)
+	q.indexes = append(q.indexes, index)
+	return q
+}
+
+// Explain returns the plan used to execute the query.
+func (q *Query) Explain() (QueryPlan, error) {
+	if q.err != nil {
+		return QueryPlan{}, q.err
+	}
+	plan, _, _ := q.plan()
+	return plan, nil
+}
+
+// Run executes the query within the given transaction.
+func (q *Query) Run(tx *TX) ([]QueryResult, error) {
+	var results []QueryResult
+
+	if q.err != nil {
+		return nil, q.err
+	}
+	_, index, scans := q.plan()
+
+	data, err := tx.Bucket(q.path)
+	if err != nil {
+		if errors.Is(err, ErrBucketNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	// Without sorting, the scan can stop as soon as enough documents are found.
+	maxResults := 0
+	if len(q.sorts) == 0 && q.limit > 0 {
+		maxResults = q.offset + q.limit
+	}
+
+	seen := make(map[string]struct{})
+	visit := func(key []byte, value []byte) (bool, error) {
+		if !bytes.HasPrefix(key, q.keyPrefix) {
+			return false, nil
+		}
+		if index != nil {
+			// Index entries point to documents, which might be reached more than once.
+			if _, ok := seen[string(key)]; ok {
+				return false, nil
+			}
+			seen[string(key)] = struct{}{}
+		}
+
+		var doc map[string]any
+		if decodeQueryJSON(value, &doc) != nil || doc == nil {
+			return false, nil
+		}
+		for idx := range q.predicates {
+			if !q.predicates[idx].match(doc) {
+				return false, nil
+			}
+		}
+		results = append(results, QueryResult{
+			Key: cloneBytes(key),
+			Doc: doc,
+		})
+		return maxResults > 0 && len(results) >= maxResults, nil
+	}
+
+	if index == nil {
+		opts := WithIteratorOptions{
+			Prefix: q.keyPrefix,
+		}
+		err = data.WithIterator(opts, func(iter *Iterator) (bool, error) {
+			if iter.IsNestedBucket() {
+				return false, nil
+			}
+			return visit(iter.Key(), iter.Value())
+		})
+	} else {
+		var indexBucket *Bucket
+
+		indexBucket, err = tx.Bucket(index.Path)
+		if err != nil {
+			if errors.Is(err, ErrBucketNotFound) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		for _, scan := range scans {
+			stop := false
+			err = indexBucket.WithIterator(scan.iteratorOptions(), func(iter *Iterator) (bool, error) {
+				if !scan.contains(iter.Key()) {
+					return true, nil
+				}
+				docKey := iter.Value()
+				if docKey == nil {
+					return false, nil
+				}
+				value, err2 := data.GetValue(docKey)
+				if err2 != nil || value == nil {
+					return false, err2
+				}
+				stop, err2 = visit(docKey, value)
+				return stop, err2
+			})
+			if err != nil || stop {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Sort, paginate and project.
+	if len(q.sorts) > 0 {
+		sort.SliceStable(results, func(i, j int) bool {
+			for _, s := range q.sorts {
+				a, aOk := lookupQueryField(results[i].Doc, s.field)
+				b, bOk := lookupQueryField(results[j].Doc, s.field)
+				c := compareQueryFieldValues(a, aOk, b, bOk)
+				if c != 0 {
+					return (c < 0) != s.desc
+				}
+			}
+			return false
+		})
+	}
+	if q.offset >= len(results) {
+		results = nil
+	} else {
+		results = results[q.offset:]
+	}
+	if q.limit > 0 && len(results) > q.limit {
+		results = results[:q.limit]
+	}
+	if len(q.projection) > 0 {
+		for idx := range results {
+			results[idx].Doc = projectQueryDoc(results[idx].Doc, q.projection)
+		}
+	}
+	// Done
+	return results, nil
+}
+
+// String returns a human-readable description of the plan.
+func (p QueryPlan) String() string {
+	var sb strings.Builder
+
+	_, _ = fmt.Fprintf(&sb, "%s of %q", p.Strategy, p.Bucket)
+	if p.Index != nil {
+		_, _ = fmt.Fprintf(&sb, " using index %q on %s", p.Index, p.IndexPredicate)
+	}
+	if p.KeyPrefix != nil {
+		_, _ = fmt.Fprintf(&sb, " with key prefix %q", p.KeyPrefix)
+	}
+	if len(p.Filters) > 0 {
+		_, _ = fmt.Fprintf(&sb, "; filter %s", strings.Join(p.Filters, " AND "))
+	}
+	if len(p.Sort) > 0 {
+		_, _ = fmt.Fprintf(&sb, "; sort by %s", strings.Join(p.Sort, ", "))
+	}
+	if p.Offset > 0 {
+		_, _ = fmt.Fprintf(&sb, "; offset %d", p.Offset)
+	}
+	if p.Limit > 0 {
+		_, _ = fmt.Fprintf(&sb, "; limit %d", p.Limit)
+	}
+	if len(p.Projection) > 0 {
+		_, _ = fmt.Fprintf(&sb, "; select %s", strings.Join(p.Projection, ", "))
+	}
+	return sb.String()
+}
+
+// QueryIndexKey returns the key of an index entry for a document field value. Field values are ordered
+// by type (null, booleans, numbers and strings) and then by value.
+func QueryIndexKey(value any, docKey []byte) ([]byte, error) {
+	key, err := encodeQueryValue(normalizeQueryValue(value))
+	if err != nil {
+		return nil, err
+	}
+	return storeIndexKey(key, docKey), nil
+}
+
+// -----------------------------------------------------------------------------
+
+func (q *Query) setError(err error) {
+	if q.err == nil {
+		q.err = err
+	}
+}
+
+// plan selects the index and the key ranges to scan. Equality is preferred over membership, prefix and
+// range predicates.
+func (q *Query) plan() (QueryPlan, *QueryIndex, []queryScan) {
+	var index *QueryIndex
+	var indexPredicate *queryPredicate
+	var scans []queryScan
+
+	plan := QueryPlan{
+		Strategy: "full-scan",
+		Bucket:   q.path,
+		Offset:   q.offset,
+		Limit:    q.limit,
+	}
+
+	priority := func(op Operator) int {
+		switch op {
+		case OpEq:
+			return 4
+		case OpIn:
+			return 3
+		case OpPrefix:
+			return 2
+		case OpLt, OpGt:
+			return 1
+		}
+		return 0
+	}
+	for idx := range q.predicates {
+		p := &q.predicates[idx]
+		if priority(p.op) == 0 || (indexPredicate != nil && priority(p.op) <= priority(indexPredicate.op)) {
+			continue
+		}
+		for indexIdx := range q.indexes {
+			if q.indexes[indexIdx].Field == p.field {
+				candidateScans, ok := p.indexScans()
+				if ok {
+					index, indexPredicate, scans = &q.indexes[indexIdx], p, candidateScans
+				}
+				break
+			}
+		}
+	}
+
+	if index != nil {
+		plan.Strategy = "index-scan"
+		plan.Index = index.Path
+		plan.IndexPredicate = indexPredicate.String()
+	} else if len(q.keyPrefix) > 0 {
+		plan.Strategy = "prefix-scan"
+		plan.KeyPrefix = q.keyPrefix
+	}
+	for idx := range q.predicates {
+		plan.Filters = append(plan.Filters, q.predicates[idx].String())
+	}
+	for _, s := range q.sorts {
+		if s.desc {
+			plan.Sort = append(plan.Sort, s.field+" DESC")
+		} else {
+			plan.Sort = append(plan.Sort, s.field+" ASC")
+		}
+	}
+	plan.Projection = q.projection
+
+	// Done
+	return plan, index, scans
+}
+
+// indexScans returns the index ranges that contain all the documents matching the predicate.
+func (p *queryPredicate) indexScans() ([]queryScan, bool) {
+	switch p.op {
+	case OpEq:
+		key, err := encodeQueryValue(p.value)
+		if err != nil {
+			return nil, false
+		}
+		return []queryScan{{prefix: key}}, true
+
+	case OpIn:
+		var scans []queryScan
+
+		for _, value := range p.value.([]any) {
+			key, err := encodeQueryValue(value)
+			if err != nil {
+				return nil, false
+			}
+			scans = append(scans, queryScan{prefix: key})
+		}
+		return scans, true
+
+	case OpPrefix:
+		key, err := encodeQueryValue(p.value)
+		if err != nil {
+			return nil, false
+		}
+		// Escaped string bytes without the terminator.
+		return []queryScan{{prefix: key[:len(key)-2]}}, true
+
+	case OpLt, OpGt:
+		key, err := encodeQueryValue(p.value)
+		if err != nil {
+			return nil, false
+		}
+		// Values of other types never match, so the range is limited to the type of the value.
+		typePrefix := key[:1]
+		if p.op == OpLt {
+			return []queryScan{{start: typePrefix, end: key, prefix: typePrefix}}, true
+		}
+		return []queryScan{{start: key, prefix: typePrefix}}, true
+
+	default:
+	}
+	return nil, false
+}
+
+func (s queryScan) iteratorOptions() WithIteratorOptions {
+	if s.start == nil {
+		return WithIteratorOptions{Prefix: s.prefix}
+	}
+	return WithIteratorOptions{FirstKey: s.start}
+}
+
+func (s queryScan) contains(key []byte) bool {
+	if s.prefix != nil && !bytes.HasPrefix(key, s.prefix) {
+		return false
+	}
+	return s.end == nil || bytes.Compare(key, s.end) <= 0 || bytes.HasPrefix(key, s.end)
+}
+
+func (p *queryPredicate) match(doc map[string]any) bool {
+	value, ok := lookupQueryField(doc, p.field)
+
+	switch p.op {
+	case OpExists:
+		return ok == p.value.(bool)
+	case OpNe:
+		return !ok || compareQueryValues(value, p.value) != 0
+	}
+	if !ok {
+		return false
+	}
+
+	switch p.op {
+	case OpEq:
+		return compareQueryValues(value, p.value) == 0
+	case OpLt:
+		return queryTypeOf(value) == queryTypeOf(p.value) && compareQueryValues(value, p.value) < 0
+	case OpGt:
+		return queryTypeOf(value) == queryTypeOf(p.value) && compareQueryValues(value, p.value) > 0
+	case OpIn:
+		for _, candidate := range p.value.([]any) {
+			if compareQueryValues(value, candidate) == 0 {
+				return true
+			}
+		}
+		return false
+	case OpPrefix:
+		s, isString := value.(string)
+		return isString && strings.HasPrefix(s, p.value.(string))
+	}
+	return false
+}
+
+func (p *queryPredicate) String() string {
+	value, _ := json.Marshal(p.value)
+	return fmt.Sprintf("%s %s %s", p.field, p.op, value)
+}
+
+func lookupQueryField(doc map[string]any, field string) (any, bool) {
+	var current any = doc
+
+	for _, segment := range strings.Split(field, ".") {
+		switch node := current.(type) {
+		case map[string]any:
+			value, ok := node[segment]
+			if !ok {
+				return nil, false
+			}
+			current = value
+		case []any:
+			idx, err := strconv.Atoi(segment)
+			if err != nil || idx < 0 || idx >= len(node) {
+				return nil, false
+			}
+			current = node[idx]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func projectQueryDoc(doc map[string]any, fields []string) map[string]any {
+	projected := make(map[string]any)
+	for _, field := range fields {
+		if _, ok := lookupQueryField(doc, field); ok {
+			projected = projectQueryField(projected, doc, strings.Split(field, ".")).(map[string]any)
+		}
+	}
+	return projected
+}
+
+// projectQueryField copies the value found following the segments of an existing field from src into
+// dst, which has the same shape, creating the objects and arrays in between.
+func projectQueryField(dst any, src any, segments []string) any {
+	if len(segments) == 0 {
+		return src
+	}
+
+	switch node := src.(type) {
+	case map[string]any:
+		out, _ := dst.(map[string]any)
+		if out == nil {
+			out = make(map[string]any)
+		}
+		out[segments[0]] = projectQueryField(out[segments[0]], node[segments[0]], segments[1:])
+		return out
+
+	case []any:
+		idx, _ := strconv.Atoi(segments[0]) // Intentionally ignored: the field was already looked up.
+		out, _ := dst.([]any)
+		for len(out) <= idx {
+			out = append(out, nil)
+		}
+		out[idx] = projectQueryField(out[idx], node[idx], segments[1:])
+		return out
+	}
+	return dst
+}
+
+// normalizeQueryValue converts Go values to the types produced by the JSON decoder used by queries.
+func normalizeQueryValue(value any) any {
+	switch v := value.(type) {
+	case nil, bool, string, json.Number, map[string]any, []any:
+		return v
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return json.Number(strconv.FormatInt(rv.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return json.Number(strconv.FormatUint(rv.Uint(), 10))
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if !math.IsNaN(f) && !math.IsInf(f, 0) {
+			return json.Number(strconv.FormatFloat(f, 'g', -1, 64))
+		}
+		return value
+	case reflect.String:
+		return rv.String()
+	case reflect.Bool:
+		return rv.Bool()
+	}
+
+	// Round-trip other values through JSON.
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	var decoded any
+	if decodeQueryJSON(encoded, &decoded) != nil {
+		return value
+	}
+	return decoded
+}
+
+func queryTypeOf(value any) int {
+	switch value.(type) {
+	case nil:
+		return queryTypeNull
+	case bool:
+		return queryTypeBool
+	case json.Number:
+		return queryTypeNumber
+	case string:
+		return queryTypeString
+	}
+	return queryTypeString + 1
+}
+
+// compareQueryValues compares two JSON values ordering first by type.
+func compareQueryValues(a any, b any) int {
+	ta, tb := queryTypeOf(a), queryTypeOf(b)
+	if ta != tb {
+		if ta < tb {
+			return -1
+		}
+		return 1
+	}
+
+	switch va := a.(type) {
+	case bool:
+		vb := b.(bool)
+		if va == vb {
+			return 0
+		} else if !va {
+			return -1
+		}
+		return 1
+	case json.Number:
+		return compareQueryNumbers(va, b.(json.Number))
+	case string:
+		return strings.Compare(va, b.(string))
+	case nil:
+		return 0
+	}
+
+	// Objects and arrays are only compared for equality.
+	if reflect.DeepEqual(a, b) {
+		return 0
+	}
+	ea, _ := json.Marshal(a)
+	eb, _ := json.Marshal(b)
+	return bytes.Compare(ea, eb)
+}
+
+// compareQueryNumbers compares two JSON numbers without rounding integers to float64.
+func compareQueryNumbers(a json.Number, b json.Number) int {
+	if ia, err := a.Int64(); err == nil {
+		if ib, err2 := b.Int64(); err2 == nil {
+			if ia < ib {
+				return -1
+			} else if ia > ib {
+				return 1
+			}
+			return 0
+		}
+	}
+
+	fa, _, errA := big.ParseFloat(string(a), 10, 256, big.ToNearestEven)
+	fb, _, errB := big.ParseFloat(string(b), 10, 256, big.ToNearestEven)
+	if errA != nil || errB != nil {
+		return strings.Compare(string(a), string(b))
+	}
+	return fa.Cmp(fb)
+}
+
+func compareQueryFieldValues(a any, aOk bool, b any, bOk bool) int {
+	if aOk != bOk {
+		if !aOk {
+			return -1
+		}
+		return 1
+	}
+	return compareQueryValues(a, b)
+}
+
+// encodeQueryValue encodes a JSON value as the type followed by the same ordered encoding used by the
+// Store keys. Numbers are encoded as float64, so integers beyond 2^53 may share a key with their
+// neighbours. Index scans are inclusive and predicates are evaluated on the documents, so this only
+// widens the scanned range.
+func encodeQueryValue(value any) ([]byte, error) {
+	var typ byte
+
+	switch v := value.(type) {
+	case nil:
+		return []byte{queryTypeNull}, nil
+	case bool:
+		typ = queryTypeBool
+	case json.Number:
+		f, err := strconv.ParseFloat(string(v), 64)
+		if err != nil && !math.IsInf(f, 0) {
+			return nil, fmt.Errorf("%w: invalid number %q", ErrInvalidQuery, v)
+		}
+		typ, value = queryTypeNumber, f
+	case string:
+		typ = queryTypeString
+	default:
+		return nil, fmt.Errorf("%w: value of type %T cannot be indexed", ErrInvalidQuery, value)
+	}
+	key, err := encodeOrderedKey(reflect.ValueOf(value))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidQuery, err)
+	}
+	return append([]byte{typ}, key...), nil
+}
+
+// decodeQueryJSON decodes a JSON document keeping numbers as json.Number.
+func decodeQueryJSON(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	err := dec.Decode(v)
+	if err != nil {
+		return err
+	}
+	if _, err = dec.Token(); err != io.EOF {
+		return errors.New("unexpected data after the JSON document")
+	}
+	return nil
+}
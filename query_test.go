@@ -0,0 +1,197 @@
+// See the LICENSE file for license details.
+
+package boltdb_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/mxmauro/boltdb/v3"
+)
+
+// -----------------------------------------------------------------------------
+
+func TestQuery(t *testing.T) {
+	db := openTestDb(t)
+	defer db.Close()
+
+	docs := map[string]map[string]any{
+		"user:1":  {"name": "Alice", "age": 31, "address": map[string]any{"city": "Buenos Aires"}, "tags": []string{"admin"}},
+		"user:2":  {"name": "Bob", "age": 25, "address": map[string]any{"city": "Boston"}},
+		"user:3":  {"name": "Carol", "age": 42, "address": map[string]any{"city": "Buenos Aires"}, "vip": true},
+		"user:4":  {"name": "Dave", "age": "unknown"},
+		"group:1": {"name": "Admins"},
+	}
+	err := db.WithinTx(boltdb.TxOptions{}, func(tx *boltdb.TX) error {
+		data, err2 := tx.Bucket([]byte("docs"))
+		if err2 != nil {
+			return err2
+		}
+		index, err2 := tx.Bucket([]byte("docs_by_city"))
+		if err2 != nil {
+			return err2
+		}
+		for key, doc := range docs {
+			encoded, _ := json.Marshal(doc)
+			if err2 = data.Put([]byte(key), encoded); err2 != nil {
+				return err2
+			}
+			if address, ok := doc["address"].(map[string]any); ok {
+				indexKey, err3 := boltdb.QueryIndexKey(address["city"], []byte(key))
+				if err3 != nil {
+					return err3
+				}
+				if err2 = index.Put(indexKey, []byte(key)); err2 != nil {
+					return err2
+				}
+			}
+		}
+		return data.Put([]byte("raw"), []byte("not json"))
+	})
+	if err != nil {
+		t.Fatalf("cannot seed test database [err=%v]", err.Error())
+	}
+
+	run := func(q *boltdb.Query) []boltdb.QueryResult {
+		t.Helper()
+
+		var results []boltdb.QueryResult
+		err2 := db.WithinTx(boltdb.TxOptions{ReadOnly: true}, func(tx *boltdb.TX) error {
+			var err3 error
+			results, err3 = q.Run(tx)
+			return err3
+		})
+		if err2 != nil {
+			t.Fatalf("cannot run query [err=%v]", err2.Error())
+		}
+		return results
+	}
+
+	// Full scan with sorting and pagination.
+	q := boltdb.NewQuery([]byte("docs")).Where("age", boltdb.OpGt, 20).OrderBy("age", true).Offset(1).Limit(1)
+	results := run(q)
+	if len(results) != 1 || results[0].Doc["name"] != "Alice" {
+		t.Fatalf("unexpected sorted results [got=%v]", results)
+	}
+	if plan, _ := q.Explain(); plan.Strategy != "full-scan" {
+		t.Fatalf("unexpected plan [got=%v]", plan)
+	}
+
+	// Index scan.
+	q = boltdb.NewQuery([]byte("docs")).
+		UseIndex(boltdb.QueryIndex{Field: "address.city", Path: []byte("docs_by_city")}).
+		Where("address.city", boltdb.OpPrefix, "Buenos").
+		Where("vip", boltdb.OpExists, false).
+		Select("name", "address.city")
+	results = run(q)
+	if len(results) != 1 || string(results[0].Key) != "user:1" || len(results[0].Doc) != 2 ||
+		results[0].Doc["address"].(map[string]any)["city"] != "Buenos Aires" {
+		t.Fatalf("unexpected index results [got=%v]", results)
+	}
+	plan, err := q.Explain()
+	if err != nil || plan.Strategy != "index-scan" || string(plan.Index) != "docs_by_city" || len(plan.Filters) != 2 {
+		t.Fatalf("unexpected plan [got=%v err=%v]", plan, err)
+	}
+
+	q = boltdb.NewQuery([]byte("docs")).
+		UseIndex(boltdb.QueryIndex{Field: "address.city", Path: []byte("docs_by_city")}).
+		Where("address.city", boltdb.OpLt, "Buenos Aires")
+	if results = run(q); len(results) != 1 || string(results[0].Key) != "user:2" {
+		t.Fatalf("unexpected index range results [got=%v]", results)
+	}
+
+	// Prefix scan.
+	q = boltdb.NewQuery([]byte("docs")).KeyPrefix([]byte("user:")).
+		Where("name", boltdb.OpIn, []string{"Bob", "Dave", "Admins"}).
+		Where("name", boltdb.OpNe, "Dave").
+		OrderBy("name", false)
+	results = run(q)
+	if len(results) != 1 || results[0].Doc["name"] != "Bob" {
+		t.Fatalf("unexpected prefix results [got=%v]", results)
+	}
+	if plan, _ = q.Explain(); plan.Strategy != "prefix-scan" {
+		t.Fatalf("unexpected plan [got=%v]", plan)
+	}
+
+	if _, err = boltdb.NewQuery([]byte("docs")).Where("name", boltdb.OpIn, "Bob").Explain(); err == nil {
+		t.Fatalf("expected invalid predicate to be rejected")
+	}
+}
+
+func TestQueryNumbersAndArrays(t *testing.T) {
+	db := openTestDb(t)
+	defer db.Close()
+
+	// Both ids round to the same float64.
+	docs := map[string]string{
+		"a": `{"id": 9007199254740992, "tags": ["x", "y"]}`,
+		"b": `{"id": 9007199254740993, "tags": ["z"]}`,
+	}
+	ids := map[string]uint64{
+		"a": 9007199254740992,
+		"b": 9007199254740993,
+	}
+	err := db.WithinTx(boltdb.TxOptions{}, func(tx *boltdb.TX) error {
+		data, err2 := tx.Bucket([]byte("docs"))
+		if err2 != nil {
+			return err2
+		}
+		index, err2 := tx.Bucket([]byte("docs_by_id"))
+		if err2 != nil {
+			return err2
+		}
+		for key, doc := range docs {
+			if err2 = data.Put([]byte(key), []byte(doc)); err2 != nil {
+				return err2
+			}
+			indexKey, err3 := boltdb.QueryIndexKey(ids[key], []byte(key))
+			if err3 != nil {
+				return err3
+			}
+			if err2 = index.Put(indexKey, []byte(key)); err2 != nil {
+				return err2
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("cannot seed test database [err=%v]", err.Error())
+	}
+
+	run := func(q *boltdb.Query) []boltdb.QueryResult {
+		t.Helper()
+
+		var results []boltdb.QueryResult
+		err2 := db.WithinTx(boltdb.TxOptions{ReadOnly: true}, func(tx *boltdb.TX) error {
+			var err3 error
+			results, err3 = q.Run(tx)
+			return err3
+		})
+		if err2 != nil {
+			t.Fatalf("cannot run query [err=%v]", err2.Error())
+		}
+		return results
+	}
+
+	// Large integers are compared exactly, with or without an index.
+	for _, q := range []*boltdb.Query{
+		boltdb.NewQuery([]byte("docs")).Where("id", boltdb.OpEq, uint64(9007199254740993)),
+		boltdb.NewQuery([]byte("docs")).Where("id", boltdb.OpGt, int64(9007199254740992)),
+		boltdb.NewQuery([]byte("docs")).
+			UseIndex(boltdb.QueryIndex{Field: "id", Path: []byte("docs_by_id")}).
+			Where("id", boltdb.OpGt, int64(9007199254740992)),
+	} {
+		results := run(q)
+		if len(results) != 1 || string(results[0].Key) != "b" ||
+			results[0].Doc["id"] != json.Number("9007199254740993") {
+			t.Fatalf("unexpected results [got=%v]", results)
+		}
+	}
+
+	// Array elements keep their position when projected.
+	results := run(boltdb.NewQuery([]byte("docs")).Where("tags.1", boltdb.OpExists, true).Select("tags.1"))
+	if len(results) != 1 || !reflect.DeepEqual(results[0].Doc, map[string]any{"tags": []any{nil, "y"}}) {
+		t.Fatalf("unexpected projected results [got=%v]", results)
+	}
+}
@@ -0,0 +1,148 @@
+// See the LICENSE file for license details.
+
+package boltdb
+
+import (
+	"context"
+	"fmt"
+)
+
+// -----------------------------------------------------------------------------
+
+// Quota limits the data stored inside a bucket and its nested buckets. Zero values mean unlimited.
+type Quota struct {
+	// MaxKeys and MaxBytes limit the number of keys and their size as stored.
+	MaxKeys  uint64
+	MaxBytes uint64
+
+	// MaxKeySize and MaxValueSize limit the size of every key and value written, before encoding.
+	MaxKeySize   uint64
+	MaxValueSize uint64
+}
+
+// QuotaStatus contains the quota and the current usage of a tracked bucket.
+type QuotaStatus struct {
+	Path  []byte
+	Quota Quota
+	Usage Usage
+}
+
+// -----------------------------------------------------------------------------
+
+// SetQuota sets the quota of a bucket, which does not need to exist yet, replacing the previous one. The
+// usage of the bucket is computed and, from then on, tracked on every write inside it. Writes exceeding
+// the quota fail with ErrQuotaExceeded. A zero quota tracks the usage without limits.
+func (db *DB) SetQuota(path []byte, quota Quota) error {
+	fragments, err := splitUserPath(path)
+	if err != nil {
+		return err
+	}
+	return db.WithinTx(TxOptions{}, func(tx *TX) error {
+		return tx.setQuota(fragments, &quota)
+	})
+}
+
+// RemoveQuota removes the quota of a bucket and stops tracking its usage.
+func (db *DB) RemoveQuota(path []byte) error {
+	fragments, err := splitUserPath(path)
+	if err != nil {
+		return err
+	}
+	return db.WithinTx(TxOptions{}, func(tx *TX) error {
+		usage := tx.usageBucket()
+		if usage == nil {
+			return nil
+		}
+		key := joinPath(fragments)
+		tx.journalRawKey([][]byte{metaBucketName, metaUsageBucket}, usage, key)
+		return tx.writeMeta(metaUsageBucket, usage, key, nil)
+	})
+}
+
+// Usage returns the number of keys stored inside a bucket and its nested buckets and their size as
+// stored. The usage of buckets not being tracked is computed by walking them.
+func (db *DB) Usage(path []byte) (Usage, error) {
+	var usage Usage
+
+	fragments, err := splitUserPath(path)
+	if err != nil {
+		return usage, err
+	}
+	err = db.WithinTx(TxOptions{ReadOnly: true}, func(tx *TX) error {
+		usage = tx.usage(fragments)
+		return nil
+	})
+
+	// Done
+	return usage, err
+}
+
+// Quotas returns the quota and the current usage of all the tracked buckets, sorted by path.
+func (db *DB) Quotas() ([]QuotaStatus, error) {
+	var quotas []QuotaStatus
+
+	err := db.WithinTx(TxOptions{ReadOnly: true}, func(tx *TX) error {
+		usage := tx.usageBucket()
+		if usage == nil {
+			return nil
+		}
+		return usage.ForEach(func(k []byte, v []byte) error {
+			record := decodeUsageRecord(v)
+			quotas = append(quotas, QuotaStatus{
+				Path:  cloneBytes(k),
+				Quota: record.quota,
+				Usage: record.usage,
+			})
+			return nil
+		})
+	})
+
+	// Done
+	return quotas, err
+}
+
+// RecomputeUsage walks the tracked buckets and replaces their tracked usage with the actual one. It fixes
+// usage tracked before upgrading or altered by writes made without the wrapper.
+func (db *DB) RecomputeUsage(ctx context.Context) error {
+	return db.WithinTx(TxOptions{}, func(tx *TX) error {
+		usage := tx.usageBucket()
+		if usage == nil {
+			return nil
+		}
+
+		var paths [][]byte
+
+		c := usage.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			paths = append(paths, cloneBytes(k))
+		}
+		for _, path := range paths {
+			err := ctx.Err()
+			if err != nil {
+				return err
+			}
+
+			record := decodeUsageRecord(usage.Get(path))
+			record.usage = countUsage(tx.rawBucket(splitBucketFragments(path)))
+			err = tx.writeMeta(metaUsageBucket, usage, path, record.encode())
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// -----------------------------------------------------------------------------
+
+// splitUserPath parses a bucket path given by the user, which cannot point to the metadata bucket.
+func splitUserPath(path []byte) ([][]byte, error) {
+	fragments, err := splitPath(path)
+	if err != nil {
+		return nil, err
+	}
+	if isReservedBucketName(fragments[0]) {
+		return nil, fmt.Errorf("%w: reserved bucket name", ErrInvalidPath)
+	}
+	return fragments, nil
+}
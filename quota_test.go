@@ -0,0 +1,156 @@
+// See the LICENSE file for license details.
+
+package boltdb_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/mxmauro/boltdb/v3"
+	"go.etcd.io/bbolt"
+)
+
+// -----------------------------------------------------------------------------
+
+func TestQuota(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	db, err := boltdb.New(filename)
+	if err != nil {
+		t.Fatalf("cannot create test database [err=%v]", err.Error())
+	}
+
+	err = db.SetQuota([]byte("logs"), boltdb.Quota{MaxBytes: 25, MaxKeySize: 4, MaxValueSize: 8})
+	if err != nil {
+		db.Close()
+		t.Fatalf("cannot set quota [err=%v]", err)
+	}
+
+	checks := []struct {
+		path  string
+		key   string
+		value string
+		fails bool
+	}{
+		{"logs", "k1", "12345678", false},
+		{"logs/app", "k2", "12345678", false},
+		{"logs", "key-3", "1", true},
+		{"logs", "k3", "123456789", true},
+		{"logs/app", "k3", "12345678", true},
+		{"other", "key-3", "123456789", false},
+	}
+	for idx, check := range checks {
+		err = db.Put([]byte(check.path), []byte(check.key), []byte(check.value))
+		if check.fails != errors.Is(err, boltdb.ErrQuotaExceeded) || (!check.fails && err != nil) {
+			db.Close()
+			t.Fatalf("unexpected result [check=%d err=%v]", idx, err)
+		}
+	}
+
+	// Nested bucket deletions release the quota.
+	err = db.WithinTx(boltdb.TxOptions{}, func(tx *boltdb.TX) error {
+		return tx.DeleteBucket([]byte("logs/app"))
+	})
+	if err != nil {
+		db.Close()
+		t.Fatalf("cannot delete bucket [err=%v]", err)
+	}
+	if err = db.Put([]byte("logs"), []byte("k3"), []byte("12345678")); err != nil {
+		db.Close()
+		t.Fatalf("cannot write to test database [err=%v]", err)
+	}
+
+	quotas, err := db.Quotas()
+	if err != nil || len(quotas) != 1 || string(quotas[0].Path) != "logs" || quotas[0].Usage.Keys != 2 ||
+		quotas[0].Usage.Bytes != 20 || quotas[0].Quota.MaxBytes != 25 {
+		db.Close()
+		t.Fatalf("unexpected quotas [quotas=%+v err=%v]", quotas, err)
+	}
+	db.Close()
+
+	// Writes made without the wrapper are not tracked until the usage is recomputed.
+	raw, err := bbolt.Open(filename, 0600, nil)
+	if err != nil {
+		t.Fatalf("cannot open database [err=%v]", err)
+	}
+	err = raw.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte("logs")).Delete([]byte("k1"))
+	})
+	_ = raw.Close()
+	if err != nil {
+		t.Fatalf("cannot modify database [err=%v]", err)
+	}
+
+	db, err = boltdb.New(filename)
+	if err != nil {
+		t.Fatalf("cannot open test database [err=%v]", err.Error())
+	}
+	defer db.Close()
+
+	if err = db.RecomputeUsage(context.Background()); err != nil {
+		t.Fatalf("cannot recompute usage [err=%v]", err)
+	}
+	usage, err := db.Usage([]byte("logs"))
+	if err != nil || usage.Keys != 1 || usage.Bytes != 10 {
+		t.Fatalf("unexpected usage [usage=%+v err=%v]", usage, err)
+	}
+	if usage, err = db.Usage([]byte("other")); err != nil || usage.Keys != 1 || usage.Bytes != 14 {
+		t.Fatalf("unexpected usage [usage=%+v err=%v]", usage, err)
+	}
+
+	if err = db.RemoveQuota([]byte("logs")); err != nil {
+		t.Fatalf("cannot remove quota [err=%v]", err)
+	}
+	if err = db.Put([]byte("logs"), []byte("k4"), []byte("123456789")); err != nil {
+		t.Fatalf("cannot write to test database [err=%v]", err)
+	}
+}
+
+func TestQuotaRejectedWriteLeavesNoTrace(t *testing.T) {
+	db, err := boltdb.NewWithOptions(filepath.Join(t.TempDir(), "test.db"), boltdb.Options{Audit: true})
+	if err != nil {
+		t.Fatalf("cannot create test database [err=%v]", err.Error())
+	}
+	defer db.Close()
+
+	if err = db.SetQuota([]byte("a"), boltdb.Quota{}); err != nil {
+		t.Fatalf("cannot set quota [err=%v]", err)
+	}
+	if err = db.SetQuota([]byte("a/b"), boltdb.Quota{MaxKeys: 1}); err != nil {
+		t.Fatalf("cannot set quota [err=%v]", err)
+	}
+	if err = db.Put([]byte("a/b"), []byte("k1"), []byte("v")); err != nil {
+		t.Fatalf("cannot write to test database [err=%v]", err)
+	}
+
+	// The caller ignores the rejected write and commits.
+	err = db.WithinTx(boltdb.TxOptions{}, func(tx *boltdb.TX) error {
+		b, err2 := tx.Bucket([]byte("a/b"))
+		if err2 != nil {
+			return err2
+		}
+		if err2 = b.Put([]byte("k2"), []byte("v")); !errors.Is(err2, boltdb.ErrQuotaExceeded) {
+			t.Errorf("expected quota exceeded [got=%v]", err2)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("cannot commit transaction [err=%v]", err)
+	}
+
+	usage, err := db.Usage([]byte("a"))
+	if err != nil || usage.Keys != 1 {
+		t.Fatalf("unexpected usage [usage=%+v err=%v]", usage, err)
+	}
+	entries := 0
+	err = db.QueryAudit(boltdb.AuditQuery{Path: []byte("a/b")}, func(entry boltdb.AuditEntry) (bool, error) {
+		if entry.Operation == boltdb.AuditPut {
+			entries += 1
+		}
+		return false, nil
+	})
+	if err != nil || entries != 1 {
+		t.Fatalf("unexpected audit entries [entries=%d err=%v]", entries, err)
+	}
+}
@@ -0,0 +1,635 @@
+// See the LICENSE file for license details.
+
+package boltdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+	bolterrors "go.etcd.io/bbolt/errors"
+)
+
+// The replication protocol exchanges JSON messages. The follower starts by sending a "hello" message
+// with the sequence number of the last change it applied. Then the primary repeatedly sends either a
+// batch of "change" messages or, if the follower was never bootstrapped or the change log no longer
+// holds the changes it needs, a "snapshot" message followed by the "snapshot-bucket" and "snapshot-key"
+// records of its contents. Every batch ends with an "end" message and the follower answers with an "ack"
+// message once the batch is committed.
+
+// -----------------------------------------------------------------------------
+
+const (
+	replicationHello          = "hello"
+	replicationChange         = "change"
+	replicationSnapshot       = "snapshot"
+	replicationSnapshotBucket = "snapshot-bucket"
+	replicationSnapshotKey    = "snapshot-key"
+	replicationEnd            = "end"
+	replicationAck            = "ack"
+
+	defaultReplicationBatchSize    = 1000
+	defaultReplicationPollInterval = 100 * time.Millisecond
+)
+
+var metaReplicationSeqKey = []byte("replication_seq")
+
+// Replicator ships the change log of a primary database to a follower.
+type Replicator struct {
+	db   *DB
+	opts ReplicatorOptions
+	mtx  sync.Mutex
+	lag  ReplicationLag
+}
+
+// ReplicatorOptions specifies a set of options when creating a replicator.
+type ReplicatorOptions struct {
+	// BatchSize sets the maximum number of changes sent per batch. Defaults to 1000.
+	BatchSize int
+
+	// PollInterval sets how often the change log is checked once the follower is up to date. Defaults
+	// to 100 milliseconds.
+	PollInterval time.Duration
+}
+
+// Follower applies the changes shipped by a replicator to a standby database. The standby database
+// must not be modified by other means and must use the same encryption settings as the primary.
+//
+// Along with the user buckets, the follower receives the access control policy, which is enabled as
+// soon as it is applied, the schema version and migration checkpoint, the version clock and the tracked
+// usage and quotas. The audit log and the history are not replicated, and the change log of the
+// follower only records its own writes, so after promoting it, its followers are bootstrapped from a
+// snapshot.
+type Follower struct {
+	db  *DB
+	mtx sync.Mutex
+	lag ReplicationLag
+}
+
+// ReplicationLag describes how far a follower is behind the primary.
+type ReplicationLag struct {
+	// Applied is the sequence number of the last change applied by the follower.
+	Applied uint64
+
+	// Head is the sequence number of the last change recorded by the primary, as last reported.
+	Head uint64
+
+	// Entries is the number of changes not applied yet.
+	Entries uint64
+
+	// Time is the time elapsed between the last applied change and the last recorded one.
+	Time time.Duration
+}
+
+type replicationMessage struct {
+	Type         string       `json:"type"`
+	Seq          uint64       `json:"seq,omitempty"`
+	Bootstrapped bool         `json:"bootstrapped,omitempty"`
+	Head         uint64       `json:"head,omitempty"`
+	HeadTime     time.Time    `json:"head_time"`
+	Change       *ChangeEntry `json:"change,omitempty"`
+	Path         [][]byte     `json:"path,omitempty"`
+	Key          []byte       `json:"key,omitempty"`
+	Value        []byte       `json:"value,omitempty"`
+	Sequence     uint64       `json:"bucket_seq,omitempty"`
+}
+
+// -----------------------------------------------------------------------------
+
+// NewReplicator creates a replicator for a database opened with the change log enabled.
+func NewReplicator(db *DB, opts ReplicatorOptions) (*Replicator, error) {
+	if db.changeLog == nil {
+		return nil, fmt.Errorf("%w: change log is not enabled", ErrReplicationProtocol)
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultReplicationBatchSize
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = defaultReplicationPollInterval
+	}
+
+	// Done
+	return &Replicator{
+		db:   db,
+		opts: opts,
+	}, nil
+}
+
+// Serve streams the changes to the follower connected through the given stream until the context is
+// done or the stream fails.
+func (r *Replicator) Serve(ctx context.Context, rw io.ReadWriter) error {
+	enc := json.NewEncoder(rw)
+	dec := json.NewDecoder(rw)
+
+	var hello replicationMessage
+	err := dec.Decode(&hello)
+	if err != nil {
+		return err
+	}
+	if hello.Type != replicationHello {
+		return fmt.Errorf("%w: unexpected %q message", ErrReplicationProtocol, hello.Type)
+	}
+	applied, bootstrapped := hello.Seq, hello.Bootstrapped
+
+	for {
+		var changes []ChangeEntry
+		var head uint64
+		var headTime time.Time
+		var snapshot bool
+
+		err = ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		// Send a snapshot if the follower cannot catch up using the change log, else the pending changes.
+		err = r.db.WithinTx(TxOptions{ReadOnly: true}, func(tx *TX) error {
+			var first uint64
+
+			head, first = tx.changeLogBounds()
+			headTime = tx.changeLogHeadTime()
+			if !bootstrapped || applied > head || (first > 0 && applied+1 < first) {
+				snapshot = true
+				return sendSnapshot(tx, enc, head)
+			}
+			return tx.changesAfter(applied, func(entry ChangeEntry) (bool, error) {
+				changes = append(changes, entry)
+				return len(changes) >= r.opts.BatchSize, nil
+			})
+		})
+		if err != nil {
+			return err
+		}
+		for idx := range changes {
+			err = enc.Encode(replicationMessage{
+				Type:   replicationChange,
+				Change: &changes[idx],
+			})
+			if err != nil {
+				return err
+			}
+		}
+		err = enc.Encode(replicationMessage{
+			Type:     replicationEnd,
+			Head:     head,
+			HeadTime: headTime,
+		})
+		if err != nil {
+			return err
+		}
+
+		// Wait for the follower to commit the batch.
+		var ack replicationMessage
+		err = dec.Decode(&ack)
+		if err != nil {
+			return err
+		}
+		if ack.Type != replicationAck {
+			return fmt.Errorf("%w: unexpected %q message", ErrReplicationProtocol, ack.Type)
+		}
+		applied, bootstrapped = ack.Seq, true
+		r.setLag(applied, head, 0)
+
+		// Wait for new changes if the follower is up to date.
+		if !snapshot && len(changes) == 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(r.opts.PollInterval):
+			}
+		}
+	}
+}
+
+// Lag returns how far the follower is behind, as known by the last acknowledgment received.
+func (r *Replicator) Lag() ReplicationLag {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.lag
+}
+
+// NewFollower creates a follower that applies the changes to the given standby database.
+func NewFollower(db *DB) *Follower {
+	return &Follower{
+		db: db,
+	}
+}
+
+// Run receives changes through the given stream and applies them until the context is done or the stream
+// fails. Changes already applied are skipped, so the follower can reconnect at any time.
+// NOTE: A blocked read is not interrupted when the context is done, close the stream to stop the follower.
+func (f *Follower) Run(ctx context.Context, rw io.ReadWriter) error {
+	var tx *TX
+	var appliedTime time.Time
+	var reloadACL bool
+
+	enc := json.NewEncoder(rw)
+	dec := json.NewDecoder(rw)
+	defer func() {
+		if tx != nil {
+			tx.Rollback()
+		}
+	}()
+
+	applied, bootstrapped, err := f.appliedSequence()
+	if err != nil {
+		return err
+	}
+	f.setLag(applied, f.Lag().Head, 0)
+	err = enc.Encode(replicationMessage{
+		Type:         replicationHello,
+		Seq:          applied,
+		Bootstrapped: bootstrapped,
+	})
+	if err != nil {
+		return err
+	}
+
+	for {
+		var msg replicationMessage
+
+		err = ctx.Err()
+		if err != nil {
+			return err
+		}
+		err = dec.Decode(&msg)
+		if err != nil {
+			return err
+		}
+		if tx == nil {
+			tx, err = f.db.BeginTx(TxOptions{})
+			if err != nil {
+				return err
+			}
+		}
+
+		switch msg.Type {
+		case replicationChange:
+			if msg.Change == nil {
+				return fmt.Errorf("%w: empty change", ErrReplicationProtocol)
+			}
+			if msg.Change.Sequence <= applied {
+				continue
+			}
+			err = tx.applyChange(msg.Change)
+			applied, appliedTime = msg.Change.Sequence, msg.Change.Time
+			reloadACL = reloadACL || isACLPolicyChange(msg.Change)
+
+		case replicationSnapshot:
+			err = tx.dropUserBuckets()
+			if err == nil {
+				err = tx.dropReplicatedMeta()
+			}
+			applied, appliedTime = msg.Seq, time.Time{}
+			reloadACL = true
+
+		case replicationSnapshotBucket:
+			var b *bbolt.Bucket
+
+			b, err = tx.createRawBucket(msg.Path)
+			if err == nil {
+				err = b.SetSequence(msg.Sequence)
+			}
+
+		case replicationSnapshotKey:
+			var b *bbolt.Bucket
+
+			if len(msg.Path) > 0 && isReservedBucketName(msg.Path[0]) {
+				b, err = tx.replicatedMetaBucket(msg.Path, msg.Key)
+				if err == nil && b == nil {
+					err = fmt.Errorf("%w: invalid metadata key", ErrReplicationProtocol)
+				}
+			} else {
+				b, err = tx.createRawBucket(msg.Path)
+			}
+			if err == nil {
+				err = b.Put(msg.Key, msg.Value)
+			}
+
+		case replicationEnd:
+			var meta *bbolt.Bucket
+
+			meta, err = tx.metaBucket()
+			if err == nil {
+				err = meta.Put(metaReplicationSeqKey, EncodeUint64(applied))
+			}
+			if err == nil {
+				err = tx.Commit()
+			}
+			tx = nil
+			if err == nil && reloadACL {
+				err = f.db.loadACLPolicy()
+				reloadACL = false
+			}
+			if err != nil {
+				return err
+			}
+
+			var lagTime time.Duration
+			if msg.Head > applied && !appliedTime.IsZero() {
+				lagTime = msg.HeadTime.Sub(appliedTime)
+			}
+			f.setLag(applied, msg.Head, lagTime)
+			err = enc.Encode(replicationMessage{
+				Type: replicationAck,
+				Seq:  applied,
+			})
+
+		default:
+			err = fmt.Errorf("%w: unexpected %q message", ErrReplicationProtocol, msg.Type)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Lag returns how far the follower is behind, as known by the last batch received.
+func (f *Follower) Lag() ReplicationLag {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.lag
+}
+
+// -----------------------------------------------------------------------------
+
+func (r *Replicator) setLag(applied uint64, head uint64, lagTime time.Duration) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.lag = newReplicationLag(applied, head, lagTime)
+}
+
+func (f *Follower) setLag(applied uint64, head uint64, lagTime time.Duration) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.lag = newReplicationLag(applied, head, lagTime)
+}
+
+func newReplicationLag(applied uint64, head uint64, lagTime time.Duration) ReplicationLag {
+	lag := ReplicationLag{
+		Applied: applied,
+		Head:    head,
+		Time:    lagTime,
+	}
+	if head > applied {
+		lag.Entries = head - applied
+	}
+	return lag
+}
+
+// appliedSequence returns the sequence number of the last applied change and if the follower was
+// bootstrapped.
+func (f *Follower) appliedSequence() (uint64, bool, error) {
+	var applied uint64
+	var bootstrapped bool
+
+	err := f.db.WithinTx(TxOptions{ReadOnly: true}, func(tx *TX) error {
+		meta, err := tx.metaBucket()
+		if err != nil || meta == nil {
+			return err
+		}
+		if stored := meta.Get(metaReplicationSeqKey); stored != nil {
+			applied, bootstrapped = DecodeUint64(stored), true
+		}
+		return nil
+	})
+
+	// Done
+	return applied, bootstrapped, err
+}
+
+// changeLogHeadTime returns the time of the last change recorded in the change log.
+func (tx *TX) changeLogHeadTime() time.Time {
+	var entry ChangeEntry
+
+	changeLog := tx.changeLogBucket()
+	if changeLog == nil {
+		return time.Time{}
+	}
+	if _, v := changeLog.Cursor().Last(); v != nil {
+		_ = json.Unmarshal(v, &entry) // Intentionally ignored: the time is only used to report the lag.
+	}
+	return entry.Time
+}
+
+// sendSnapshot sends the contents of the database, as seen by the transaction, to a follower.
+func sendSnapshot(tx *TX, enc *json.Encoder, head uint64) error {
+	var walk func(path [][]byte, b *bbolt.Bucket) error
+
+	walk = func(path [][]byte, b *bbolt.Bucket) error {
+		err := enc.Encode(replicationMessage{
+			Type:     replicationSnapshotBucket,
+			Path:     path,
+			Sequence: b.Sequence(),
+		})
+		if err != nil {
+			return err
+		}
+
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if v == nil {
+				err = walk(append(path[:len(path):len(path)], k), b.Bucket(k))
+			} else {
+				err = enc.Encode(replicationMessage{
+					Type:  replicationSnapshotKey,
+					Path:  path,
+					Key:   k,
+					Value: v,
+				})
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	err := enc.Encode(replicationMessage{
+		Type: replicationSnapshot,
+		Seq:  head,
+	})
+	if err != nil {
+		return err
+	}
+	c := tx.tx.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		if isReservedBucketName(k) {
+			continue
+		}
+		err = walk([][]byte{k}, tx.tx.Bucket(k))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Send the replicated metadata.
+	meta := tx.tx.Bucket(metaBucketName)
+	if meta == nil {
+		return nil
+	}
+	for _, key := range replicatedMetaKeys {
+		if v := meta.Get(key); v != nil {
+			err = enc.Encode(replicationMessage{
+				Type:  replicationSnapshotKey,
+				Path:  [][]byte{metaBucketName},
+				Key:   key,
+				Value: v,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if usage := meta.Bucket(metaUsageBucket); usage != nil {
+		c = usage.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			err = enc.Encode(replicationMessage{
+				Type:  replicationSnapshotKey,
+				Path:  [][]byte{metaBucketName, metaUsageBucket},
+				Key:   k,
+				Value: v,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Done
+	return nil
+}
+
+// applyChange applies a change received from the primary. Applying a change twice has no effect.
+func (tx *TX) applyChange(entry *ChangeEntry) error {
+	if len(entry.Path) == 0 {
+		return fmt.Errorf("%w: invalid change path", ErrReplicationProtocol)
+	}
+	if isReservedBucketName(entry.Path[0]) {
+		return tx.applyMetaChange(entry)
+	}
+
+	switch entry.Operation {
+	case ChangePut:
+		b, err := tx.createRawBucket(entry.Path)
+		if err != nil {
+			return err
+		}
+		return b.Put(entry.Key, entry.Value)
+
+	case ChangeDelete:
+		if b := tx.rawBucket(entry.Path); b != nil {
+			return b.Delete(entry.Key)
+		}
+		return nil
+
+	case ChangeCreateBucket:
+		_, err := tx.createRawBucket(entry.Path)
+		return err
+
+	case ChangeDeleteBucket:
+		var err error
+
+		last := len(entry.Path) - 1
+		if last == 0 {
+			err = tx.tx.DeleteBucket(entry.Path[0])
+		} else if parent := tx.rawBucket(entry.Path[:last]); parent != nil {
+			err = parent.DeleteBucket(entry.Path[last])
+		}
+		if err != nil && errors.Is(err, bolterrors.ErrBucketNotFound) {
+			return nil // Already deleted.
+		}
+		return err
+
+	case ChangeSetSequence:
+		b, err := tx.createRawBucket(entry.Path)
+		if err != nil {
+			return err
+		}
+		return b.SetSequence(entry.BucketSequence)
+	}
+
+	// Done
+	return fmt.Errorf("%w: unknown %q operation", ErrReplicationProtocol, entry.Operation)
+}
+
+// applyMetaChange applies a change to the metadata replicated from the primary.
+func (tx *TX) applyMetaChange(entry *ChangeEntry) error {
+	if entry.Operation != ChangePut && entry.Operation != ChangeDelete {
+		return fmt.Errorf("%w: invalid change path", ErrReplicationProtocol)
+	}
+	b, err := tx.replicatedMetaBucket(entry.Path, entry.Key)
+	if err != nil {
+		return err
+	}
+	if b == nil {
+		return fmt.Errorf("%w: invalid change path", ErrReplicationProtocol)
+	}
+	if entry.Operation == ChangeDelete {
+		return b.Delete(entry.Key)
+	}
+	return b.Put(entry.Key, entry.Value)
+}
+
+// isACLPolicyChange returns true if the change modifies the access control policy.
+func isACLPolicyChange(entry *ChangeEntry) bool {
+	return len(entry.Path) == 1 && isReservedBucketName(entry.Path[0]) && bytes.Equal(entry.Key, metaACLPolicyKey)
+}
+
+// createRawBucket returns the bbolt bucket located at the given path fragments, creating it if needed.
+func (tx *TX) createRawBucket(fragments [][]byte) (*bbolt.Bucket, error) {
+	if len(fragments) == 0 || isReservedBucketName(fragments[0]) {
+		return nil, fmt.Errorf("%w: invalid bucket path", ErrReplicationProtocol)
+	}
+	b, err := tx.tx.CreateBucketIfNotExists(fragments[0])
+	for idx := 1; err == nil && idx < len(fragments); idx++ {
+		b, err = b.CreateBucketIfNotExists(fragments[idx])
+	}
+	return b, err
+}
+
+// dropUserBuckets deletes all the top-level buckets but the metadata one.
+func (tx *TX) dropUserBuckets() error {
+	var names [][]byte
+
+	c := tx.tx.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		if !isReservedBucketName(k) {
+			names = append(names, cloneBytes(k))
+		}
+	}
+	for _, name := range names {
+		err := tx.tx.DeleteBucket(name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropReplicatedMeta deletes the metadata replicated from the primary.
+func (tx *TX) dropReplicatedMeta() error {
+	meta := tx.tx.Bucket(metaBucketName)
+	if meta == nil {
+		return nil
+	}
+	for _, key := range replicatedMetaKeys {
+		err := meta.Delete(key)
+		if err != nil {
+			return err
+		}
+	}
+	err := meta.DeleteBucket(metaUsageBucket)
+	if err != nil && !errors.Is(err, bolterrors.ErrBucketNotFound) {
+		return err
+	}
+
+	// Done
+	return nil
+}
@@ -0,0 +1,303 @@
+// See the LICENSE file for license details.
+
+package boltdb_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mxmauro/boltdb/v3"
+)
+
+// -----------------------------------------------------------------------------
+
+func TestReplication(t *testing.T) {
+	dir := t.TempDir()
+	primary, err := boltdb.NewWithOptions(filepath.Join(dir, "primary.db"), boltdb.Options{
+		ChangeLog: &boltdb.ChangeLogOptions{MaxEntries: 5},
+	})
+	if err != nil {
+		t.Fatalf("cannot create primary database [err=%v]", err.Error())
+	}
+	defer primary.Close()
+	standby, err := boltdb.New(filepath.Join(dir, "standby.db"))
+	if err != nil {
+		t.Fatalf("cannot create standby database [err=%v]", err.Error())
+	}
+	defer standby.Close()
+
+	replicator, err := boltdb.NewReplicator(primary, boltdb.ReplicatorOptions{PollInterval: 5 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("cannot create replicator [err=%v]", err)
+	}
+	follower := boltdb.NewFollower(standby)
+
+	// connect links the follower with the primary and returns a function to disconnect them.
+	connect := func() func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		primaryConn, standbyConn := net.Pipe()
+		done := make(chan struct{}, 2)
+		go func() {
+			_ = replicator.Serve(ctx, primaryConn)
+			done <- struct{}{}
+		}()
+		go func() {
+			_ = follower.Run(ctx, standbyConn)
+			done <- struct{}{}
+		}()
+		return func() {
+			cancel()
+			_ = primaryConn.Close()
+			_ = standbyConn.Close()
+			<-done
+			<-done
+		}
+	}
+	waitForSync := func() {
+		head, err := primary.ChangeLogHead()
+		if err != nil {
+			t.Fatalf("cannot read change log head [err=%v]", err)
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			lag := follower.Lag()
+			if lag.Applied == head && lag.Entries == 0 && replicator.Lag().Applied == head {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("follower did not catch up [head=%d lag=%+v]", head, lag)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	checkValue := func(bucket string, key string, expected string) {
+		value, err := standby.Get([]byte(bucket), []byte(key))
+		if err != nil || (expected == "" && value != nil) || (expected != "" && string(value) != expected) {
+			t.Fatalf("unexpected standby value [bucket=%s key=%s value=%q err=%v]", bucket, key, value, err)
+		}
+	}
+
+	// Data written before the follower connects is bootstrapped from a snapshot.
+	if err = primary.Put([]byte("users/eu"), []byte("alice"), []byte("1")); err != nil {
+		t.Fatalf("cannot write to primary database [err=%v]", err)
+	}
+	disconnect := connect()
+	waitForSync()
+	checkValue("users/eu", "alice", "1")
+
+	// Later changes are streamed.
+	err = primary.WithinTx(boltdb.TxOptions{}, func(tx *boltdb.TX) error {
+		b, err := tx.Bucket([]byte("users/eu"))
+		if err == nil {
+			err = b.Put([]byte("bob"), []byte("2"))
+		}
+		if err == nil {
+			err = b.Delete([]byte("alice"))
+		}
+		if err == nil {
+			_, err = b.NextSequence()
+		}
+		if err == nil {
+			_, err = tx.Bucket([]byte("tmp"))
+		}
+		if err == nil {
+			err = tx.DeleteBucket([]byte("tmp"))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("cannot write to primary database [err=%v]", err)
+	}
+	waitForSync()
+	checkValue("users/eu", "bob", "2")
+	checkValue("users/eu", "alice", "")
+	err = standby.WithinTx(boltdb.TxOptions{ReadOnly: true}, func(tx *boltdb.TX) error {
+		b, err := tx.Bucket([]byte("users/eu"))
+		if err == nil && b.Sequence() != 1 {
+			err = fmt.Errorf("unexpected sequence %d", b.Sequence())
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("unexpected standby bucket [err=%v]", err)
+	}
+	disconnect()
+
+	// A follower falling behind the retained change log is bootstrapped again.
+	for idx := 0; idx < 10; idx++ {
+		if err = primary.Put([]byte("events"), []byte(fmt.Sprintf("%02d", idx)), []byte("x")); err != nil {
+			t.Fatalf("cannot write to primary database [err=%v]", err)
+		}
+	}
+	if lag := follower.Lag(); lag.Applied == 0 {
+		t.Fatalf("unexpected lag [lag=%+v]", lag)
+	}
+	disconnect = connect()
+	defer disconnect()
+	waitForSync()
+	checkValue("events", "09", "x")
+	checkValue("users/eu", "bob", "2")
+}
+
+func TestReplicationMetadata(t *testing.T) {
+	dir := t.TempDir()
+	buckets := []boltdb.BucketOptions{
+		{Path: "docs", Versioned: true},
+	}
+	primary, err := boltdb.NewWithOptions(filepath.Join(dir, "primary.db"), boltdb.Options{
+		Buckets:   buckets,
+		ChangeLog: &boltdb.ChangeLogOptions{},
+	})
+	if err != nil {
+		t.Fatalf("cannot create primary database [err=%v]", err)
+	}
+	defer primary.Close()
+	standby, err := boltdb.NewWithOptions(filepath.Join(dir, "standby.db"), boltdb.Options{
+		Buckets: buckets,
+	})
+	if err != nil {
+		t.Fatalf("cannot create standby database [err=%v]", err)
+	}
+	defer standby.Close()
+
+	replicator, err := boltdb.NewReplicator(primary, boltdb.ReplicatorOptions{PollInterval: 5 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("cannot create replicator [err=%v]", err)
+	}
+	follower := boltdb.NewFollower(standby)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	primaryConn, standbyConn := net.Pipe()
+	done := make(chan struct{}, 2)
+	defer func() {
+		cancel()
+		_ = primaryConn.Close()
+		_ = standbyConn.Close()
+		<-done
+		<-done
+	}()
+
+	waitForSync := func() {
+		head, err := primary.ChangeLogHead()
+		if err != nil {
+			t.Fatalf("cannot read change log head [err=%v]", err)
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for replicator.Lag().Applied != head {
+			if time.Now().After(deadline) {
+				t.Fatalf("follower did not catch up [head=%d lag=%+v]", head, follower.Lag())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	migrate := func(version uint64) {
+		_, err := primary.Migrate([]boltdb.Migration{
+			{Version: version, Up: func(tx *boltdb.TX) error { return nil }},
+		}, boltdb.MigrateOptions{})
+		if err != nil {
+			t.Fatalf("cannot migrate primary database [err=%v]", err)
+		}
+	}
+	// checkMetadata verifies the standby has the same metadata as the primary.
+	checkMetadata := func(schemaVersion uint64) {
+		version, err := standby.SchemaVersion()
+		if err != nil || version != schemaVersion {
+			t.Fatalf("unexpected standby schema version [version=%d err=%v]", version, err)
+		}
+		if !reflect.DeepEqual(standby.ACLPolicy(), primary.ACLPolicy()) {
+			t.Fatalf("unexpected standby access control policy [policy=%+v]", standby.ACLPolicy())
+		}
+		expected, err := primary.Quotas()
+		if err != nil {
+			t.Fatalf("cannot read primary quotas [err=%v]", err)
+		}
+		quotas, err := standby.Quotas()
+		if err != nil || !reflect.DeepEqual(quotas, expected) {
+			t.Fatalf("unexpected standby quotas [quotas=%+v err=%v]", quotas, err)
+		}
+	}
+
+	// Metadata set before the follower connects is bootstrapped from a snapshot.
+	err = primary.SaveACLPolicy(&boltdb.ACLPolicy{
+		Rules: []boltdb.ACLRule{
+			{Principal: boltdb.AnyPrincipal, Path: "docs", Permission: boltdb.PermissionAdmin},
+		},
+	})
+	if err != nil {
+		t.Fatalf("cannot save access control policy [err=%v]", err)
+	}
+	if err = primary.SetQuota([]byte("docs"), boltdb.Quota{MaxKeys: 10}); err != nil {
+		t.Fatalf("cannot set quota [err=%v]", err)
+	}
+	migrate(1)
+	if err = primary.Put([]byte("docs"), []byte("a"), []byte("1")); err != nil {
+		t.Fatalf("cannot write to primary database [err=%v]", err)
+	}
+	go func() {
+		_ = replicator.Serve(ctx, primaryConn)
+		done <- struct{}{}
+	}()
+	go func() {
+		_ = follower.Run(ctx, standbyConn)
+		done <- struct{}{}
+	}()
+	waitForSync()
+	checkMetadata(1)
+
+	// Later changes are streamed.
+	var deletedVersion boltdb.Version
+	err = primary.WithinTx(boltdb.TxOptions{}, func(tx *boltdb.TX) error {
+		b, err := tx.Bucket([]byte("docs"))
+		if err == nil {
+			err = b.Put([]byte("b"), []byte("2"))
+		}
+		if err == nil {
+			_, deletedVersion, err = b.GetVersioned([]byte("b"))
+		}
+		if err == nil {
+			err = b.Delete([]byte("b"))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("cannot write to primary database [err=%v]", err)
+	}
+	if err = primary.SetQuota([]byte("docs"), boltdb.Quota{MaxKeys: 20}); err != nil {
+		t.Fatalf("cannot set quota [err=%v]", err)
+	}
+	migrate(2)
+	if err = primary.SaveACLPolicy(nil); err != nil {
+		t.Fatalf("cannot remove access control policy [err=%v]", err)
+	}
+	waitForSync()
+	checkMetadata(2)
+
+	// A key created again on the standby never reuses a version it had on the primary.
+	err = standby.WithinTx(boltdb.TxOptions{}, func(tx *boltdb.TX) error {
+		b, err := tx.Bucket([]byte("docs"))
+		if err == nil {
+			err = b.Put([]byte("b"), []byte("3"))
+		}
+		if err == nil {
+			var version boltdb.Version
+
+			_, version, err = b.GetVersioned([]byte("b"))
+			if err == nil && version <= deletedVersion {
+				err = fmt.Errorf("version %d was already used", version)
+			}
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("unexpected standby version [err=%v]", err)
+	}
+	report, err := standby.Check(context.Background())
+	if err != nil || !report.OK() {
+		t.Fatalf("unexpected standby check result [report=%+v err=%v]", report, err)
+	}
+}
@@ -0,0 +1,322 @@
+// See the LICENSE file for license details.
+
+package boltdb
+
+import (
+	"errors"
+	"fmt"
+
+	"go.etcd.io/bbolt"
+)
+
+// -----------------------------------------------------------------------------
+
+// Savepoint marks a position within a writable transaction that changes can be rolled back to.
+type Savepoint struct {
+	id  uint64
+	pos int
+}
+
+type journalKind int
+
+const (
+	journalKey journalKind = iota + 1
+	journalSequence
+	journalBucketCreated
+	journalBucketDeleted
+)
+
+// journalEntry records how to undo a change made while savepoints are active. Buckets are referenced by
+// their path fragments because bbolt bucket objects are not valid after the bucket is deleted.
+type journalEntry struct {
+	kind      journalKind
+	fragments [][]byte
+	key       []byte
+	value     []byte
+	sequence  uint64
+	snapshot  *bucketSnapshot
+}
+
+// bucketSnapshot holds the raw contents of a deleted bucket.
+type bucketSnapshot struct {
+	sequence uint64
+	entries  []bucketSnapshotEntry
+}
+
+type bucketSnapshotEntry struct {
+	key    []byte
+	value  []byte
+	bucket *bucketSnapshot
+}
+
+// -----------------------------------------------------------------------------
+
+// Savepoint creates a savepoint within the transaction. Changes made afterwards through the wrapper's
+// buckets and iterators can be undone with RollbackTo without discarding the whole transaction.
+func (tx *TX) Savepoint() (Savepoint, error) {
+	if tx.readOnly {
+		return Savepoint{}, ErrTxNotWritable
+	}
+
+	tx.nextSavepointID += 1
+	sp := Savepoint{
+		id:  tx.nextSavepointID,
+		pos: len(tx.journal),
+	}
+	tx.savepoints = append(tx.savepoints, sp.id)
+
+	// Done
+	return sp, nil
+}
+
+// RollbackTo undoes the changes made since the savepoint was created. The savepoint remains valid, but the
+// ones created after it are released.
+func (tx *TX) RollbackTo(sp Savepoint) error {
+	idx := tx.savepointIndex(sp)
+	if idx < 0 {
+		return ErrInvalidSavepoint
+	}
+
+	for pos := len(tx.journal) - 1; pos >= sp.pos; pos-- {
+		err := tx.undo(&tx.journal[pos])
+		if err != nil {
+			return fmt.Errorf("cannot roll back to savepoint: %w", err)
+		}
+		tx.journal = tx.journal[:pos]
+	}
+	tx.savepoints = tx.savepoints[:idx+1]
+
+	// Done
+	return nil
+}
+
+// Release discards the savepoint, and the ones created after it, keeping the changes.
+func (tx *TX) Release(sp Savepoint) error {
+	idx := tx.savepointIndex(sp)
+	if idx < 0 {
+		return ErrInvalidSavepoint
+	}
+
+	tx.savepoints = tx.savepoints[:idx]
+	if len(tx.savepoints) == 0 {
+		tx.journal = nil
+	}
+
+	// Done
+	return nil
+}
+
+// Nested runs the callback within a savepoint. If the callback fails, its changes are rolled back and the
+// error is returned, leaving the rest of the transaction intact.
+func (tx *TX) Nested(cb WithinTxCallback) error {
+	sp, err := tx.Savepoint()
+	if err != nil {
+		return err
+	}
+
+	err = cb(tx)
+	if err != nil {
+		rollbackErr := tx.RollbackTo(sp)
+		if rollbackErr != nil {
+			return errors.Join(err, rollbackErr)
+		}
+	}
+	_ = tx.Release(sp) // Intentionally ignored: the savepoint is still valid at this point.
+
+	// Done
+	return err
+}
+
+// -----------------------------------------------------------------------------
+
+func (tx *TX) savepointIndex(sp Savepoint) int {
+	for idx, id := range tx.savepoints {
+		if id == sp.id {
+			return idx
+		}
+	}
+	return -1
+}
+
+func (tx *TX) journaling() bool {
+	return len(tx.savepoints) > 0
+}
+
+// journalKey records the current raw value of a key before it is modified.
+func (tx *TX) journalKey(bucket *Bucket, rawKey []byte) {
+	if !tx.journaling() {
+		return
+	}
+	tx.journalRawKey(bucket.fragments(), bucket.b, rawKey)
+}
+
+// journalRawKey acts like journalKey for buckets not opened through the wrapper, like internal ones.
+func (tx *TX) journalRawKey(fragments [][]byte, b *bbolt.Bucket, rawKey []byte) {
+	if !tx.journaling() {
+		return
+	}
+	tx.journal = append(tx.journal, journalEntry{
+		kind:      journalKey,
+		fragments: cloneFragments(fragments),
+		key:       cloneBytes(rawKey),
+		value:     cloneBytes(b.Get(rawKey)),
+	})
+}
+
+// journalSequence records the current sequence of a bucket before it is modified.
+func (tx *TX) journalSequence(bucket *Bucket) {
+	if !tx.journaling() {
+		return
+	}
+	tx.journal = append(tx.journal, journalEntry{
+		kind:      journalSequence,
+		fragments: bucket.fragments(),
+		sequence:  bucket.b.Sequence(),
+	})
+}
+
+// journalBucketCreated records that a bucket did not exist before.
+func (tx *TX) journalBucketCreated(fragments [][]byte) {
+	if !tx.journaling() {
+		return
+	}
+	tx.journal = append(tx.journal, journalEntry{
+		kind:      journalBucketCreated,
+		fragments: cloneFragments(fragments),
+	})
+}
+
+// journalBucketDeleted records the contents of a bucket before it is deleted.
+func (tx *TX) journalBucketDeleted(fragments [][]byte, b *bbolt.Bucket) {
+	if !tx.journaling() || b == nil {
+		return
+	}
+	tx.journal = append(tx.journal, journalEntry{
+		kind:      journalBucketDeleted,
+		fragments: cloneFragments(fragments),
+		snapshot:  takeBucketSnapshot(b),
+	})
+}
+
+func (tx *TX) undo(entry *journalEntry) error {
+	switch entry.kind {
+	case journalKey:
+		b := tx.rawBucket(entry.fragments)
+		if b == nil {
+			return ErrBucketNotFound
+		}
+		if entry.value == nil {
+			return b.Delete(entry.key)
+		}
+		return b.Put(entry.key, entry.value)
+
+	case journalSequence:
+		b := tx.rawBucket(entry.fragments)
+		if b == nil {
+			return ErrBucketNotFound
+		}
+		return b.SetSequence(entry.sequence)
+
+	case journalBucketCreated:
+		last := len(entry.fragments) - 1
+		if last == 0 {
+			return tx.tx.DeleteBucket(entry.fragments[0])
+		}
+		parent := tx.rawBucket(entry.fragments[:last])
+		if parent == nil {
+			return ErrBucketNotFound
+		}
+		return parent.DeleteBucket(entry.fragments[last])
+
+	case journalBucketDeleted:
+		var b *bbolt.Bucket
+		var err error
+
+		last := len(entry.fragments) - 1
+		if last == 0 {
+			b, err = tx.tx.CreateBucket(entry.fragments[0])
+		} else {
+			parent := tx.rawBucket(entry.fragments[:last])
+			if parent == nil {
+				return ErrBucketNotFound
+			}
+			b, err = parent.CreateBucket(entry.fragments[last])
+		}
+		if err != nil {
+			return err
+		}
+		return entry.snapshot.restore(b)
+	}
+
+	// Done
+	return nil
+}
+
+// rawBucket returns the bbolt bucket located at the given path fragments or nil if it does not exist.
+func (tx *TX) rawBucket(fragments [][]byte) *bbolt.Bucket {
+	b := tx.tx.Bucket(fragments[0])
+	for idx := 1; b != nil && idx < len(fragments); idx++ {
+		b = b.Bucket(fragments[idx])
+	}
+	return b
+}
+
+// fragments returns the path fragments of the bucket.
+func (bucket *Bucket) fragments() [][]byte {
+	return splitBucketFragments(bucket.path)
+}
+
+// splitBucketFragments splits an already validated path into a copy of its fragments.
+func splitBucketFragments(path []byte) [][]byte {
+	fragments, _ := splitPath(path) // Intentionally ignored: the path was validated when parsed.
+	return cloneFragments(fragments)
+}
+
+func takeBucketSnapshot(b *bbolt.Bucket) *bucketSnapshot {
+	snapshot := &bucketSnapshot{
+		sequence: b.Sequence(),
+	}
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		entry := bucketSnapshotEntry{
+			key: cloneBytes(k),
+		}
+		if v != nil {
+			entry.value = cloneBytes(v)
+		} else {
+			entry.bucket = takeBucketSnapshot(b.Bucket(k))
+		}
+		snapshot.entries = append(snapshot.entries, entry)
+	}
+	return snapshot
+}
+
+func (snapshot *bucketSnapshot) restore(b *bbolt.Bucket) error {
+	for _, entry := range snapshot.entries {
+		if entry.bucket == nil {
+			err := b.Put(entry.key, entry.value)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		child, err := b.CreateBucket(entry.key)
+		if err != nil {
+			return err
+		}
+		err = entry.bucket.restore(child)
+		if err != nil {
+			return err
+		}
+	}
+	return b.SetSequence(snapshot.sequence)
+}
+
+func cloneFragments(fragments [][]byte) [][]byte {
+	cloned := make([][]byte, len(fragments))
+	for idx, fragment := range fragments {
+		cloned[idx] = cloneBytes(fragment)
+	}
+	return cloned
+}
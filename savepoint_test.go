@@ -0,0 +1,172 @@
+// See the LICENSE file for license details.
+
+package boltdb_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mxmauro/boltdb/v3"
+)
+
+// -----------------------------------------------------------------------------
+
+func TestSavepoints(t *testing.T) {
+	db := openTestDb(t)
+	defer db.Close()
+
+	err := db.WithinTx(boltdb.TxOptions{}, func(tx *boltdb.TX) error {
+		b, err := tx.Bucket([]byte("items"))
+		if err != nil {
+			return err
+		}
+		if err = b.Put([]byte("a"), []byte("1")); err != nil {
+			return err
+		}
+		if err = b.Put([]byte("b"), []byte("2")); err != nil {
+			return err
+		}
+		nested, err := b.Bucket([]byte("nested/deep"))
+		if err != nil {
+			return err
+		}
+		if err = nested.Put([]byte("x"), []byte("y")); err != nil {
+			return err
+		}
+
+		sp, err := tx.Savepoint()
+		if err != nil {
+			return err
+		}
+
+		// Modify, delete and create.
+		if err = b.Put([]byte("a"), []byte("changed")); err != nil {
+			return err
+		}
+		if err = b.Delete([]byte("b")); err != nil {
+			return err
+		}
+		if err = b.Put([]byte("c"), []byte("3")); err != nil {
+			return err
+		}
+		if err = b.DeleteBucket([]byte("nested")); err != nil {
+			return err
+		}
+		if _, err = tx.Bucket([]byte("other/child")); err != nil {
+			return err
+		}
+		if _, err = b.NextSequence(); err != nil {
+			return err
+		}
+
+		if err = tx.RollbackTo(sp); err != nil {
+			return err
+		}
+		return tx.Release(sp)
+	})
+	if err != nil {
+		t.Fatalf("cannot run transaction [err=%v]", err)
+	}
+
+	expected := map[string]string{"a": "1", "b": "2", "c": ""}
+	for key, value := range expected {
+		got, err := db.Get([]byte("items"), []byte(key))
+		if err != nil || string(got) != value {
+			t.Fatalf("unexpected value [key=%s got=%q err=%v]", key, got, err)
+		}
+	}
+	got, err := db.Get([]byte("items/nested/deep"), []byte("x"))
+	if err != nil || string(got) != "y" {
+		t.Fatalf("deleted bucket was not restored [got=%q err=%v]", got, err)
+	}
+	err = db.WithinTx(boltdb.TxOptions{ReadOnly: true}, func(tx *boltdb.TX) error {
+		if _, err := tx.Bucket([]byte("other")); !errors.Is(err, boltdb.ErrBucketNotFound) {
+			return errors.New("created bucket was not removed")
+		}
+		b, err := tx.Bucket([]byte("items"))
+		if err != nil {
+			return err
+		}
+		if b.Sequence() != 0 {
+			return errors.New("sequence was not restored")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected database state [err=%v]", err)
+	}
+}
+
+func TestNestedTx(t *testing.T) {
+	db := openTestDb(t)
+	defer db.Close()
+
+	errFailed := errors.New("failed")
+	err := db.WithinTx(boltdb.TxOptions{}, func(tx *boltdb.TX) error {
+		b, err := tx.Bucket([]byte("items"))
+		if err != nil {
+			return err
+		}
+		if err = b.Put([]byte("outer"), []byte("1")); err != nil {
+			return err
+		}
+
+		err = tx.Nested(func(tx *boltdb.TX) error {
+			if err := b.Put([]byte("inner-ok"), []byte("1")); err != nil {
+				return err
+			}
+
+			// A failing inner step only discards its own changes.
+			err := tx.Nested(func(tx *boltdb.TX) error {
+				if err := b.Put([]byte("outer"), []byte("overwritten")); err != nil {
+					return err
+				}
+				return errFailed
+			})
+			if !errors.Is(err, errFailed) {
+				return errors.New("nested error was not returned")
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return tx.Nested(func(tx *boltdb.TX) error {
+			if err := b.Put([]byte("inner-failed"), []byte("1")); err != nil {
+				return err
+			}
+			return errFailed
+		})
+	})
+	if !errors.Is(err, errFailed) {
+		t.Fatalf("unexpected transaction result [err=%v]", err)
+	}
+
+	// The outer transaction failed, so nothing was committed.
+	if value, _ := db.Get([]byte("items"), []byte("outer")); value != nil {
+		t.Fatalf("transaction was committed")
+	}
+
+	err = db.WithinTx(boltdb.TxOptions{}, func(tx *boltdb.TX) error {
+		b, err := tx.Bucket([]byte("items"))
+		if err != nil {
+			return err
+		}
+		if err = b.Put([]byte("outer"), []byte("1")); err != nil {
+			return err
+		}
+		_ = tx.Nested(func(tx *boltdb.TX) error {
+			_ = b.Put([]byte("outer"), []byte("overwritten"))
+			return errFailed
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("cannot run transaction [err=%v]", err)
+	}
+	value, err := db.Get([]byte("items"), []byte("outer"))
+	if err != nil || string(value) != "1" {
+		t.Fatalf("unexpected value [got=%q err=%v]", value, err)
+	}
+}
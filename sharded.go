@@ -0,0 +1,263 @@
+// See the LICENSE file for license details.
+
+package boltdb
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+)
+
+// -----------------------------------------------------------------------------
+
+const defaultReshardBatchSize = 1000
+
+// ShardHashFunc computes the hash used to route a key of a bucket to a shard.
+type ShardHashFunc func(bucket []byte, key []byte) uint64
+
+// ShardedDB spreads the keys of a logical database across several database files, so writes to
+// different shards do not wait for each other.
+type ShardedDB struct {
+	shards []*DB
+	hash   ShardHashFunc
+}
+
+// ShardedOptions specifies a set of options when creating/opening a sharded database.
+type ShardedOptions struct {
+	// Options are used to open every shard.
+	Options Options
+
+	// Hash routes keys to shards. If not set, DefaultShardHash is used. All the users of the same set
+	// of files must use the same function.
+	Hash ShardHashFunc
+}
+
+// ReshardOptions specifies a set of options when resharding a database.
+type ReshardOptions struct {
+	// Options are used to create the new sharded database.
+	Options ShardedOptions
+
+	// BatchSize sets the number of keys written per transaction. Defaults to 1000.
+	BatchSize int
+}
+
+type reshardEntry struct {
+	path  []byte
+	key   []byte
+	value []byte
+}
+
+// -----------------------------------------------------------------------------
+
+// NewSharded opens a sharded database using one file per shard. The order of the files must not change
+// between runs.
+func NewSharded(filenames []string, opts ShardedOptions) (*ShardedDB, error) {
+	if len(filenames) == 0 {
+		return nil, ErrInvalidShardCount
+	}
+
+	// Create a wrapper.
+	s := &ShardedDB{
+		shards: make([]*DB, 0, len(filenames)),
+		hash:   opts.Hash,
+	}
+	if s.hash == nil {
+		s.hash = DefaultShardHash
+	}
+
+	// Open shards.
+	for _, filename := range filenames {
+		db, err := NewWithOptions(filename, opts.Options)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.shards = append(s.shards, db)
+	}
+
+	// Done
+	return s, nil
+}
+
+// DefaultShardHash computes the FNV-1a hash of the normalized bucket path and the key.
+func DefaultShardHash(bucket []byte, key []byte) uint64 {
+	if fragments, err := splitPath(bucket); err == nil {
+		bucket = joinPath(fragments)
+	}
+
+	h := fnv.New64a()
+	_, _ = h.Write(bucket)
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write(key)
+	return h.Sum64()
+}
+
+// Close closes all the shards.
+func (s *ShardedDB) Close() {
+	for _, db := range s.shards {
+		db.Close()
+	}
+}
+
+// ShardCount returns the number of shards.
+func (s *ShardedDB) ShardCount() int {
+	return len(s.shards)
+}
+
+// Shard returns the database of the shard with the given index.
+func (s *ShardedDB) Shard(idx int) *DB {
+	return s.shards[idx]
+}
+
+// ShardFor returns the index of the shard where a key of a bucket is stored.
+func (s *ShardedDB) ShardFor(bucket []byte, key []byte) int {
+	return int(s.hash(bucket, key) % uint64(len(s.shards)))
+}
+
+// WithinShardTx initiates a transaction on the shard with the given index and calls a callback. Use
+// ShardFor to locate the shard of the keys to modify together.
+func (s *ShardedDB) WithinShardTx(idx int, opts TxOptions, cb WithinTxCallback) error {
+	return s.shards[idx].WithinTx(opts, cb)
+}
+
+// Get returns the value of a key in the specified bucket or nil if not found.
+func (s *ShardedDB) Get(bucket []byte, key []byte) ([]byte, error) {
+	return s.shards[s.ShardFor(bucket, key)].Get(bucket, key)
+}
+
+// Put stores a key/value pair in the specified bucket.
+func (s *ShardedDB) Put(bucket []byte, key []byte, value []byte) error {
+	return s.shards[s.ShardFor(bucket, key)].Put(bucket, key, value)
+}
+
+// Delete deletes a specific key in the specified bucket. No error is returned if the key is not found.
+func (s *ShardedDB) Delete(bucket []byte, key []byte) error {
+	return s.shards[s.ShardFor(bucket, key)].Delete(bucket, key)
+}
+
+// WithIterator traverses the keys of a bucket in all the shards at once, merging them in key order.
+// Nested buckets are skipped.
+// NOTE: Prefix and FirstKey cannot be used at the same time.
+func (s *ShardedDB) WithIterator(bucket []byte, opts WithIteratorOptions, cb MergedIteratorCallback) error {
+	iters := make([]*Iterator, 0, len(s.shards))
+	for _, db := range s.shards {
+		tx, err := db.BeginTx(TxOptions{ReadOnly: true})
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		b, err := tx.Bucket(bucket)
+		if err != nil {
+			if errors.Is(err, ErrBucketNotFound) {
+				continue
+			}
+			return err
+		}
+		iters = append(iters, b.Iterate())
+	}
+
+	// Done
+	return mergeIterators(iters, opts, cb)
+}
+
+// Reshard copies all the keys into a new sharded database with as many shards as files given and returns
+// it. The database must not be modified meanwhile. Empty buckets and bucket sequences are not copied.
+func (s *ShardedDB) Reshard(ctx context.Context, filenames []string, opts ReshardOptions) (*ShardedDB, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultReshardBatchSize
+	}
+
+	dst, err := NewSharded(filenames, opts.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make([][]reshardEntry, len(dst.shards))
+	flush := func(idx int) error {
+		err := dst.shards[idx].WithinTx(TxOptions{}, func(tx *TX) error {
+			buckets := make(map[string]*Bucket)
+			for _, entry := range pending[idx] {
+				b, ok := buckets[string(entry.path)]
+				if !ok {
+					var err error
+
+					b, err = tx.Bucket(entry.path)
+					if err != nil {
+						return err
+					}
+					buckets[string(entry.path)] = b
+				}
+				err := b.Put(entry.key, entry.value)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		pending[idx] = pending[idx][:0]
+		return err
+	}
+
+	var copyBucket func(b *Bucket) error
+	copyBucket = func(b *Bucket) error {
+		return b.WithIterator(WithIteratorOptions{}, func(iter *Iterator) (bool, error) {
+			if iter.IsNestedBucket() {
+				child, err := b.Bucket(iter.Key())
+				if err != nil {
+					return true, err
+				}
+				return false, copyBucket(child)
+			}
+
+			value := iter.CopyValue()
+			if iter.Err() != nil {
+				return true, iter.Err()
+			}
+			idx := dst.ShardFor(b.Path(), iter.Key())
+			pending[idx] = append(pending[idx], reshardEntry{
+				path:  b.Path(),
+				key:   iter.CopyKey(),
+				value: value,
+			})
+			if len(pending[idx]) >= opts.BatchSize {
+				err := ctx.Err()
+				if err == nil {
+					err = flush(idx)
+				}
+				if err != nil {
+					return true, err
+				}
+			}
+			return false, nil
+		})
+	}
+
+	for _, db := range s.shards {
+		err = db.WithinTx(TxOptions{ReadOnly: true}, func(tx *TX) error {
+			return tx.WithIterator(WithIteratorOptions{}, func(iter *Iterator) (bool, error) {
+				b, err := tx.Bucket(iter.Key())
+				if err != nil {
+					return true, err
+				}
+				return false, copyBucket(b)
+			})
+		})
+		if err != nil {
+			dst.Close()
+			return nil, err
+		}
+	}
+	for idx := range pending {
+		if len(pending[idx]) > 0 {
+			err = flush(idx)
+			if err != nil {
+				dst.Close()
+				return nil, err
+			}
+		}
+	}
+
+	// Done
+	return dst, nil
+}
@@ -0,0 +1,97 @@
+// See the LICENSE file for license details.
+
+package boltdb_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mxmauro/boltdb/v3"
+)
+
+// -----------------------------------------------------------------------------
+
+func TestShardedDB(t *testing.T) {
+	dir := t.TempDir()
+	shardFiles := func(prefix string, count int) []string {
+		filenames := make([]string, count)
+		for idx := range filenames {
+			filenames[idx] = filepath.Join(dir, fmt.Sprintf("%s-%d.db", prefix, idx))
+		}
+		return filenames
+	}
+
+	s, err := boltdb.NewSharded(shardFiles("a", 3), boltdb.ShardedOptions{})
+	if err != nil {
+		t.Fatalf("cannot create sharded database [err=%v]", err)
+	}
+	defer s.Close()
+
+	for idx := 0; idx < 100; idx++ {
+		err = s.Put([]byte("events"), []byte(fmt.Sprintf("key-%03d", idx)), []byte(fmt.Sprintf("value-%d", idx)))
+		if err != nil {
+			t.Fatalf("cannot write to sharded database [err=%v]", err)
+		}
+	}
+
+	// Keys are spread across shards.
+	used := make(map[int]bool)
+	for idx := 0; idx < 100; idx++ {
+		used[s.ShardFor([]byte("events"), []byte(fmt.Sprintf("key-%03d", idx)))] = true
+	}
+	if len(used) != 3 {
+		t.Fatalf("keys were not spread across shards [used=%v]", used)
+	}
+	if value, err := s.Get([]byte("events"), []byte("key-042")); err != nil || string(value) != "value-42" {
+		t.Fatalf("unexpected value [value=%q err=%v]", value, err)
+	}
+
+	collect := func(s *boltdb.ShardedDB, opts boltdb.WithIteratorOptions) []string {
+		var keys []string
+
+		err := s.WithIterator([]byte("events"), opts, func(key []byte, value []byte) (bool, error) {
+			keys = append(keys, string(key))
+			return false, nil
+		})
+		if err != nil {
+			t.Fatalf("cannot iterate sharded database [err=%v]", err)
+		}
+		return keys
+	}
+
+	// Iteration merges the shards in key order.
+	keys := collect(s, boltdb.WithIteratorOptions{})
+	if len(keys) != 100 || keys[0] != "key-000" || keys[99] != "key-099" {
+		t.Fatalf("unexpected keys [keys=%v]", keys)
+	}
+	for idx := 1; idx < len(keys); idx++ {
+		if keys[idx-1] >= keys[idx] {
+			t.Fatalf("keys are not sorted [keys=%v]", keys)
+		}
+	}
+	keys = collect(s, boltdb.WithIteratorOptions{Prefix: []byte("key-05"), Reverse: true})
+	if strings.Join(keys, ",") != "key-059,key-058,key-057,key-056,key-055,key-054,key-053,key-052,key-051,key-050" {
+		t.Fatalf("unexpected keys [keys=%v]", keys)
+	}
+
+	// Resharding moves every key to its new shard.
+	resharded, err := s.Reshard(context.Background(), shardFiles("b", 2), boltdb.ReshardOptions{BatchSize: 7})
+	if err != nil {
+		t.Fatalf("cannot reshard database [err=%v]", err)
+	}
+	defer resharded.Close()
+
+	if keys = collect(resharded, boltdb.WithIteratorOptions{}); len(keys) != 100 {
+		t.Fatalf("unexpected keys after resharding [keys=%v]", keys)
+	}
+	for idx := 0; idx < 100; idx++ {
+		key := []byte(fmt.Sprintf("key-%03d", idx))
+		value, err := resharded.Shard(resharded.ShardFor([]byte("events"), key)).Get([]byte("events"), key)
+		if err != nil || string(value) != fmt.Sprintf("value-%d", idx) {
+			t.Fatalf("unexpected value after resharding [key=%s value=%q err=%v]", key, value, err)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+// See the LICENSE file for license details.
+
+package boltdb
+
+import (
+	"math/bits"
+	"os"
+
+	"go.etcd.io/bbolt"
+)
+
+// -----------------------------------------------------------------------------
+
+// Stats contains statistical data about the database.
+type Stats struct {
+	// Transaction statistics.
+	ReadTxN  int   // total number of started read-only transactions
+	OpenTxN  int   // number of currently open read-only transactions
+	WriteTxN int64 // total number of started writable transactions
+
+	// Page statistics accumulated by all committed transactions.
+	PageCount int64 // number of page allocations
+	PageAlloc int64 // total bytes allocated
+	PageWrite int64 // number of pages written to disk
+
+	// Freelist statistics.
+	FreePageN     int // total number of free pages on the freelist
+	PendingPageN  int // total number of pages freed by transactions not yet released
+	FreeAlloc     int // total bytes allocated in free pages
+	FreelistInuse int // total bytes used by the freelist
+
+	// Size statistics.
+	PageSize int   // size of a database page
+	FileSize int64 // size of the database file on disk
+	DataSize int64 // bytes up to the high water mark of used pages
+	UsedSize int64 // bytes of the data size not available for reuse
+}
+
+// BucketSizeReport contains size information about a bucket and its nested buckets.
+type BucketSizeReport struct {
+	// Path is the full path of the bucket. It is nil for the virtual root of a report over all buckets.
+	Path []byte
+
+	// Depth is the nesting level relative to the bucket the report was requested for.
+	Depth int
+
+	// Keys, KeyBytes and ValueBytes account for the key/value pairs stored directly in this bucket.
+	Keys       int
+	KeyBytes   int64
+	ValueBytes int64
+
+	// TotalKeys, TotalKeyBytes and TotalValueBytes include the nested buckets.
+	TotalKeys       int
+	TotalKeyBytes   int64
+	TotalValueBytes int64
+
+	// Inline is true if the bucket is small enough to be stored inside its parent page.
+	Inline bool
+
+	// Pages contains the page statistics of the bucket including nested buckets.
+	Pages BucketStats
+
+	// Children contains the reports of the nested buckets.
+	Children []BucketSizeReport
+}
+
+// SizeHistogram contains the distribution of key and value sizes.
+type SizeHistogram struct {
+	Keys   Histogram
+	Values Histogram
+}
+
+// Histogram contains a distribution of sizes grouped in power-of-two bins.
+type Histogram struct {
+	Count int
+	Total int64
+	Max   int
+	Bins  []HistogramBin
+}
+
+// HistogramBin contains the amount of items whose size is between Min and Max, both inclusive.
+type HistogramBin struct {
+	Min   int
+	Max   int
+	Count int
+	Bytes int64
+}
+
+// -----------------------------------------------------------------------------
+
+// Stats returns statistical data about the database.
+func (db *DB) Stats() (Stats, error) {
+	boltStats := db.db.Stats()
+	stats := Stats{
+		ReadTxN:       boltStats.TxN,
+		OpenTxN:       boltStats.OpenTxN,
+		WriteTxN:      db.writeTxN.Load(),
+		PageCount:     boltStats.TxStats.GetPageCount(),
+		PageAlloc:     boltStats.TxStats.GetPageAlloc(),
+		PageWrite:     boltStats.TxStats.GetWrite(),
+		FreePageN:     boltStats.FreePageN,
+		PendingPageN:  boltStats.PendingPageN,
+		FreeAlloc:     boltStats.FreeAlloc,
+		FreelistInuse: boltStats.FreelistInuse,
+		PageSize:      db.db.Info().PageSize,
+	}
+
+	fi, err := os.Stat(db.db.Path())
+	if err != nil {
+		return Stats{}, err
+	}
+	stats.FileSize = fi.Size()
+
+	err = db.db.View(func(tx *bbolt.Tx) error {
+		stats.DataSize = tx.Size()
+		return nil
+	})
+	if err != nil {
+		return Stats{}, err
+	}
+	stats.UsedSize = stats.DataSize - int64(stats.FreePageN+stats.PendingPageN)*int64(stats.PageSize)
+
+	// Done
+	return stats, nil
+}
+
+// SizeReport computes the size of the bucket with the given path and all its nested buckets. If the
+// path is empty, the report covers all the top-level buckets except the reserved one.
+// NOTE: Sizes are measured on the stored data and traversing a large database may take a while.
+func (db *DB) SizeReport(path []byte) (BucketSizeReport, error) {
+	var report BucketSizeReport
+
+	err := db.WithinTx(TxOptions{ReadOnly: true}, func(tx *TX) error {
+		if len(path) == 0 {
+			c := tx.tx.Cursor()
+			for k, _ := c.First(); k != nil; k, _ = c.Next() {
+				if isReservedBucketName(k) {
+					continue
+				}
+				child := sizeReport(tx.tx.Bucket(k), cloneBytes(k), 1)
+				report.addChild(child)
+			}
+			return nil
+		}
+
+		b, err := tx.Bucket(path)
+		if err != nil {
+			return err
+		}
+		fragments, _ := splitPath(path)
+		report = sizeReport(b.b, joinPath(fragments), 0)
+		return nil
+	})
+
+	// Done
+	return report, err
+}
+
+// SizeHistogram computes the distribution of key and value sizes of the bucket with the given path and
+// all its nested buckets. If the path is empty, all the buckets except the reserved one are included.
+func (db *DB) SizeHistogram(path []byte) (SizeHistogram, error) {
+	var hist SizeHistogram
+
+	err := db.WithinTx(TxOptions{ReadOnly: true}, func(tx *TX) error {
+		if len(path) == 0 {
+			c := tx.tx.Cursor()
+			for k, _ := c.First(); k != nil; k, _ = c.Next() {
+				if isReservedBucketName(k) {
+					continue
+				}
+				hist.addBucket(tx.tx.Bucket(k))
+			}
+			return nil
+		}
+
+		b, err := tx.Bucket(path)
+		if err != nil {
+			return err
+		}
+		hist.addBucket(b.b)
+		return nil
+	})
+
+	// Done
+	return hist, err
+}
+
+// -----------------------------------------------------------------------------
+
+func sizeReport(b *bbolt.Bucket, path []byte, depth int) BucketSizeReport {
+	report := BucketSizeReport{
+		Path:   path,
+		Depth:  depth,
+		Inline: b.Root() == 0,
+		Pages:  b.Stats(),
+	}
+
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if v != nil {
+			report.Keys += 1
+			report.KeyBytes += int64(len(k))
+			report.ValueBytes += int64(len(v))
+			continue
+		}
+
+		childPath := make([]byte, 0, len(path)+1+len(k))
+		childPath = append(append(append(childPath, path...), '/'), k...)
+		report.addChild(sizeReport(b.Bucket(k), childPath, depth+1))
+	}
+
+	report.TotalKeys += report.Keys
+	report.TotalKeyBytes += report.KeyBytes
+	report.TotalValueBytes += report.ValueBytes
+
+	// Done
+	return report
+}
+
+func (report *BucketSizeReport) addChild(child BucketSizeReport) {
+	report.Children = append(report.Children, child)
+	report.TotalKeys += child.TotalKeys
+	report.TotalKeyBytes += child.TotalKeyBytes
+	report.TotalValueBytes += child.TotalValueBytes
+	if report.Path == nil {
+		report.Pages.Add(child.Pages)
+	}
+}
+
+func (hist *SizeHistogram) addBucket(b *bbolt.Bucket) {
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if v == nil {
+			hist.addBucket(b.Bucket(k))
+			continue
+		}
+		hist.Keys.add(len(k))
+		hist.Values.add(len(v))
+	}
+}
+
+func (h *Histogram) add(size int) {
+	// Bin 0 holds empty items and bin N holds sizes between 2^(N-1) and 2^N-1.
+	idx := bits.Len(uint(size))
+	for len(h.Bins) <= idx {
+		binIdx := len(h.Bins)
+		bin := HistogramBin{}
+		if binIdx > 0 {
+			bin.Min = 1 << (binIdx - 1)
+			bin.Max = (1 << binIdx) - 1
+		}
+		h.Bins = append(h.Bins, bin)
+	}
+
+	h.Bins[idx].Count += 1
+	h.Bins[idx].Bytes += int64(size)
+	h.Count += 1
+	h.Total += int64(size)
+	if size > h.Max {
+		h.Max = size
+	}
+}
@@ -0,0 +1,118 @@
+// See the LICENSE file for license details.
+
+package boltdb_test
+
+import (
+	"testing"
+
+	"github.com/mxmauro/boltdb/v3"
+)
+
+// -----------------------------------------------------------------------------
+
+func TestDatabaseStats(t *testing.T) {
+	db := openTestDb(t)
+	defer db.Close()
+
+	seedDumpTestDb(t, db)
+
+	if _, err := db.Get([]byte("users"), []byte("carol")); err != nil {
+		t.Fatalf("cannot read from test database [err=%v]", err.Error())
+	}
+
+	stats, err := db.Stats()
+	if err != nil {
+		t.Fatalf("cannot get database stats [err=%v]", err.Error())
+	}
+	if stats.WriteTxN != 1 || stats.ReadTxN < 1 || stats.OpenTxN != 0 {
+		t.Fatalf("unexpected transaction stats [got=%+v]", stats)
+	}
+	if stats.PageSize <= 0 || stats.FileSize < stats.DataSize || stats.DataSize < stats.UsedSize || stats.UsedSize <= 0 {
+		t.Fatalf("unexpected size stats [got=%+v]", stats)
+	}
+}
+
+func TestSizeReport(t *testing.T) {
+	db := openTestDb(t)
+	defer db.Close()
+
+	seedDumpTestDb(t, db)
+
+	report, err := db.SizeReport(nil)
+	if err != nil {
+		t.Fatalf("cannot compute size report [err=%v]", err.Error())
+	}
+	if report.Path != nil || len(report.Children) != 2 || report.TotalKeys != 4 {
+		t.Fatalf("unexpected root report [got=%+v]", report)
+	}
+	if report.Pages.BucketN != 3 {
+		t.Fatalf("unexpected root page stats [got=%+v]", report.Pages)
+	}
+
+	users := report.Children[1]
+	if string(users.Path) != "users" || users.Depth != 1 || users.Keys != 2 || users.TotalKeys != 4 {
+		t.Fatalf("unexpected bucket report [got=%+v]", users)
+	}
+	if users.KeyBytes != int64(len("binary")+len("carol")) || users.ValueBytes != 2 {
+		t.Fatalf("unexpected bucket sizes [got=%+v]", users)
+	}
+	if users.Inline || len(users.Children) != 1 || !users.Children[0].Inline {
+		t.Fatalf("expected only the small leaf bucket to be inlined")
+	}
+
+	report, err = db.SizeReport([]byte("/users/eu"))
+	if err != nil {
+		t.Fatalf("cannot compute size report [err=%v]", err.Error())
+	}
+	if string(report.Path) != "users/eu" || report.Depth != 0 || report.Keys != 2 || len(report.Children) != 0 {
+		t.Fatalf("unexpected nested bucket report [got=%+v]", report)
+	}
+}
+
+func TestSizeHistogram(t *testing.T) {
+	db := openTestDb(t)
+	defer db.Close()
+
+	seedDumpTestDb(t, db)
+
+	hist, err := db.SizeHistogram([]byte("users"))
+	if err != nil {
+		t.Fatalf("cannot compute size histogram [err=%v]", err.Error())
+	}
+	if hist.Keys.Count != 4 || hist.Values.Count != 4 {
+		t.Fatalf("unexpected histogram counts [got=%+v]", hist)
+	}
+	if hist.Values.Max != len(`{"name":"Bob"}`) || hist.Values.Bins[0].Count != 1 {
+		t.Fatalf("unexpected value histogram [got=%+v]", hist.Values)
+	}
+	for _, bin := range hist.Keys.Bins {
+		if bin.Count > 0 && (bin.Bytes < int64(bin.Min*bin.Count) || bin.Bytes > int64(bin.Max*bin.Count)) {
+			t.Fatalf("inconsistent histogram bin [got=%+v]", bin)
+		}
+	}
+}
+
+func TestSizeReportSkipsReservedBucket(t *testing.T) {
+	db := openTestDb(t)
+	defer db.Close()
+
+	seedDumpTestDb(t, db)
+	if err := db.SetQuota([]byte("users"), boltdb.Quota{}); err != nil {
+		t.Fatalf("cannot set quota [err=%v]", err.Error())
+	}
+
+	report, err := db.SizeReport(nil)
+	if err != nil {
+		t.Fatalf("cannot compute size report [err=%v]", err.Error())
+	}
+	if len(report.Children) != 2 || report.TotalKeys != 4 {
+		t.Fatalf("unexpected root report [got=%+v]", report)
+	}
+	hist, err := db.SizeHistogram(nil)
+	if err != nil {
+		t.Fatalf("cannot compute size histogram [err=%v]", err.Error())
+	}
+	if hist.Keys.Count != 4 {
+		t.Fatalf("unexpected histogram counts [got=%+v]", hist)
+	}
+}
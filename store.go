@@ -0,0 +1,648 @@
+// See the LICENSE file for license details.
+
+package boltdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"go.etcd.io/bbolt"
+)
+
+// -----------------------------------------------------------------------------
+
+// Store persists Go structs of type T encoded as JSON. Struct tags select how fields are stored:
+//
+//	boltdb:"id"      the primary key. Zero integer keys are assigned using the bucket sequence on Save.
+//	boltdb:"index"   maintains a secondary index on the field.
+//	boltdb:"unique"  maintains a secondary index that rejects duplicated values.
+//
+// Records are stored in the "data" bucket located at the store path and indexes in the "index/<field>"
+// and "unique/<field>" buckets. Supported key types are integers, floats, booleans, strings, byte slices
+// and time.Time. All the operations run within a transaction, so they compose with other changes.
+type Store[T any] struct {
+	path   []byte
+	schema *storeSchema
+}
+
+type storeSchema struct {
+	typ     reflect.Type
+	id      *storeField
+	fields  map[string]*storeField
+	indexes []*storeField
+}
+
+type storeField struct {
+	name    string
+	index   []int
+	typ     reflect.Type
+	indexed bool
+	unique  bool
+}
+
+// -----------------------------------------------------------------------------
+
+var (
+	storeDataBucket   = []byte("data")
+	storeIndexBucket  = []byte("index")
+	storeUniqueBucket = []byte("unique")
+)
+
+// -----------------------------------------------------------------------------
+
+// NewStore creates a store of T located at the given bucket path.
+func NewStore[T any](path []byte) (*Store[T], error) {
+	fragments, err := splitPath(path)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := parseStoreSchema(reflect.TypeOf((*T)(nil)).Elem())
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store[T]{
+		path:   joinPath(fragments),
+		schema: schema,
+	}
+
+	// Done
+	return s, nil
+}
+
+// Save stores a record, replacing any record with the same primary key and updating the indexes.
+func (s *Store[T]) Save(tx *TX, item *T) error {
+	if tx.readOnly {
+		return ErrTxNotWritable
+	}
+
+	existing, err := tx.existingBucket(s.subPath(storeDataBucket))
+	if err != nil {
+		return err
+	}
+
+	// Pick the primary key the record would get without touching the sequence or the record yet.
+	rv := reflect.ValueOf(item).Elem()
+	idValue := rv.FieldByIndex(s.schema.id.index)
+	candidate := idValue
+	assignID := false
+	if idValue.IsZero() {
+		var next uint64 = 1
+		if existing != nil {
+			next = existing.Sequence() + 1
+		}
+		switch idValue.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			candidate = reflect.New(idValue.Type()).Elem()
+			candidate.SetInt(int64(next))
+			assignID = true
+
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			candidate = reflect.New(idValue.Type()).Elem()
+			candidate.SetUint(next)
+			assignID = true
+
+		default:
+		}
+	}
+	id, err := encodeOrderedKey(candidate)
+	if err != nil {
+		return fmt.Errorf("invalid primary key: %w", err)
+	}
+
+	// Encode the new index entries and check the unique constraints before changing anything, so a
+	// rejected record leaves the transaction and the item untouched.
+	fieldKeys := make([][]byte, len(s.schema.indexes))
+	for idx, field := range s.schema.indexes {
+		fieldKey, err2 := encodeOrderedKey(rv.FieldByIndex(field.index))
+		if err2 != nil {
+			return fmt.Errorf("invalid value for field %s: %w", field.name, err2)
+		}
+		if field.unique {
+			b, err3 := tx.existingBucket(s.indexPath(field))
+			if err3 != nil {
+				return err3
+			}
+			if b != nil {
+				if current := b.Get(fieldKey); current != nil && !bytes.Equal(current, id) {
+					return fmt.Errorf("%w [field=%s]", ErrUniqueViolation, field.name)
+				}
+			}
+		}
+		fieldKeys[idx] = fieldKey
+	}
+
+	// Assign the primary key.
+	data, err := tx.Bucket(s.subPath(storeDataBucket))
+	if err != nil {
+		return err
+	}
+	if assignID {
+		seq, err2 := data.NextSequence()
+		if err2 != nil {
+			return err2
+		}
+		if idValue.CanInt() {
+			idValue.SetInt(int64(seq))
+		} else {
+			idValue.SetUint(seq)
+		}
+	}
+
+	encoded, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	// Remove the index entries of the previous version.
+	old, err := s.get(data, id)
+	if err != nil && !errors.Is(err, ErrRecordNotFound) {
+		return err
+	}
+	if err == nil {
+		err = s.removeIndexes(tx, reflect.ValueOf(old).Elem(), id)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Add the new index entries.
+	for idx, field := range s.schema.indexes {
+		index, err2 := tx.Bucket(s.indexPath(field))
+		if err2 != nil {
+			return err2
+		}
+		if field.unique {
+			err = index.Put(fieldKeys[idx], id)
+		} else {
+			err = index.Put(storeIndexKey(fieldKeys[idx], id), id)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// Done
+	return data.Put(id, encoded)
+}
+
+// One returns the first record whose field matches the given value. The field can be the primary key,
+// an indexed field or any other field, in which case all the records are scanned.
+func (s *Store[T]) One(tx *TX, field string, value any) (T, error) {
+	var zero T
+
+	items, err := s.find(tx, field, value, 1)
+	if err != nil {
+		return zero, err
+	}
+	if len(items) == 0 {
+		return zero, ErrRecordNotFound
+	}
+
+	// Done
+	return items[0], nil
+}
+
+// Find returns all the records whose field matches the given value. The field can be the primary key, an
+// indexed field or any other field, in which case all the records are scanned.
+func (s *Store[T]) Find(tx *TX, field string, value any) ([]T, error) {
+	return s.find(tx, field, value, 0)
+}
+
+// All returns all the records sorted by primary key.
+func (s *Store[T]) All(tx *TX) ([]T, error) {
+	var items []T
+
+	data, err := s.bucket(tx, s.subPath(storeDataBucket))
+	if err != nil || data == nil {
+		return nil, err
+	}
+	err = data.WithIterator(WithIteratorOptions{}, func(iter *Iterator) (bool, error) {
+		item, err2 := s.decode(iter.Value())
+		if err2 != nil {
+			return true, err2
+		}
+		items = append(items, *item)
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Done
+	return items, nil
+}
+
+// Range returns the records whose field value is between min and max, both inclusive, sorted by that
+// field. The field must be the primary key or an indexed field.
+func (s *Store[T]) Range(tx *TX, field string, min any, max any) ([]T, error) {
+	var items []T
+	var b *Bucket
+
+	f, err := s.field(field)
+	if err != nil {
+		return nil, err
+	}
+	if f != s.schema.id && !f.indexed {
+		return nil, fmt.Errorf("%w [field=%s]", ErrFieldNotIndexed, field)
+	}
+	minKey, err := convertOrderedKey(min, f.typ)
+	if err != nil {
+		return nil, err
+	}
+	maxKey, err := convertOrderedKey(max, f.typ)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := s.bucket(tx, s.subPath(storeDataBucket))
+	if err != nil || data == nil {
+		return nil, err
+	}
+	if f == s.schema.id {
+		b = data
+	} else {
+		b, err = s.bucket(tx, s.indexPath(f))
+		if err != nil || b == nil {
+			return nil, err
+		}
+	}
+
+	// Index keys start with the encoded field value, which is prefix-free, so every key having the
+	// encoded maximum as prefix is within the range.
+	err = b.WithIterator(WithIteratorOptions{FirstKey: minKey}, func(iter *Iterator) (bool, error) {
+		if bytes.Compare(iter.Key(), maxKey) > 0 && !bytes.HasPrefix(iter.Key(), maxKey) {
+			return true, nil
+		}
+
+		item, err2 := s.resolve(data, b, iter.Value())
+		if err2 != nil {
+			return true, err2
+		}
+		items = append(items, *item)
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Done
+	return items, nil
+}
+
+// Delete removes the record with the given primary key and its index entries. No error is returned if
+// the record is not found.
+func (s *Store[T]) Delete(tx *TX, id any) error {
+	if tx.readOnly {
+		return ErrTxNotWritable
+	}
+
+	encodedID, err := convertOrderedKey(id, s.schema.id.typ)
+	if err != nil {
+		return err
+	}
+	data, err := tx.Bucket(s.subPath(storeDataBucket))
+	if err != nil {
+		return err
+	}
+
+	item, err := s.get(data, encodedID)
+	if err != nil {
+		if errors.Is(err, ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	err = s.removeIndexes(tx, reflect.ValueOf(item).Elem(), encodedID)
+	if err != nil {
+		return err
+	}
+
+	// Done
+	return data.Delete(encodedID)
+}
+
+// -----------------------------------------------------------------------------
+
+func parseStoreSchema(typ reflect.Type) (*storeSchema, error) {
+	if typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("store type %v is not a struct", typ)
+	}
+
+	schema := &storeSchema{
+		typ:    typ,
+		fields: make(map[string]*storeField),
+	}
+	for _, sf := range reflect.VisibleFields(typ) {
+		if !sf.IsExported() || sf.Anonymous {
+			continue
+		}
+
+		field := &storeField{
+			name:  sf.Name,
+			index: sf.Index,
+			typ:   sf.Type,
+		}
+		for _, option := range strings.Split(sf.Tag.Get("boltdb"), ",") {
+			switch strings.TrimSpace(option) {
+			case "id":
+				if schema.id != nil {
+					return nil, fmt.Errorf("multiple primary keys in type %v", typ)
+				}
+				schema.id = field
+			case "index":
+				field.indexed = true
+			case "unique":
+				field.indexed = true
+				field.unique = true
+			case "":
+			default:
+				return nil, fmt.Errorf("invalid tag option %q in field %s", option, sf.Name)
+			}
+		}
+		if field.indexed {
+			schema.indexes = append(schema.indexes, field)
+		}
+		schema.fields[sf.Name] = field
+	}
+	if schema.id == nil {
+		return nil, fmt.Errorf("type %v has no primary key", typ)
+	}
+
+	// Done
+	return schema, nil
+}
+
+func (s *Store[T]) find(tx *TX, field string, value any, limit int) ([]T, error) {
+	var items []T
+
+	f, err := s.field(field)
+	if err != nil {
+		return nil, err
+	}
+	key, err := convertOrderedKey(value, f.typ)
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.bucket(tx, s.subPath(storeDataBucket))
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	add := func(item *T) bool {
+		items = append(items, *item)
+		return limit > 0 && len(items) >= limit
+	}
+
+	switch {
+	case f == s.schema.id:
+		item, err2 := s.get(data, key)
+		if err2 != nil {
+			if errors.Is(err2, ErrRecordNotFound) {
+				return nil, nil
+			}
+			return nil, err2
+		}
+		_ = add(item)
+
+	case f.indexed:
+		b, err2 := s.bucket(tx, s.indexPath(f))
+		if err2 != nil || b == nil {
+			return nil, err2
+		}
+		if f.unique {
+			id := b.Get(key)
+			if id == nil {
+				return nil, nil
+			}
+			item, err3 := s.get(data, id)
+			if err3 != nil {
+				return nil, err3
+			}
+			_ = add(item)
+			break
+		}
+		err2 = b.WithIterator(WithIteratorOptions{Prefix: key}, func(iter *Iterator) (bool, error) {
+			item, err3 := s.get(data, iter.Value())
+			if err3 != nil {
+				return true, err3
+			}
+			return add(item), nil
+		})
+		if err2 != nil {
+			return nil, err2
+		}
+
+	default:
+		err2 := data.WithIterator(WithIteratorOptions{}, func(iter *Iterator) (bool, error) {
+			item, err3 := s.decode(iter.Value())
+			if err3 != nil {
+				return true, err3
+			}
+			fieldKey, err3 := encodeOrderedKey(reflect.ValueOf(item).Elem().FieldByIndex(f.index))
+			if err3 != nil {
+				return true, err3
+			}
+			if bytes.Equal(fieldKey, key) {
+				return add(item), nil
+			}
+			return false, nil
+		})
+		if err2 != nil {
+			return nil, err2
+		}
+	}
+
+	// Done
+	return items, nil
+}
+
+// resolve returns the record referenced by an entry of b, which is either the data bucket or an index.
+func (s *Store[T]) resolve(data *Bucket, b *Bucket, value []byte) (*T, error) {
+	if b == data {
+		return s.decode(value)
+	}
+	return s.get(data, value)
+}
+
+func (s *Store[T]) get(data *Bucket, id []byte) (*T, error) {
+	encoded, err := data.GetValue(id)
+	if err != nil {
+		return nil, err
+	}
+	if encoded == nil {
+		return nil, ErrRecordNotFound
+	}
+	return s.decode(encoded)
+}
+
+func (s *Store[T]) decode(encoded []byte) (*T, error) {
+	item := new(T)
+	err := json.Unmarshal(encoded, item)
+	if err != nil {
+		return nil, fmt.Errorf("invalid record [path=%q]: %w", s.path, err)
+	}
+	return item, nil
+}
+
+func (s *Store[T]) removeIndexes(tx *TX, rv reflect.Value, id []byte) error {
+	for _, field := range s.schema.indexes {
+		fieldKey, err := encodeOrderedKey(rv.FieldByIndex(field.index))
+		if err != nil {
+			return err
+		}
+		b, err := tx.Bucket(s.indexPath(field))
+		if err != nil {
+			return err
+		}
+		if field.unique {
+			if bytes.Equal(b.Get(fieldKey), id) {
+				err = b.Delete(fieldKey)
+			}
+		} else {
+			err = b.Delete(storeIndexKey(fieldKey, id))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// Done
+	return nil
+}
+
+// storeIndexKey returns the key of a non-unique index entry, which is the encoded field value followed
+// by the record key. The entry value is the record key.
+func storeIndexKey(fieldKey []byte, id []byte) []byte {
+	key := make([]byte, 0, len(fieldKey)+len(id))
+	return append(append(key, fieldKey...), id...)
+}
+
+func (s *Store[T]) field(name string) (*storeField, error) {
+	f, ok := s.schema.fields[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown field %s in type %v", name, s.schema.typ)
+	}
+	return f, nil
+}
+
+// bucket returns a bucket of the store or nil if it does not exist yet in a read-only transaction.
+func (s *Store[T]) bucket(tx *TX, path []byte) (*Bucket, error) {
+	b, err := tx.Bucket(path)
+	if err != nil {
+		if errors.Is(err, ErrBucketNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+func (s *Store[T]) subPath(name []byte) []byte {
+	path := make([]byte, 0, len(s.path)+1+len(name))
+	return append(append(append(path, s.path...), '/'), name...)
+}
+
+func (s *Store[T]) indexPath(field *storeField) []byte {
+	kind := storeIndexBucket
+	if field.unique {
+		kind = storeUniqueBucket
+	}
+	return append(append(s.subPath(kind), '/'), field.name...)
+}
+
+// checkStoreIndexes verifies the indexes of the stores found in the database. A store is a bucket with
+// a "data" bucket and an "index" or "unique" bucket. Every index must have one entry per record and
+// every entry must reference an existing record.
+func checkStoreIndexes(ctx context.Context, tx *TX, report *Report) error {
+	return walkBuckets(ctx, tx, func(fragments [][]byte, b *bbolt.Bucket) error {
+		if b.Bucket(storeDataBucket) == nil || (b.Bucket(storeIndexBucket) == nil && b.Bucket(storeUniqueBucket) == nil) {
+			return nil
+		}
+		data := tx.bucketFromFragments(appendPath(fragments, storeDataBucket))
+		if data == nil {
+			return nil
+		}
+
+		records := 0
+		err := data.WithIterator(WithIteratorOptions{}, func(iter *Iterator) (bool, error) {
+			if !iter.IsNestedBucket() {
+				records += 1
+			}
+			return false, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, kind := range [][]byte{storeIndexBucket, storeUniqueBucket} {
+			kindFragments := appendPath(fragments, kind)
+			kindBucket := tx.rawBucket(kindFragments)
+			if kindBucket == nil {
+				continue
+			}
+			err = kindBucket.ForEachBucket(func(name []byte) error {
+				index := tx.bucketFromFragments(appendPath(kindFragments, cloneBytes(name)))
+				if index == nil {
+					return nil
+				}
+				return checkStoreIndex(index, data, records, kind, report)
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		// Done
+		return nil
+	})
+}
+
+func checkStoreIndex(index *Bucket, data *Bucket, records int, kind []byte, report *Report) error {
+	entries := 0
+	err := index.WithIterator(WithIteratorOptions{}, func(iter *Iterator) (bool, error) {
+		if iter.IsNestedBucket() {
+			return false, nil
+		}
+		entries += 1
+
+		id := iter.Value()
+		if err := iter.Err(); err != nil {
+			return true, err
+		}
+		message := ""
+		switch {
+		case bytes.Equal(kind, storeIndexBucket) && !bytes.HasSuffix(iter.Key(), id):
+			message = "entry key does not end with the record key"
+		case data.lookup(id) == nil:
+			message = fmt.Sprintf("entry references missing record %q", id)
+		}
+		if len(message) > 0 {
+			report.Findings = append(report.Findings, Finding{
+				Kind:    FindingStoreIndex,
+				Path:    cloneBytes(index.path),
+				Key:     iter.CopyKey(),
+				Message: message,
+			})
+		}
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+	if entries != records {
+		report.Findings = append(report.Findings, Finding{
+			Kind:    FindingStoreIndex,
+			Path:    cloneBytes(index.path),
+			Message: fmt.Sprintf("index has %d entries for %d records", entries, records),
+		})
+	}
+
+	// Done
+	return nil
+}
@@ -0,0 +1,183 @@
+// See the LICENSE file for license details.
+
+package boltdb_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mxmauro/boltdb/v3"
+)
+
+// -----------------------------------------------------------------------------
+
+type testUser struct {
+	ID      uint64    `boltdb:"id"`
+	Email   string    `boltdb:"unique"`
+	Country string    `boltdb:"index"`
+	Age     int       `boltdb:"index"`
+	Joined  time.Time `boltdb:"index"`
+	Name    string
+}
+
+// -----------------------------------------------------------------------------
+
+func TestStore(t *testing.T) {
+	db := openTestDb(t)
+	defer db.Close()
+
+	store, err := boltdb.NewStore[testUser]([]byte("app/users"))
+	if err != nil {
+		t.Fatalf("cannot create store [err=%v]", err.Error())
+	}
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	users := []testUser{
+		{Email: "alice@example.com", Country: "AR", Age: 31, Joined: base, Name: "Alice"},
+		{Email: "bob@example.com", Country: "US", Age: -1, Joined: base.Add(time.Hour), Name: "Bob"},
+		{Email: "carol@example.com", Country: "AR", Age: 25, Joined: base.Add(2 * time.Hour), Name: "Carol"},
+	}
+	err = db.WithinTx(boltdb.TxOptions{}, func(tx *boltdb.TX) error {
+		for idx := range users {
+			if err2 := store.Save(tx, &users[idx]); err2 != nil {
+				return err2
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("cannot save records [err=%v]", err.Error())
+	}
+	if users[0].ID != 1 || users[2].ID != 3 {
+		t.Fatalf("unexpected primary keys [got=%d,%d]", users[0].ID, users[2].ID)
+	}
+
+	// Unique indexes reject duplicates and the failed transaction leaves no trace.
+	err = db.WithinTx(boltdb.TxOptions{}, func(tx *boltdb.TX) error {
+		return store.Save(tx, &testUser{Email: "bob@example.com", Country: "UY"})
+	})
+	if !errors.Is(err, boltdb.ErrUniqueViolation) {
+		t.Fatalf("expected unique violation [got=%v]", err)
+	}
+
+	// A rejected insert caught by the caller neither assigns a primary key nor consumes the sequence.
+	err = db.WithinTx(boltdb.TxOptions{}, func(tx *boltdb.TX) error {
+		rejected := testUser{Email: "bob@example.com", Country: "UY"}
+		if err2 := store.Save(tx, &rejected); !errors.Is(err2, boltdb.ErrUniqueViolation) {
+			t.Errorf("expected unique violation [got=%v]", err2)
+		}
+		if rejected.ID != 0 {
+			t.Errorf("rejected record got a primary key [got=%d]", rejected.ID)
+		}
+		data, err2 := tx.Bucket([]byte("app/users/data"))
+		if err2 != nil {
+			return err2
+		}
+		if data.Sequence() != 3 {
+			t.Errorf("rejected record consumed the sequence [got=%d]", data.Sequence())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("cannot commit transaction [err=%v]", err.Error())
+	}
+
+	// A rejected update caught by the caller leaves the stored record and its indexes intact.
+	err = db.WithinTx(boltdb.TxOptions{}, func(tx *boltdb.TX) error {
+		carol, err2 := store.One(tx, "Email", "carol@example.com")
+		if err2 != nil {
+			return err2
+		}
+		carol.Country = "UY"
+		carol.Email = "bob@example.com"
+		if err2 = store.Save(tx, &carol); !errors.Is(err2, boltdb.ErrUniqueViolation) {
+			t.Errorf("expected unique violation [got=%v]", err2)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("cannot commit transaction [err=%v]", err.Error())
+	}
+	err = db.WithinTx(boltdb.TxOptions{ReadOnly: true}, func(tx *boltdb.TX) error {
+		if carol, err2 := store.One(tx, "Email", "carol@example.com"); err2 != nil || carol.Country != "AR" {
+			t.Errorf("unexpected record by unique index [got=%+v err=%v]", carol, err2)
+		}
+		if found, err2 := store.Find(tx, "Country", "UY"); err2 != nil || len(found) != 0 {
+			t.Errorf("unexpected indexed find result [got=%d err=%v]", len(found), err2)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("cannot query records [err=%v]", err.Error())
+	}
+
+	// Update a record changing indexed fields.
+	err = db.WithinTx(boltdb.TxOptions{}, func(tx *boltdb.TX) error {
+		bob, err2 := store.One(tx, "Email", "bob@example.com")
+		if err2 != nil {
+			return err2
+		}
+		bob.Country = "AR"
+		bob.Email = "robert@example.com"
+		return store.Save(tx, &bob)
+	})
+	if err != nil {
+		t.Fatalf("cannot update record [err=%v]", err.Error())
+	}
+
+	err = db.WithinTx(boltdb.TxOptions{ReadOnly: true}, func(tx *boltdb.TX) error {
+		if _, err2 := store.One(tx, "Email", "bob@example.com"); !errors.Is(err2, boltdb.ErrRecordNotFound) {
+			t.Errorf("expected old unique value to be released [got=%v]", err2)
+		}
+		if bob, err2 := store.One(tx, "ID", 2); err2 != nil || bob.Email != "robert@example.com" {
+			t.Errorf("unexpected record by id [got=%+v err=%v]", bob, err2)
+		}
+
+		found, err2 := store.Find(tx, "Country", "AR")
+		if err2 != nil || len(found) != 3 {
+			t.Errorf("unexpected indexed find result [got=%d err=%v]", len(found), err2)
+		}
+		found, err2 = store.Find(tx, "Name", "Carol")
+		if err2 != nil || len(found) != 1 || found[0].ID != 3 {
+			t.Errorf("unexpected scan find result [got=%+v err=%v]", found, err2)
+		}
+
+		found, err2 = store.Range(tx, "Age", -5, 30)
+		if err2 != nil || len(found) != 2 || found[0].Name != "Bob" || found[1].Name != "Carol" {
+			t.Errorf("unexpected age range result [got=%+v err=%v]", found, err2)
+		}
+		found, err2 = store.Range(tx, "Joined", base.Add(time.Hour), base.Add(5*time.Hour))
+		if err2 != nil || len(found) != 2 {
+			t.Errorf("unexpected time range result [got=%d err=%v]", len(found), err2)
+		}
+		if _, err2 = store.Range(tx, "Name", "A", "Z"); !errors.Is(err2, boltdb.ErrFieldNotIndexed) {
+			t.Errorf("expected range over a non-indexed field to fail [got=%v]", err2)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("cannot query records [err=%v]", err.Error())
+	}
+
+	err = db.WithinTx(boltdb.TxOptions{}, func(tx *boltdb.TX) error {
+		if err2 := store.Delete(tx, 1); err2 != nil {
+			return err2
+		}
+		all, err2 := store.All(tx)
+		if err2 != nil {
+			return err2
+		}
+		found, err2 := store.Find(tx, "Country", "AR")
+		if err2 != nil {
+			return err2
+		}
+		if len(all) != 2 || len(found) != 2 || all[0].ID != 2 {
+			t.Errorf("unexpected records after delete [all=%+v found=%d]", all, len(found))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("cannot delete record [err=%v]", err.Error())
+	}
+}
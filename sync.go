@@ -0,0 +1,540 @@
+// See the LICENSE file for license details.
+
+package boltdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Sync compares the digests of the source bucket with the ones of the destination. A differing range is
+// split in up to syncFanOut parts at pivot keys taken from the side having more keys, and only the parts
+// whose digests differ are visited. Once a range is small enough, it fetches the leaves of the destination
+// and applies the puts and deletes needed to converge.
+//
+// Through a stream, the destination side runs ServeSync and the source side queries it with a SyncClient.
+// Every request is a JSON message answered with a "result" message. The client ends the session with a
+// "done" message.
+
+// -----------------------------------------------------------------------------
+
+const (
+	syncRangeDigests = "digests"
+	syncPivots       = "pivots"
+	syncLeaves       = "leaves"
+	syncApply        = "apply"
+	syncDone         = "done"
+	syncResult       = "result"
+
+	defaultSyncLeafSize = 64
+	syncFanOut          = 16
+)
+
+// SyncPeer gives access to the destination database of a synchronization.
+type SyncPeer interface {
+	// RangeDigests returns the digests of the consecutive ranges of a bucket delimited by the given
+	// bounds. The first and last bounds can be nil to leave the ranges open.
+	RangeDigests(path []byte, bounds [][]byte) ([]RangeDigest, error)
+
+	// Pivots returns up to count-1 sorted keys splitting the range from start to end in parts holding
+	// about the same number of keys.
+	Pivots(path []byte, start []byte, end []byte, count int) ([][]byte, error)
+
+	// Leaves returns the leaves of the keys from start, inclusive, to end, exclusive.
+	Leaves(path []byte, start []byte, end []byte) ([]DigestLeaf, error)
+
+	// Apply applies a set of changes to a bucket, creating it if it does not exist.
+	Apply(path []byte, changes SyncChanges) error
+}
+
+// SyncChanges contains the changes sent to the destination of a synchronization. They are applied in
+// the order of the fields.
+type SyncChanges struct {
+	Deletes       [][]byte  `json:"deletes,omitempty"`
+	DeleteBuckets [][]byte  `json:"delete_buckets,omitempty"`
+	CreateBuckets [][]byte  `json:"create_buckets,omitempty"`
+	Puts          []SyncPut `json:"puts,omitempty"`
+}
+
+// SyncPut is a key/value pair stored by a synchronization.
+type SyncPut struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// SyncOptions specifies a set of options when synchronizing a bucket.
+type SyncOptions struct {
+	// LeafSize sets the number of keys below which a differing range is compared key by key instead of
+	// descending further. Defaults to 64.
+	LeafSize int
+}
+
+// SyncStats summarizes a synchronization.
+type SyncStats struct {
+	// Puts is the number of keys stored in the destination.
+	Puts uint64
+
+	// Deletes is the number of keys and nested buckets deleted from the destination.
+	Deletes uint64
+
+	// Requests is the number of requests sent to the destination.
+	Requests uint64
+}
+
+// SyncClient is a SyncPeer that forwards the requests to ServeSync through a stream. It is safe for
+// concurrent use.
+type SyncClient struct {
+	mtx sync.Mutex
+	enc *json.Encoder
+	dec *json.Decoder
+}
+
+type syncMessage struct {
+	Type    string        `json:"type"`
+	Path    []byte        `json:"path,omitempty"`
+	Start   []byte        `json:"start,omitempty"`
+	End     []byte        `json:"end,omitempty"`
+	Bounds  [][]byte      `json:"bounds,omitempty"`
+	Count   int           `json:"count,omitempty"`
+	Pivots  [][]byte      `json:"pivots,omitempty"`
+	Changes *SyncChanges  `json:"changes,omitempty"`
+	Digests []RangeDigest `json:"digests,omitempty"`
+	Leaves  []DigestLeaf  `json:"leaves,omitempty"`
+	Error   string        `json:"error,omitempty"`
+}
+
+type localSyncPeer struct {
+	db *DB
+}
+
+type syncer struct {
+	ctx   context.Context
+	peer  SyncPeer
+	opts  SyncOptions
+	stats SyncStats
+}
+
+// -----------------------------------------------------------------------------
+
+// Sync makes the given bucket of the destination database, including its nested buckets, equal to the
+// one of the source database. Only the differing keys are written or deleted, through regular
+// transactions. Buckets with encrypted keys are not supported.
+func Sync(src *DB, dst *DB, path []byte) (SyncStats, error) {
+	return SyncTo(context.Background(), src, path, localSyncPeer{db: dst}, SyncOptions{})
+}
+
+// SyncTo acts like Sync but the destination is accessed through the given peer.
+func SyncTo(ctx context.Context, src *DB, path []byte, peer SyncPeer, opts SyncOptions) (SyncStats, error) {
+	if opts.LeafSize <= 0 {
+		opts.LeafSize = defaultSyncLeafSize
+	}
+
+	s := syncer{
+		ctx:  ctx,
+		peer: peer,
+		opts: opts,
+	}
+	err := src.withDigestBucket(path, func(b *Bucket) error {
+		return s.syncBucket(b, path)
+	})
+
+	// Done
+	return s.stats, err
+}
+
+// ServeSync answers the requests of a SyncClient connected through the given stream, using the database
+// as the destination, until the client ends the session, the context is done or the stream fails.
+func ServeSync(ctx context.Context, db *DB, rw io.ReadWriter) error {
+	enc := json.NewEncoder(rw)
+	dec := json.NewDecoder(rw)
+	peer := localSyncPeer{db: db}
+
+	for {
+		var req syncMessage
+		var err error
+
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		err = dec.Decode(&req)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		resp := syncMessage{
+			Type: syncResult,
+		}
+		switch req.Type {
+		case syncRangeDigests:
+			resp.Digests, err = peer.RangeDigests(req.Path, req.Bounds)
+
+		case syncPivots:
+			resp.Pivots, err = peer.Pivots(req.Path, req.Start, req.End, req.Count)
+
+		case syncLeaves:
+			resp.Leaves, err = peer.Leaves(req.Path, req.Start, req.End)
+
+		case syncApply:
+			if req.Changes == nil {
+				return fmt.Errorf("%w: missing changes", ErrSyncProtocol)
+			}
+			err = peer.Apply(req.Path, *req.Changes)
+
+		case syncDone:
+			return nil
+
+		default:
+			return fmt.Errorf("%w: unexpected %q message", ErrSyncProtocol, req.Type)
+		}
+		if err != nil {
+			resp.Error = err.Error()
+		}
+		err = enc.Encode(&resp)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// NewSyncClient creates a SyncPeer that forwards the requests to ServeSync through the given stream.
+func NewSyncClient(rw io.ReadWriter) *SyncClient {
+	return &SyncClient{
+		enc: json.NewEncoder(rw),
+		dec: json.NewDecoder(rw),
+	}
+}
+
+// Close ends the session. It does not close the stream.
+func (c *SyncClient) Close() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.enc.Encode(&syncMessage{
+		Type: syncDone,
+	})
+}
+
+// RangeDigests implements SyncPeer.
+func (c *SyncClient) RangeDigests(path []byte, bounds [][]byte) ([]RangeDigest, error) {
+	resp, err := c.request(syncMessage{
+		Type:   syncRangeDigests,
+		Path:   path,
+		Bounds: bounds,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Digests) != len(bounds)-1 {
+		return nil, fmt.Errorf("%w: unexpected number of digests", ErrSyncProtocol)
+	}
+	return resp.Digests, nil
+}
+
+// Pivots implements SyncPeer.
+func (c *SyncClient) Pivots(path []byte, start []byte, end []byte, count int) ([][]byte, error) {
+	resp, err := c.request(syncMessage{
+		Type:  syncPivots,
+		Path:  path,
+		Start: start,
+		End:   end,
+		Count: count,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Pivots, nil
+}
+
+// Leaves implements SyncPeer.
+func (c *SyncClient) Leaves(path []byte, start []byte, end []byte) ([]DigestLeaf, error) {
+	resp, err := c.request(syncMessage{
+		Type:  syncLeaves,
+		Path:  path,
+		Start: start,
+		End:   end,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Leaves, nil
+}
+
+// Apply implements SyncPeer.
+func (c *SyncClient) Apply(path []byte, changes SyncChanges) error {
+	_, err := c.request(syncMessage{
+		Type:    syncApply,
+		Path:    path,
+		Changes: &changes,
+	})
+	return err
+}
+
+// -----------------------------------------------------------------------------
+
+func (c *SyncClient) request(req syncMessage) (syncMessage, error) {
+	var resp syncMessage
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	err := c.enc.Encode(&req)
+	if err != nil {
+		return resp, err
+	}
+	err = c.dec.Decode(&resp)
+	if err != nil {
+		return resp, err
+	}
+	if resp.Type != syncResult {
+		return resp, fmt.Errorf("%w: unexpected %q message", ErrSyncProtocol, resp.Type)
+	}
+	if resp.Error != "" {
+		return resp, fmt.Errorf("%w: %s", ErrSyncPeerFailed, resp.Error)
+	}
+
+	// Done
+	return resp, nil
+}
+
+func (p localSyncPeer) RangeDigests(path []byte, bounds [][]byte) ([]RangeDigest, error) {
+	var digests []RangeDigest
+
+	err := p.db.withDigestBucket(path, func(b *Bucket) error {
+		var err error
+
+		digests, err = rangeDigests(b, bounds)
+		return err
+	})
+
+	// Done
+	return digests, err
+}
+
+func (p localSyncPeer) Pivots(path []byte, start []byte, end []byte, count int) ([][]byte, error) {
+	var pivots [][]byte
+
+	err := p.db.withDigestBucket(path, func(b *Bucket) error {
+		var err error
+
+		pivots, err = rangePivots(b, start, end, count)
+		return err
+	})
+
+	// Done
+	return pivots, err
+}
+
+func (p localSyncPeer) Leaves(path []byte, start []byte, end []byte) ([]DigestLeaf, error) {
+	var leaves []DigestLeaf
+
+	err := p.db.withDigestBucket(path, func(b *Bucket) error {
+		return forEachLeaf(b, start, end, func(leaf DigestLeaf, _ []byte) error {
+			leaf.Key = cloneBytes(leaf.Key)
+			leaves = append(leaves, leaf)
+			return nil
+		})
+	})
+
+	// Done
+	return leaves, err
+}
+
+func (p localSyncPeer) Apply(path []byte, changes SyncChanges) error {
+	return p.db.WithinTx(TxOptions{}, func(tx *TX) error {
+		b, err := tx.Bucket(path)
+		if err != nil {
+			return err
+		}
+		for _, key := range changes.Deletes {
+			err = b.Delete(key)
+			if err != nil {
+				return err
+			}
+		}
+		for _, name := range changes.DeleteBuckets {
+			err = b.DeleteBucket(name)
+			if err != nil {
+				return err
+			}
+		}
+		for _, name := range changes.CreateBuckets {
+			_, err = b.Bucket(name)
+			if err != nil {
+				return err
+			}
+		}
+		for _, put := range changes.Puts {
+			err = b.Put(put.Key, put.Value)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Done
+		return nil
+	})
+}
+
+// syncBucket synchronizes a bucket of the source database, which is nil if it does not exist.
+func (s *syncer) syncBucket(b *Bucket, path []byte) error {
+	bounds := [][]byte{nil, nil}
+	local, err := rangeDigests(b, bounds)
+	if err != nil {
+		return err
+	}
+	s.stats.Requests += 1
+	remote, err := s.peer.RangeDigests(path, bounds)
+	if err != nil {
+		return err
+	}
+	return s.syncRange(b, path, local[0], remote[0])
+}
+
+// syncRange synchronizes the range covered by the local digest.
+func (s *syncer) syncRange(b *Bucket, path []byte, local RangeDigest, remote RangeDigest) error {
+	var pivots [][]byte
+	var err error
+
+	if err = s.ctx.Err(); err != nil {
+		return err
+	}
+	if bytes.Equal(local.Hash, remote.Hash) {
+		return nil
+	}
+	if local.Keys <= uint64(s.opts.LeafSize) && remote.Keys <= uint64(s.opts.LeafSize) {
+		return s.reconcile(b, path, local.Start, local.End)
+	}
+
+	// Split the range using the keys of the side having more of them, so every part is smaller.
+	if local.Keys >= remote.Keys {
+		pivots, err = rangePivots(b, local.Start, local.End, syncFanOut)
+	} else {
+		s.stats.Requests += 1
+		pivots, err = s.peer.Pivots(path, local.Start, local.End, syncFanOut)
+	}
+	if err != nil {
+		return err
+	}
+	if len(pivots) == 0 {
+		return s.reconcile(b, path, local.Start, local.End)
+	}
+	bounds := make([][]byte, 0, len(pivots)+2)
+	bounds = append(append(append(bounds, local.Start), pivots...), local.End)
+
+	// Descend into the parts.
+	localParts, err := rangeDigests(b, bounds)
+	if err != nil {
+		return err
+	}
+	s.stats.Requests += 1
+	remoteParts, err := s.peer.RangeDigests(path, bounds)
+	if err != nil {
+		return err
+	}
+	if len(remoteParts) != len(localParts) {
+		return fmt.Errorf("%w: unexpected number of digests", ErrSyncProtocol)
+	}
+	for idx := range localParts {
+		err = s.syncRange(b, path, localParts[idx], remoteParts[idx])
+		if err != nil {
+			return err
+		}
+	}
+
+	// Done
+	return nil
+}
+
+// reconcile compares the range key by key, sends the changes and synchronizes the differing nested
+// buckets.
+func (s *syncer) reconcile(b *Bucket, path []byte, start []byte, end []byte) error {
+	var changes SyncChanges
+	var nested [][]byte
+
+	s.stats.Requests += 1
+	leaves, err := s.peer.Leaves(path, start, end)
+	if err != nil {
+		return err
+	}
+	remote := make(map[string]DigestLeaf, len(leaves))
+	for _, leaf := range leaves {
+		remote[string(leaf.Key)] = leaf
+	}
+
+	err = forEachLeaf(b, start, end, func(leaf DigestLeaf, value []byte) error {
+		key := cloneBytes(leaf.Key)
+		r, found := remote[string(key)]
+		delete(remote, string(key))
+		if found && r.Bucket == leaf.Bucket && bytes.Equal(r.Hash, leaf.Hash) {
+			return nil
+		}
+
+		if leaf.Bucket {
+			if found && !r.Bucket {
+				changes.Deletes = append(changes.Deletes, key)
+			}
+			if !found || !r.Bucket {
+				changes.CreateBuckets = append(changes.CreateBuckets, key)
+			}
+			nested = append(nested, key)
+		} else {
+			if found && r.Bucket {
+				changes.DeleteBuckets = append(changes.DeleteBuckets, key)
+			}
+			changes.Puts = append(changes.Puts, SyncPut{
+				Key:   key,
+				Value: cloneBytes(value),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Keys only present in the destination.
+	for _, leaf := range leaves {
+		if _, found := remote[string(leaf.Key)]; !found {
+			continue
+		}
+		if leaf.Bucket {
+			changes.DeleteBuckets = append(changes.DeleteBuckets, leaf.Key)
+		} else {
+			changes.Deletes = append(changes.Deletes, leaf.Key)
+		}
+	}
+
+	if len(changes.Deletes) > 0 || len(changes.DeleteBuckets) > 0 || len(changes.CreateBuckets) > 0 ||
+		len(changes.Puts) > 0 {
+		s.stats.Requests += 1
+		err = s.peer.Apply(path, changes)
+		if err != nil {
+			return err
+		}
+		s.stats.Puts += uint64(len(changes.Puts))
+		s.stats.Deletes += uint64(len(changes.Deletes) + len(changes.DeleteBuckets))
+	}
+
+	// Synchronize the nested buckets.
+	for _, name := range nested {
+		child, err := b.Bucket(name)
+		if err != nil {
+			return err
+		}
+		err = s.syncBucket(child, joinPath([][]byte{path, name}))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Done
+	return nil
+}
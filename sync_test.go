@@ -0,0 +1,168 @@
+// See the LICENSE file for license details.
+
+package boltdb_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/mxmauro/boltdb/v3"
+)
+
+// -----------------------------------------------------------------------------
+
+func TestSync(t *testing.T) {
+	dir := t.TempDir()
+	openDb := func(name string) *boltdb.DB {
+		db, err := boltdb.New(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("cannot create database [err=%v]", err.Error())
+		}
+		t.Cleanup(db.Close)
+		return db
+	}
+	put := func(db *boltdb.DB, bucket string, key string, value string) {
+		if err := db.Put([]byte(bucket), []byte(key), []byte(value)); err != nil {
+			t.Fatalf("cannot store key [err=%v]", err)
+		}
+	}
+	checkDigests := func(src *boltdb.DB, dst *boltdb.DB, equal bool) {
+		srcDigest, err := src.Digest([]byte("data"))
+		if err != nil {
+			t.Fatalf("cannot compute digest [err=%v]", err)
+		}
+		dstDigest, err := dst.Digest([]byte("data"))
+		if err != nil {
+			t.Fatalf("cannot compute digest [err=%v]", err)
+		}
+		if bytes.Equal(srcDigest, dstDigest) != equal {
+			t.Fatalf("unexpected digests [src=%x dst=%x]", srcDigest, dstDigest)
+		}
+	}
+
+	src := openDb("src.db")
+	dst := openDb("dst.db")
+	for idx := 0; idx < 500; idx++ {
+		key := fmt.Sprintf("key-%03d", idx)
+		put(src, "data", key, "value")
+		put(dst, "data", key, "value")
+	}
+	checkDigests(src, dst, true)
+
+	// Introduce some differences.
+	put(src, "data", "key-042", "changed")
+	put(src, "data", "key-500", "new")
+	put(src, "data/nested", "a", "1")
+	put(dst, "data", "extra", "x")
+	put(dst, "data/stale", "a", "1")
+	if err := dst.Delete([]byte("data"), []byte("key-100")); err != nil {
+		t.Fatalf("cannot delete key [err=%v]", err)
+	}
+	put(dst, "data/key-100/inner", "a", "1")
+	checkDigests(src, dst, false)
+
+	stats, err := boltdb.Sync(src, dst, []byte("data"))
+	if err != nil {
+		t.Fatalf("cannot sync databases [err=%v]", err)
+	}
+	if stats.Puts != 4 || stats.Deletes != 3 {
+		t.Fatalf("unexpected sync stats [stats=%+v]", stats)
+	}
+	checkDigests(src, dst, true)
+	value, err := dst.Get([]byte("data/nested"), []byte("a"))
+	if err != nil || string(value) != "1" {
+		t.Fatalf("unexpected nested value [value=%q err=%v]", value, err)
+	}
+
+	// Synchronizing equal buckets exchanges a single digest.
+	stats, err = boltdb.Sync(src, dst, []byte("data"))
+	if err != nil {
+		t.Fatalf("cannot sync databases [err=%v]", err)
+	}
+	if stats.Puts != 0 || stats.Deletes != 0 || stats.Requests != 1 {
+		t.Fatalf("unexpected sync stats [stats=%+v]", stats)
+	}
+
+	// Synchronize through a stream.
+	remote := openDb("remote.db")
+	put(remote, "data", "key-250", "old")
+	srcConn, dstConn := net.Pipe()
+	defer func() {
+		_ = srcConn.Close()
+		_ = dstConn.Close()
+	}()
+	done := make(chan error, 1)
+	go func() {
+		done <- boltdb.ServeSync(context.Background(), remote, dstConn)
+	}()
+	client := boltdb.NewSyncClient(srcConn)
+	stats, err = boltdb.SyncTo(context.Background(), src, []byte("data"), client, boltdb.SyncOptions{LeafSize: 8})
+	if err != nil {
+		t.Fatalf("cannot sync databases [err=%v]", err)
+	}
+	if stats.Puts != 502 || stats.Deletes != 0 {
+		t.Fatalf("unexpected sync stats [stats=%+v]", stats)
+	}
+	if err = client.Close(); err != nil {
+		t.Fatalf("cannot close sync session [err=%v]", err)
+	}
+	if err = <-done; err != nil {
+		t.Fatalf("sync server failed [err=%v]", err)
+	}
+	checkDigests(src, remote, true)
+}
+
+func TestSyncSharedPrefixes(t *testing.T) {
+	dir := t.TempDir()
+	src, err := boltdb.New(filepath.Join(dir, "src.db"))
+	if err != nil {
+		t.Fatalf("cannot create database [err=%v]", err.Error())
+	}
+	defer src.Close()
+	dst, err := boltdb.New(filepath.Join(dir, "dst.db"))
+	if err != nil {
+		t.Fatalf("cannot create database [err=%v]", err.Error())
+	}
+	defer dst.Close()
+
+	// Keys sharing a long prefix must not make the comparison descend one byte at a time.
+	for _, db := range []*boltdb.DB{src, dst} {
+		err = db.WithinTx(boltdb.TxOptions{}, func(tx *boltdb.TX) error {
+			b, err2 := tx.Bucket([]byte("data"))
+			if err2 != nil {
+				return err2
+			}
+			for idx := 0; idx < 4000; idx++ {
+				err2 = b.Put([]byte(fmt.Sprintf("tenant/0000000000000001/item-%06d", idx)), []byte("value"))
+				if err2 != nil {
+					return err2
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("cannot seed database [err=%v]", err)
+		}
+	}
+	key := []byte("tenant/0000000000000001/item-002500")
+	if err = src.Put([]byte("data"), key, []byte("changed")); err != nil {
+		t.Fatalf("cannot store key [err=%v]", err)
+	}
+
+	digest, err := src.RangeDigest([]byte("data"), key, []byte("tenant/0000000000000001/item-002600"))
+	if err != nil || digest.Keys != 100 {
+		t.Fatalf("unexpected range digest [digest=%+v err=%v]", digest, err)
+	}
+
+	stats, err := boltdb.Sync(src, dst, []byte("data"))
+	if err != nil {
+		t.Fatalf("cannot sync databases [err=%v]", err)
+	}
+	if stats.Puts != 1 || stats.Deletes != 0 || stats.Requests > 6 {
+		t.Fatalf("unexpected sync stats [stats=%+v]", stats)
+	}
+}
@@ -2,6 +2,8 @@ package boltdb
 
 import (
 	"errors"
+	"fmt"
+	"time"
 
 	"go.etcd.io/bbolt"
 	bolterrors "go.etcd.io/bbolt/errors"
@@ -11,14 +13,40 @@ import (
 
 // TX represents a transaction within the database.
 type TX struct {
-	db       *DB
-	readOnly bool
-	tx       *bbolt.Tx
+	db          *DB
+	readOnly    bool
+	tx          *bbolt.Tx
+	start       time.Time
+	wait        time.Duration
+	ended       bool
+	keysScanned int64
+
+	journal         []journalEntry
+	savepoints      []uint64
+	nextSavepointID uint64
+
+	historyID   uint64
+	historyTime time.Time
+
+	actor     string
+	reason    string
+	acl       *aclPolicy
+	principal string
+
+	// root is the bucket path of the namespace the transaction is confined to, if any.
+	root []byte
 }
 
 // TxOptions specifies a set of options when starting a transaction.
 type TxOptions struct {
 	ReadOnly bool
+
+	// Principal identifies who runs the transaction when access control is enabled.
+	Principal string
+
+	// Actor and Reason are stored in the audit entries of the changes made by the transaction.
+	Actor  string
+	Reason string
 }
 
 // WithinTxCallback is a callback to be called after the transaction is initiated.
@@ -28,16 +56,28 @@ type WithinTxCallback func(tx *TX) error
 
 // Commit stores the transaction changes into the database and, on success, ends the operation.
 func (tx *TX) Commit() error {
+	var err error
+
+	commitStart := time.Now()
 	if tx.readOnly {
 		_ = tx.tx.Rollback()
-		return nil
+	} else {
+		err = tx.tx.Commit()
 	}
-	return tx.tx.Commit()
+	if !tx.ended {
+		tx.ended = true
+		tx.notifyEnd(TxCommitted, commitStart, err)
+	}
+	return err
 }
 
 // Rollback discards the transaction changes and ends the operation.
 func (tx *TX) Rollback() {
 	_ = tx.tx.Rollback()
+	if !tx.ended {
+		tx.ended = true
+		tx.notifyEnd(TxRolledBack, time.Time{}, nil)
+	}
 }
 
 // DB gets the database this transaction belongs to.
@@ -56,6 +96,10 @@ func (tx *TX) Bucket(path []byte) (*Bucket, error) {
 	var b *bbolt.Bucket
 
 	// Parse path.
+	path, err := tx.rootedPath(path)
+	if err != nil {
+		return nil, err
+	}
 	pi, err := newPathIterator(path)
 	if err != nil {
 		return nil, err
@@ -63,7 +107,22 @@ func (tx *TX) Bucket(path []byte) (*Bucket, error) {
 
 	// Get/create the top bucket.
 	pathFragment, lastFragment := pi.fragment()
+	if isReservedBucketName(pathFragment) {
+		return nil, fmt.Errorf("%w: reserved bucket name", ErrInvalidPath)
+	}
+	if tx.acl != nil {
+		err = tx.checkPermission(splitBucketFragments(path), PermissionRead)
+		if err != nil {
+			return nil, err
+		}
+	}
 	if !tx.readOnly {
+		if tx.tracksBuckets() && tx.tx.Bucket(pathFragment) == nil {
+			err = tx.onBucketCreated([][]byte{pathFragment})
+			if err != nil {
+				return nil, err
+			}
+		}
 		b, err = tx.tx.CreateBucketIfNotExists(pathFragment)
 		if err != nil {
 			return nil, err
@@ -77,9 +136,16 @@ func (tx *TX) Bucket(path []byte) (*Bucket, error) {
 
 	// Get/create nested bucket(s) if multiple path fragments.
 	bucketName := pathFragment
+	bucketPath := cloneBytes(pathFragment)
 	for !lastFragment {
 		pathFragment, lastFragment = pi.fragment()
 		if !tx.readOnly {
+			if tx.tracksBuckets() && b.Bucket(pathFragment) == nil {
+				err = tx.onBucketCreated(append(splitBucketFragments(bucketPath), pathFragment))
+				if err != nil {
+					return nil, err
+				}
+			}
 			b, err = b.CreateBucketIfNotExists(pathFragment)
 			if err != nil {
 				return nil, err
@@ -92,17 +158,39 @@ func (tx *TX) Bucket(path []byte) (*Bucket, error) {
 		}
 
 		bucketName = pathFragment
+		bucketPath = append(append(bucketPath, '/'), pathFragment...)
 	}
 
+	// Done
+	return tx.newBucket(bucketName, bucketPath, b), nil
+}
+
+// Iterate creates an iterator object that traverses the top-level buckets of the database or, if the
+// transaction belongs to a namespace, the buckets inside it.
+// NOTE: Top-level entries are always buckets, so the iterator value is always nil.
+func (tx *TX) Iterate() *Iterator {
 	// Create a wrapper.
-	bucket := &Bucket{
-		tx:   tx,
-		name: bucketName,
-		b:    b,
+	iter := Iterator{
+		tx:     tx,
+		cursor: tx.tx.Cursor(),
+	}
+	if tx.root != nil {
+		if b := tx.rawBucket(splitBucketFragments(tx.root)); b != nil {
+			iter.cursor = b.Cursor()
+		} else {
+			iter.empty = true
+		}
 	}
 
 	// Done
-	return bucket, nil
+	return &iter
+}
+
+// WithIterator creates an iterator object that traverses the top-level buckets of the database or, if the
+// transaction belongs to a namespace, the buckets inside it.
+// NOTE: Prefix and FirstKey cannot be used at the same time.
+func (tx *TX) WithIterator(opts WithIteratorOptions, cb WithinIteratorCallback) error {
+	return withIterator(tx.Iterate(), opts, cb)
 }
 
 // DeleteBucket removes an existing child bucket from the database, including nested buckets and stored keys.
@@ -113,14 +201,27 @@ func (tx *TX) DeleteBucket(path []byte) error {
 	}
 
 	// Parse path.
+	path, err := tx.rootedPath(path)
+	if err != nil {
+		return err
+	}
 	pi, err := newPathIterator(path)
 	if err != nil {
 		return err
 	}
 
 	pathFragment, lastFragment := pi.fragment()
+	if isReservedBucketName(pathFragment) {
+		return fmt.Errorf("%w: reserved bucket name", ErrInvalidPath)
+	}
 	if lastFragment {
 		// If it is the last fragment, then delete the top bucket.
+		if tx.tracksBuckets() {
+			err = tx.onBucketDeleted([][]byte{pathFragment}, tx.tx.Bucket(pathFragment))
+			if err != nil {
+				return err
+			}
+		}
 		err = tx.tx.DeleteBucket(pathFragment)
 	} else {
 		// Else get the nested bucket.
@@ -141,6 +242,12 @@ func (tx *TX) DeleteBucket(path []byte) error {
 		}
 
 		// And delete it.
+		if tx.tracksBuckets() {
+			err = tx.onBucketDeleted(splitBucketFragments(path), b.Bucket(pathFragment))
+			if err != nil {
+				return err
+			}
+		}
 		err = b.DeleteBucket(pathFragment)
 	}
 
@@ -150,3 +257,101 @@ func (tx *TX) DeleteBucket(path []byte) error {
 	}
 	return err
 }
+
+// rootedPath returns the given path located inside the namespace of the transaction, if any.
+func (tx *TX) rootedPath(path []byte) ([]byte, error) {
+	if tx.root == nil {
+		return path, nil
+	}
+	_, err := newPathIterator(path)
+	if err != nil {
+		return nil, err
+	}
+	rooted := make([]byte, 0, len(tx.root)+1+len(path))
+	rooted = append(append(append(rooted, tx.root...), '/'), path...)
+	return rooted, nil
+}
+
+func (tx *TX) newBucket(name []byte, path []byte, b *bbolt.Bucket) *Bucket {
+	bucket := &Bucket{
+		tx:   tx,
+		name: name,
+		path: path,
+		b:    b,
+	}
+	bucket.codec, bucket.keys = tx.db.bucketCodecs(path)
+	if pattern := tx.db.bucketPattern(path); pattern != nil {
+		bucket.versioned = pattern.opts.Versioned
+		bucket.history = pattern.opts.History
+	}
+	return bucket
+}
+
+// existingBucket acts like Bucket, including the access checks, but never creates buckets. It returns nil
+// if the bucket does not exist.
+func (tx *TX) existingBucket(path []byte) (*Bucket, error) {
+	if tx.readOnly {
+		b, err := tx.Bucket(path)
+		if err != nil {
+			if errors.Is(err, ErrBucketNotFound) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		return b, nil
+	}
+
+	path, err := tx.rootedPath(path)
+	if err != nil {
+		return nil, err
+	}
+	fragments, err := splitPath(path)
+	if err != nil {
+		return nil, err
+	}
+	if isReservedBucketName(fragments[0]) {
+		return nil, fmt.Errorf("%w: reserved bucket name", ErrInvalidPath)
+	}
+	err = tx.checkPermission(fragments, PermissionRead)
+	if err != nil {
+		return nil, err
+	}
+	return tx.bucketFromFragments(fragments), nil
+}
+
+// bucketFromFragments returns the bucket located at the given path fragments or nil if it does not exist.
+// Unlike Bucket, it never creates buckets and accepts names containing slashes.
+// childBucket returns the child bucket with the given raw name of the bucket with the given fragments,
+// checking read access. The parent is nil for top-level buckets.
+func (tx *TX) childBucket(fragments [][]byte, parent *bbolt.Bucket, name []byte) (*Bucket, error) {
+	var b *bbolt.Bucket
+
+	fragments = appendPath(fragments, cloneBytes(name))
+	err := tx.checkPermission(fragments, PermissionRead)
+	if err != nil {
+		return nil, err
+	}
+	if parent != nil {
+		b = parent.Bucket(name)
+	} else {
+		b = tx.tx.Bucket(name)
+	}
+	if b == nil {
+		return nil, ErrBucketNotFound
+	}
+	return tx.newBucket(fragments[len(fragments)-1], joinPath(fragments), b), nil
+}
+
+func (tx *TX) bucketFromFragments(fragments [][]byte) *Bucket {
+	if len(fragments) == 0 {
+		return nil
+	}
+	b := tx.tx.Bucket(fragments[0])
+	for idx := 1; b != nil && idx < len(fragments); idx++ {
+		b = b.Bucket(fragments[idx])
+	}
+	if b == nil {
+		return nil
+	}
+	return tx.newBucket(fragments[len(fragments)-1], joinPath(fragments), b)
+}
@@ -0,0 +1,330 @@
+// See the LICENSE file for license details.
+
+package boltdb
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+
+	"go.etcd.io/bbolt"
+)
+
+// -----------------------------------------------------------------------------
+
+var metaUsageBucket = []byte("usage")
+
+// Usage contains the number of keys, and their size as stored, of a bucket and its nested buckets.
+type Usage struct {
+	Keys  uint64
+	Bytes uint64
+}
+
+// usageRecord is the tracked usage of a bucket path along with its quota.
+type usageRecord struct {
+	usage Usage
+	quota Quota
+}
+
+// usageChange is a change to the usage of a bucket. Sizes are zero except when storing a key.
+type usageChange struct {
+	keys      int64
+	bytes     int64
+	keySize   int
+	valueSize int
+}
+
+const usageRecordSize = 48
+
+// -----------------------------------------------------------------------------
+
+func decodeUsageRecord(data []byte) usageRecord {
+	var record usageRecord
+
+	if len(data) >= 32 {
+		record.usage.Keys = binary.BigEndian.Uint64(data[0:])
+		record.usage.Bytes = binary.BigEndian.Uint64(data[8:])
+		record.quota.MaxKeys = binary.BigEndian.Uint64(data[16:])
+		record.quota.MaxBytes = binary.BigEndian.Uint64(data[24:])
+	}
+	// Records stored before key and value size limits existed are shorter.
+	if len(data) >= usageRecordSize {
+		record.quota.MaxKeySize = binary.BigEndian.Uint64(data[32:])
+		record.quota.MaxValueSize = binary.BigEndian.Uint64(data[40:])
+	}
+	return record
+}
+
+func (record *usageRecord) encode() []byte {
+	data := make([]byte, 0, usageRecordSize)
+	data = binary.BigEndian.AppendUint64(data, record.usage.Keys)
+	data = binary.BigEndian.AppendUint64(data, record.usage.Bytes)
+	data = binary.BigEndian.AppendUint64(data, record.quota.MaxKeys)
+	data = binary.BigEndian.AppendUint64(data, record.quota.MaxBytes)
+	data = binary.BigEndian.AppendUint64(data, record.quota.MaxKeySize)
+	data = binary.BigEndian.AppendUint64(data, record.quota.MaxValueSize)
+	return data
+}
+
+// apply applies a change to the usage and returns the name of the exceeded limit, if any. Changes that
+// reduce the usage never fail.
+func (record *usageRecord) apply(change usageChange) string {
+	quota := &record.quota
+	if quota.MaxKeySize > 0 && uint64(change.keySize) > quota.MaxKeySize {
+		return "key size"
+	}
+	if quota.MaxValueSize > 0 && uint64(change.valueSize) > quota.MaxValueSize {
+		return "value size"
+	}
+	record.usage.Keys = addDelta(record.usage.Keys, change.keys)
+	record.usage.Bytes = addDelta(record.usage.Bytes, change.bytes)
+	if change.keys > 0 && quota.MaxKeys > 0 && record.usage.Keys > quota.MaxKeys {
+		return "keys"
+	}
+	if change.bytes > 0 && quota.MaxBytes > 0 && record.usage.Bytes > quota.MaxBytes {
+		return "bytes"
+	}
+	return ""
+}
+
+func addDelta(value uint64, delta int64) uint64 {
+	if delta >= 0 {
+		return value + uint64(delta)
+	}
+	if uint64(-delta) > value {
+		return 0
+	}
+	return value - uint64(-delta)
+}
+
+// usageBucket returns the bucket containing the usage records or nil if no path is being tracked.
+func (tx *TX) usageBucket() *bbolt.Bucket {
+	meta := tx.tx.Bucket(metaBucketName)
+	if meta == nil {
+		return nil
+	}
+	return meta.Bucket(metaUsageBucket)
+}
+
+// tracksUsage returns true if the usage of some bucket path is being tracked.
+func (tx *TX) tracksUsage() bool {
+	return !tx.readOnly && tx.usageBucket() != nil
+}
+
+// usage returns the usage of the bucket with the given path fragments. If it is not tracked, it is computed.
+func (tx *TX) usage(fragments [][]byte) Usage {
+	if usage := tx.usageBucket(); usage != nil {
+		if stored := usage.Get(joinPath(fragments)); stored != nil {
+			return decodeUsageRecord(stored).usage
+		}
+	}
+	return countUsage(tx.rawBucket(fragments))
+}
+
+// setQuota starts tracking the usage of the bucket with the given path fragments, if not done yet, and
+// sets its quota. A nil quota keeps the current one.
+func (tx *TX) setQuota(fragments [][]byte, quota *Quota) error {
+	usage, err := tx.metaSubBucket(metaUsageBucket)
+	if err != nil {
+		return err
+	}
+
+	path := joinPath(fragments)
+	record := usageRecord{}
+	if stored := usage.Get(path); stored != nil {
+		record = decodeUsageRecord(stored)
+		if quota == nil || record.quota == *quota {
+			return nil
+		}
+	} else {
+		record.usage = countUsage(tx.rawBucket(fragments))
+	}
+	if quota != nil {
+		record.quota = *quota
+	}
+	tx.journalRawKey([][]byte{metaBucketName, metaUsageBucket}, usage, path)
+
+	// Done
+	return tx.writeMeta(metaUsageBucket, usage, path, record.encode())
+}
+
+// hasQuota returns true if the usage of the bucket with the given path fragments is already tracked
+// with the given quota, or with any quota if it is nil.
+func (tx *TX) hasQuota(fragments [][]byte, quota *Quota) bool {
+	usage := tx.usageBucket()
+	if usage == nil {
+		return false
+	}
+	stored := usage.Get(joinPath(fragments))
+	if stored == nil {
+		return false
+	}
+	return quota == nil || decodeUsageRecord(stored).quota == *quota
+}
+
+// updateUsage applies a change to the tracked usage of the bucket with the given path fragments and all
+// its parents. It fails with ErrQuotaExceeded if the change exceeds a limit, in which case no record is
+// modified.
+func (tx *TX) updateUsage(usage *bbolt.Bucket, fragments [][]byte, change usageChange) error {
+	if change == (usageChange{}) {
+		return nil
+	}
+
+	// Check every limit before saving anything.
+	paths := make([][]byte, 0, len(fragments))
+	records := make([]usageRecord, 0, len(fragments))
+	for idx := 1; idx <= len(fragments); idx++ {
+		path := joinPath(fragments[:idx])
+		stored := usage.Get(path)
+		if stored == nil {
+			continue
+		}
+
+		record := decodeUsageRecord(stored)
+		if limit := record.apply(change); len(limit) > 0 {
+			return fmt.Errorf("%w [path=%s limit=%s]", ErrQuotaExceeded, path, limit)
+		}
+		paths = append(paths, path)
+		records = append(records, record)
+	}
+
+	for idx, path := range paths {
+		tx.journalRawKey([][]byte{metaBucketName, metaUsageBucket}, usage, path)
+		err := tx.writeMeta(metaUsageBucket, usage, path, records[idx].encode())
+		if err != nil {
+			return err
+		}
+	}
+
+	// Done
+	return nil
+}
+
+// untrackBucket updates the tracked usage before a bucket is deleted. Parents lose its contents and
+// nested tracked paths are reset.
+func (tx *TX) untrackBucket(fragments [][]byte, b *bbolt.Bucket) error {
+	usage := tx.usageBucket()
+	if usage == nil {
+		return nil
+	}
+
+	var nested [][]byte
+
+	c := usage.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		tracked, err := splitPath(k)
+		if err == nil && len(tracked) > len(fragments) && hasPathPrefix(tracked, fragments) {
+			nested = append(nested, cloneBytes(k))
+		}
+	}
+	for _, path := range nested {
+		record := decodeUsageRecord(usage.Get(path))
+		record.usage = Usage{}
+		tx.journalRawKey([][]byte{metaBucketName, metaUsageBucket}, usage, path)
+		err := tx.writeMeta(metaUsageBucket, usage, path, record.encode())
+		if err != nil {
+			return err
+		}
+	}
+
+	total := countUsage(b)
+	return tx.updateUsage(usage, fragments, usageChange{
+		keys:  -int64(total.Keys),
+		bytes: -int64(total.Bytes),
+	})
+}
+
+// trackPut updates the tracked usage before a value is stored using the first raw key and the other
+// ones are removed. The key and value sizes are the ones before encoding.
+func (bucket *Bucket) trackPut(rawKeys [][]byte, stored []byte, keySize int, valueSize int) error {
+	usage := bucket.tx.usageBucket()
+	if usage == nil {
+		return nil
+	}
+
+	change := usageChange{
+		keys:      1,
+		bytes:     int64(len(rawKeys[0]) + len(stored)),
+		keySize:   keySize,
+		valueSize: valueSize,
+	}
+	for _, rawKey := range rawKeys {
+		if old := bucket.b.Get(rawKey); old != nil {
+			change.keys -= 1
+			change.bytes -= int64(len(rawKey) + len(old))
+		}
+	}
+	return bucket.tx.updateUsage(usage, bucket.fragments(), change)
+}
+
+// trackDelete updates the tracked usage before the given raw keys are removed.
+func (bucket *Bucket) trackDelete(rawKeys [][]byte) error {
+	usage := bucket.tx.usageBucket()
+	if usage == nil {
+		return nil
+	}
+
+	change := usageChange{}
+	for _, rawKey := range rawKeys {
+		if old := bucket.b.Get(rawKey); old != nil {
+			change.keys -= 1
+			change.bytes -= int64(len(rawKey) + len(old))
+		}
+	}
+	return bucket.tx.updateUsage(usage, bucket.fragments(), change)
+}
+
+// countUsage computes the usage of a bucket by walking it. A nil bucket is empty.
+func countUsage(b *bbolt.Bucket) Usage {
+	var total Usage
+
+	if b == nil {
+		return total
+	}
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if v == nil {
+			nested := countUsage(b.Bucket(k))
+			total.Keys += nested.Keys
+			total.Bytes += nested.Bytes
+			continue
+		}
+		total.Keys += 1
+		total.Bytes += uint64(len(k) + len(v))
+	}
+	return total
+}
+
+// checkUsage verifies the tracked usage counters match the content of their buckets.
+func checkUsage(ctx context.Context, tx *TX, report *Report) error {
+	usage := tx.usageBucket()
+	if usage == nil {
+		return nil
+	}
+
+	c := usage.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		err := ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		fragments, err := splitPath(k)
+		if err != nil {
+			continue
+		}
+		tracked := decodeUsageRecord(v).usage
+		actual := countUsage(tx.rawBucket(fragments))
+		if tracked != actual {
+			report.Findings = append(report.Findings, Finding{
+				Kind: FindingUsage,
+				Path: cloneBytes(k),
+				Message: fmt.Sprintf("tracked usage is %d keys and %d bytes but the bucket has %d keys and %d bytes",
+					tracked.Keys, tracked.Bytes, actual.Keys, actual.Bytes),
+			})
+		}
+	}
+
+	// Done
+	return nil
+}
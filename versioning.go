@@ -0,0 +1,133 @@
+// See the LICENSE file for license details.
+
+package boltdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// -----------------------------------------------------------------------------
+
+const versionHeaderSize = 8
+
+var metaVersionClockKey = []byte("version_clock")
+
+// -----------------------------------------------------------------------------
+
+// Version identifies a revision of a value stored in a versioned bucket. Zero means the key does not exist.
+//
+// Versions are taken from a database-wide counter, so a key that is deleted and created again never gets
+// a version it had before.
+type Version uint64
+
+// ETag returns the version formatted as a strong HTTP entity tag, including the quotes.
+func (v Version) ETag() string {
+	return `"` + strconv.FormatUint(uint64(v), 36) + `"`
+}
+
+// String returns a textual representation of the version.
+func (v Version) String() string {
+	return strconv.FormatUint(uint64(v), 10)
+}
+
+// ParseETag parses an entity tag created by Version.ETag. Weak tags and tags without quotes are accepted.
+func ParseETag(etag string) (Version, error) {
+	s := strings.TrimPrefix(strings.TrimSpace(etag), "W/")
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		s = s[1 : len(s)-1]
+	}
+	v, err := strconv.ParseUint(s, 36, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid entity tag %q", etag)
+	}
+	return Version(v), nil
+}
+
+// -----------------------------------------------------------------------------
+
+// GetVersioned returns a copy of the value of a key and its version. If the key is not found, the value
+// is nil and the version zero. The bucket must be configured as versioned.
+func (bucket *Bucket) GetVersioned(key []byte) ([]byte, Version, error) {
+	if !bucket.versioned {
+		return nil, 0, ErrBucketNotVersioned
+	}
+
+	payload, err := bucket.getPayload(key)
+	if err != nil || payload == nil {
+		return nil, 0, err
+	}
+	version, value, err := splitVersion(payload)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Done
+	return cloneBytes(value), version, nil
+}
+
+// PutIfVersion stores a key/value pair only if the current version of the key matches the expected one
+// and returns the new version. Use zero to store the key only if it does not exist. If the versions do
+// not match, it fails with ErrVersionConflict. The bucket must be configured as versioned.
+func (bucket *Bucket) PutIfVersion(key []byte, value []byte, expected Version) (Version, error) {
+	if !bucket.versioned {
+		return 0, ErrBucketNotVersioned
+	}
+
+	_, current, err := bucket.GetVersioned(key)
+	if err != nil {
+		return 0, err
+	}
+	if current != expected {
+		return 0, fmt.Errorf("%w [expected=%v current=%v]", ErrVersionConflict, expected, current)
+	}
+
+	// Done
+	return bucket.put(key, value)
+}
+
+// -----------------------------------------------------------------------------
+
+// nextVersion advances the database-wide version counter past both its current value and the current
+// version of the key being written, and returns the new value. The counter may lag behind the stored
+// versions when the data was copied from another database, for e.g. by a replica or Sync.
+func (tx *TX) nextVersion(current Version) (Version, error) {
+	if tx.readOnly {
+		return 0, ErrTxNotWritable
+	}
+	meta, err := tx.metaBucket()
+	if err != nil {
+		return 0, err
+	}
+
+	var version uint64
+	if value := meta.Get(metaVersionClockKey); value != nil {
+		version = DecodeUint64(value)
+	}
+	version = max(version, uint64(current)) + 1
+	err = tx.writeMeta(nil, meta, metaVersionClockKey, EncodeUint64(version))
+	if err != nil {
+		return 0, err
+	}
+
+	// Done
+	return Version(version), nil
+}
+
+// appendVersion returns the payload stored in versioned buckets, which is the version followed by the
+// value. The payload is what the value codecs receive.
+func appendVersion(version Version, value []byte) []byte {
+	payload := make([]byte, versionHeaderSize, versionHeaderSize+len(value))
+	binary.BigEndian.PutUint64(payload, uint64(version))
+	return append(payload, value...)
+}
+
+func splitVersion(payload []byte) (Version, []byte, error) {
+	if len(payload) < versionHeaderSize {
+		return 0, nil, errors.New("invalid versioned value")
+	}
+	return Version(binary.BigEndian.Uint64(payload)), payload[versionHeaderSize:], nil
+}
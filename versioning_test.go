@@ -0,0 +1,203 @@
+// See the LICENSE file for license details.
+
+package boltdb_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/mxmauro/boltdb/v3"
+	"go.etcd.io/bbolt"
+)
+
+// -----------------------------------------------------------------------------
+
+func TestVersionedBuckets(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	db, err := boltdb.NewWithOptions(filename, boltdb.Options{
+		Buckets: []boltdb.BucketOptions{
+			{Path: "docs", Versioned: true},
+		},
+	})
+	if err != nil {
+		t.Fatalf("cannot create test database [err=%v]", err.Error())
+	}
+	defer db.Close()
+
+	if err = db.Put([]byte("docs"), []byte("a"), []byte("first")); err != nil {
+		t.Fatalf("cannot write to test database [err=%v]", err.Error())
+	}
+
+	// Read in a read-only transaction and keep the entity tag.
+	var etag string
+	err = db.WithinTx(boltdb.TxOptions{ReadOnly: true}, func(tx *boltdb.TX) error {
+		b, err := tx.Bucket([]byte("docs"))
+		if err != nil {
+			return err
+		}
+		value, version, err := b.GetVersioned([]byte("a"))
+		if err != nil {
+			return err
+		}
+		if string(value) != "first" || version == 0 {
+			return errors.New("unexpected versioned value")
+		}
+		etag = version.ETag()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("cannot read versioned value [err=%v]", err)
+	}
+
+	putIfVersion := func(key string, value string, etag string) (boltdb.Version, error) {
+		var version boltdb.Version
+
+		expected, err := boltdb.ParseETag(etag)
+		if err != nil {
+			return 0, err
+		}
+		err = db.WithinTx(boltdb.TxOptions{}, func(tx *boltdb.TX) error {
+			b, err := tx.Bucket([]byte("docs"))
+			if err != nil {
+				return err
+			}
+			version, err = b.PutIfVersion([]byte(key), []byte(value), expected)
+			return err
+		})
+		return version, err
+	}
+
+	// The first writer wins, the second one conflicts.
+	version, err := putIfVersion("a", "second", etag)
+	if err != nil || version.ETag() == etag {
+		t.Fatalf("cannot write with the expected version [version=%v err=%v]", version, err)
+	}
+	if _, err = putIfVersion("a", "third", etag); !errors.Is(err, boltdb.ErrVersionConflict) {
+		t.Fatalf("stale version should conflict [err=%v]", err)
+	}
+	if _, err = putIfVersion("a", "third", `W/`+version.ETag()); err != nil {
+		t.Fatalf("cannot write with a weak entity tag [err=%v]", err)
+	}
+
+	// Zero means the key must not exist.
+	if _, err = putIfVersion("a", "other", boltdb.Version(0).ETag()); !errors.Is(err, boltdb.ErrVersionConflict) {
+		t.Fatalf("existing key should conflict [err=%v]", err)
+	}
+	if _, err = putIfVersion("b", "new", boltdb.Version(0).ETag()); err != nil {
+		t.Fatalf("cannot create key [err=%v]", err)
+	}
+
+	// Versions are not reused after deleting a key.
+	if err = db.Delete([]byte("docs"), []byte("b")); err != nil {
+		t.Fatalf("cannot delete key [err=%v]", err)
+	}
+	if _, err = putIfVersion("b", "again", boltdb.Version(0).ETag()); err != nil {
+		t.Fatalf("cannot create key [err=%v]", err)
+	}
+
+	// Plain reads and iterators do not see the version.
+	value, err := db.Get([]byte("docs"), []byte("a"))
+	if err != nil || string(value) != "third" {
+		t.Fatalf("unexpected value [got=%q err=%v]", value, err)
+	}
+	var versions []boltdb.Version
+	err = db.WithinTx(boltdb.TxOptions{ReadOnly: true}, func(tx *boltdb.TX) error {
+		b, err := tx.Bucket([]byte("docs"))
+		if err != nil {
+			return err
+		}
+		err = b.WithIterator(boltdb.WithIteratorOptions{}, func(iter *boltdb.Iterator) (bool, error) {
+			_, version, err := b.GetVersioned(iter.Key())
+			if err != nil {
+				return true, err
+			}
+			if v := string(iter.Value()); v != "third" && v != "again" {
+				return true, errors.New("unexpected iterator value " + v)
+			}
+			versions = append(versions, version)
+			return false, nil
+		})
+		return err
+	})
+	if err != nil || len(versions) != 2 || versions[0] == versions[1] {
+		t.Fatalf("unexpected versions [versions=%v err=%v]", versions, err)
+	}
+
+	// Non-versioned buckets reject versioned operations.
+	err = db.WithinTx(boltdb.TxOptions{}, func(tx *boltdb.TX) error {
+		b, err := tx.Bucket([]byte("plain"))
+		if err != nil {
+			return err
+		}
+		_, err = b.PutIfVersion([]byte("a"), []byte("1"), 0)
+		return err
+	})
+	if !errors.Is(err, boltdb.ErrBucketNotVersioned) {
+		t.Fatalf("plain bucket should not be versioned [err=%v]", err)
+	}
+}
+
+func TestVersionClockBehindStoredVersions(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	opts := boltdb.Options{
+		Buckets: []boltdb.BucketOptions{
+			{Path: "docs", Versioned: true},
+		},
+	}
+	db, err := boltdb.NewWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("cannot create test database [err=%v]", err.Error())
+	}
+	for i := 0; i < 3; i++ {
+		if err = db.Put([]byte("docs"), []byte("a"), []byte("value")); err != nil {
+			db.Close()
+			t.Fatalf("cannot write to test database [err=%v]", err.Error())
+		}
+	}
+	old := getVersion(t, db, "a")
+	db.Close()
+
+	// Simulate data copied from another database by resetting the clock.
+	raw, err := bbolt.Open(filename, 0600, nil)
+	if err != nil {
+		t.Fatalf("cannot open database [err=%v]", err)
+	}
+	err = raw.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte("\x00boltdb")).Delete([]byte("version_clock"))
+	})
+	_ = raw.Close()
+	if err != nil {
+		t.Fatalf("cannot reset the version clock [err=%v]", err)
+	}
+
+	db, err = boltdb.NewWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("cannot open test database [err=%v]", err.Error())
+	}
+	defer db.Close()
+
+	if err = db.Put([]byte("docs"), []byte("a"), []byte("other")); err != nil {
+		t.Fatalf("cannot write to test database [err=%v]", err.Error())
+	}
+	if current := getVersion(t, db, "a"); current <= old {
+		t.Fatalf("version was reused [old=%v current=%v]", old, current)
+	}
+}
+
+func getVersion(t *testing.T, db *boltdb.DB, key string) boltdb.Version {
+	var version boltdb.Version
+
+	err := db.WithinTx(boltdb.TxOptions{ReadOnly: true}, func(tx *boltdb.TX) error {
+		b, err := tx.Bucket([]byte("docs"))
+		if err != nil {
+			return err
+		}
+		_, version, err = b.GetVersioned([]byte(key))
+		return err
+	})
+	if err != nil {
+		t.Fatalf("cannot read versioned value [err=%v]", err)
+	}
+	return version
+}
@@ -0,0 +1,297 @@
+// See the LICENSE file for license details.
+
+package boltdb
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// -----------------------------------------------------------------------------
+
+// WriteBatch accumulates changes in memory, without holding the database writer lock, and applies them
+// atomically in a single write transaction.
+//
+// Lookups made through the batch see its own pending changes overlaid on a read-only snapshot of the
+// database, taken on the first lookup and held until Apply, Discard or Close is called. Values read from
+// the snapshot are remembered so, if validation is enabled, Apply can check they were not changed.
+// NOTE: bbolt cannot grow its memory map while a snapshot is held, so writes needing it wait until the
+// snapshot is released. Do not write to the database from the goroutine holding a snapshot.
+type WriteBatch struct {
+	db       *DB
+	opts     WriteBatchOptions
+	ops      []batchOp
+	keys     map[batchKey]int
+	deleted  map[string]int
+	reads    map[batchKey][]byte
+	snapshot *TX
+}
+
+// WriteBatchOptions specifies a set of options when creating a write batch.
+type WriteBatchOptions struct {
+	// Validate makes Apply fail with ErrBatchConflict if any value read from the database through the
+	// batch was changed in the meantime.
+	Validate bool
+
+	// TxOptions sets the principal, actor and reason of the snapshot and of the transaction used by
+	// Apply. ReadOnly is ignored.
+	TxOptions TxOptions
+}
+
+type batchOpKind int
+
+const (
+	batchPut batchOpKind = iota + 1
+	batchDelete
+	batchCreateBucket
+	batchDeleteBucket
+)
+
+type batchOp struct {
+	kind  batchOpKind
+	path  []byte
+	key   []byte
+	value []byte
+}
+
+type batchKey struct {
+	path string
+	key  string
+}
+
+// -----------------------------------------------------------------------------
+
+// NewWriteBatch creates a new, empty, write batch.
+func (db *DB) NewWriteBatch(opts WriteBatchOptions) *WriteBatch {
+	wb := &WriteBatch{
+		db:   db,
+		opts: opts,
+	}
+	wb.reset()
+
+	// Done
+	return wb
+}
+
+// Len returns the number of pending operations.
+func (wb *WriteBatch) Len() int {
+	return len(wb.ops)
+}
+
+// Put records the storage of a key/value pair in the specified bucket.
+func (wb *WriteBatch) Put(path []byte, key []byte, value []byte) error {
+	return wb.record(batchPut, path, key, value)
+}
+
+// Delete records the deletion of a key in the specified bucket.
+func (wb *WriteBatch) Delete(path []byte, key []byte) error {
+	return wb.record(batchDelete, path, key, nil)
+}
+
+// CreateBucket records the creation of a bucket, including the missing parents.
+func (wb *WriteBatch) CreateBucket(path []byte) error {
+	return wb.record(batchCreateBucket, path, nil, nil)
+}
+
+// DeleteBucket records the deletion of a bucket, including nested buckets and stored keys.
+func (wb *WriteBatch) DeleteBucket(path []byte) error {
+	return wb.record(batchDeleteBucket, path, nil, nil)
+}
+
+// Get returns a copy of the value of a key in the specified bucket or nil if not found. Pending changes
+// in the batch take precedence over the database contents.
+func (wb *WriteBatch) Get(path []byte, key []byte) ([]byte, error) {
+	normalizedPath, err := normalizePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Look for the most recent change affecting the key.
+	bk := batchKey{
+		path: string(normalizedPath),
+		key:  string(key),
+	}
+	keyIdx, hasKey := wb.keys[bk]
+	deletedIdx := -1
+	for p := normalizedPath; ; {
+		if idx, ok := wb.deleted[string(p)]; ok && idx > deletedIdx {
+			deletedIdx = idx
+		}
+		sep := bytes.LastIndexByte(p, '/')
+		if sep < 0 {
+			break
+		}
+		p = p[:sep]
+	}
+	if hasKey && keyIdx > deletedIdx {
+		return cloneBytes(wb.ops[keyIdx].value), nil
+	}
+	if deletedIdx >= 0 {
+		return nil, nil
+	}
+
+	// Read from the snapshot.
+	if value, ok := wb.reads[bk]; ok {
+		return cloneBytes(value), nil
+	}
+	if wb.snapshot == nil {
+		opts := wb.opts.TxOptions
+		opts.ReadOnly = true
+		wb.snapshot, err = wb.db.BeginTx(opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+	value, err := batchLookup(wb.snapshot, normalizedPath, key)
+	if err != nil {
+		return nil, err
+	}
+	wb.reads[bk] = value
+
+	// Done
+	return cloneBytes(value), nil
+}
+
+// Apply releases the snapshot and applies all the pending operations, in order, within a single write
+// transaction. On success, the batch is emptied and can be reused.
+func (wb *WriteBatch) Apply() error {
+	wb.Close()
+
+	opts := wb.opts.TxOptions
+	opts.ReadOnly = false
+	err := wb.db.WithinTx(opts, func(tx *TX) error {
+		if wb.opts.Validate {
+			for bk, expected := range wb.reads {
+				current, err := batchLookup(tx, []byte(bk.path), []byte(bk.key))
+				if err != nil {
+					return err
+				}
+				if !bytes.Equal(current, expected) || (current == nil) != (expected == nil) {
+					return fmt.Errorf("%w [path=%s key=%q]", ErrBatchConflict, bk.path, bk.key)
+				}
+			}
+		}
+
+		for _, op := range wb.ops {
+			err := op.apply(tx)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Done
+	wb.reset()
+	return nil
+}
+
+// Discard drops all the pending operations and the remembered values, and releases the snapshot.
+func (wb *WriteBatch) Discard() {
+	wb.Close()
+	wb.reset()
+}
+
+// Close releases the snapshot, if any. Pending operations are kept and later lookups take a new snapshot.
+func (wb *WriteBatch) Close() {
+	if wb.snapshot != nil {
+		wb.snapshot.Rollback()
+		wb.snapshot = nil
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+func (wb *WriteBatch) record(kind batchOpKind, path []byte, key []byte, value []byte) error {
+	normalizedPath, err := normalizePath(path)
+	if err != nil {
+		return err
+	}
+	if (kind == batchPut || kind == batchDelete) && len(key) == 0 {
+		return fmt.Errorf("%w: empty key", ErrInvalidPath)
+	}
+	if kind == batchPut && value == nil {
+		value = []byte{}
+	}
+
+	idx := len(wb.ops)
+	wb.ops = append(wb.ops, batchOp{
+		kind:  kind,
+		path:  normalizedPath,
+		key:   cloneBytes(key),
+		value: cloneBytes(value),
+	})
+	switch kind {
+	case batchPut:
+		fallthrough
+	case batchDelete:
+		wb.keys[batchKey{path: string(normalizedPath), key: string(key)}] = idx
+
+	case batchDeleteBucket:
+		wb.deleted[string(normalizedPath)] = idx
+
+	case batchCreateBucket:
+	}
+
+	// Done
+	return nil
+}
+
+func (wb *WriteBatch) reset() {
+	wb.ops = nil
+	wb.keys = make(map[batchKey]int)
+	wb.deleted = make(map[string]int)
+	wb.reads = make(map[batchKey][]byte)
+}
+
+func (op *batchOp) apply(tx *TX) error {
+	switch op.kind {
+	case batchPut:
+		b, err := tx.Bucket(op.path)
+		if err != nil {
+			return err
+		}
+		return b.Put(op.key, op.value)
+
+	case batchDelete:
+		b, err := tx.existingBucket(op.path)
+		if err != nil || b == nil {
+			return err
+		}
+		return b.Delete(op.key)
+
+	case batchCreateBucket:
+		_, err := tx.Bucket(op.path)
+		return err
+
+	case batchDeleteBucket:
+		return tx.DeleteBucket(op.path)
+	}
+
+	// Done
+	return nil
+}
+
+// batchLookup returns a copy of the value of a key or nil if the key or the bucket do not exist.
+func batchLookup(tx *TX, path []byte, key []byte) ([]byte, error) {
+	b, err := tx.existingBucket(path)
+	if err != nil || b == nil {
+		return nil, err
+	}
+	return b.GetValue(key)
+}
+
+// normalizePath validates a path and returns it without redundant separators.
+func normalizePath(path []byte) ([]byte, error) {
+	fragments, err := splitPath(path)
+	if err != nil {
+		return nil, err
+	}
+	if isReservedBucketName(fragments[0]) {
+		return nil, fmt.Errorf("%w: reserved bucket name", ErrInvalidPath)
+	}
+	return joinPath(cloneFragments(fragments)), nil
+}
@@ -0,0 +1,107 @@
+// See the LICENSE file for license details.
+
+package boltdb_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mxmauro/boltdb/v3"
+)
+
+// -----------------------------------------------------------------------------
+
+func TestWriteBatch(t *testing.T) {
+	db := openTestDb(t)
+	defer db.Close()
+
+	if err := db.Put([]byte("items"), []byte("a"), []byte("1")); err != nil {
+		t.Fatalf("cannot write to test database [err=%v]", err.Error())
+	}
+	if err := db.Put([]byte("items/nested"), []byte("x"), []byte("y")); err != nil {
+		t.Fatalf("cannot write to test database [err=%v]", err.Error())
+	}
+
+	wb := db.NewWriteBatch(boltdb.WriteBatchOptions{})
+	_ = wb.Put([]byte("items"), []byte("b"), []byte("2"))
+	_ = wb.Delete([]byte("items"), []byte("a"))
+	_ = wb.DeleteBucket([]byte("items/nested"))
+	_ = wb.Put([]byte("/items//nested/"), []byte("z"), []byte("w"))
+
+	// Read-your-writes.
+	expected := []struct {
+		path, key, value string
+	}{
+		{"items", "a", ""},
+		{"items", "b", "2"},
+		{"items/nested", "x", ""},
+		{"items/nested", "z", "w"},
+	}
+	for _, e := range expected {
+		value, err := wb.Get([]byte(e.path), []byte(e.key))
+		if err != nil || string(value) != e.value {
+			t.Fatalf("unexpected batch value [path=%s key=%s got=%q err=%v]", e.path, e.key, value, err)
+		}
+	}
+
+	// Nothing is written until applied.
+	if value, _ := db.Get([]byte("items"), []byte("b")); value != nil {
+		t.Fatalf("batch was written before apply")
+	}
+	if err := wb.Apply(); err != nil {
+		t.Fatalf("cannot apply batch [err=%v]", err)
+	}
+	if wb.Len() != 0 {
+		t.Fatalf("batch was not emptied")
+	}
+	for _, e := range expected {
+		value, err := db.Get([]byte(e.path), []byte(e.key))
+		if err != nil || string(value) != e.value {
+			t.Fatalf("unexpected value [path=%s key=%s got=%q err=%v]", e.path, e.key, value, err)
+		}
+	}
+}
+
+func TestWriteBatchValidation(t *testing.T) {
+	db := openTestDb(t)
+	defer db.Close()
+
+	if err := db.Put([]byte("counters"), []byte("hits"), []byte("1")); err != nil {
+		t.Fatalf("cannot write to test database [err=%v]", err.Error())
+	}
+
+	wb := db.NewWriteBatch(boltdb.WriteBatchOptions{Validate: true})
+	value, err := wb.Get([]byte("counters"), []byte("hits"))
+	if err != nil || string(value) != "1" {
+		t.Fatalf("unexpected value [got=%q err=%v]", value, err)
+	}
+	_ = wb.Put([]byte("counters"), []byte("hits"), []byte("2"))
+
+	// A concurrent change invalidates the batch. The snapshot is released first because this goroutine
+	// writes to the database.
+	wb.Close()
+	wb2 := db.NewWriteBatch(boltdb.WriteBatchOptions{})
+	_ = wb2.Put([]byte("counters"), []byte("hits"), []byte("5"))
+	if err = wb2.Apply(); err != nil {
+		t.Fatalf("cannot apply batch [err=%v]", err)
+	}
+	if err = wb.Apply(); !errors.Is(err, boltdb.ErrBatchConflict) {
+		t.Fatalf("batch should conflict [err=%v]", err)
+	}
+	value, _ = db.Get([]byte("counters"), []byte("hits"))
+	if string(value) != "5" {
+		t.Fatalf("conflicting batch was applied [got=%q]", value)
+	}
+
+	// Retry.
+	wb.Discard()
+	value, _ = wb.Get([]byte("counters"), []byte("hits"))
+	_ = wb.Put([]byte("counters"), []byte("hits"), append(value, '0'))
+	if err = wb.Apply(); err != nil {
+		t.Fatalf("cannot apply batch [err=%v]", err)
+	}
+	value, _ = db.Get([]byte("counters"), []byte("hits"))
+	if string(value) != "50" {
+		t.Fatalf("unexpected value [got=%q]", value)
+	}
+}